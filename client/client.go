@@ -1,14 +1,17 @@
 package client
 
 import (
+	"bufio"
 	"bytes"
 	"context"
 	"crypto/tls"
 	"encoding/json"
 	"fmt"
+	"io"
 	"net"
 	"net/http"
 	"net/url"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -35,6 +38,10 @@ type Config struct {
 
 	// Transport is the Transport to use for the HTTP client.
 	Transport http.RoundTripper
+
+	// Consistency is a default consistency level of the requests. When
+	// empty, the level configured by the server is used.
+	Consistency string
 }
 
 func (cfg *Config) transport() http.RoundTripper {
@@ -44,6 +51,20 @@ func (cfg *Config) transport() http.RoundTripper {
 	return DefaultTransport
 }
 
+const (
+	// ConsistencyOne requires a single copy of the record to acknowledge
+	// the write or answer the read.
+	ConsistencyOne = "ONE"
+
+	// ConsistencyQuorum requires a majority of the copies of the record
+	// to acknowledge the write or answer the read.
+	ConsistencyQuorum = "QUORUM"
+
+	// ConsistencyAll requires all copies of the record to acknowledge
+	// the write or answer the read.
+	ConsistencyAll = "ALL"
+)
+
 // Meta is a metadata about the record in a hash.
 type Meta struct {
 	// Index defines a record serial number. Each time the record
@@ -54,6 +75,10 @@ type Meta struct {
 	// equal to zero, record won't be ever evicted from the storage.
 	ExpireTime Duration `json:"expire_time"`
 
+	// Sliding defines whether the time to live of the record is
+	// counted from the last access to the record.
+	Sliding bool `json:"sliding"`
+
 	// AccessedAt defines a moment when the record was accessed last
 	// time.
 	AccessedAt time.Time `json:"accessed_at"`
@@ -74,6 +99,32 @@ type Node struct {
 
 	// Addr is an endpoint of the node in a cluster (a port:host pair).
 	Addr string `json:"addr"`
+
+	// Primaries is a number of partitions, where the node holds the
+	// primary copy of the records. It is set only in the list of nodes.
+	Primaries int `json:"primaries,omitempty"`
+
+	// Replicas is a number of partitions, where the node holds the
+	// replica of the records. It is set only in the list of nodes.
+	Replicas int `json:"replicas,omitempty"`
+
+	// State is a state of the node detected by the failure detector:
+	// alive, suspect or dead. It is set only in the list of nodes.
+	State string `json:"state,omitempty"`
+}
+
+// JoinOptions defines parameters of the request to add a node to the
+// cluster.
+type JoinOptions struct {
+	// Addr is a server address of the new node (a host:port pair).
+	Addr string `json:"addr"`
+}
+
+// LeaveOptions defines parameters of the request to remove a node from
+// the cluster.
+type LeaveOptions struct {
+	// Addr is a server address of the leaving node (a host:port pair).
+	Addr string `json:"-"`
 }
 
 // Error is a server error, usually it is returned when the user
@@ -113,6 +164,9 @@ type Response struct {
 type LoadOptions struct {
 	// Key is a key to load.
 	Key string `json:"-"`
+	// Consistency is a number of copies of the record, that should
+	// answer the read. When empty, the level of the client is used.
+	Consistency string `json:"-"`
 }
 
 // StoreOptions defines parameters of the store request.
@@ -123,12 +177,198 @@ type StoreOptions struct {
 	Data interface{} `json:"data"`
 	// ExpireTime specifies an expiration of the data.
 	ExpireTime Duration `json:"expire_time"`
+	// Sliding specifies whether the expiration of the data is extended
+	// on each access to the record.
+	Sliding bool `json:"sliding"`
+	// ExpectedIndex is an index of the record expected to be persisted
+	// in a store. When non-zero, the data is stored only if the index of
+	// the record matches the expected one.
+	ExpectedIndex int64 `json:"-"`
+	// Absent specifies whether the data is stored only if the record
+	// does not exist in a store.
+	Absent bool `json:"-"`
+	// Consistency is a number of copies of the record, that should
+	// acknowledge the write. When empty, the level of the client is used.
+	Consistency string `json:"-"`
+}
+
+// LoadManyOptions defines parameters of the batch load request.
+type LoadManyOptions struct {
+	// Keys is a list of keys to load.
+	Keys []string `json:"keys"`
+}
+
+// StoreRecord defines a single record of the batch store request.
+type StoreRecord struct {
+	// Key is a key to store.
+	Key string `json:"key"`
+	// Data defines a data to store.
+	Data interface{} `json:"data"`
+	// ExpireTime specifies an expiration of the data.
+	ExpireTime Duration `json:"expire_time"`
+	// Sliding specifies whether the expiration of the data is extended
+	// on each access to the record.
+	Sliding bool `json:"sliding"`
+}
+
+// StoreManyOptions defines parameters of the batch store request.
+type StoreManyOptions struct {
+	// Records is a list of records to store.
+	Records []StoreRecord `json:"records"`
+}
+
+// BatchResult is a result of the batch request for a single key.
+type BatchResult struct {
+	// Key is a key of the result.
+	Key string `json:"key"`
+	// Status is a status code of the request for the key.
+	Status int `json:"status"`
+	// Error is an error message, it is empty, when the key is processed
+	// successfully.
+	Error string `json:"error,omitempty"`
+
+	// Meta defines a metadata about the returned record, it is nil,
+	// when the key is processed with an error.
+	Meta *Meta `json:"meta,omitempty"`
+	// Data is the data returned from the key-value storage.
+	Data interface{} `json:"data,omitempty"`
+	// Node is a node of the cluster that stores the requested data.
+	Node *Node `json:"node,omitempty"`
+}
+
+// BatchResponse is a response of the batch request.
+type BatchResponse struct {
+	// Results is a list of results in the order of the requested keys.
+	Results []BatchResult `json:"results"`
+}
+
+// TransactionRequest defines a single request of the transaction.
+type TransactionRequest struct {
+	// Action is an action of the request, one of "load", "store",
+	// "delete", "incr" or "decr".
+	Action string `json:"action"`
+	// Key is a key of the request.
+	Key string `json:"key"`
+	// Data defines a data to store.
+	Data interface{} `json:"data,omitempty"`
+	// ExpireTime specifies an expiration of the stored data.
+	ExpireTime Duration `json:"expire_time,omitempty"`
+	// Sliding specifies whether the expiration of the stored data is
+	// extended on each access to the record.
+	Sliding bool `json:"sliding,omitempty"`
+	// Delta is a value to add to (or subtract from) the counter. When
+	// zero, the counter is changed by one.
+	Delta int64 `json:"delta,omitempty"`
+}
+
+// Precondition defines an expected index of the record, the index is
+// zero for missing records.
+type Precondition struct {
+	// Key is a key of the record.
+	Key string `json:"key"`
+	// Index is an expected index of the record.
+	Index int64 `json:"index"`
+}
+
+// TransactionOptions defines parameters of the transaction. The keys
+// of the transaction should be owned by the same node, use hash tags
+// (like "{user42}:cart") to place the keys onto the same node.
+type TransactionOptions struct {
+	// Requests is an ordered list of requests to apply atomically.
+	Requests []TransactionRequest `json:"requests"`
+	// Preconditions is a list of expected indices of the records, the
+	// transaction is applied only when all of them are satisfied.
+	Preconditions []Precondition `json:"preconditions,omitempty"`
+}
+
+// TransactionResponse is a response of the applied transaction.
+type TransactionResponse struct {
+	// Results is a list of results in the order of the requests.
+	Results []Response `json:"results"`
+}
+
+// EventsOptions defines parameters of the events request.
+type EventsOptions struct {
+	// Prefix limits the events to the keys with the given prefix.
+	Prefix string `json:"-"`
+	// Types limits the events to the given types: "store", "delete",
+	// "expire" or "evict". When empty, events of all types are sent.
+	Types []string `json:"-"`
+}
+
+// Event is a change of the record in a cluster.
+type Event struct {
+	// Type is a type of the change: "store", "delete", "expire" or
+	// "evict".
+	Type string `json:"type"`
+	// Key is a key of the changed record.
+	Key string `json:"key"`
+	// Meta defines a metadata of the record. For the deleted records it
+	// is the last metadata of the record before deletion.
+	Meta Meta `json:"meta"`
+	// Data is the data of the record.
+	Data interface{} `json:"data,omitempty"`
+	// Node is a node of the cluster that stores the record.
+	Node Node `json:"node"`
+}
+
+// PublishOptions defines parameters of the publish request.
+type PublishOptions struct {
+	// Channel is a name of the channel.
+	Channel string `json:"-"`
+	// Data is a payload of the message.
+	Data interface{} `json:"data"`
+}
+
+// PublishResponse is a result of publishing a message to all nodes in
+// a cluster.
+type PublishResponse struct {
+	// Receivers is a number of subscribers received the message.
+	Receivers int `json:"receivers"`
+	// Errors is a list of nodes failed to deliver the message.
+	Errors []NodeError `json:"errors"`
+}
+
+// SubscribeOptions defines parameters of the subscribe request.
+type SubscribeOptions struct {
+	// Channel is a name of the channel.
+	Channel string `json:"-"`
+}
+
+// Message is a message published to the channel.
+type Message struct {
+	// Channel is a name of the channel.
+	Channel string `json:"channel"`
+	// Data is a payload of the message.
+	Data interface{} `json:"data,omitempty"`
+}
+
+// WatchOptions defines parameters of the watch request.
+type WatchOptions struct {
+	// Key is a key to watch.
+	Key string `json:"-"`
+	// Index is the last known index of the record. The first change
+	// is reported, when the index of the record exceeds this value.
+	Index int64 `json:"-"`
+}
+
+// WatchEvent is a change of the watched record.
+type WatchEvent struct {
+	// Response is a new state of the record, the action of response is
+	// a type of the change: "store", "delete", "expire" or "evict".
+	*Response
+	// Err is an error occurred during the watch, it is the last event
+	// sent to the channel.
+	Err error
 }
 
 // DeleteOptions defines parameters for the delete request.
 type DeleteOptions struct {
 	// Key is a key to delete.
 	Key string `json:"-"`
+	// Consistency is a number of copies of the record, that should
+	// acknowledge the write. When empty, the level of the client is used.
+	Consistency string `json:"-"`
 }
 
 // DictItemOptions defines parameters for the dict item request.
@@ -139,6 +379,47 @@ type DictItemOptions struct {
 	Item interface{} `json:"item"`
 }
 
+// DictSetItemOptions defines parameters for the dict set item request.
+type DictSetItemOptions struct {
+	// Key is a key of the dictionary.
+	Key string `json:"-"`
+	// Item is an item in a dictionary to set.
+	Item interface{} `json:"item"`
+	// Data is a new value of the item.
+	Data interface{} `json:"data"`
+}
+
+// DictDeleteItemOptions defines parameters for the dict delete item
+// request.
+type DictDeleteItemOptions struct {
+	// Key is a key of the dictionary.
+	Key string `json:"-"`
+	// Item is an item in a dictionary to delete.
+	Item interface{} `json:"item"`
+}
+
+// DictFieldsOptions defines parameters for the dict fields request.
+type DictFieldsOptions struct {
+	// Key is a key of the dictionary.
+	Key string `json:"-"`
+}
+
+// DictItemsOptions defines parameters for the dict items request.
+type DictItemsOptions struct {
+	// Key is a key of the dictionary.
+	Key string `json:"-"`
+	// Items is a list of items in a dictionary to retrieve.
+	Items []interface{} `json:"items"`
+}
+
+// DictMergeOptions defines parameters for the dict merge request.
+type DictMergeOptions struct {
+	// Key is a key of the dictionary.
+	Key string `json:"-"`
+	// Data is a partial dictionary to merge into the stored one.
+	Data interface{} `json:"data"`
+}
+
 // ListIndexOptions defines parameters for the list index request.
 type ListIndexOptions struct {
 	// Key is a key to use to retrieve the data.
@@ -147,11 +428,227 @@ type ListIndexOptions struct {
 	Index uint64 `json:"index"`
 }
 
+// ListPushOptions defines parameters for the list push request.
+type ListPushOptions struct {
+	// Key is a key of the list.
+	Key string `json:"-"`
+	// Data is an element to insert into the list.
+	Data interface{} `json:"data"`
+	// Front defines whether the element is inserted to the beginning
+	// of the list, by default it is appended to the end.
+	Front bool `json:"front"`
+}
+
+// ListPopOptions defines parameters for the list pop request.
+type ListPopOptions struct {
+	// Key is a key of the list.
+	Key string `json:"-"`
+	// Front defines whether the element is extracted from the
+	// beginning of the list, by default it is extracted from the end.
+	Front bool `json:"front"`
+}
+
+// ListSetOptions defines parameters for the list set request.
+type ListSetOptions struct {
+	// Key is a key of the list.
+	Key string `json:"-"`
+	// Index is a position of the element to replace.
+	Index uint64 `json:"index"`
+	// Data is a new value of the element.
+	Data interface{} `json:"data"`
+}
+
+// ListRemoveOptions defines parameters for the list remove request.
+type ListRemoveOptions struct {
+	// Key is a key of the list.
+	Key string `json:"-"`
+	// Index is a position of the element to remove.
+	Index uint64 `json:"index"`
+}
+
+// ListRangeOptions defines parameters for the list range request.
+// Negative positions are counted from the end of the list.
+type ListRangeOptions struct {
+	// Key is a key of the list.
+	Key string `json:"-"`
+	// Start is a first position of the range.
+	Start int64 `json:"start"`
+	// Stop is a last position of the range (inclusive).
+	Stop int64 `json:"stop"`
+}
+
+// ListTrimOptions defines parameters for the list trim request.
+// Negative positions are counted from the end of the list.
+type ListTrimOptions struct {
+	// Key is a key of the list.
+	Key string `json:"-"`
+	// Start is a first position of the range to keep.
+	Start int64 `json:"start"`
+	// Stop is a last position of the range to keep (inclusive).
+	Stop int64 `json:"stop"`
+}
+
+// CounterOptions defines parameters for the increment and decrement
+// requests.
+type CounterOptions struct {
+	// Key is a key of the numeric record.
+	Key string `json:"-"`
+	// Delta is a value to add to (or subtract from) the record. When
+	// zero, the record is changed by one.
+	Delta int64 `json:"delta"`
+}
+
+// ExpireOptions defines parameters for the expire request.
+type ExpireOptions struct {
+	// Key is a key of the record.
+	Key string `json:"-"`
+	// ExpireTime is a new time to live of the record counted from the
+	// moment of the request. When zero, the record becomes permanent.
+	ExpireTime Duration `json:"expire_time"`
+}
+
+// PersistOptions defines parameters for the persist request.
+type PersistOptions struct {
+	// Key is a key of the record.
+	Key string `json:"-"`
+}
+
+// TTLOptions defines parameters for the time to live request.
+type TTLOptions struct {
+	// Key is a key of the record.
+	Key string `json:"-"`
+}
+
+// NodeError is an error occurred on a single node of the cluster.
+type NodeError struct {
+	// Addr is an address of the failed node.
+	Addr string `json:"addr"`
+	// Text is an error message.
+	Text string `json:"text"`
+}
+
+// ClusterKeysResponse is a list of keys stored on all nodes in a cluster.
+type ClusterKeysResponse struct {
+	// Keys is a sorted list of unique keys.
+	Keys []string `json:"keys"`
+	// Errors is a list of nodes failed to return the keys.
+	Errors []NodeError `json:"errors"`
+}
+
+// Partition describes the copies of a single partition of the ring.
+type Partition struct {
+	// Index is an index of the partition in the ring.
+	Index int `json:"index"`
+	// Nodes is a list of addresses of the nodes holding the copies,
+	// the primary copy is the first one.
+	Nodes []string `json:"nodes"`
+	// Divergent is a list of addresses of the nodes, which copies
+	// differ from the primary copy.
+	Divergent []string `json:"divergent"`
+}
+
+// PartitionsResponse is a list of partitions, which copies diverged.
+type PartitionsResponse struct {
+	// Partitions is a list of divergent partitions.
+	Partitions []Partition `json:"partitions"`
+	// Errors is a list of nodes failed to return the partition hashes.
+	Errors []NodeError `json:"errors"`
+}
+
+// ScanOptions defines parameters for the scan request.
+type ScanOptions struct {
+	// Cursor is a cursor returned by the previous scan, empty cursor
+	// starts a new scan.
+	Cursor string `json:"-"`
+	// Limit is a maximum number of keys in a page.
+	Limit int `json:"-"`
+	// Prefix limits the scan to keys with the given prefix.
+	Prefix string `json:"-"`
+	// Match limits the scan to keys matching the given glob pattern.
+	Match string `json:"-"`
+}
+
+// values returns the scan options as URL query parameters.
+func (opts *ScanOptions) values() url.Values {
+	values := url.Values{"cursor": []string{opts.Cursor}}
+	if opts.Limit > 0 {
+		values.Set("limit", strconv.Itoa(opts.Limit))
+	}
+	if opts.Prefix != "" {
+		values.Set("prefix", opts.Prefix)
+	}
+	if opts.Match != "" {
+		values.Set("match", opts.Match)
+	}
+	return values
+}
+
+// ScanResponse is a page of keys returned by the scan request.
+type ScanResponse struct {
+	// Cursor is a cursor of the next page, it is empty, when all keys
+	// of the node are scanned.
+	Cursor string `json:"cursor"`
+	// Keys is a list of keys of the page.
+	Keys []string `json:"keys"`
+}
+
 // Client describes types to communicate with a key-value storage.
 type Client interface {
 	// Keys returns a list of keys.
 	Keys(context.Context) ([]string, error)
 
+	// Events returns a channel of the changes of the records in a
+	// cluster. The channel is closed, when the context is canceled or
+	// the stream is terminated by the server.
+	Events(context.Context, *EventsOptions) (<-chan *Event, error)
+
+	// Publish sends the message to the subscribers of the channel
+	// connected to any node in a cluster.
+	Publish(context.Context, *PublishOptions) (*PublishResponse, error)
+
+	// Subscribe returns a channel of the messages published to the
+	// channel. The channel is closed, when the context is canceled or
+	// the stream is terminated by the server.
+	Subscribe(context.Context, *SubscribeOptions) (<-chan *Message, error)
+
+	// Join adds a node to the cluster through the configured node. It
+	// returns an updated list of nodes in a cluster.
+	Join(context.Context, *JoinOptions) ([]Node, error)
+
+	// Leave removes a node from the cluster through the configured node.
+	// It returns an updated list of nodes in a cluster.
+	Leave(context.Context, *LeaveOptions) ([]Node, error)
+
+	// Watch returns a channel of the changes of the record. The record
+	// is long-polled until the context is canceled or an error occurs,
+	// the channel is closed after that.
+	Watch(context.Context, *WatchOptions) <-chan *WatchEvent
+
+	// LoadMany loads multiple records at once. The result of each key
+	// is reported individually in the order of the requested keys.
+	LoadMany(context.Context, *LoadManyOptions) (*BatchResponse, error)
+
+	// StoreMany persists multiple records at once. The result of each
+	// record is reported individually in the order of the records.
+	StoreMany(context.Context, *StoreManyOptions) (*BatchResponse, error)
+
+	// Transaction applies the list of requests atomically, either all
+	// requests are applied or none of them.
+	Transaction(context.Context,
+		*TransactionOptions) (*TransactionResponse, error)
+
+	// ClusterKeys returns a list of keys gathered by the configured
+	// node from all nodes in a cluster.
+	ClusterKeys(context.Context) (*ClusterKeysResponse, error)
+
+	// Scan returns a page of keys of the configured node starting from
+	// the given cursor.
+	Scan(context.Context, *ScanOptions) (*ScanResponse, error)
+
+	// Iterate returns an iterator over the keys of all nodes in
+	// a cluster. Nodes are scanned one by one.
+	Iterate(*ScanOptions) *KeyIterator
+
 	// Load returns a record persisted under the given key.
 	Load(context.Context, *LoadOptions) (*Response, error)
 
@@ -168,19 +665,85 @@ type Client interface {
 	// ListIndex returns an element of the dictionary persisted under the
 	// given key and index.
 	ListIndex(context.Context, *ListIndexOptions) (*Response, error)
+
+	// DictSetItem sets an element of the dictionary persisted under the
+	// given key. Missing dictionary is created.
+	DictSetItem(context.Context, *DictSetItemOptions) (*Response, error)
+
+	// DictDeleteItem removes an element of the dictionary persisted
+	// under the given key.
+	DictDeleteItem(context.Context, *DictDeleteItemOptions) (*Response, error)
+
+	// DictFields returns a list of keys of the dictionary persisted
+	// under the given key.
+	DictFields(context.Context, *DictFieldsOptions) (*Response, error)
+
+	// DictItems returns multiple elements of the dictionary persisted
+	// under the given key.
+	DictItems(context.Context, *DictItemsOptions) (*Response, error)
+
+	// DictMerge merges a partial dictionary into the dictionary
+	// persisted under the given key.
+	DictMerge(context.Context, *DictMergeOptions) (*Response, error)
+
+	// Incr atomically increments a numeric record persisted under the
+	// given key. Missing record is created with a zero value.
+	Incr(context.Context, *CounterOptions) (*Response, error)
+
+	// Decr atomically decrements a numeric record persisted under the
+	// given key. Missing record is created with a zero value.
+	Decr(context.Context, *CounterOptions) (*Response, error)
+
+	// Expire sets a new time to live of the record persisted under the
+	// given key, the data of the record remains untouched.
+	Expire(context.Context, *ExpireOptions) (*Response, error)
+
+	// Persist removes the expiration time of the record persisted under
+	// the given key, so it becomes permanent.
+	Persist(context.Context, *PersistOptions) (*Response, error)
+
+	// TTL returns the remaining lifetime of the record persisted under
+	// the given key as a data of the response.
+	TTL(context.Context, *TTLOptions) (*Response, error)
+
+	// ListRange returns elements of the list persisted under the given
+	// key in the requested range of positions.
+	ListRange(context.Context, *ListRangeOptions) (*Response, error)
+
+	// ListPush inserts an element to the list persisted under the given
+	// key. Missing list is created.
+	ListPush(context.Context, *ListPushOptions) (*Response, error)
+
+	// ListPop extracts an element from the list persisted under the
+	// given key.
+	ListPop(context.Context, *ListPopOptions) (*Response, error)
+
+	// ListSet replaces an element of the list persisted under the
+	// given key.
+	ListSet(context.Context, *ListSetOptions) (*Response, error)
+
+	// ListRemove removes an element of the list persisted under the
+	// given key.
+	ListRemove(context.Context, *ListRemoveOptions) (*Response, error)
+
+	// ListTrim trims the list persisted under the given key to the
+	// requested range of positions.
+	ListTrim(context.Context, *ListTrimOptions) (*Response, error)
 }
 
 // client is a key-value storage client.
 type client struct {
-	host       string
-	tlsConfig  *tls.Config
-	httpClient *http.Client
+	host        string
+	consistency string
+	tlsConfig   *tls.Config
+	httpClient  *http.Client
 }
 
 // NewClient creates a new instance of the Client.
 func NewClient(config *Config) Client {
 	return &client{
-		host: config.Host,
+		host:        config.Host,
+		consistency: config.Consistency,
 		httpClient: &http.Client{
 			Transport: config.transport(),
 		},
@@ -196,6 +759,11 @@ func (c *client) scheme() string {
 
 func (c *client) do(ctx context.Context, method string,
 	u *url.URL, in, out interface{}) error {
+	return c.doHeader(ctx, method, u, nil, in, out)
+}
+
+func (c *client) doHeader(ctx context.Context, method string,
+	u *url.URL, header http.Header, in, out interface{}) error {
 
 	var (
 		b   []byte
@@ -213,6 +781,12 @@ func (c *client) do(ctx context.Context, method string,
 	if err != nil {
 		return err
 	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	if c.consistency != "" && req.Header.Get(headerConsistency) == "" {
+		req.Header.Set(headerConsistency, c.consistency)
+	}
 	resp, err := c.httpClient.Do(req.WithContext(ctx))
 	if err != nil {
 		return err
@@ -247,6 +821,20 @@ func (c *client) do(ctx context.Context, method string,
 	return decoder.Decode(out)
 }
 
+// headerConsistency is a header of the request used to specify the
+// consistency level.
+const headerConsistency = "X-Consistency-Level"
+
+// consistencyHeader returns a header with the given consistency level,
+// when the level is not empty.
+func (c *client) consistencyHeader(level string) http.Header {
+	header := make(http.Header)
+	if level != "" {
+		header.Set(headerConsistency, level)
+	}
+	return header
+}
+
 // nodes returns a list of the nodes in a cluster.
 func (c *client) nodes(ctx context.Context) (nodes []Node, err error) {
 	err = c.do(ctx, "GET", c.urlOf("/v1/nodes"), nil, &nodes)
@@ -304,9 +892,9 @@ func (c *client) Keys(ctx context.Context) ([]string, error) {
 	}
 	// Call each node in parallel and then aggregate the results
 	// into a single list of keys.
-	for _, node := range nodes {
+	for i := range nodes {
 		wg.Add(1)
-		go retrieve(&node)
+		go retrieve(&nodes[i])
 	}
 
 	wg.Wait()
@@ -323,26 +911,379 @@ func (c *client) Keys(ctx context.Context) ([]string, error) {
 	return kys, nil
 }
 
+// ClusterKeys implements Client interface.
+func (c *client) ClusterKeys(ctx context.Context) (*ClusterKeysResponse, error) {
+	u := &url.URL{
+		Scheme:   c.scheme(),
+		Host:     c.host,
+		Path:     "/v1/keys",
+		RawQuery: "scope=cluster",
+	}
+
+	resp := new(ClusterKeysResponse)
+	if err := c.do(ctx, "GET", u, nil, resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// Scan implements Client interface.
+func (c *client) Scan(ctx context.Context,
+	opts *ScanOptions) (*ScanResponse, error) {
+	return c.scan(ctx, c.host, opts)
+}
+
+// scan returns a page of keys of the given node.
+func (c *client) scan(ctx context.Context, host string,
+	opts *ScanOptions) (resp *ScanResponse, err error) {
+
+	u := &url.URL{
+		Scheme:   c.scheme(),
+		Host:     host,
+		Path:     "/v1/keys",
+		RawQuery: opts.values().Encode(),
+	}
+
+	resp = new(ScanResponse)
+	if err = c.do(ctx, "GET", u, nil, resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// Iterate implements Client interface.
+func (c *client) Iterate(opts *ScanOptions) *KeyIterator {
+	return &KeyIterator{c: c, opts: *opts}
+}
+
+// KeyIterator iterates over the keys of all nodes in a cluster. The
+// keys are retrieved from the nodes page by page.
+type KeyIterator struct {
+	c    *client
+	opts ScanOptions
+
+	// A list of nodes to scan, it is retrieved on the first call.
+	nodes   []Node
+	started bool
+
+	keys []string
+	key  string
+	err  error
+}
+
+// Next advances the iterator to the next key. It returns false, when
+// all keys are iterated or an error occurred.
+func (it *KeyIterator) Next(ctx context.Context) bool {
+	if it.err != nil {
+		return false
+	}
+	if !it.started {
+		it.started = true
+		if it.nodes, it.err = it.c.nodes(ctx); it.err != nil {
+			return false
+		}
+	}
+
+	// Retrieve the pages until a non-empty page is found, pages could
+	// be empty, even if the node is not scanned completely.
+	for len(it.keys) == 0 {
+		if len(it.nodes) == 0 {
+			return false
+		}
+
+		var resp *ScanResponse
+		resp, it.err = it.c.scan(ctx, it.nodes[0].Addr, &it.opts)
+		if it.err != nil {
+			return false
+		}
+
+		it.keys = resp.Keys
+		it.opts.Cursor = resp.Cursor
+
+		// Move to the next node, when the current one is scanned.
+		if resp.Cursor == "" {
+			it.nodes = it.nodes[1:]
+		}
+	}
+
+	it.key, it.keys = it.keys[0], it.keys[1:]
+	return true
+}
+
+// Key returns the current key of the iterator.
+func (it *KeyIterator) Key() string {
+	return it.key
+}
+
+// Err returns an error occurred during the iteration.
+func (it *KeyIterator) Err() error {
+	return it.err
+}
+
 // Load implements Client interface.
 func (c *client) Load(ctx context.Context,
 	opts *LoadOptions) (resp *Response, err error) {
 
 	resp = new(Response)
+	header := c.consistencyHeader(opts.Consistency)
 	path := fmt.Sprintf("/v1/keys/%s", opts.Key)
-	err = c.do(ctx, "GET", c.urlOf(path), nil, resp)
+	err = c.doHeader(ctx, "GET", c.urlOf(path), header, nil, resp)
 	if err != nil {
 		return nil, err
 	}
 	return resp, err
 }
 
+// LoadMany implements Client interface.
+func (c *client) LoadMany(ctx context.Context,
+	opts *LoadManyOptions) (resp *BatchResponse, err error) {
+
+	resp = new(BatchResponse)
+	err = c.do(ctx, "POST", c.urlOf("/v1/batch/load"), opts, resp)
+	if err != nil {
+		return nil, err
+	}
+	return resp, err
+}
+
+// StoreMany implements Client interface.
+func (c *client) StoreMany(ctx context.Context,
+	opts *StoreManyOptions) (resp *BatchResponse, err error) {
+
+	resp = new(BatchResponse)
+	err = c.do(ctx, "POST", c.urlOf("/v1/batch/store"), opts, resp)
+	if err != nil {
+		return nil, err
+	}
+	return resp, err
+}
+
+// Transaction implements Client interface.
+func (c *client) Transaction(ctx context.Context,
+	opts *TransactionOptions) (resp *TransactionResponse, err error) {
+
+	resp = new(TransactionResponse)
+	err = c.do(ctx, "POST", c.urlOf("/v1/transaction"), opts, resp)
+	if err != nil {
+		return nil, err
+	}
+	return resp, err
+}
+
+// maxEventSize is a maximum size of the event in the stream of events.
+const maxEventSize = 64 << 20
+
+// Events implements Client interface.
+func (c *client) Events(ctx context.Context,
+	opts *EventsOptions) (<-chan *Event, error) {
+
+	values := make(url.Values)
+	if opts.Prefix != "" {
+		values.Set("prefix", opts.Prefix)
+	}
+	if len(opts.Types) != 0 {
+		values.Set("types", strings.Join(opts.Types, ","))
+	}
+
+	u := c.urlOf("/v1/events")
+	u.RawQuery = values.Encode()
+
+	resp, err := c.stream(ctx, u)
+	if err != nil {
+		return nil, err
+	}
+
+	events := make(chan *Event)
+	go func() {
+		defer close(events)
+		defer resp.Body.Close()
+
+		c.readEvents(resp.Body, func(data []byte) bool {
+			ev := new(Event)
+			if err := json.Unmarshal(data, ev); err != nil {
+				return false
+			}
+
+			select {
+			case events <- ev:
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		})
+	}()
+	return events, nil
+}
+
+// Publish implements Client interface.
+func (c *client) Publish(ctx context.Context,
+	opts *PublishOptions) (resp *PublishResponse, err error) {
+
+	resp = new(PublishResponse)
+	u := c.urlOf(fmt.Sprintf("/v1/channels/%s", opts.Channel))
+	if err = c.do(ctx, "POST", u, opts, resp); err != nil {
+		return nil, err
+	}
+	return resp, err
+}
+
+// Join implements Client interface.
+func (c *client) Join(ctx context.Context,
+	opts *JoinOptions) (nodes []Node, err error) {
+
+	err = c.do(ctx, "POST", c.urlOf("/v1/nodes"), opts, &nodes)
+	return nodes, err
+}
+
+// Leave implements Client interface.
+func (c *client) Leave(ctx context.Context,
+	opts *LeaveOptions) (nodes []Node, err error) {
+
+	u := c.urlOf(fmt.Sprintf("/v1/nodes/%s", opts.Addr))
+	err = c.do(ctx, "DELETE", u, nil, &nodes)
+	return nodes, err
+}
+
+// Subscribe implements Client interface.
+func (c *client) Subscribe(ctx context.Context,
+	opts *SubscribeOptions) (<-chan *Message, error) {
+
+	u := c.urlOf(fmt.Sprintf("/v1/channels/%s", opts.Channel))
+	resp, err := c.stream(ctx, u)
+	if err != nil {
+		return nil, err
+	}
+
+	messages := make(chan *Message)
+	go func() {
+		defer close(messages)
+		defer resp.Body.Close()
+
+		c.readEvents(resp.Body, func(data []byte) bool {
+			msg := new(Message)
+			if err := json.Unmarshal(data, msg); err != nil {
+				return false
+			}
+
+			select {
+			case messages <- msg:
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		})
+	}()
+	return messages, nil
+}
+
+// stream opens a stream of the server-sent events. The body of the
+// returned response should be closed by the caller.
+func (c *client) stream(ctx context.Context,
+	u *url.URL) (*http.Response, error) {
+
+	req, err := http.NewRequest("GET", u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "text/event-stream")
+
+	resp, err := c.httpClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		var re Error
+		if err := json.NewDecoder(resp.Body).Decode(&re); err != nil {
+			return nil, &Error{http.StatusText(resp.StatusCode)}
+		}
+		return nil, &re
+	}
+	return resp, nil
+}
+
+// readEvents reads the server-sent events from the reader and passes
+// the data of each event to the given function until it returns false.
+func (c *client) readEvents(r io.Reader, fn func(data []byte) bool) {
+	// Each event is sent as a set of lines terminated by an empty
+	// line, the data of the event is a JSON-encoded value.
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, maxEventSize)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !strings.HasPrefix(line, "data:") {
+			continue
+		}
+
+		data := strings.TrimSpace(strings.TrimPrefix(line, "data:"))
+		if !fn([]byte(data)) {
+			return
+		}
+	}
+}
+
+// Watch implements Client interface.
+func (c *client) Watch(ctx context.Context,
+	opts *WatchOptions) <-chan *WatchEvent {
+
+	events := make(chan *WatchEvent)
+	go func() {
+		defer close(events)
+		index := opts.Index
+
+		for {
+			u := c.urlOf(fmt.Sprintf("/v1/keys/%s", opts.Key))
+			u.RawQuery = url.Values{
+				"wait":  []string{"true"},
+				"index": []string{strconv.FormatInt(index, 10)},
+			}.Encode()
+
+			ev := &WatchEvent{Response: new(Response)}
+			if ev.Err = c.do(ctx, "GET", u, nil, ev.Response); ev.Err != nil {
+				ev.Response = nil
+			}
+			if ctx.Err() != nil {
+				return
+			}
+
+			select {
+			case events <- ev:
+			case <-ctx.Done():
+				return
+			}
+			if ev.Err != nil {
+				return
+			}
+
+			// Index of the created record starts from one, so after
+			// deletion wait for any new record.
+			index = ev.Meta.Index
+			if ev.Action != "store" {
+				index = 0
+			}
+		}
+	}()
+	return events
+}
+
 // Store implements Client interface.
 func (c *client) Store(ctx context.Context,
 	opts *StoreOptions) (resp *Response, err error) {
 
+	// Make the request conditional, when the expected index of the
+	// record is specified.
+	header := c.consistencyHeader(opts.Consistency)
+	if opts.ExpectedIndex != 0 {
+		index := strconv.FormatInt(opts.ExpectedIndex, 10)
+		header.Set("If-Match", strconv.Quote(index))
+	}
+	if opts.Absent {
+		header.Set("If-None-Match", "*")
+	}
+
 	resp = new(Response)
 	path := fmt.Sprintf("/v1/keys/%s", opts.Key)
-	err = c.do(ctx, "PUT", c.urlOf(path), opts, resp)
+	err = c.doHeader(ctx, "PUT", c.urlOf(path), header, opts, resp)
 	if err != nil {
 		return nil, err
 	}
@@ -354,8 +1295,9 @@ func (c *client) Delete(ctx context.Context,
 	opts *DeleteOptions) (resp *Response, err error) {
 
 	resp = new(Response)
+	header := c.consistencyHeader(opts.Consistency)
 	path := fmt.Sprintf("/v1/keys/%s", opts.Key)
-	err = c.do(ctx, "DELETE", c.urlOf(path), nil, resp)
+	err = c.doHeader(ctx, "DELETE", c.urlOf(path), header, nil, resp)
 	if err != nil {
 		return nil, err
 	}
@@ -387,3 +1329,211 @@ func (c *client) ListIndex(ctx context.Context,
 	}
 	return resp, err
 }
+
+// Incr implements Client interface.
+func (c *client) Incr(ctx context.Context,
+	opts *CounterOptions) (resp *Response, err error) {
+
+	resp = new(Response)
+	path := fmt.Sprintf("/v1/keys/%s/incr", opts.Key)
+	err = c.do(ctx, "POST", c.urlOf(path), opts, resp)
+	if err != nil {
+		return nil, err
+	}
+	return resp, err
+}
+
+// Decr implements Client interface.
+func (c *client) Decr(ctx context.Context,
+	opts *CounterOptions) (resp *Response, err error) {
+
+	resp = new(Response)
+	path := fmt.Sprintf("/v1/keys/%s/decr", opts.Key)
+	err = c.do(ctx, "POST", c.urlOf(path), opts, resp)
+	if err != nil {
+		return nil, err
+	}
+	return resp, err
+}
+
+// ListRange implements Client interface.
+func (c *client) ListRange(ctx context.Context,
+	opts *ListRangeOptions) (resp *Response, err error) {
+
+	resp = new(Response)
+	path := fmt.Sprintf("/v1/keys/%s/list", opts.Key)
+	err = c.do(ctx, "GET", c.urlOf(path), opts, resp)
+	if err != nil {
+		return nil, err
+	}
+	return resp, err
+}
+
+// ListPush implements Client interface.
+func (c *client) ListPush(ctx context.Context,
+	opts *ListPushOptions) (resp *Response, err error) {
+
+	resp = new(Response)
+	path := fmt.Sprintf("/v1/keys/%s/list/push", opts.Key)
+	err = c.do(ctx, "POST", c.urlOf(path), opts, resp)
+	if err != nil {
+		return nil, err
+	}
+	return resp, err
+}
+
+// ListPop implements Client interface.
+func (c *client) ListPop(ctx context.Context,
+	opts *ListPopOptions) (resp *Response, err error) {
+
+	resp = new(Response)
+	path := fmt.Sprintf("/v1/keys/%s/list/pop", opts.Key)
+	err = c.do(ctx, "POST", c.urlOf(path), opts, resp)
+	if err != nil {
+		return nil, err
+	}
+	return resp, err
+}
+
+// ListSet implements Client interface.
+func (c *client) ListSet(ctx context.Context,
+	opts *ListSetOptions) (resp *Response, err error) {
+
+	resp = new(Response)
+	path := fmt.Sprintf("/v1/keys/%s/list/index", opts.Key)
+	err = c.do(ctx, "PUT", c.urlOf(path), opts, resp)
+	if err != nil {
+		return nil, err
+	}
+	return resp, err
+}
+
+// ListRemove implements Client interface.
+func (c *client) ListRemove(ctx context.Context,
+	opts *ListRemoveOptions) (resp *Response, err error) {
+
+	resp = new(Response)
+	path := fmt.Sprintf("/v1/keys/%s/list/index", opts.Key)
+	err = c.do(ctx, "DELETE", c.urlOf(path), opts, resp)
+	if err != nil {
+		return nil, err
+	}
+	return resp, err
+}
+
+// ListTrim implements Client interface.
+func (c *client) ListTrim(ctx context.Context,
+	opts *ListTrimOptions) (resp *Response, err error) {
+
+	resp = new(Response)
+	path := fmt.Sprintf("/v1/keys/%s/list/trim", opts.Key)
+	err = c.do(ctx, "POST", c.urlOf(path), opts, resp)
+	if err != nil {
+		return nil, err
+	}
+	return resp, err
+}
+
+// DictSetItem implements Client interface.
+func (c *client) DictSetItem(ctx context.Context,
+	opts *DictSetItemOptions) (resp *Response, err error) {
+
+	resp = new(Response)
+	path := fmt.Sprintf("/v1/keys/%s/item", opts.Key)
+	err = c.do(ctx, "PUT", c.urlOf(path), opts, resp)
+	if err != nil {
+		return nil, err
+	}
+	return resp, err
+}
+
+// DictDeleteItem implements Client interface.
+func (c *client) DictDeleteItem(ctx context.Context,
+	opts *DictDeleteItemOptions) (resp *Response, err error) {
+
+	resp = new(Response)
+	path := fmt.Sprintf("/v1/keys/%s/item", opts.Key)
+	err = c.do(ctx, "DELETE", c.urlOf(path), opts, resp)
+	if err != nil {
+		return nil, err
+	}
+	return resp, err
+}
+
+// DictFields implements Client interface.
+func (c *client) DictFields(ctx context.Context,
+	opts *DictFieldsOptions) (resp *Response, err error) {
+
+	resp = new(Response)
+	path := fmt.Sprintf("/v1/keys/%s/fields", opts.Key)
+	err = c.do(ctx, "GET", c.urlOf(path), nil, resp)
+	if err != nil {
+		return nil, err
+	}
+	return resp, err
+}
+
+// DictItems implements Client interface.
+func (c *client) DictItems(ctx context.Context,
+	opts *DictItemsOptions) (resp *Response, err error) {
+
+	resp = new(Response)
+	path := fmt.Sprintf("/v1/keys/%s/items", opts.Key)
+	err = c.do(ctx, "GET", c.urlOf(path), opts, resp)
+	if err != nil {
+		return nil, err
+	}
+	return resp, err
+}
+
+// DictMerge implements Client interface.
+func (c *client) DictMerge(ctx context.Context,
+	opts *DictMergeOptions) (resp *Response, err error) {
+
+	resp = new(Response)
+	path := fmt.Sprintf("/v1/keys/%s", opts.Key)
+	err = c.do(ctx, "PATCH", c.urlOf(path), opts, resp)
+	if err != nil {
+		return nil, err
+	}
+	return resp, err
+}
+
+// Expire implements Client interface.
+func (c *client) Expire(ctx context.Context,
+	opts *ExpireOptions) (resp *Response, err error) {
+
+	resp = new(Response)
+	path := fmt.Sprintf("/v1/keys/%s/ttl", opts.Key)
+	err = c.do(ctx, "PATCH", c.urlOf(path), opts, resp)
+	if err != nil {
+		return nil, err
+	}
+	return resp, err
+}
+
+// Persist implements Client interface.
+func (c *client) Persist(ctx context.Context,
+	opts *PersistOptions) (resp *Response, err error) {
+
+	resp = new(Response)
+	path := fmt.Sprintf("/v1/keys/%s/ttl", opts.Key)
+	err = c.do(ctx, "DELETE", c.urlOf(path), nil, resp)
+	if err != nil {
+		return nil, err
+	}
+	return resp, err
+}
+
+// TTL implements Client interface.
+func (c *client) TTL(ctx context.Context,
+	opts *TTLOptions) (resp *Response, err error) {
+
+	resp = new(Response)
+	path := fmt.Sprintf("/v1/keys/%s/ttl", opts.Key)
+	err = c.do(ctx, "GET", c.urlOf(path), nil, resp)
+	if err != nil {
+		return nil, err
+	}
+	return resp, err
+}
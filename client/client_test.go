@@ -3,12 +3,14 @@ package client
 import (
 	"context"
 	"encoding/json"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
 	"reflect"
 	"sync"
 	"testing"
+	"time"
 )
 
 func newTest(handler http.HandlerFunc) (*httptest.Server, Client) {
@@ -60,6 +62,85 @@ func TestClientKeys(t *testing.T) {
 	}
 }
 
+func TestClientClusterKeys(t *testing.T) {
+	handler := func(rw http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("scope") != "cluster" {
+			t.Fatalf("invalid scope of keys requested: %s", r.URL)
+		}
+		json.NewEncoder(rw).Encode(ClusterKeysResponse{
+			Keys:   []string{"1", "2"},
+			Errors: []NodeError{{Addr: "127.0.0.1:2372", Text: "boom"}},
+		})
+	}
+
+	s, c := newTest(handler)
+	defer s.Close()
+
+	resp, err := c.ClusterKeys(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error returned: %s", err)
+	}
+	if !reflect.DeepEqual(resp.Keys, []string{"1", "2"}) {
+		t.Fatalf("invalid list of keys returned: %v", resp.Keys)
+	}
+	if len(resp.Errors) != 1 || resp.Errors[0].Text != "boom" {
+		t.Fatalf("invalid list of errors returned: %v", resp.Errors)
+	}
+}
+
+func TestClientIterate(t *testing.T) {
+	var nodes []Node
+
+	// Each node returns two pages of keys, the first page of the
+	// second node is empty.
+	pages := map[string][]ScanResponse{
+		"a": {{Cursor: "0:a1", Keys: []string{"a1"}}, {Keys: []string{"a2"}}},
+		"b": {{Cursor: "1:"}, {Keys: []string{"b1", "b2"}}},
+	}
+
+	handlerOf := func(name string) http.HandlerFunc {
+		return func(rw http.ResponseWriter, r *http.Request) {
+			enc := json.NewEncoder(rw)
+			if r.URL.Path == "/v1/nodes" {
+				enc.Encode(nodes)
+				return
+			}
+
+			if r.URL.Query().Get("match") != "*" {
+				t.Fatalf("scan options should be passed: %s", r.URL)
+			}
+			page := 0
+			if r.URL.Query().Get("cursor") != "" {
+				page = 1
+			}
+			enc.Encode(pages[name][page])
+		}
+	}
+
+	sa := httptest.NewServer(handlerOf("a"))
+	defer sa.Close()
+	sb := httptest.NewServer(handlerOf("b"))
+	defer sb.Close()
+
+	ua, _ := url.Parse(sa.URL)
+	ub, _ := url.Parse(sb.URL)
+	nodes = []Node{{Addr: ua.Host}, {Addr: ub.Host}}
+
+	c := NewClient(&Config{Host: ua.Host})
+	it := c.Iterate(&ScanOptions{Match: "*"})
+
+	var keys []string
+	for it.Next(context.Background()) {
+		keys = append(keys, it.Key())
+	}
+	if it.Err() != nil {
+		t.Fatalf("unexpected error returned: %s", it.Err())
+	}
+	if !reflect.DeepEqual(keys, []string{"a1", "a2", "b1", "b2"}) {
+		t.Fatalf("invalid list of keys returned: %v", keys)
+	}
+}
+
 func TestClientLoad(t *testing.T) {
 	handler := func(rw http.ResponseWriter, r *http.Request) {
 		if r.Method == "GET" && r.RequestURI == "/v1/keys/1" {
@@ -81,6 +162,142 @@ func TestClientLoad(t *testing.T) {
 	}
 }
 
+func TestClientLoadMany(t *testing.T) {
+	handler := func(rw http.ResponseWriter, r *http.Request) {
+		var opts LoadManyOptions
+		json.NewDecoder(r.Body).Decode(&opts)
+		if r.Method != "POST" || r.URL.Path != "/v1/batch/load" {
+			t.Fatalf("invalid request: %s %s", r.Method, r.URL)
+		}
+
+		var resp BatchResponse
+		for _, key := range opts.Keys {
+			resp.Results = append(resp.Results, BatchResult{
+				Key: key, Status: http.StatusOK, Data: key,
+			})
+		}
+		json.NewEncoder(rw).Encode(resp)
+	}
+
+	s, c := newTest(handler)
+	defer s.Close()
+
+	resp, err := c.LoadMany(context.Background(),
+		&LoadManyOptions{Keys: []string{"1", "2"}})
+	if err != nil {
+		t.Fatalf("unexpected error returned: %s", err)
+	}
+	if len(resp.Results) != 2 || resp.Results[1].Data != "2" {
+		t.Fatalf("invalid results returned: %v", resp.Results)
+	}
+}
+
+func TestClientStoreMany(t *testing.T) {
+	handler := func(rw http.ResponseWriter, r *http.Request) {
+		var opts StoreManyOptions
+		json.NewDecoder(r.Body).Decode(&opts)
+		if r.Method != "POST" || r.URL.Path != "/v1/batch/store" {
+			t.Fatalf("invalid request: %s %s", r.Method, r.URL)
+		}
+		if len(opts.Records) != 1 || opts.Records[0].Key != "1" {
+			t.Fatalf("invalid records sent: %v", opts.Records)
+		}
+
+		json.NewEncoder(rw).Encode(BatchResponse{Results: []BatchResult{
+			{Key: "1", Status: http.StatusInsufficientStorage, Error: "full"},
+		}})
+	}
+
+	s, c := newTest(handler)
+	defer s.Close()
+
+	resp, err := c.StoreMany(context.Background(), &StoreManyOptions{
+		Records: []StoreRecord{{Key: "1", Data: 42}}})
+	if err != nil {
+		t.Fatalf("unexpected error returned: %s", err)
+	}
+	if len(resp.Results) != 1 || resp.Results[0].Error != "full" {
+		t.Fatalf("invalid results returned: %v", resp.Results)
+	}
+}
+
+func TestClientTransaction(t *testing.T) {
+	handler := func(rw http.ResponseWriter, r *http.Request) {
+		var opts TransactionOptions
+		json.NewDecoder(r.Body).Decode(&opts)
+		if r.Method != "POST" || r.URL.Path != "/v1/transaction" {
+			t.Fatalf("invalid request: %s %s", r.Method, r.URL)
+		}
+		if len(opts.Requests) != 2 || opts.Preconditions[0].Index != 3 {
+			t.Fatalf("invalid transaction sent: %v", opts)
+		}
+
+		json.NewEncoder(rw).Encode(TransactionResponse{Results: []Response{
+			{Action: "store", Data: "a"}, {Action: "incr", Data: 1},
+		}})
+	}
+
+	s, c := newTest(handler)
+	defer s.Close()
+
+	resp, err := c.Transaction(context.Background(), &TransactionOptions{
+		Requests: []TransactionRequest{
+			{Action: "store", Key: "{u}:a", Data: "a"},
+			{Action: "incr", Key: "{u}:b"},
+		},
+		Preconditions: []Precondition{{Key: "{u}:a", Index: 3}},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error returned: %s", err)
+	}
+	if len(resp.Results) != 2 || resp.Results[1].Action != "incr" {
+		t.Fatalf("invalid results returned: %v", resp.Results)
+	}
+}
+
+func TestClientWatch(t *testing.T) {
+	var indices []string
+	handler := func(rw http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("wait") != "true" {
+			t.Fatalf("wait parameter expected: %s", r.URL)
+		}
+
+		index := r.URL.Query().Get("index")
+		indices = append(indices, index)
+
+		resp := Response{Action: "store", Meta: Meta{Index: 2}}
+		switch index {
+		case "2":
+			resp = Response{Action: "delete", Meta: Meta{Index: 2}}
+		case "0":
+			rw.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(rw).Encode(Error{"boom"})
+			return
+		}
+		json.NewEncoder(rw).Encode(resp)
+	}
+
+	s, c := newTest(handler)
+	defer s.Close()
+
+	events := c.Watch(context.Background(), &WatchOptions{Key: "1", Index: 1})
+	var actions []string
+	for ev := range events {
+		if ev.Err != nil {
+			actions = append(actions, ev.Err.Error())
+			continue
+		}
+		actions = append(actions, ev.Action)
+	}
+
+	if !reflect.DeepEqual(actions, []string{"store", "delete", "boom"}) {
+		t.Fatalf("invalid list of events: %v", actions)
+	}
+	if !reflect.DeepEqual(indices, []string{"1", "2", "0"}) {
+		t.Fatalf("invalid list of indices: %v", indices)
+	}
+}
+
 func TestClientStore(t *testing.T) {
 	ch := make(chan interface{}, 1)
 	handler := func(rw http.ResponseWriter, r *http.Request) {
@@ -110,6 +327,84 @@ func TestClientStore(t *testing.T) {
 	}
 }
 
+func TestClientConsistency(t *testing.T) {
+	ch := make(chan string, 1)
+	handler := func(rw http.ResponseWriter, r *http.Request) {
+		ch <- r.Header.Get(headerConsistency)
+		enc := json.NewEncoder(rw)
+		enc.Encode(Response{Data: "hello"})
+	}
+
+	s := httptest.NewServer(http.HandlerFunc(handler))
+	defer s.Close()
+
+	u, _ := url.Parse(s.URL)
+	c := NewClient(&Config{Host: u.Host, Consistency: ConsistencyQuorum})
+
+	_, err := c.Load(context.Background(), &LoadOptions{Key: "1"})
+	if err != nil {
+		t.Fatalf("unexpected error returned: %s", err)
+	}
+	if level := <-ch; level != ConsistencyQuorum {
+		t.Fatalf("default consistency level expected: %s", level)
+	}
+
+	opts := &StoreOptions{Key: "1", Data: "hello",
+		Consistency: ConsistencyAll}
+	if _, err = c.Store(context.Background(), opts); err != nil {
+		t.Fatalf("unexpected error returned: %s", err)
+	}
+	if level := <-ch; level != ConsistencyAll {
+		t.Fatalf("requested consistency level expected: %s", level)
+	}
+}
+
+func TestClientStoreExpectedIndex(t *testing.T) {
+	ch := make(chan string, 1)
+	handler := func(rw http.ResponseWriter, r *http.Request) {
+		if r.Method == "PUT" && r.RequestURI == "/v1/keys/2" {
+			ch <- r.Header.Get("If-Match")
+
+			enc := json.NewEncoder(rw)
+			enc.Encode(Response{Meta: Meta{Index: 8}})
+		}
+	}
+
+	s, c := newTest(handler)
+	defer s.Close()
+
+	opts := &StoreOptions{Key: "2", Data: "hello", ExpectedIndex: 7}
+	if _, err := c.Store(context.Background(), opts); err != nil {
+		t.Fatalf("unexpected error returned: %s", err)
+	}
+	if etag := <-ch; etag != `"7"` {
+		t.Fatalf("invalid If-Match header: %s", etag)
+	}
+}
+
+func TestClientStoreAbsent(t *testing.T) {
+	ch := make(chan string, 1)
+	handler := func(rw http.ResponseWriter, r *http.Request) {
+		if r.Method == "PUT" && r.RequestURI == "/v1/keys/2" {
+			ch <- r.Header.Get("If-None-Match")
+
+			enc := json.NewEncoder(rw)
+			enc.Encode(Response{Meta: Meta{Index: 1}})
+		}
+	}
+
+	s, c := newTest(handler)
+	defer s.Close()
+
+	opts := &StoreOptions{Key: "2", Data: "hello", Absent: true}
+	if _, err := c.Store(context.Background(), opts); err != nil {
+		t.Fatalf("unexpected error returned: %s", err)
+	}
+	if etag := <-ch; etag != "*" {
+		t.Fatalf("invalid If-None-Match header: %s", etag)
+	}
+}
+
 func TestClientDelete(t *testing.T) {
 	handler := func(rw http.ResponseWriter, r *http.Request) {
 		if r.Method == "DELETE" && r.RequestURI == "/v1/keys/3" {
@@ -181,6 +476,202 @@ func TestClientListIndex(t *testing.T) {
 	}
 }
 
+func TestClientIncr(t *testing.T) {
+	handler := func(rw http.ResponseWriter, r *http.Request) {
+		if r.Method == "POST" && r.RequestURI == "/v1/keys/5/incr" {
+			var opts CounterOptions
+			dec := json.NewDecoder(r.Body)
+			dec.Decode(&opts)
+
+			enc := json.NewEncoder(rw)
+			enc.Encode(Response{Data: opts.Delta})
+		}
+	}
+
+	s, c := newTest(handler)
+	defer s.Close()
+
+	opts := &CounterOptions{Key: "5", Delta: 3}
+	resp, err := c.Incr(context.Background(), opts)
+	if err != nil {
+		t.Fatalf("unexpected error returned: %s", err)
+	}
+	if resp.Data.(float64) != 3 {
+		t.Fatalf("invalid data returned: %v", resp.Data)
+	}
+}
+
+func TestClientTTL(t *testing.T) {
+	handler := func(rw http.ResponseWriter, r *http.Request) {
+		if r.RequestURI != "/v1/keys/5/ttl" {
+			return
+		}
+
+		enc := json.NewEncoder(rw)
+		switch r.Method {
+		case "PATCH":
+			var opts ExpireOptions
+			dec := json.NewDecoder(r.Body)
+			dec.Decode(&opts)
+			enc.Encode(Response{Meta: Meta{ExpireTime: opts.ExpireTime}})
+		case "DELETE":
+			enc.Encode(Response{Meta: Meta{}})
+		case "GET":
+			enc.Encode(Response{Data: Duration(time.Minute)})
+		}
+	}
+
+	s, c := newTest(handler)
+	defer s.Close()
+
+	ctx := context.Background()
+	resp, err := c.Expire(ctx, &ExpireOptions{
+		Key: "5", ExpireTime: Duration(time.Hour)})
+	if err != nil {
+		t.Fatalf("unexpected error returned: %s", err)
+	}
+	if resp.Meta.ExpireTime != Duration(time.Hour) {
+		t.Fatalf("invalid expiration time returned: %v", resp.Meta.ExpireTime)
+	}
+
+	resp, err = c.Persist(ctx, &PersistOptions{Key: "5"})
+	if err != nil {
+		t.Fatalf("unexpected error returned: %s", err)
+	}
+	if resp.Meta.ExpireTime != 0 {
+		t.Fatalf("record should be permanent")
+	}
+
+	resp, err = c.TTL(ctx, &TTLOptions{Key: "5"})
+	if err != nil {
+		t.Fatalf("unexpected error returned: %s", err)
+	}
+	if resp.Data.(string) != "1m0s" {
+		t.Fatalf("invalid data returned: %v", resp.Data)
+	}
+}
+
+func TestClientDecr(t *testing.T) {
+	handler := func(rw http.ResponseWriter, r *http.Request) {
+		if r.Method == "POST" && r.RequestURI == "/v1/keys/5/decr" {
+			enc := json.NewEncoder(rw)
+			enc.Encode(Response{Data: -1})
+		}
+	}
+
+	s, c := newTest(handler)
+	defer s.Close()
+
+	opts := &CounterOptions{Key: "5"}
+	resp, err := c.Decr(context.Background(), opts)
+	if err != nil {
+		t.Fatalf("unexpected error returned: %s", err)
+	}
+	if resp.Data.(float64) != -1 {
+		t.Fatalf("invalid data returned: %v", resp.Data)
+	}
+}
+
+func TestClientList(t *testing.T) {
+	var method, uri string
+	handler := func(rw http.ResponseWriter, r *http.Request) {
+		method, uri = r.Method, r.RequestURI
+
+		var opts map[string]interface{}
+		dec := json.NewDecoder(r.Body)
+		dec.Decode(&opts)
+
+		enc := json.NewEncoder(rw)
+		enc.Encode(Response{Data: opts})
+	}
+
+	s, c := newTest(handler)
+	defer s.Close()
+
+	ctx := context.Background()
+	tests := []struct {
+		Call   func() (*Response, error)
+		Method string
+		URI    string
+	}{
+		{func() (*Response, error) {
+			return c.ListRange(ctx, &ListRangeOptions{Key: "7", Stop: -1})
+		}, "GET", "/v1/keys/7/list"},
+		{func() (*Response, error) {
+			return c.ListPush(ctx, &ListPushOptions{Key: "7", Data: 1})
+		}, "POST", "/v1/keys/7/list/push"},
+		{func() (*Response, error) {
+			return c.ListPop(ctx, &ListPopOptions{Key: "7", Front: true})
+		}, "POST", "/v1/keys/7/list/pop"},
+		{func() (*Response, error) {
+			return c.ListSet(ctx, &ListSetOptions{Key: "7", Index: 1})
+		}, "PUT", "/v1/keys/7/list/index"},
+		{func() (*Response, error) {
+			return c.ListRemove(ctx, &ListRemoveOptions{Key: "7", Index: 1})
+		}, "DELETE", "/v1/keys/7/list/index"},
+		{func() (*Response, error) {
+			return c.ListTrim(ctx, &ListTrimOptions{Key: "7", Stop: 2})
+		}, "POST", "/v1/keys/7/list/trim"},
+	}
+
+	for _, tt := range tests {
+		if _, err := tt.Call(); err != nil {
+			t.Fatalf("unexpected error returned: %s", err)
+		}
+		if method != tt.Method || uri != tt.URI {
+			t.Fatalf("invalid request sent: %s %s", method, uri)
+		}
+	}
+}
+
+func TestClientDict(t *testing.T) {
+	var method, uri string
+	handler := func(rw http.ResponseWriter, r *http.Request) {
+		method, uri = r.Method, r.RequestURI
+		enc := json.NewEncoder(rw)
+		enc.Encode(Response{Data: 1})
+	}
+
+	s, c := newTest(handler)
+	defer s.Close()
+
+	ctx := context.Background()
+	tests := []struct {
+		Call   func() (*Response, error)
+		Method string
+		URI    string
+	}{
+		{func() (*Response, error) {
+			opts := &DictSetItemOptions{Key: "9", Item: "a", Data: 1}
+			return c.DictSetItem(ctx, opts)
+		}, "PUT", "/v1/keys/9/item"},
+		{func() (*Response, error) {
+			opts := &DictDeleteItemOptions{Key: "9", Item: "a"}
+			return c.DictDeleteItem(ctx, opts)
+		}, "DELETE", "/v1/keys/9/item"},
+		{func() (*Response, error) {
+			return c.DictFields(ctx, &DictFieldsOptions{Key: "9"})
+		}, "GET", "/v1/keys/9/fields"},
+		{func() (*Response, error) {
+			opts := &DictItemsOptions{Key: "9", Items: []interface{}{"a"}}
+			return c.DictItems(ctx, opts)
+		}, "GET", "/v1/keys/9/items"},
+		{func() (*Response, error) {
+			opts := &DictMergeOptions{Key: "9", Data: map[string]int{"a": 1}}
+			return c.DictMerge(ctx, opts)
+		}, "PATCH", "/v1/keys/9"},
+	}
+
+	for _, tt := range tests {
+		if _, err := tt.Call(); err != nil {
+			t.Fatalf("unexpected error returned: %s", err)
+		}
+		if method != tt.Method || uri != tt.URI {
+			t.Fatalf("invalid request sent: %s %s", method, uri)
+		}
+	}
+}
+
 func TestClientError(t *testing.T) {
 	handler := func(rw http.ResponseWriter, r *http.Request) {
 		rw.WriteHeader(http.StatusNotAcceptable)
@@ -196,3 +687,139 @@ func TestClientError(t *testing.T) {
 		t.Fatalf("invalid error returned: %v", err)
 	}
 }
+
+func TestClientEvents(t *testing.T) {
+	handler := func(rw http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		if query.Get("types") != "store,delete" {
+			rw.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(rw).Encode(Error{"invalid event type"})
+			return
+		}
+		if query.Get("prefix") != "user:" {
+			t.Fatalf("invalid prefix requested: %s", r.URL)
+		}
+
+		rw.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(rw, ": keep-alive\n\n")
+		fmt.Fprint(rw, "event: store\ndata: {\"type\":\"store\",\"key\":\"user:1\"}\n\n")
+		fmt.Fprint(rw, "event: delete\ndata: {\"type\":\"delete\",\"key\":\"user:1\"}\n\n")
+	}
+
+	s, c := newTest(handler)
+	defer s.Close()
+
+	opts := &EventsOptions{Prefix: "user:", Types: []string{"store", "delete"}}
+	events, err := c.Events(context.Background(), opts)
+	if err != nil {
+		t.Fatalf("failed to subscribe to events: %s", err)
+	}
+
+	var types []string
+	for ev := range events {
+		if ev.Key != "user:1" {
+			t.Fatalf("invalid key of event: %s", ev.Key)
+		}
+		types = append(types, ev.Type)
+	}
+	if !reflect.DeepEqual(types, []string{"store", "delete"}) {
+		t.Fatalf("invalid events received: %v", types)
+	}
+
+	opts = &EventsOptions{Types: []string{"update"}}
+	_, err = c.Events(context.Background(), opts)
+	if err == nil || err.Error() != "invalid event type" {
+		t.Fatalf("error expected, got: %v", err)
+	}
+}
+
+func TestClientPublish(t *testing.T) {
+	handler := func(rw http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" || r.URL.Path != "/v1/channels/news" {
+			t.Fatalf("invalid request: %s %s", r.Method, r.URL)
+		}
+
+		var opts PublishOptions
+		json.NewDecoder(r.Body).Decode(&opts)
+		if opts.Data != "a" {
+			t.Fatalf("invalid message published: %v", opts.Data)
+		}
+		json.NewEncoder(rw).Encode(PublishResponse{Receivers: 2,
+			Errors: []NodeError{{Addr: "127.0.0.1:2372", Text: "EOF"}}})
+	}
+
+	s, c := newTest(handler)
+	defer s.Close()
+
+	opts := &PublishOptions{Channel: "news", Data: "a"}
+	resp, err := c.Publish(context.Background(), opts)
+	if err != nil {
+		t.Fatalf("failed to publish message: %s", err)
+	}
+	if resp.Receivers != 2 || len(resp.Errors) != 1 {
+		t.Fatalf("invalid response returned: %v", resp)
+	}
+}
+
+func TestClientMembership(t *testing.T) {
+	nodes := []Node{{Addr: "127.0.0.1:2371"}, {Addr: "127.0.0.1:2372"}}
+	handler := func(rw http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == "POST" && r.URL.Path == "/v1/nodes":
+			var opts JoinOptions
+			json.NewDecoder(r.Body).Decode(&opts)
+			if opts.Addr != "127.0.0.1:2372" {
+				t.Fatalf("invalid address of joining node: %s", opts.Addr)
+			}
+			json.NewEncoder(rw).Encode(nodes)
+		case r.Method == "DELETE" && r.URL.Path == "/v1/nodes/127.0.0.1:2372":
+			json.NewEncoder(rw).Encode(nodes[:1])
+		default:
+			t.Fatalf("invalid request: %s %s", r.Method, r.URL)
+		}
+	}
+
+	s, c := newTest(handler)
+	defer s.Close()
+
+	ctx := context.Background()
+	resp, err := c.Join(ctx, &JoinOptions{Addr: "127.0.0.1:2372"})
+	if err != nil || !reflect.DeepEqual(resp, nodes) {
+		t.Fatalf("invalid nodes returned: %v, %v", resp, err)
+	}
+
+	resp, err = c.Leave(ctx, &LeaveOptions{Addr: "127.0.0.1:2372"})
+	if err != nil || !reflect.DeepEqual(resp, nodes[:1]) {
+		t.Fatalf("invalid nodes returned: %v, %v", resp, err)
+	}
+}
+
+func TestClientSubscribe(t *testing.T) {
+	handler := func(rw http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/channels/news" {
+			t.Fatalf("invalid channel requested: %s", r.URL)
+		}
+
+		rw.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(rw, ": keep-alive\n\n")
+		fmt.Fprint(rw, "event: message\ndata: {\"channel\":\"news\",\"data\":\"a\"}\n\n")
+		fmt.Fprint(rw, "event: message\ndata: {\"channel\":\"news\",\"data\":\"b\"}\n\n")
+	}
+
+	s, c := newTest(handler)
+	defer s.Close()
+
+	opts := &SubscribeOptions{Channel: "news"}
+	messages, err := c.Subscribe(context.Background(), opts)
+	if err != nil {
+		t.Fatalf("failed to subscribe to channel: %s", err)
+	}
+
+	var data []interface{}
+	for msg := range messages {
+		data = append(data, msg.Data)
+	}
+	if !reflect.DeepEqual(data, []interface{}{"a", "b"}) {
+		t.Fatalf("invalid messages received: %v", data)
+	}
+}
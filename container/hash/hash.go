@@ -19,6 +19,10 @@ type Meta struct {
 	// equal to zero, record won't be ever evicted from the storage.
 	ExpireTime time.Duration
 
+	// Sliding defines whether the time to live is counted from the
+	// last access or update of the record instead of its creation.
+	Sliding bool
+
 	// AccessedAt defines a moment when the record was accessed last
 	// time.
 	AccessedAt time.Time
@@ -50,10 +54,27 @@ func (r *Record) IsExpired() bool {
 
 	now := time.Now()
 	// Calculate how much data is presented in a storage.
-	live := now.Sub(r.Meta.CreatedAt)
+	live := now.Sub(r.liveSince())
 	return live > r.Meta.ExpireTime
 }
 
+// liveSince returns a moment since which the lifetime of the record is
+// counted. For sliding records it is the last access or update time.
+func (r *Record) liveSince() time.Time {
+	if !r.Meta.Sliding {
+		return r.Meta.CreatedAt
+	}
+
+	since := r.Meta.CreatedAt
+	if r.Meta.UpdatedAt.After(since) {
+		since = r.Meta.UpdatedAt
+	}
+	if r.Meta.AccessedAt.After(since) {
+		since = r.Meta.AccessedAt
+	}
+	return since
+}
+
 // IsPermanent returns true, when expiration time is less than or equal
 // to zero, and false otherwise.
 func (r *Record) IsPermanent() bool {
@@ -62,7 +83,7 @@ func (r *Record) IsPermanent() bool {
 
 // ExpiresAt returns a moment in a future, when the record expires.
 func (r *Record) ExpiresAt() time.Time {
-	return r.Meta.CreatedAt.Add(r.Meta.ExpireTime)
+	return r.liveSince().Add(r.Meta.ExpireTime)
 }
 
 // Hash describes types that implement hashing table.
@@ -73,9 +94,17 @@ type Hash interface {
 	// Load returns a record persisted under the given key.
 	Load(key string) (Record, bool)
 
+	// Peek returns a record persisted under the given key without
+	// updating the access time of the record.
+	Peek(key string) (Record, bool)
+
 	// Store persists the record under the given key.
 	Store(key string, rec Record) Record
 
+	// Restore persists the record under the given key as is, including
+	// the metadata of the record.
+	Restore(key string, rec Record) Record
+
 	// Delete removes the record stored under the given key.
 	Delete(key string)
 }
@@ -139,32 +168,62 @@ func (h *unsafeHash) Load(key string) (rec Record, ok bool) {
 
 	// Update accessed time of the record in a hash table.
 	rec.Meta.AccessedAt = time.Now()
+	h.records[key] = rec
 	return rec, true
 }
 
+// Peek implements Hash interface.
+func (h *unsafeHash) Peek(key string) (rec Record, ok bool) {
+	rec, ok = h.records[key]
+	return rec, ok
+}
+
 // Store implements Hash interface.
 func (h *unsafeHash) Store(key string, rec Record) Record {
 	prevrec, ok := h.records[key]
 	if !ok {
-		// Create a new record, when it is missing in the hash table.
-		prevrec = Record{Meta: Meta{CreatedAt: time.Now()}}
+		h.appendKey(key)
 	}
 
-	// Append a new key into the list of keys only when it is not
-	// dirty, otherwise, it will re-constructed during access of keys.
-	if !h.dirty {
-		h.keys = append(h.keys, key)
+	rec = Update(prevrec, ok, rec)
+	h.records[key] = rec
+	return rec
+}
+
+// Update returns a new version of the record, that replaces the data
+// and the expiration settings of the previous record, ok reports whether
+// the previous record exists. This is the record persisted by Store.
+func Update(prevrec Record, ok bool, rec Record) Record {
+	if !ok {
+		// Create a new record, when it is missing in the hash table.
+		prevrec = Record{Meta: Meta{CreatedAt: time.Now()}}
 	}
 
 	prevrec.Meta.Index++
 	prevrec.Meta.UpdatedAt = time.Now()
 	prevrec.Meta.ExpireTime = rec.Meta.ExpireTime
+	prevrec.Meta.Sliding = rec.Meta.Sliding
 	prevrec.Data = rec.Data
-
-	h.records[key] = prevrec
 	return prevrec
 }
 
+// Restore implements Hash interface.
+func (h *unsafeHash) Restore(key string, rec Record) Record {
+	if _, ok := h.records[key]; !ok {
+		h.appendKey(key)
+	}
+	h.records[key] = rec
+	return rec
+}
+
+// appendKey appends a new key into the list of keys only when it is not
+// dirty, otherwise, it will re-constructed during access of keys.
+func (h *unsafeHash) appendKey(key string) {
+	if !h.dirty {
+		h.keys = append(h.keys, key)
+	}
+}
+
 // Delete implements Hash interface.
 func (h *unsafeHash) Delete(key string) {
 	// Mark the keys as dirty only when the key to delete is presented
@@ -27,6 +27,29 @@ func TestRecordIsExpired(t *testing.T) {
 	}
 }
 
+func TestRecordIsExpiredSliding(t *testing.T) {
+	now := time.Now()
+	r := Record{Meta: Meta{
+		ExpireTime: time.Minute,
+		Sliding:    true,
+		CreatedAt:  now.Add(-time.Hour),
+		UpdatedAt:  now.Add(-time.Hour),
+		AccessedAt: now,
+	}}
+
+	if r.IsExpired() {
+		t.Fatalf("recently accessed record should not expire")
+	}
+	if !r.ExpiresAt().Equal(now.Add(time.Minute)) {
+		t.Fatalf("invalid expiration time: %s", r.ExpiresAt())
+	}
+
+	r.Meta.Sliding = false
+	if !r.IsExpired() {
+		t.Fatalf("record should be expired")
+	}
+}
+
 func TestUnsafeHashStore(t *testing.T) {
 	h := newUnsafeHash(0)
 	tests := []struct {
@@ -94,6 +117,45 @@ func TestUnsafeHashLoad(t *testing.T) {
 	}
 }
 
+func TestUnsafeHashPeek(t *testing.T) {
+	h := newUnsafeHash(0)
+	h.Store("a", Record{Data: 1})
+
+	rec, ok := h.Peek("a")
+	if !ok || rec.Data.(int) != 1 {
+		t.Fatalf("key `a` expected to be in hash")
+	}
+	if !rec.Meta.AccessedAt.IsZero() {
+		t.Fatalf("accessed at time for `a` must be zero")
+	}
+
+	h.Load("a")
+	if rec, _ = h.Peek("a"); rec.Meta.AccessedAt.IsZero() {
+		t.Fatalf("accessed at time for `a` must be persisted")
+	}
+	if _, ok = h.Peek("b"); ok {
+		t.Fatalf("key `b` is not expected to be in hash")
+	}
+}
+
+func TestUnsafeHashRestore(t *testing.T) {
+	h := newUnsafeHash(0)
+
+	meta := Meta{Index: 7, ExpireTime: time.Hour, CreatedAt: time.Now()}
+	rec := h.Restore("a", Record{Data: 1, Meta: meta})
+	if !reflect.DeepEqual(rec.Meta, meta) {
+		t.Fatalf("metadata of the record should be preserved: %v", rec.Meta)
+	}
+
+	h.Store("a", Record{Data: 2})
+	if rec, _ = h.Peek("a"); rec.Meta.Index != 8 {
+		t.Fatalf("invalid index of the record: %d", rec.Meta.Index)
+	}
+	if keys := h.Keys(); !reflect.DeepEqual(keys, []string{"a"}) {
+		t.Fatalf("invalid list of keys returned: `%v`", keys)
+	}
+}
+
 func TestUnsafeHashKeys(t *testing.T) {
 	h := newUnsafeHash(0)
 	h.Store("1", Record{Data: 42})
@@ -0,0 +1,134 @@
+package merkle
+
+import (
+	"encoding/binary"
+	"hash/fnv"
+)
+
+// Tree is a hash tree (Merkle tree) of the records. The keys are
+// distributed among the leaves of the tree by the hash of the key. The
+// hash of the leaf combines the digests of the records regardless of
+// the order of insertion, and the hash of the inner node is a hash of
+// its children, so two trees are equal only when the records are equal.
+//
+// The trees of the same depth are compared from the root, the subtrees
+// with equal hashes are skipped, so only the leaves with the differing
+// records are returned.
+type Tree struct {
+	// Hashes is a list of the hashes of the tree nodes in the
+	// breadth-first order: the root is the first one and the leaves are
+	// the last ones.
+	Hashes []uint64
+}
+
+// New creates a new empty tree of the given depth, the tree contains
+// 2^depth leaves. Depth should not be less than zero.
+func New(depth int) *Tree {
+	if depth < 0 {
+		panic("merkle: depth is less than zero")
+	}
+
+	t := &Tree{Hashes: make([]uint64, 1<<uint(depth+1)-1)}
+	// Calculate the hashes of the empty inner nodes, so the empty
+	// trees of the same depth are equal.
+	for ii := t.Leaves() - 2; ii >= 0; ii-- {
+		t.Hashes[ii] = t.sum(ii)
+	}
+	return t
+}
+
+// Leaves returns a number of leaves of the tree.
+func (t *Tree) Leaves() int {
+	return (len(t.Hashes) + 1) / 2
+}
+
+// Root returns a hash of the root of the tree.
+func (t *Tree) Root() uint64 {
+	if len(t.Hashes) == 0 {
+		return 0
+	}
+	return t.Hashes[0]
+}
+
+// Leaf returns an index of the leaf the key belongs to.
+func (t *Tree) Leaf(key string) int {
+	ha := fnv.New32a()
+	ha.Write([]byte(key))
+	return int(ha.Sum32() % uint32(t.Leaves()))
+}
+
+// Insert adds a digest of the record to the leaf of the key and updates
+// the hashes of all nodes on the path to the root. The value should
+// identify the content of the record, so the copies of the record with
+// the same content produce the same digest.
+func (t *Tree) Insert(key string, value []byte) {
+	ha := fnv.New64a()
+	ha.Write([]byte(key))
+	ha.Write([]byte{0})
+	ha.Write(value)
+
+	ii := t.Leaves() - 1 + t.Leaf(key)
+	t.Hashes[ii] ^= ha.Sum64()
+
+	for ii > 0 {
+		ii = (ii - 1) / 2
+		t.Hashes[ii] = t.sum(ii)
+	}
+}
+
+// Remove removes a digest of the record inserted before from the leaf of
+// the key and updates the hashes of all nodes on the path to the root.
+// The digests of the leaf are combined with XOR, so the removal of the
+// digest is the same as its insertion.
+func (t *Tree) Remove(key string, value []byte) {
+	t.Insert(key, value)
+}
+
+// Copy returns a copy of the tree.
+func (t *Tree) Copy() *Tree {
+	return &Tree{Hashes: append([]uint64(nil), t.Hashes...)}
+}
+
+// sum calculates a hash of the inner node with the given index.
+func (t *Tree) sum(ii int) uint64 {
+	var b [16]byte
+	binary.BigEndian.PutUint64(b[:8], t.Hashes[2*ii+1])
+	binary.BigEndian.PutUint64(b[8:], t.Hashes[2*ii+2])
+
+	ha := fnv.New64a()
+	ha.Write(b[:])
+	return ha.Sum64()
+}
+
+// Diff returns a list of indices of the leaves, which hashes differ in
+// both trees. When the trees are of different depths, all leaves of the
+// tree are returned.
+func (t *Tree) Diff(other *Tree) []int {
+	if len(t.Hashes) != len(other.Hashes) {
+		leaves := make([]int, t.Leaves())
+		for ii := range leaves {
+			leaves[ii] = ii
+		}
+		return leaves
+	}
+
+	var (
+		leaves []int
+		queue  = []int{0}
+		first  = t.Leaves() - 1
+	)
+
+	for len(queue) != 0 {
+		ii := queue[0]
+		queue = queue[1:]
+		if t.Hashes[ii] == other.Hashes[ii] {
+			continue
+		}
+		if ii >= first {
+			leaves = append(leaves, ii-first)
+			continue
+		}
+		queue = append(queue, 2*ii+1, 2*ii+2)
+	}
+	return leaves
+}
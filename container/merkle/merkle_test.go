@@ -0,0 +1,66 @@
+package merkle
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestTreeInsert(t *testing.T) {
+	t1, t2 := New(3), New(3)
+	if t1.Leaves() != 8 || t1.Root() != t2.Root() {
+		t.Fatalf("empty trees should be equal: %v, %v", t1, t2)
+	}
+
+	// The order of insertion should not matter.
+	t1.Insert("a", []byte("1"))
+	t1.Insert("b", []byte("2"))
+	t2.Insert("b", []byte("2"))
+	t2.Insert("a", []byte("1"))
+
+	if !reflect.DeepEqual(t1, t2) {
+		t.Fatalf("trees should be equal: %v, %v", t1, t2)
+	}
+	if len(t1.Diff(t2)) != 0 {
+		t.Fatalf("equal trees should not differ: %v", t1.Diff(t2))
+	}
+
+	root := t2.Root()
+	t2.Insert("c", []byte("3"))
+	if t2.Root() == root {
+		t.Fatalf("root should be updated")
+	}
+
+	t3 := t2.Copy()
+	t3.Remove("c", []byte("3"))
+	if t3.Root() != root || t2.Root() == root {
+		t.Fatalf("only the copy should be updated: %v, %v", t2, t3)
+	}
+}
+
+func TestTreeDiff(t *testing.T) {
+	t1, t2 := New(4), New(4)
+	keys := []string{"a", "b", "c", "d", "e", "f"}
+	for _, key := range keys {
+		t1.Insert(key, []byte("x"))
+		t2.Insert(key, []byte("x"))
+	}
+
+	t1.Insert("g", []byte("y"))
+	t2.Insert("h", []byte("y"))
+
+	expected := []int{t1.Leaf("g"), t1.Leaf("h")}
+	if expected[0] > expected[1] {
+		expected[0], expected[1] = expected[1], expected[0]
+	}
+	if expected[0] == expected[1] {
+		expected = expected[:1]
+	}
+
+	if leaves := t1.Diff(t2); !reflect.DeepEqual(leaves, expected) {
+		t.Fatalf("invalid leaves returned: %v, expected %v", leaves, expected)
+	}
+
+	if leaves := t1.Diff(New(2)); len(leaves) != t1.Leaves() {
+		t.Fatalf("all leaves expected for different depths: %v", leaves)
+	}
+}
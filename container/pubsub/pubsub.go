@@ -0,0 +1,155 @@
+package pubsub
+
+import (
+	"fmt"
+	"sync"
+)
+
+const (
+	// ActionPublish is an action to publish a message to the channel.
+	ActionPublish = "publish"
+
+	// subscribeBuffer is a number of messages buffered by the
+	// subscription.
+	subscribeBuffer = 64
+)
+
+// Message is a message published to the channel.
+type Message struct {
+	// Channel is a name of the channel.
+	Channel string
+
+	// Data is a payload of the message.
+	Data interface{}
+}
+
+// Subscription receives the messages of the channel it was created for.
+// Messages are delivered to the channel without blocking the publisher,
+// so when the receiver falls behind and the buffer is full, the channel
+// is closed.
+type Subscription struct {
+	// C is a channel of messages.
+	C <-chan Message
+
+	c       chan Message
+	channel string
+
+	b *Broker
+
+	// Mutex guards the channel from sending to the closed channel.
+	mu     sync.Mutex
+	closed bool
+}
+
+// send sends the message to the subscription without blocking, the
+// channel is closed when the buffer is full.
+func (s *Subscription) send(msg Message) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return false
+	}
+
+	select {
+	case s.c <- msg:
+		return true
+	default:
+		s.closed = true
+		close(s.c)
+	}
+	return false
+}
+
+// Stop stops the delivery of the messages and closes the channel.
+func (s *Subscription) Stop() {
+	s.b.remove(s)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.closed {
+		s.closed = true
+		close(s.c)
+	}
+}
+
+// Broker delivers the published messages to the subscribers of the
+// channels. Channels are not related to the keys of the store, they
+// exist only while there are subscribers.
+type Broker struct {
+	mu   sync.RWMutex
+	subs map[string]map[*Subscription]struct{}
+}
+
+// New creates a new instance of the Broker.
+func New() *Broker {
+	return &Broker{subs: make(map[string]map[*Subscription]struct{})}
+}
+
+// Subscribe creates a new subscription to the messages of the channel.
+func (b *Broker) Subscribe(channel string) *Subscription {
+	c := make(chan Message, subscribeBuffer)
+	s := &Subscription{C: c, c: c, channel: channel, b: b}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	set, ok := b.subs[channel]
+	if !ok {
+		set = make(map[*Subscription]struct{})
+		b.subs[channel] = set
+	}
+	set[s] = struct{}{}
+	return s
+}
+
+// remove removes the subscription from the broker.
+func (b *Broker) remove(s *Subscription) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	set := b.subs[s.channel]
+	delete(set, s)
+	if len(set) == 0 {
+		delete(b.subs, s.channel)
+	}
+}
+
+// Publish sends the message to all subscribers of the channel. It returns
+// a number of subscribers received the message.
+func (b *Broker) Publish(msg Message) int {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	var n int
+	for s := range b.subs[msg.Channel] {
+		if s.send(msg) {
+			n++
+		}
+	}
+	return n
+}
+
+// RequestPublish defines a request to deliver a message to the local
+// subscribers of the channel of the remote node.
+type RequestPublish struct {
+	// ID is a request identifier.
+	ID string
+	// Channel is a name of the channel.
+	Channel string
+	// Data is a payload of the message.
+	Data interface{}
+}
+
+// Action returns an action of the request.
+func (r *RequestPublish) Action() string {
+	return ActionPublish
+}
+
+// String implements fmt.Stringer interface.
+func (r *RequestPublish) String() string {
+	return fmt.Sprintf("id: %s, type: publish, channel: %s",
+		r.ID, r.Channel)
+}
+
+// Message returns a message of the request.
+func (r *RequestPublish) Message() Message {
+	return Message{Channel: r.Channel, Data: r.Data}
+}
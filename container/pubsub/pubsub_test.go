@@ -0,0 +1,75 @@
+package pubsub
+
+import (
+	"testing"
+	"time"
+)
+
+func assertMessage(t *testing.T, s *Subscription, channel string, data interface{}) {
+	select {
+	case msg := <-s.C:
+		if msg.Channel != channel || msg.Data != data {
+			t.Fatalf("invalid message received: %v", msg)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("message %v of %s expected", data, channel)
+	}
+}
+
+func TestBrokerPublish(t *testing.T) {
+	b := New()
+
+	s1 := b.Subscribe("news")
+	defer s1.Stop()
+	s2 := b.Subscribe("news")
+	s3 := b.Subscribe("sports")
+	defer s3.Stop()
+
+	if n := b.Publish(Message{Channel: "news", Data: "a"}); n != 2 {
+		t.Fatalf("message should be delivered to two subscribers: %d", n)
+	}
+	assertMessage(t, s1, "news", "a")
+	assertMessage(t, s2, "news", "a")
+
+	s2.Stop()
+	if _, ok := <-s2.C; ok {
+		t.Fatalf("channel of stopped subscription should be closed")
+	}
+
+	if n := b.Publish(Message{Channel: "news", Data: "b"}); n != 1 {
+		t.Fatalf("message should be delivered to one subscriber: %d", n)
+	}
+	if n := b.Publish(Message{Channel: "weather", Data: "c"}); n != 0 {
+		t.Fatalf("message should not be delivered: %d", n)
+	}
+	assertMessage(t, s1, "news", "b")
+
+	select {
+	case msg := <-s3.C:
+		t.Fatalf("unexpected message received: %v", msg)
+	default:
+	}
+}
+
+func TestBrokerPublishOverflow(t *testing.T) {
+	b := New()
+	s := b.Subscribe("news")
+
+	for i := 0; i <= subscribeBuffer; i++ {
+		b.Publish(Message{Channel: "news", Data: i})
+	}
+
+	var n int
+	for range s.C {
+		n++
+	}
+	if n != subscribeBuffer {
+		t.Fatalf("invalid number of buffered messages: %d", n)
+	}
+
+	// Stop of the closed subscription should not panic.
+	s.Stop()
+	if len(b.subs) != 0 {
+		t.Fatalf("subscription should be removed: %v", b.subs)
+	}
+}
@@ -2,6 +2,7 @@ package ring
 
 import (
 	"hash/fnv"
+	"strings"
 )
 
 // Ring describes types that implement a ring container.
@@ -10,15 +11,36 @@ import (
 // for equal balancing of the data between multiple database shards.
 type Ring interface {
 	// Insert inserts a new element into a ring. After the insertion,
-	// the partition assignments will be re-calculated.
+	// the new element is assigned to the fair share of partitions taken
+	// from the most loaded elements, the rest of partitions are kept.
 	Insert(*Element)
 
 	// Remove removes element from the ring. After the remove, the
-	// partition assignments will be re-calculated.
+	// partitions of the element are assigned to the least loaded
+	// elements, the rest of partitions are kept.
 	Remove(*Element)
 
+	// Assign assigns the partitions to the given elements in the order
+	// of partitions, so the ring could be reproduced from the result of
+	// Partitions(1) of another ring. The number of elements should be
+	// equal to the number of partitions.
+	Assign([]*Element)
+
 	// Find searches for element, that is assigned to the given key.
 	Find(Hasher) *Element
+
+	// FindN searches for n distinct elements, that are assigned to the
+	// given key. The first element is the one returned by Find, when
+	// the ring contains less than n elements, all elements are returned.
+	FindN(Hasher, int) []*Element
+
+	// Partitions returns a list of n distinct elements assigned to each
+	// partition of the ring in the order of the partitions.
+	Partitions(int) [][]*Element
+
+	// Partition returns an index of the partition the given key
+	// belongs to.
+	Partition(Hasher) int
 }
 
 // Element defines an element of the ring.
@@ -41,13 +63,31 @@ func fnvSum32(b []byte) uint32 {
 	return ha.Sum32()
 }
 
+// HashTag returns a part of the key used to calculate a hash. When the
+// key contains a non-empty substring enclosed in braces, like
+// "{user42}:cart", only the first such substring is hashed, so the keys
+// with the same tag are assigned to the same element of the ring.
+// Otherwise the whole key is hashed.
+func HashTag(key string) string {
+	start := strings.IndexByte(key, '{')
+	if start < 0 {
+		return key
+	}
+	end := strings.IndexByte(key[start+1:], '}')
+	if end <= 0 {
+		return key
+	}
+	return key[start+1 : start+1+end]
+}
+
 // StringHasher is an implementation of the Hasher interface that
-// calculates Fowler-Noll-Vo checksum of the string.
+// calculates Fowler-Noll-Vo checksum of the string. The hash tag of the
+// string is honored, see HashTag for details.
 type StringHasher string
 
 // Hash implement Hasher interface.
 func (h StringHasher) Hash() uint32 {
-	return fnvSum32([]byte(h))
+	return fnvSum32([]byte(HashTag(string(h))))
 }
 
 // ring is a consistent-hashing ring with virtual partitions. The ring
@@ -77,32 +117,124 @@ func New(ratio int) Ring {
 	return newRing(ratio)
 }
 
-// repartition performs a remapping of virtual partitions to the
-// elements. It is called after insertion or deletion of elements.
-func (r *ring) repartition() {
-	num := len(r.elements)
-	for ii := range r.virtual {
-		r.virtual[ii] = ii % num
+// owned returns a list of partitions assigned to each element in the
+// ascending order.
+func (r *ring) owned() [][]int {
+	owned := make([][]int, len(r.elements))
+	for ii, element := range r.virtual {
+		owned[element] = append(owned[element], ii)
+	}
+	return owned
+}
+
+// loaded returns an index of the element with the most (or the least)
+// number of partitions. The first element is returned on tie.
+func loaded(loads []int, most bool) int {
+	index := 0
+	for ii, load := range loads {
+		if (most && load > loads[index]) || (!most && load < loads[index]) {
+			index = ii
+		}
 	}
+	return index
 }
 
 // Insert implements Ring interface.
 func (r *ring) Insert(e *Element) {
 	r.elements = append(r.elements, e)
-	r.repartition()
+	num := len(r.elements)
+	if num == 1 {
+		for ii := range r.virtual {
+			r.virtual[ii] = 0
+		}
+		return
+	}
+
+	var (
+		owned = r.owned()
+		loads = make([]int, num-1)
+		gives = make([]int, num-1)
+	)
+
+	for ii := range loads {
+		loads[ii] = len(owned[ii])
+	}
+	// Take the partitions one by one from the most loaded elements,
+	// until the new element gets a fair share.
+	for ii := 0; ii < int(r.ratio)/num; ii++ {
+		donor := loaded(loads, true)
+		loads[donor]--
+		gives[donor]++
+	}
+
+	// Pick evenly spaced partitions of each donor, so the partitions
+	// of the new element are spread across the ring.
+	for donor, give := range gives {
+		load := len(owned[donor])
+		for ii := 0; ii < give; ii++ {
+			partition := owned[donor][(ii+1)*load/give-1]
+			r.virtual[partition] = num - 1
+		}
+	}
 }
 
 // Remove implements consistent hashing interface.
 func (r *ring) Remove(e *Element) {
+	index := -1
 	for ii := 0; ii < len(r.elements); ii++ {
-		if r.elements[ii].Value != e.Value {
-			continue
+		if r.elements[ii].Value == e.Value {
+			index = ii
+			break
 		}
+	}
+	if index < 0 {
+		return
+	}
 
-		r.elements = append(r.elements[:ii], r.elements[ii+1:]...)
-		break
+	owned := r.owned()
+	r.elements = append(r.elements[:index], r.elements[index+1:]...)
+	if len(r.elements) == 0 {
+		return
+	}
+
+	loads := make([]int, 0, len(r.elements))
+	for ii := range owned {
+		if ii != index {
+			loads = append(loads, len(owned[ii]))
+		}
+	}
+	for ii, element := range r.virtual {
+		if element > index {
+			r.virtual[ii] = element - 1
+		}
+	}
+
+	// Distribute the partitions of the removed element among the least
+	// loaded elements.
+	for _, partition := range owned[index] {
+		element := loaded(loads, false)
+		loads[element]++
+		r.virtual[partition] = element
+	}
+}
+
+// Assign implements Ring interface.
+func (r *ring) Assign(elements []*Element) {
+	if len(elements) != len(r.virtual) {
+		panic("ring: Number of elements does not match partitions")
+	}
+
+	r.elements = nil
+	indices := make(map[interface{}]int)
+	for ii, e := range elements {
+		index, ok := indices[e.Value]
+		if !ok {
+			index = len(r.elements)
+			indices[e.Value] = index
+			r.elements = append(r.elements, e)
+		}
+		r.virtual[ii] = index
 	}
-	r.repartition()
 }
 
 // Find implements Ring interface.
@@ -111,3 +243,42 @@ func (r *ring) Find(h Hasher) *Element {
 	element := r.virtual[index]
 	return r.elements[element]
 }
+
+// FindN implements Ring interface.
+func (r *ring) FindN(h Hasher, n int) []*Element {
+	return r.preference(h.Hash()%r.ratio, n)
+}
+
+// Partition implements Ring interface.
+func (r *ring) Partition(h Hasher) int {
+	return int(h.Hash() % r.ratio)
+}
+
+// Partitions implements Ring interface.
+func (r *ring) Partitions(n int) [][]*Element {
+	partitions := make([][]*Element, len(r.virtual))
+	for ii := range r.virtual {
+		partitions[ii] = r.preference(uint32(ii), n)
+	}
+	return partitions
+}
+
+// preference returns a list of n distinct elements of the partition.
+// The elements are collected walking the partitions clockwise starting
+// from the given one.
+func (r *ring) preference(index uint32, n int) []*Element {
+	if n > len(r.elements) {
+		n = len(r.elements)
+	}
+
+	elements := make([]*Element, 0, n)
+	seen := make(map[int]bool, n)
+	for ii := uint32(0); ii < r.ratio && len(elements) < n; ii++ {
+		element := r.virtual[(index+ii)%r.ratio]
+		if !seen[element] {
+			seen[element] = true
+			elements = append(elements, r.elements[element])
+		}
+	}
+	return elements
+}
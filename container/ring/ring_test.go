@@ -32,12 +32,78 @@ func TestRingRemove(t *testing.T) {
 		t.Fatalf("expected to elements in a ring")
 	}
 
-	mapping := []int{0, 1, 2, 0}
+	// Only the partition of the removed element should be reassigned.
+	mapping := []int{0, 0, 1, 2}
 	if !reflect.DeepEqual(r.virtual, mapping) {
 		t.Fatalf("invalid partition mapping: %v", r.virtual)
 	}
 }
 
+func TestRingRebalance(t *testing.T) {
+	r := newRing(64)
+	for ii := 0; ii < 4; ii++ {
+		r.Insert(&Element{Value: ii})
+	}
+
+	valuesOf := func() []interface{} {
+		var values []interface{}
+		for _, elements := range r.Partitions(1) {
+			values = append(values, elements[0].Value)
+		}
+		return values
+	}
+
+	before := valuesOf()
+	r.Insert(&Element{Value: 4})
+	after := valuesOf()
+
+	// Partitions should be moved only to the new element.
+	var moved int
+	for ii := range before {
+		if before[ii] != after[ii] {
+			if after[ii] != 4 {
+				t.Fatalf("partition %d moved to %v", ii, after[ii])
+			}
+			moved++
+		}
+	}
+	if moved != 64/5 {
+		t.Fatalf("invalid number of moved partitions: %d", moved)
+	}
+
+	for ii, owned := range r.owned() {
+		if n := len(owned); n < 12 || n > 13 {
+			t.Fatalf("element %d is not balanced: %d", ii, n)
+		}
+	}
+
+	// Partitions should be moved only from the removed element.
+	r.Remove(&Element{Value: 2})
+	for ii, value := range valuesOf() {
+		if value != after[ii] && after[ii] != 2 {
+			t.Fatalf("partition %d moved from %v", ii, after[ii])
+		}
+	}
+}
+
+func TestRingAssign(t *testing.T) {
+	r1 := newRing(8)
+	for ii := 0; ii < 3; ii++ {
+		r1.Insert(&Element{Value: ii})
+	}
+
+	var owners []*Element
+	for _, elements := range r1.Partitions(1) {
+		owners = append(owners, &Element{Value: elements[0].Value})
+	}
+
+	r2 := newRing(8)
+	r2.Assign(owners)
+	if !reflect.DeepEqual(r1.Partitions(2), r2.Partitions(2)) {
+		t.Fatalf("rings should be equal: %v, %v", r1.Partitions(2), r2.Partitions(2))
+	}
+}
+
 func TestRingFind(t *testing.T) {
 	r := newRing(4)
 	r.Insert(&Element{Value: 1})
@@ -50,7 +116,7 @@ func TestRingFind(t *testing.T) {
 	}{
 		{"1", 3},
 		{"3", 1},
-		{"0", 1},
+		{"0", 2},
 	}
 
 	for _, tt := range tests {
@@ -60,3 +126,78 @@ func TestRingFind(t *testing.T) {
 		}
 	}
 }
+
+func TestHashTag(t *testing.T) {
+	tests := []struct {
+		Key string
+		Tag string
+	}{
+		{"user42", "user42"},
+		{"{user42}:cart", "user42"},
+		{"cart:{user42}", "user42"},
+		{"{user42}:{cart}", "user42"},
+		{"{}:cart", "{}:cart"},
+		{"{user42:cart", "{user42:cart"},
+		{"}user42{", "}user42{"},
+	}
+
+	for _, tt := range tests {
+		if tag := HashTag(tt.Key); tag != tt.Tag {
+			t.Fatalf("invalid hash tag of %s: %s", tt.Key, tag)
+		}
+	}
+
+	h1 := StringHasher("{user42}:cart").Hash()
+	h2 := StringHasher("{user42}:profile").Hash()
+	if h1 != h2 {
+		t.Fatalf("keys with the same tag should have the same hash")
+	}
+}
+
+func TestRingFindN(t *testing.T) {
+	r := newRing(4)
+	r.Insert(&Element{Value: 1})
+	r.Insert(&Element{Value: 2})
+	r.Insert(&Element{Value: 3})
+
+	valuesOf := func(elements []*Element) (values []int) {
+		for _, el := range elements {
+			values = append(values, el.Value.(int))
+		}
+		return values
+	}
+
+	tests := []struct {
+		Key    string
+		N      int
+		Values []int
+	}{
+		{"1", 2, []int{3, 2}},
+		{"3", 2, []int{1, 2}},
+		{"0", 1, []int{2}},
+		{"0", 5, []int{2, 1, 3}},
+	}
+
+	for _, tt := range tests {
+		values := valuesOf(r.FindN(StringHasher(tt.Key), tt.N))
+		if !reflect.DeepEqual(values, tt.Values) {
+			t.Fatalf("invalid nodes returned for %s: %v", tt.Key, values)
+		}
+		if el := r.Find(StringHasher(tt.Key)); el.Value != tt.Values[0] {
+			t.Fatalf("first node should match the owner of %s", tt.Key)
+		}
+
+		partition := r.Partitions(tt.N)[r.Partition(StringHasher(tt.Key))]
+		if values := valuesOf(partition); !reflect.DeepEqual(values, tt.Values) {
+			t.Fatalf("invalid partition of %s: %v", tt.Key, values)
+		}
+	}
+
+	partitions := r.Partitions(2)
+	if len(partitions) != 4 {
+		t.Fatalf("invalid number of partitions: %d", len(partitions))
+	}
+	if values := valuesOf(partitions[3]); !reflect.DeepEqual(values, []int{2, 1}) {
+		t.Fatalf("invalid nodes of the last partition: %v", values)
+	}
+}
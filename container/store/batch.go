@@ -0,0 +1,137 @@
+package store
+
+import (
+	"fmt"
+
+	"github.com/ybubnov/memhashd/container/hash"
+)
+
+const (
+	// ActionBatchLoad is an action to load multiple records at once.
+	ActionBatchLoad = "batch_load"
+
+	// ActionBatchStore is an action to persist multiple records at once.
+	ActionBatchStore = "batch_store"
+)
+
+// BatchRequest is a request, that consists of multiple independent
+// requests to the different keys. The keys could be owned by different
+// nodes of the cluster, therefore the batch request is split into the
+// individual requests before processing.
+type BatchRequest interface {
+	Request
+
+	// Requests returns a list of individual requests of the batch.
+	Requests() []Request
+
+	// Subset returns a batch request, that consists only of the
+	// individual requests with given indices.
+	Subset(indices []int) BatchRequest
+}
+
+// processBatch returns an error, since the batch requests should not be
+// processed by the store directly.
+func processBatch(r Request) (hash.Record, error) {
+	text := fmt.Sprintf("batch request %s should be split", r.Action())
+	return hash.RecordZero, &ErrInternal{text}
+}
+
+// RequestBatchLoad defines a request to a storage to load records by
+// the given keys.
+type RequestBatchLoad struct {
+	// ID is a request identifier.
+	ID string
+	// Keys is a list of keys to load.
+	Keys []string
+}
+
+// Action implements Request interface.
+func (r *RequestBatchLoad) Action() string {
+	return ActionBatchLoad
+}
+
+// Hash implements Request interface. Hash for batch request is always
+// an empty string, since the keys are processed individually.
+func (r *RequestBatchLoad) Hash() string {
+	return ""
+}
+
+// String implements fmt.Stringer interface.
+func (r *RequestBatchLoad) String() string {
+	return fmt.Sprintf("id: %s, type: batch_load, keys: %d",
+		r.ID, len(r.Keys))
+}
+
+// Requests implements BatchRequest interface.
+func (r *RequestBatchLoad) Requests() []Request {
+	reqs := make([]Request, len(r.Keys))
+	for i, key := range r.Keys {
+		reqs[i] = &RequestLoad{ID: r.ID, Key: key}
+	}
+	return reqs
+}
+
+// Subset implements BatchRequest interface.
+func (r *RequestBatchLoad) Subset(indices []int) BatchRequest {
+	keys := make([]string, len(indices))
+	for i, index := range indices {
+		keys[i] = r.Keys[index]
+	}
+	return &RequestBatchLoad{ID: r.ID, Keys: keys}
+}
+
+// Process implements Request interface.
+func (r *RequestBatchLoad) Process(h hash.Hash) (hash.Record, error) {
+	return processBatch(r)
+}
+
+// RequestBatchStore defines a request to a storage to persist multiple
+// records. Each record is stored independently of the others.
+type RequestBatchStore struct {
+	// ID is a request identifier.
+	ID string
+	// Records is a list of store requests.
+	Records []RequestStore
+}
+
+// Action implements Request interface.
+func (r *RequestBatchStore) Action() string {
+	return ActionBatchStore
+}
+
+// Hash implements Request interface. Hash for batch request is always
+// an empty string, since the keys are processed individually.
+func (r *RequestBatchStore) Hash() string {
+	return ""
+}
+
+// String implements fmt.Stringer interface.
+func (r *RequestBatchStore) String() string {
+	return fmt.Sprintf("id: %s, type: batch_store, keys: %d",
+		r.ID, len(r.Records))
+}
+
+// Requests implements BatchRequest interface.
+func (r *RequestBatchStore) Requests() []Request {
+	reqs := make([]Request, len(r.Records))
+	for i := range r.Records {
+		req := r.Records[i]
+		req.ID = r.ID
+		reqs[i] = &req
+	}
+	return reqs
+}
+
+// Subset implements BatchRequest interface.
+func (r *RequestBatchStore) Subset(indices []int) BatchRequest {
+	records := make([]RequestStore, len(indices))
+	for i, index := range indices {
+		records[i] = r.Records[index]
+	}
+	return &RequestBatchStore{ID: r.ID, Records: records}
+}
+
+// Process implements Request interface.
+func (r *RequestBatchStore) Process(h hash.Hash) (hash.Record, error) {
+	return processBatch(r)
+}
@@ -0,0 +1,45 @@
+package store
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestRequestBatchLoad(t *testing.T) {
+	req := &RequestBatchLoad{ID: "1", Keys: []string{"a", "b", "c"}}
+	if req.Action() != ActionBatchLoad || req.Hash() != "" {
+		t.Fatalf("invalid batch request: %s", req)
+	}
+
+	reqs := req.Requests()
+	if len(reqs) != 3 || reqs[1].Hash() != "b" {
+		t.Fatalf("invalid list of requests: %v", reqs)
+	}
+
+	sub := req.Subset([]int{2, 0}).(*RequestBatchLoad)
+	if !reflect.DeepEqual(sub.Keys, []string{"c", "a"}) {
+		t.Fatalf("invalid subset of keys: %v", sub.Keys)
+	}
+
+	s := newStore(&Config{Capacity: 16})
+	if _, err := s.Serve(req); err == nil {
+		t.Fatalf("batch request should not be processed by store")
+	}
+}
+
+func TestRequestBatchStore(t *testing.T) {
+	req := &RequestBatchStore{ID: "1", Records: []RequestStore{
+		{Key: "a", Data: 1}, {Key: "b", Data: 2},
+	}}
+
+	reqs := req.Requests()
+	store, ok := reqs[1].(*RequestStore)
+	if !ok || store.ID != "1" || store.Key != "b" {
+		t.Fatalf("invalid request of the batch: %v", reqs[1])
+	}
+
+	sub := req.Subset([]int{1}).(*RequestBatchStore)
+	if len(sub.Records) != 1 || sub.Records[0].Key != "b" {
+		t.Fatalf("invalid subset of records: %v", sub.Records)
+	}
+}
@@ -0,0 +1,46 @@
+package store
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Consistency defines how many copies of the record should acknowledge
+// the write or answer the read, before the request is completed.
+type Consistency string
+
+const (
+	// ConsistencyOne requires a single copy of the record.
+	ConsistencyOne Consistency = "ONE"
+
+	// ConsistencyQuorum requires a majority of the copies of the record.
+	ConsistencyQuorum Consistency = "QUORUM"
+
+	// ConsistencyAll requires all copies of the record.
+	ConsistencyAll Consistency = "ALL"
+)
+
+// ConsistencyOf returns a consistency level by its name, the name is
+// case-insensitive. When the name is empty, the ConsistencyOne level is
+// returned. If the level is undefined, an error is returned to the caller.
+func ConsistencyOf(name string) (Consistency, error) {
+	switch level := Consistency(strings.ToUpper(name)); level {
+	case "":
+		return ConsistencyOne, nil
+	case ConsistencyOne, ConsistencyQuorum, ConsistencyAll:
+		return level, nil
+	}
+	return "", fmt.Errorf("store: invalid consistency level %s", name)
+}
+
+// Required returns a number of the copies required by the consistency
+// level out of the given number of copies.
+func (c Consistency) Required(n int) int {
+	switch c {
+	case ConsistencyQuorum:
+		return n/2 + 1
+	case ConsistencyAll:
+		return n
+	}
+	return 1
+}
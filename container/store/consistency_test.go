@@ -0,0 +1,49 @@
+package store
+
+import (
+	"testing"
+)
+
+func TestConsistencyOf(t *testing.T) {
+	tests := []struct {
+		Name  string
+		Level Consistency
+	}{
+		{"", ConsistencyOne},
+		{"one", ConsistencyOne},
+		{"QUORUM", ConsistencyQuorum},
+		{"All", ConsistencyAll},
+	}
+
+	for _, tt := range tests {
+		level, err := ConsistencyOf(tt.Name)
+		if err != nil || level != tt.Level {
+			t.Fatalf("invalid level of %s: %s, %v", tt.Name, level, err)
+		}
+	}
+
+	if _, err := ConsistencyOf("TWO"); err == nil {
+		t.Fatalf("error expected for undefined level")
+	}
+}
+
+func TestConsistencyRequired(t *testing.T) {
+	tests := []struct {
+		Level    Consistency
+		N        int
+		Required int
+	}{
+		{ConsistencyOne, 3, 1},
+		{ConsistencyQuorum, 3, 2},
+		{ConsistencyQuorum, 4, 3},
+		{ConsistencyQuorum, 1, 1},
+		{ConsistencyAll, 3, 3},
+	}
+
+	for _, tt := range tests {
+		if n := tt.Level.Required(tt.N); n != tt.Required {
+			t.Fatalf("invalid number of copies for %s of %d: %d",
+				tt.Level, tt.N, n)
+		}
+	}
+}
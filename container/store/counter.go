@@ -0,0 +1,174 @@
+package store
+
+import (
+	"fmt"
+	"reflect"
+
+	"github.com/ybubnov/memhashd/container/hash"
+)
+
+const (
+	// ActionIncr is an action to increment a numeric record by one.
+	ActionIncr = "incr"
+
+	// ActionDecr is an action to decrement a numeric record by one.
+	ActionDecr = "decr"
+
+	// ActionAdd is an action to add a delta to a numeric record.
+	ActionAdd = "add"
+)
+
+// addDelta adds a delta to the numeric value preserving its type. It
+// returns false, when the value is not a number or the result cannot
+// be represented by the type of the value.
+func addDelta(v interface{}, delta int64) (interface{}, bool) {
+	val := reflect.ValueOf(v)
+	if !val.IsValid() {
+		return nil, false
+	}
+
+	sum := reflect.New(val.Type()).Elem()
+	switch val.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32,
+		reflect.Int64:
+		n := val.Int() + delta
+		if (delta > 0 && n < val.Int()) || (delta < 0 && n > val.Int()) {
+			return nil, false
+		}
+		if sum.OverflowInt(n) {
+			return nil, false
+		}
+		sum.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32,
+		reflect.Uint64, reflect.Uintptr:
+		n := val.Uint() + uint64(delta)
+		if (delta > 0 && n < val.Uint()) ||
+			(delta < 0 && uint64(-delta) > val.Uint()) || sum.OverflowUint(n) {
+			return nil, false
+		}
+		sum.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		sum.SetFloat(val.Float() + float64(delta))
+	default:
+		return nil, false
+	}
+	return sum.Interface(), true
+}
+
+// addTo atomically adds a delta to the numeric record persisted under
+// the given key. When the record is missing, it is created with a zero
+// value. Hash should not be concurrently changed during this operation.
+func addTo(h hash.Hash, key string, delta int64) (hash.Record, error) {
+	rec, ok := h.Load(key)
+	if !ok {
+		rec = hash.Record{Data: int64(0)}
+	}
+	data, ok := addDelta(rec.Data, delta)
+	if !ok {
+		text := fmt.Sprintf("%s is not a number or out of range", key)
+		return hash.RecordZero, &ErrConflict{text}
+	}
+
+	// Preserve the expiration settings of the record, only the data
+	// should be changed by the counter actions.
+	rec = h.Store(key, hash.Record{
+		Data: data, Meta: rec.Meta,
+	})
+	return rec, nil
+}
+
+// RequestIncr defines a request to a storage to increment a numeric
+// value stored by the given key. A missing record is created with a
+// zero value before increment.
+type RequestIncr struct {
+	// ID is a request identifier.
+	ID string
+	// Key is a name of the key.
+	Key string
+}
+
+// Action implements Request interface.
+func (r *RequestIncr) Action() string {
+	return ActionIncr
+}
+
+// Hash implements Request interface.
+func (r *RequestIncr) Hash() string {
+	return r.Key
+}
+
+// String implements fmt.Stringer interface.
+func (r *RequestIncr) String() string {
+	return fmt.Sprintf("id: %s, type: incr, key: %s", r.ID, r.Key)
+}
+
+// Process implements Request interface, it increments a value of the
+// record by one.
+func (r *RequestIncr) Process(h hash.Hash) (hash.Record, error) {
+	return addTo(h, r.Key, 1)
+}
+
+// RequestDecr defines a request to a storage to decrement a numeric
+// value stored by the given key. A missing record is created with a
+// zero value before decrement.
+type RequestDecr struct {
+	// ID is a request identifier.
+	ID string
+	// Key is a name of the key.
+	Key string
+}
+
+// Action implements Request interface.
+func (r *RequestDecr) Action() string {
+	return ActionDecr
+}
+
+// Hash implements Request interface.
+func (r *RequestDecr) Hash() string {
+	return r.Key
+}
+
+// String implements fmt.Stringer interface.
+func (r *RequestDecr) String() string {
+	return fmt.Sprintf("id: %s, type: decr, key: %s", r.ID, r.Key)
+}
+
+// Process implements Request interface, it decrements a value of the
+// record by one.
+func (r *RequestDecr) Process(h hash.Hash) (hash.Record, error) {
+	return addTo(h, r.Key, -1)
+}
+
+// RequestAdd defines a request to a storage to add a delta to the
+// numeric value stored by the given key. A missing record is created
+// with a zero value before addition.
+type RequestAdd struct {
+	// ID is a request identifier.
+	ID string
+	// Key is a name of the key.
+	Key string
+	// Delta is a value to add, it could be negative.
+	Delta int64
+}
+
+// Action implements Request interface.
+func (r *RequestAdd) Action() string {
+	return ActionAdd
+}
+
+// Hash implements Request interface.
+func (r *RequestAdd) Hash() string {
+	return r.Key
+}
+
+// String implements fmt.Stringer interface.
+func (r *RequestAdd) String() string {
+	return fmt.Sprintf("id: %s, type: add, key: %s, delta: %d",
+		r.ID, r.Key, r.Delta)
+}
+
+// Process implements Request interface, it adds a delta to the value
+// of the record.
+func (r *RequestAdd) Process(h hash.Hash) (hash.Record, error) {
+	return addTo(h, r.Key, r.Delta)
+}
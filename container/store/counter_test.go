@@ -0,0 +1,108 @@
+package store
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/ybubnov/memhashd/container/hash"
+)
+
+func TestAddDelta(t *testing.T) {
+	tests := []struct {
+		Value  interface{}
+		Delta  int64
+		Result interface{}
+		Ok     bool
+	}{
+		{int(1), 2, int(3), true},
+		{int8(127), 1, nil, false},
+		{uint(1), -1, uint(0), true},
+		{uint(0), -1, nil, false},
+		{uint64(math.MaxUint64 - 1), 1, uint64(math.MaxUint64), true},
+		{uint64(math.MaxUint64), 1, nil, false},
+		{int64(math.MaxInt64), 1, nil, false},
+		{float64(1.5), -2, float64(-0.5), true},
+		{"1", 1, nil, false},
+		{nil, 1, nil, false},
+	}
+
+	for _, tt := range tests {
+		result, ok := addDelta(tt.Value, tt.Delta)
+		if ok != tt.Ok || result != tt.Result {
+			t.Fatalf("invalid result of %v + %d: %v", tt.Value, tt.Delta, result)
+		}
+	}
+}
+
+func TestRequestIncr(t *testing.T) {
+	s := newStore(&Config{Capacity: 16})
+	req := &RequestIncr{Key: "1"}
+	if req.Action() != ActionIncr {
+		t.Fatalf("invalid request action: %s", req.Action())
+	}
+
+	rec, err := s.Serve(req)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if rec.Data.(int64) != 1 {
+		t.Fatalf("invalid value returned: %v", rec.Data)
+	}
+
+	meta := hash.Meta{ExpireTime: time.Hour}
+	s.Store("1", hash.Record{Data: float64(41), Meta: meta})
+	rec, err = s.Serve(req)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if rec.Data.(float64) != 42 {
+		t.Fatalf("invalid value returned: %v", rec.Data)
+	}
+	if rec.Meta.ExpireTime != time.Hour {
+		t.Fatalf("expiration time should be preserved")
+	}
+
+	s.Store("1", hash.Record{Data: "a"})
+	_, err = s.Serve(req)
+	if _, ok := err.(*ErrConflict); !ok {
+		t.Fatalf("expected conflict error, got %v", err)
+	}
+}
+
+func TestRequestDecr(t *testing.T) {
+	s := newStore(&Config{Capacity: 16})
+	req := &RequestDecr{Key: "1"}
+	if req.Action() != ActionDecr {
+		t.Fatalf("invalid request action: %s", req.Action())
+	}
+
+	rec, err := s.Serve(req)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if rec.Data.(int64) != -1 {
+		t.Fatalf("invalid value returned: %v", rec.Data)
+	}
+}
+
+func TestRequestAdd(t *testing.T) {
+	s := newStore(&Config{Capacity: 16})
+	s.Store("1", hash.Record{Data: 10})
+
+	req := &RequestAdd{Key: "1", Delta: -15}
+	if req.Action() != ActionAdd {
+		t.Fatalf("invalid request action: %s", req.Action())
+	}
+
+	rec, err := s.Serve(req)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if rec.Data.(int) != -5 {
+		t.Fatalf("invalid value returned: %v", rec.Data)
+	}
+	if rec.Meta.Index != 2 {
+		t.Fatalf("invalid index of the record: %d", rec.Meta.Index)
+	}
+}
@@ -0,0 +1,399 @@
+package store
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+
+	"github.com/ybubnov/memhashd/container/hash"
+)
+
+const (
+	// ActionDictSetItem is an action to set an element of a dict.
+	ActionDictSetItem = "setitem"
+
+	// ActionDictDeleteItem is an action to delete an element of a dict.
+	ActionDictDeleteItem = "delitem"
+
+	// ActionDictFields is an action to list the keys of a dict.
+	ActionDictFields = "fields"
+
+	// ActionDictItems is an action to access multiple elements of a dict.
+	ActionDictItems = "items"
+
+	// ActionDictMerge is an action to merge a dict into the stored one.
+	ActionDictMerge = "merge"
+)
+
+var (
+	// interfaceType is a type of the empty interface, it is used as a
+	// type of the values for the new dictionaries.
+	interfaceType = reflect.TypeOf((*interface{})(nil)).Elem()
+)
+
+// dictOf loads a dictionary persisted under the given key. When the
+// record is missing, the zero value of the map is returned if create
+// flag is set, otherwise an error is returned.
+func dictOf(h hash.Hash, key string, create bool) (
+	hash.Record, reflect.Value, error) {
+
+	rec, ok := h.Load(key)
+	if !ok && create {
+		return rec, reflect.Value{}, nil
+	}
+	if !ok && !create {
+		text := fmt.Sprintf("%s does not exist", key)
+		return hash.RecordZero, reflect.Value{}, &ErrMissing{text}
+	}
+
+	if rec.Data == nil || reflect.TypeOf(rec.Data).Kind() != reflect.Map {
+		text := fmt.Sprintf("%s is not a dictionary", key)
+		return hash.RecordZero, reflect.Value{}, &ErrConflict{text}
+	}
+	return rec, reflect.ValueOf(rec.Data), nil
+}
+
+// copyDict returns a shallow copy of the dictionary. The persisted
+// dictionaries are never modified in place, since they could be
+// referenced by the concurrent readers.
+func copyDict(dict reflect.Value) reflect.Value {
+	dup := reflect.MakeMap(dict.Type())
+	for _, key := range dict.MapKeys() {
+		dup.SetMapIndex(key, dict.MapIndex(key))
+	}
+	return dup
+}
+
+// valueOf converts the given value to the value of the given type. It
+// returns an error, when the value cannot be used in a dictionary.
+func valueOf(t reflect.Type, v interface{}) (reflect.Value, error) {
+	if v == nil {
+		return reflect.Zero(t), nil
+	}
+
+	val := reflect.ValueOf(v)
+	if !val.Type().AssignableTo(t) {
+		text := fmt.Sprintf("%v cannot be stored in a dictionary", v)
+		return reflect.Value{}, &ErrConflict{text}
+	}
+	return val, nil
+}
+
+// itemOf converts the given item to the key of the dictionary.
+func itemOf(dict reflect.Value, item interface{}) (reflect.Value, error) {
+	if item == nil {
+		return reflect.Value{}, &ErrConflict{"item <nil> is invalid"}
+	}
+
+	// Lists and dictionaries could not be used as the keys of the
+	// dictionary, even when the dictionary accepts any type of keys.
+	val := reflect.ValueOf(item)
+	if !val.Type().Comparable() || !val.Type().AssignableTo(dict.Type().Key()) {
+		text := fmt.Sprintf("item %v is invalid", item)
+		return reflect.Value{}, &ErrConflict{text}
+	}
+	return val, nil
+}
+
+// storeDict persists a new version of the dictionary under the given
+// key. The expiration settings of the record are preserved.
+func storeDict(h hash.Hash, key string, rec hash.Record,
+	dict reflect.Value) hash.Record {
+
+	return h.Store(key, hash.Record{
+		Data: dict.Interface(), Meta: rec.Meta,
+	})
+}
+
+// RequestDictSetItem defines a request to a store to set an item of
+// the dictionary. Missing dictionary is created.
+type RequestDictSetItem struct {
+	// ID is a request identifier.
+	ID string
+	// Key is a name of a key.
+	Key string
+	// Item is a key of the dictionary to set.
+	Item interface{}
+	// Data is a new value of the item.
+	Data interface{}
+}
+
+// Action implements Request interface.
+func (r *RequestDictSetItem) Action() string {
+	return ActionDictSetItem
+}
+
+// Hash implements Request interface.
+func (r *RequestDictSetItem) Hash() string {
+	return r.Key
+}
+
+// String implement fmt.Stringer interface.
+func (r *RequestDictSetItem) String() string {
+	return fmt.Sprintf("id: %s, type: dict set item, key: %s"+
+		", item: %v, data: %v", r.ID, r.Key, r.Item, r.Data)
+}
+
+// Process implements Request interface, it returns a new value of
+// the item.
+func (r *RequestDictSetItem) Process(h hash.Hash) (hash.Record, error) {
+	rec, dict, err := dictOf(h, r.Key, true)
+	if err != nil {
+		return hash.RecordZero, err
+	}
+
+	if !dict.IsValid() {
+		if r.Item == nil {
+			return hash.RecordZero, &ErrConflict{"item <nil> is invalid"}
+		}
+		if !reflect.TypeOf(r.Item).Comparable() {
+			text := fmt.Sprintf("item %v is invalid", r.Item)
+			return hash.RecordZero, &ErrConflict{text}
+		}
+		dictType := reflect.MapOf(reflect.TypeOf(r.Item), interfaceType)
+		dict = reflect.MakeMap(dictType)
+	}
+
+	item, err := itemOf(dict, r.Item)
+	if err != nil {
+		return hash.RecordZero, err
+	}
+	val, err := valueOf(dict.Type().Elem(), r.Data)
+	if err != nil {
+		return hash.RecordZero, err
+	}
+
+	dict = copyDict(dict)
+	dict.SetMapIndex(item, val)
+
+	rec = storeDict(h, r.Key, rec, dict)
+	rec.Data = val.Interface()
+	return rec, nil
+}
+
+// RequestDictDeleteItem defines a request to a store to delete an item
+// from the dictionary. When the item is missing, an error is returned.
+type RequestDictDeleteItem struct {
+	// ID is a request identifier.
+	ID string
+	// Key is a name of a key.
+	Key string
+	// Item is a key of the dictionary to delete.
+	Item interface{}
+}
+
+// Action implements Request interface.
+func (r *RequestDictDeleteItem) Action() string {
+	return ActionDictDeleteItem
+}
+
+// Hash implements Request interface.
+func (r *RequestDictDeleteItem) Hash() string {
+	return r.Key
+}
+
+// String implement fmt.Stringer interface.
+func (r *RequestDictDeleteItem) String() string {
+	return fmt.Sprintf("id: %s, type: dict delete item, key: %s"+
+		", item: %v", r.ID, r.Key, r.Item)
+}
+
+// Process implements Request interface, it returns a removed value of
+// the item.
+func (r *RequestDictDeleteItem) Process(h hash.Hash) (hash.Record, error) {
+	rec, dict, err := dictOf(h, r.Key, false)
+	if err != nil {
+		return hash.RecordZero, err
+	}
+
+	item, err := itemOf(dict, r.Item)
+	if err != nil {
+		return hash.RecordZero, err
+	}
+
+	val := dict.MapIndex(item)
+	if !val.IsValid() {
+		text := fmt.Sprintf("item %v does not exist", r.Item)
+		return hash.RecordZero, &ErrMissing{text}
+	}
+
+	dict = copyDict(dict)
+	dict.SetMapIndex(item, reflect.Value{})
+
+	rec = storeDict(h, r.Key, rec, dict)
+	rec.Data = val.Interface()
+	return rec, nil
+}
+
+// fieldSlice is a list of dictionary keys, this type is used to order
+// keys by their string representation.
+type fieldSlice []interface{}
+
+// Len implements sort.Interface interface.
+func (f fieldSlice) Len() int {
+	return len(f)
+}
+
+// Less implements sort.Interface interface.
+func (f fieldSlice) Less(i, j int) bool {
+	return fmt.Sprint(f[i]) < fmt.Sprint(f[j])
+}
+
+// Swap implements sort.Interface interface.
+func (f fieldSlice) Swap(i, j int) {
+	f[i], f[j] = f[j], f[i]
+}
+
+// RequestDictFields defines a request to a store to retrieve a list of
+// keys of the dictionary.
+type RequestDictFields struct {
+	// ID is a request identifier.
+	ID string
+	// Key is a name of a key.
+	Key string
+}
+
+// Action implements Request interface.
+func (r *RequestDictFields) Action() string {
+	return ActionDictFields
+}
+
+// Hash implements Request interface.
+func (r *RequestDictFields) Hash() string {
+	return r.Key
+}
+
+// String implement fmt.Stringer interface.
+func (r *RequestDictFields) String() string {
+	return fmt.Sprintf("id: %s, type: dict fields, key: %s", r.ID, r.Key)
+}
+
+// Process implements Request interface, it returns a list of keys of
+// the dictionary in lexicographical order of their representation.
+func (r *RequestDictFields) Process(h hash.Hash) (hash.Record, error) {
+	rec, dict, err := dictOf(h, r.Key, false)
+	if err != nil {
+		return hash.RecordZero, err
+	}
+
+	keys := dict.MapKeys()
+	fields := make([]interface{}, 0, len(keys))
+	for _, key := range keys {
+		fields = append(fields, key.Interface())
+	}
+
+	sort.Sort(fieldSlice(fields))
+	rec.Data = fields
+	return rec, nil
+}
+
+// RequestDictItems defines a request to a store to retrieve multiple
+// items of the dictionary at once. Missing items are omitted.
+type RequestDictItems struct {
+	// ID is a request identifier.
+	ID string
+	// Key is a name of a key.
+	Key string
+	// Items is a list of keys of the dictionary to request.
+	Items []interface{}
+}
+
+// Action implements Request interface.
+func (r *RequestDictItems) Action() string {
+	return ActionDictItems
+}
+
+// Hash implements Request interface.
+func (r *RequestDictItems) Hash() string {
+	return r.Key
+}
+
+// String implement fmt.Stringer interface.
+func (r *RequestDictItems) String() string {
+	return fmt.Sprintf("id: %s, type: dict items, key: %s"+
+		", items: %v", r.ID, r.Key, r.Items)
+}
+
+// Process implements Request interface, it returns a dictionary with
+// the requested items.
+func (r *RequestDictItems) Process(h hash.Hash) (hash.Record, error) {
+	rec, dict, err := dictOf(h, r.Key, false)
+	if err != nil {
+		return hash.RecordZero, err
+	}
+
+	items := reflect.MakeMap(dict.Type())
+	for _, it := range r.Items {
+		item, err := itemOf(dict, it)
+		if err != nil {
+			return hash.RecordZero, err
+		}
+		if val := dict.MapIndex(item); val.IsValid() {
+			items.SetMapIndex(item, val)
+		}
+	}
+
+	rec.Data = items.Interface()
+	return rec, nil
+}
+
+// RequestDictMerge defines a request to a store to merge the given
+// dictionary into the persisted one. The items of the given dictionary
+// replace the existing ones. Missing dictionary is created.
+type RequestDictMerge struct {
+	// ID is a request identifier.
+	ID string
+	// Key is a name of a key.
+	Key string
+	// Data is a dictionary to merge.
+	Data interface{}
+}
+
+// Action implements Request interface.
+func (r *RequestDictMerge) Action() string {
+	return ActionDictMerge
+}
+
+// Hash implements Request interface.
+func (r *RequestDictMerge) Hash() string {
+	return r.Key
+}
+
+// String implement fmt.Stringer interface.
+func (r *RequestDictMerge) String() string {
+	return fmt.Sprintf("id: %s, type: dict merge, key: %s"+
+		", data: %v", r.ID, r.Key, r.Data)
+}
+
+// Process implements Request interface, it returns a merged dictionary.
+func (r *RequestDictMerge) Process(h hash.Hash) (hash.Record, error) {
+	if r.Data == nil || reflect.TypeOf(r.Data).Kind() != reflect.Map {
+		text := fmt.Sprintf("%v is not a dictionary", r.Data)
+		return hash.RecordZero, &ErrConflict{text}
+	}
+
+	rec, dict, err := dictOf(h, r.Key, true)
+	if err != nil {
+		return hash.RecordZero, err
+	}
+
+	patch := reflect.ValueOf(r.Data)
+	if !dict.IsValid() {
+		return storeDict(h, r.Key, rec, copyDict(patch)), nil
+	}
+
+	dict = copyDict(dict)
+	for _, key := range patch.MapKeys() {
+		item, err := itemOf(dict, key.Interface())
+		if err != nil {
+			return hash.RecordZero, err
+		}
+		elem := patch.MapIndex(key).Interface()
+		val, err := valueOf(dict.Type().Elem(), elem)
+		if err != nil {
+			return hash.RecordZero, err
+		}
+		dict.SetMapIndex(item, val)
+	}
+	return storeDict(h, r.Key, rec, dict), nil
+}
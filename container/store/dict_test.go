@@ -0,0 +1,159 @@
+package store
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/ybubnov/memhashd/container/hash"
+)
+
+func TestRequestDictSetItem(t *testing.T) {
+	s := newStore(&Config{Capacity: 0})
+	req := &RequestDictSetItem{Key: "1", Item: "a", Data: 1}
+	if req.Action() != ActionDictSetItem {
+		t.Fatalf("invalid request action: %s", req.Action())
+	}
+	rec, err := s.Serve(req)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if rec.Data.(int) != 1 {
+		t.Fatalf("invalid value returned: %v", rec.Data)
+	}
+
+	dict := map[string]interface{}{"a": 1}
+	if rec, _ = s.Load("1"); !reflect.DeepEqual(rec.Data, dict) {
+		t.Fatalf("invalid dictionary stored: %v", rec.Data)
+	}
+
+	dict = map[string]interface{}{"b": 2}
+	s.Store("2", hash.Record{Data: dict})
+	req = &RequestDictSetItem{Key: "2", Item: "c", Data: 3}
+	if _, err = s.Serve(req); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(dict) != 1 {
+		t.Fatalf("persisted dictionary was modified: %v", dict)
+	}
+
+	req = &RequestDictSetItem{Key: "2", Item: 4, Data: 3}
+	_, err = s.Serve(req)
+	if err == nil || err.Error() != "item 4 is invalid" {
+		t.Fatalf("expected an error, %v", err)
+	}
+
+	// Lists and dictionaries decoded from JSON are not hashable.
+	for _, item := range []interface{}{
+		[]interface{}{1.0}, map[string]interface{}{"a": 1.0}} {
+
+		req = &RequestDictSetItem{Key: "4", Item: item, Data: 3}
+		_, err = s.Serve(req)
+		if _, ok := err.(*ErrConflict); !ok {
+			t.Fatalf("expected conflict error, got %v", err)
+		}
+	}
+	s.Store("5", hash.Record{Data: map[interface{}]interface{}{"a": 1}})
+	req = &RequestDictSetItem{Key: "5", Item: []interface{}{1.0}, Data: 3}
+	if _, err = s.Serve(req); err == nil {
+		t.Fatalf("expected an error for unhashable item")
+	}
+	if _, ok := s.Peek("4"); ok {
+		t.Fatalf("dictionary should not be created")
+	}
+
+	s.Store("3", hash.Record{Data: []int{}})
+	req = &RequestDictSetItem{Key: "3", Item: "a", Data: 3}
+	_, err = s.Serve(req)
+	if err == nil || err.Error() != "3 is not a dictionary" {
+		t.Fatalf("expected an error, %v", err)
+	}
+}
+
+func TestRequestDictDeleteItem(t *testing.T) {
+	s := newStore(&Config{Capacity: 0})
+	s.Store("1", hash.Record{Data: map[int]int{1: 2, 3: 4}})
+
+	req := &RequestDictDeleteItem{Key: "1", Item: 1}
+	if req.Action() != ActionDictDeleteItem {
+		t.Fatalf("invalid request action: %s", req.Action())
+	}
+	rec, err := s.Serve(req)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if rec.Data.(int) != 2 {
+		t.Fatalf("invalid value returned: %v", rec.Data)
+	}
+
+	_, err = s.Serve(req)
+	if err == nil || err.Error() != "item 1 does not exist" {
+		t.Fatalf("expected an error, %v", err)
+	}
+}
+
+func TestRequestDictFields(t *testing.T) {
+	s := newStore(&Config{Capacity: 0})
+	s.Store("1", hash.Record{Data: map[string]int{"b": 1, "a": 2}})
+
+	req := &RequestDictFields{Key: "1"}
+	if req.Action() != ActionDictFields {
+		t.Fatalf("invalid request action: %s", req.Action())
+	}
+	rec, err := s.Serve(req)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !reflect.DeepEqual(rec.Data, []interface{}{"a", "b"}) {
+		t.Fatalf("invalid fields returned: %v", rec.Data)
+	}
+}
+
+func TestRequestDictItems(t *testing.T) {
+	s := newStore(&Config{Capacity: 0})
+	s.Store("1", hash.Record{Data: map[string]int{"a": 1, "b": 2, "c": 3}})
+
+	req := &RequestDictItems{Key: "1", Items: []interface{}{"a", "c", "d"}}
+	if req.Action() != ActionDictItems {
+		t.Fatalf("invalid request action: %s", req.Action())
+	}
+	rec, err := s.Serve(req)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !reflect.DeepEqual(rec.Data, map[string]int{"a": 1, "c": 3}) {
+		t.Fatalf("invalid items returned: %v", rec.Data)
+	}
+}
+
+func TestRequestDictMerge(t *testing.T) {
+	s := newStore(&Config{Capacity: 0})
+	data := map[string]interface{}{"a": 1, "b": 2}
+
+	req := &RequestDictMerge{Key: "1", Data: data}
+	if req.Action() != ActionDictMerge {
+		t.Fatalf("invalid request action: %s", req.Action())
+	}
+	if _, err := s.Serve(req); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	req = &RequestDictMerge{Key: "1", Data: map[string]interface{}{"b": 3}}
+	rec, err := s.Serve(req)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	merged := map[string]interface{}{"a": 1, "b": 3}
+	if !reflect.DeepEqual(rec.Data, merged) {
+		t.Fatalf("invalid dictionary returned: %v", rec.Data)
+	}
+	if data["b"] != 2 {
+		t.Fatalf("merged dictionary was modified: %v", data)
+	}
+
+	req = &RequestDictMerge{Key: "1", Data: 1}
+	_, err = s.Serve(req)
+	if err == nil || err.Error() != "1 is not a dictionary" {
+		t.Fatalf("expected an error, %v", err)
+	}
+}
@@ -38,3 +38,30 @@ type ErrMissing struct {
 func (e *ErrMissing) Error() string {
 	return e.Text
 }
+
+// ErrFull describes error generated when the record cannot be stored,
+// because the limits of the store are exceeded and none of the records
+// could be evicted.
+type ErrFull struct {
+	// Text is a text of the error.
+	Text string
+}
+
+// Error implements error interface. It returns a string representation
+// of the error.
+func (e *ErrFull) Error() string {
+	return e.Text
+}
+
+// ErrUnavailable describes error generated when the number of available
+// copies of the record does not satisfy the requested consistency level.
+type ErrUnavailable struct {
+	// Text is a text of the error.
+	Text string
+}
+
+// Error implements error interface. It returns a string representation
+// of the error.
+func (e *ErrUnavailable) Error() string {
+	return e.Text
+}
@@ -24,3 +24,17 @@ func TestErrorMissing(t *testing.T) {
 		t.Fatalf("invalid error string returned")
 	}
 }
+
+func TestErrorFull(t *testing.T) {
+	err := error(&ErrFull{Text: "splash"})
+	if err.Error() != "splash" {
+		t.Fatalf("invalid error string returned")
+	}
+}
+
+func TestErrorUnavailable(t *testing.T) {
+	err := error(&ErrUnavailable{Text: "gone"})
+	if err.Error() != "gone" {
+		t.Fatalf("invalid error string returned")
+	}
+}
@@ -0,0 +1,186 @@
+package store
+
+import (
+	"fmt"
+	"reflect"
+	"time"
+
+	"github.com/ybubnov/memhashd/container/hash"
+)
+
+const (
+	// PolicyNoEviction is a name of the policy, that never evicts
+	// records, instead an error is returned on attempt to exceed the
+	// limits of the store.
+	PolicyNoEviction = "noeviction"
+
+	// PolicyLRU is a name of the policy, that evicts the least recently
+	// used records.
+	PolicyLRU = "lru"
+
+	// PolicyLFU is a name of the policy, that evicts the least
+	// frequently used records.
+	PolicyLFU = "lfu"
+
+	// PolicyVolatileTTL is a name of the policy, that evicts records
+	// with the nearest expiration time. Permanent records are never
+	// evicted by this policy.
+	PolicyVolatileTTL = "volatile-ttl"
+)
+
+// evictionSamples is a number of records sampled from the store to
+// select a record for the eviction. Eviction policies select the best
+// candidate from the sample instead of the whole store.
+const evictionSamples = 5
+
+// policyMap stores a mapping of names to the eviction policies.
+var policyMap = map[string]EvictionPolicy{
+	PolicyNoEviction:  noEviction{},
+	PolicyLRU:         lruEviction{},
+	PolicyLFU:         lfuEviction{},
+	PolicyVolatileTTL: volatileTTLEviction{},
+}
+
+// EvictionPolicyOf returns an eviction policy by its name. If the policy
+// is undefined, an error is returned to the caller.
+func EvictionPolicyOf(name string) (EvictionPolicy, error) {
+	policy, ok := policyMap[name]
+	if !ok {
+		return nil, fmt.Errorf("store: invalid eviction policy %s", name)
+	}
+	return policy, nil
+}
+
+// Candidate is a record considered for the eviction.
+type Candidate struct {
+	// Key is a key of the record.
+	Key string
+
+	// Record is a record persisted in a store.
+	Record hash.Record
+
+	// Hits is a number of accesses to the record.
+	Hits int64
+}
+
+// EvictionPolicy describes types that select records to evict from the
+// store, when the limits of the store are exceeded.
+type EvictionPolicy interface {
+	// Select returns a position of the candidate to evict, or -1 when
+	// none of the candidates could be evicted.
+	Select(candidates []Candidate) int
+}
+
+// EvictionPolicyFunc is a function adapter for EvictionPolicy interface.
+type EvictionPolicyFunc func([]Candidate) int
+
+// Select implements EvictionPolicy interface.
+func (fn EvictionPolicyFunc) Select(candidates []Candidate) int {
+	return fn(candidates)
+}
+
+// noEviction is a policy that never evicts records.
+type noEviction struct{}
+
+// Select implements EvictionPolicy interface.
+func (noEviction) Select([]Candidate) int {
+	return -1
+}
+
+// lruEviction is a policy that evicts the least recently used records.
+type lruEviction struct{}
+
+// Select implements EvictionPolicy interface. Records that were never
+// accessed are compared by the time of the last update.
+func (lruEviction) Select(candidates []Candidate) int {
+	pos := -1
+	for ii := range candidates {
+		rec := &candidates[ii].Record
+		if pos < 0 || usedAt(rec).Before(usedAt(&candidates[pos].Record)) {
+			pos = ii
+		}
+	}
+	return pos
+}
+
+// usedAt returns a moment when the record was used last time.
+func usedAt(rec *hash.Record) time.Time {
+	if rec.Meta.AccessedAt.After(rec.Meta.UpdatedAt) {
+		return rec.Meta.AccessedAt
+	}
+	return rec.Meta.UpdatedAt
+}
+
+// lfuEviction is a policy that evicts the least frequently used records.
+type lfuEviction struct{}
+
+// Select implements EvictionPolicy interface.
+func (lfuEviction) Select(candidates []Candidate) int {
+	pos := -1
+	for ii := range candidates {
+		if pos < 0 || candidates[ii].Hits < candidates[pos].Hits {
+			pos = ii
+		}
+	}
+	return pos
+}
+
+// volatileTTLEviction is a policy that evicts records with the nearest
+// expiration time.
+type volatileTTLEviction struct{}
+
+// Select implements EvictionPolicy interface.
+func (volatileTTLEviction) Select(candidates []Candidate) int {
+	pos := -1
+	for ii := range candidates {
+		rec := &candidates[ii].Record
+		if rec.IsPermanent() {
+			continue
+		}
+		if pos < 0 || rec.ExpiresAt().Before(candidates[pos].Record.ExpiresAt()) {
+			pos = ii
+		}
+	}
+	return pos
+}
+
+// sizeOf returns an estimated amount of memory in bytes occupied by
+// the given value.
+func sizeOf(v interface{}) int64 {
+	return sizeOfValue(reflect.ValueOf(v))
+}
+
+// sizeOfValue returns an estimated amount of memory in bytes occupied
+// by the given value.
+func sizeOfValue(val reflect.Value) int64 {
+	switch val.Kind() {
+	case reflect.Invalid:
+		return 0
+	case reflect.String:
+		return int64(val.Len())
+	case reflect.Interface, reflect.Ptr:
+		if val.IsNil() {
+			return int64(val.Type().Size())
+		}
+		return int64(val.Type().Size()) + sizeOfValue(val.Elem())
+	case reflect.Slice, reflect.Array:
+		size := int64(val.Type().Size())
+		for ii := 0; ii < val.Len(); ii++ {
+			size += sizeOfValue(val.Index(ii))
+		}
+		return size
+	case reflect.Map:
+		size := int64(val.Type().Size())
+		for _, key := range val.MapKeys() {
+			size += sizeOfValue(key) + sizeOfValue(val.MapIndex(key))
+		}
+		return size
+	case reflect.Struct:
+		var size int64
+		for ii := 0; ii < val.NumField(); ii++ {
+			size += sizeOfValue(val.Field(ii))
+		}
+		return size
+	}
+	return int64(val.Type().Size())
+}
@@ -0,0 +1,133 @@
+package store
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ybubnov/memhashd/container/hash"
+)
+
+func TestEvictionPolicyOf(t *testing.T) {
+	for name := range policyMap {
+		if _, err := EvictionPolicyOf(name); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	}
+
+	_, err := EvictionPolicyOf("random")
+	if err == nil || err.Error() != "store: invalid eviction policy random" {
+		t.Fatalf("expected error on invalid policy")
+	}
+}
+
+func TestEvictionPolicySelect(t *testing.T) {
+	now := time.Now()
+	candidates := []Candidate{
+		{Key: "1", Hits: 3, Record: hash.Record{Meta: hash.Meta{
+			UpdatedAt:  now.Add(-time.Hour),
+			ExpireTime: time.Hour, CreatedAt: now,
+		}}},
+		{Key: "2", Hits: 1, Record: hash.Record{Meta: hash.Meta{
+			UpdatedAt: now.Add(-time.Hour), AccessedAt: now,
+		}}},
+		{Key: "3", Hits: 2, Record: hash.Record{Meta: hash.Meta{
+			UpdatedAt:  now.Add(-time.Minute),
+			ExpireTime: time.Minute, CreatedAt: now,
+		}}},
+	}
+
+	tests := []struct {
+		Policy   string
+		Position int
+	}{
+		{PolicyNoEviction, -1},
+		{PolicyLRU, 0},
+		{PolicyLFU, 1},
+		{PolicyVolatileTTL, 2},
+	}
+
+	for _, tt := range tests {
+		policy, _ := EvictionPolicyOf(tt.Policy)
+		if pos := policy.Select(candidates); pos != tt.Position {
+			t.Fatalf("%s selected invalid candidate: %d", tt.Policy, pos)
+		}
+	}
+
+	policy, _ := EvictionPolicyOf(PolicyVolatileTTL)
+	if pos := policy.Select(candidates[1:2]); pos != -1 {
+		t.Fatalf("permanent record should not be evicted")
+	}
+}
+
+func TestSizeOf(t *testing.T) {
+	tests := []struct {
+		Value interface{}
+		Size  int64
+	}{
+		{nil, 0},
+		{"hello", 5},
+		{int64(1), 8},
+		{[]string{"a", "bc"}, 27},
+		{map[string]string{"a": "bc"}, 11},
+	}
+
+	for _, tt := range tests {
+		if size := sizeOf(tt.Value); size != tt.Size {
+			t.Fatalf("invalid size of %v: %d", tt.Value, size)
+		}
+	}
+}
+
+func TestStoreMaxKeys(t *testing.T) {
+	s := newStore(&Config{MaxKeys: 2})
+	s.Store("1", hash.Record{Data: 1})
+	s.Store("2", hash.Record{Data: 2})
+
+	_, err := s.Serve(&RequestStore{Key: "3", Data: 3})
+	if _, ok := err.(*ErrFull); !ok {
+		t.Fatalf("expected full error, got %v", err)
+	}
+
+	// An update of the existing key does not change the number of
+	// keys in the store.
+	if _, err = s.Serve(&RequestStore{Key: "2", Data: 4}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+}
+
+func TestStoreMaxMemory(t *testing.T) {
+	policy, _ := EvictionPolicyOf(PolicyLRU)
+	s := newStore(&Config{MaxMemory: 10, EvictionPolicy: policy})
+
+	s.Store("1", hash.Record{Data: "aaaa"})
+	s.Store("2", hash.Record{Data: "bbbb"})
+	if s.memory != 10 {
+		t.Fatalf("invalid amount of memory: %d", s.memory)
+	}
+
+	s.Load("1")
+	s.Store("3", hash.Record{Data: "cc"})
+	if _, ok := s.Load("2"); ok {
+		t.Fatalf("least recently used record should be evicted")
+	}
+	if _, ok := s.Load("1"); !ok {
+		t.Fatalf("recently used record should not be evicted")
+	}
+	if s.memory != 8 {
+		t.Fatalf("invalid amount of memory: %d", s.memory)
+	}
+
+	_, err := s.Serve(&RequestStore{Key: "4", Data: "ddddddddddd"})
+	if _, ok := err.(*ErrFull); !ok {
+		t.Fatalf("expected full error, got %v", err)
+	}
+	if _, ok := s.Load("1"); !ok {
+		t.Fatalf("records should not be evicted for too large record")
+	}
+
+	s.Delete("1")
+	s.Delete("3")
+	if s.memory != 0 {
+		t.Fatalf("invalid amount of memory: %d", s.memory)
+	}
+}
@@ -0,0 +1,451 @@
+package store
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/ybubnov/memhashd/container/hash"
+	"github.com/ybubnov/memhashd/system/log"
+)
+
+const (
+	// JournalVersion is a version of the journal format.
+	JournalVersion = 1
+
+	// FsyncAlways is a policy to flush the journal to the disk after
+	// each write.
+	FsyncAlways = "always"
+
+	// FsyncEverySec is a policy to flush the journal to the disk once
+	// per second.
+	FsyncEverySec = "everysec"
+
+	// FsyncNever is a policy to leave flushing of the journal to the
+	// operating system.
+	FsyncNever = "never"
+
+	// journalOpRestore is an operation to persist the record as is.
+	journalOpRestore = "restore"
+
+	// journalOpDelete is an operation to delete the record.
+	journalOpDelete = "delete"
+
+	// defaultCompactSize is a default size of the journal in bytes, after
+	// which the journal is compacted.
+	defaultCompactSize = 64 << 20
+)
+
+// fsyncMap stores a list of supported fsync policies.
+var fsyncMap = map[string]struct{}{
+	FsyncAlways:   struct{}{},
+	FsyncEverySec: struct{}{},
+	FsyncNever:    struct{}{},
+}
+
+// FsyncPolicyOf validates a name of the fsync policy. If the policy is
+// undefined, an error is returned to the caller.
+func FsyncPolicyOf(name string) (string, error) {
+	if _, ok := fsyncMap[name]; !ok {
+		err := fmt.Errorf("store: invalid fsync policy %s", name)
+		return "", err
+	}
+	return name, nil
+}
+
+// journalEntry is a single operation of the journal. Instead of the
+// requests, the journal records the resulting state of the records, so
+// the expiration of the records is not extended on replay.
+type journalEntry struct {
+	Op string `json:"op"`
+	snapshotEntry
+}
+
+// JournalConfig is a configuration of the journal.
+type JournalConfig struct {
+	// Path is a path to the journal file.
+	Path string
+
+	// Fsync is a policy of flushing the journal to the disk. By
+	// default the journal is flushed once per second.
+	Fsync string
+
+	// CompactSize is a size of the journal in bytes, after which the
+	// journal is rewritten to contain only the current state of the
+	// records. The journal is compacted only when it doubled in size
+	// since the last compaction.
+	CompactSize int64
+}
+
+// Journal is an append-only log of the store modifications. Each
+// modification is appended to the journal before the response is
+// returned to the client.
+type Journal struct {
+	path        string
+	fsync       string
+	compactSize int64
+
+	file *os.File
+	// Size of the journal file and the size of the file after the last
+	// compaction.
+	size     int64
+	baseSize int64
+
+	// empty is true, when the journal had no entries on open.
+	empty bool
+	// replaying is true, while the journal is replayed, so modifications
+	// of the store are not recorded.
+	replaying bool
+
+	// rewriting is true, while the journal is compacted in background.
+	// The entries appended meanwhile are buffered in pending, so they
+	// are written after the current state of the records.
+	rewriting bool
+	pending   []journalEntry
+	rewrites  sync.WaitGroup
+
+	// A mutex to access the journal file.
+	mu sync.Mutex
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// OpenJournal opens the journal file for appending. The file is created,
+// when it does not exist.
+func OpenJournal(config *JournalConfig) (*Journal, error) {
+	fsync := config.Fsync
+	if fsync == "" {
+		fsync = FsyncEverySec
+	}
+	if _, err := FsyncPolicyOf(fsync); err != nil {
+		return nil, err
+	}
+
+	compactSize := config.CompactSize
+	if compactSize <= 0 {
+		compactSize = defaultCompactSize
+	}
+
+	j := &Journal{
+		path:        config.Path,
+		fsync:       fsync,
+		compactSize: compactSize,
+	}
+	if err := j.open(); err != nil {
+		return nil, err
+	}
+
+	j.empty = j.size == 0
+	if j.empty {
+		n, err := j.writeHeader(j.file)
+		j.size += n
+		if err != nil {
+			j.file.Close()
+			return nil, err
+		}
+	}
+	j.baseSize = j.size
+
+	if j.fsync == FsyncEverySec {
+		j.stop = make(chan struct{})
+		j.done = make(chan struct{})
+		go j.run()
+	}
+	return j, nil
+}
+
+// open opens the journal file for appending.
+func (j *Journal) open() error {
+	file, err := os.OpenFile(j.path, os.O_CREATE|os.O_APPEND|os.O_RDWR, 0644)
+	if err != nil {
+		return err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	j.file = file
+	j.size = info.Size()
+	return nil
+}
+
+// writeHeader writes a header of the journal into the given writer. It
+// returns the number of written bytes.
+func (j *Journal) writeHeader(w io.Writer) (int64, error) {
+	header := snapshotHeader{Version: JournalVersion, CreatedAt: time.Now()}
+	b, err := json.Marshal(header)
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := w.Write(append(b, '\n'))
+	return int64(n), err
+}
+
+// Empty returns true, when the journal had no entries on open.
+func (j *Journal) Empty() bool {
+	return j.empty
+}
+
+// Replay reads the journal and applies the recorded modifications to
+// the given hash. It returns the number of replayed entries.
+func (j *Journal) Replay(h hash.Hash) (int, error) {
+	j.mu.Lock()
+	j.replaying = true
+	j.mu.Unlock()
+
+	defer func() {
+		j.mu.Lock()
+		j.replaying = false
+		j.mu.Unlock()
+	}()
+
+	file, err := os.Open(j.path)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	decoder := json.NewDecoder(bufio.NewReader(file))
+	var header snapshotHeader
+	if err := decoder.Decode(&header); err != nil {
+		return 0, err
+	}
+	if header.Version != JournalVersion {
+		err := fmt.Errorf("store: unsupported journal version %d",
+			header.Version)
+		return 0, err
+	}
+
+	var replayed int
+	for {
+		var entry journalEntry
+		err := decoder.Decode(&entry)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			// The last entry could be partially written, when the
+			// node crashed, so stop the replay on a broken entry.
+			log.ErrorLogf("store/JOURNAL_REPLAY",
+				"stopped replay of %s, %s", j.path, err)
+			break
+		}
+
+		rec := entry.record()
+		switch {
+		case entry.Op == journalOpDelete || rec.IsExpired():
+			h.Delete(entry.Key)
+		case entry.Op == journalOpRestore:
+			h.Restore(entry.Key, rec)
+		default:
+			err := fmt.Errorf("store: invalid journal operation %s", entry.Op)
+			return replayed, err
+		}
+		replayed++
+	}
+
+	log.InfoLogf("store/JOURNAL_REPLAY",
+		"replayed %d entries from %s", replayed, j.path)
+	return replayed, nil
+}
+
+// appendRestore records the persisted record into the journal.
+func (j *Journal) appendRestore(key string, rec hash.Record) error {
+	return j.append(journalEntry{journalOpRestore, entryOf(key, rec)})
+}
+
+// appendDelete records the deletion of the record into the journal.
+func (j *Journal) appendDelete(key string) error {
+	return j.append(journalEntry{journalOpDelete, snapshotEntry{Key: key}})
+}
+
+// append writes an entry into the journal and flushes it to the disk
+// according to the fsync policy.
+func (j *Journal) append(entry journalEntry) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if j.replaying {
+		return nil
+	}
+
+	b, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	n, err := j.file.Write(append(b, '\n'))
+	j.size += int64(n)
+	if err != nil {
+		return err
+	}
+	if j.rewriting {
+		j.pending = append(j.pending, entry)
+	}
+
+	if j.fsync == FsyncAlways {
+		return j.file.Sync()
+	}
+	return nil
+}
+
+// needsCompaction returns true, when the journal exceeded the compaction
+// size and doubled since the last compaction.
+func (j *Journal) needsCompaction() bool {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.compactable()
+}
+
+// compactable returns true, when the journal needs compaction and it is
+// not being compacted. The journal mutex should be held by the caller.
+func (j *Journal) compactable() bool {
+	return !j.replaying && !j.rewriting &&
+		j.size >= j.compactSize && j.size >= 2*j.baseSize
+}
+
+// beginRewrite starts buffering of the appended entries, when the
+// journal needs compaction. It returns false, when the compaction is
+// not needed or it is already in progress. Each started rewrite should
+// be completed by the call of rewrite.
+func (j *Journal) beginRewrite() bool {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if !j.compactable() {
+		return false
+	}
+	j.rewriting, j.pending = true, nil
+	j.rewrites.Add(1)
+	return true
+}
+
+// rewrite replaces the journal with the given list of entries followed
+// by the entries appended since the start of the rewrite. The entries
+// are written into a temporary file first, which then replaces the
+// journal, so the journal is never partially written. Only the pending
+// entries are written under the journal lock.
+func (j *Journal) rewrite(entries []snapshotEntry) (err error) {
+	defer j.rewrites.Done()
+	defer func() {
+		if err != nil {
+			j.mu.Lock()
+			j.rewriting, j.pending = false, nil
+			j.mu.Unlock()
+		}
+	}()
+
+	dir, name := filepath.Split(j.path)
+	file, err := ioutil.TempFile(dir, "."+name)
+	if err != nil {
+		return err
+	}
+
+	// Remove the temporary file, when it was not renamed.
+	defer os.Remove(file.Name())
+	defer file.Close()
+
+	w := bufio.NewWriter(file)
+	if _, err = j.writeHeader(w); err != nil {
+		return err
+	}
+
+	encoder := json.NewEncoder(w)
+	for _, entry := range entries {
+		if err = encoder.Encode(journalEntry{journalOpRestore, entry}); err != nil {
+			return err
+		}
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	for _, entry := range j.pending {
+		if err = encoder.Encode(entry); err != nil {
+			return err
+		}
+	}
+
+	if err = w.Flush(); err != nil {
+		return err
+	}
+	if err = file.Sync(); err != nil {
+		return err
+	}
+	if err = file.Close(); err != nil {
+		return err
+	}
+	if err = os.Rename(file.Name(), j.path); err != nil {
+		return err
+	}
+
+	// Re-open the compacted journal, all subsequent entries will be
+	// appended to it.
+	j.rewriting, j.pending = false, nil
+	j.file.Close()
+	if err = j.open(); err != nil {
+		return err
+	}
+
+	log.InfoLogf("store/JOURNAL_REWRITE",
+		"compacted %s to %d bytes", j.path, j.size)
+	j.baseSize = j.size
+	return nil
+}
+
+// run flushes the journal to the disk once per second.
+func (j *Journal) run() {
+	defer close(j.done)
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-j.stop:
+			return
+		case <-ticker.C:
+			if err := j.Sync(); err != nil {
+				log.ErrorLogf("store/JOURNAL_RUN",
+					"failed to sync %s, %s", j.path, err)
+			}
+		}
+	}
+}
+
+// Sync flushes the journal to the disk.
+func (j *Journal) Sync() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.file.Sync()
+}
+
+// Close flushes the journal to the disk and closes the journal file.
+func (j *Journal) Close() error {
+	// Wait for the compaction in background, so the journal file is
+	// not re-opened after the close.
+	j.rewrites.Wait()
+
+	if j.stop != nil {
+		close(j.stop)
+		<-j.done
+		j.stop = nil
+	}
+
+	if err := j.Sync(); err != nil {
+		j.file.Close()
+		return err
+	}
+	return j.file.Close()
+}
@@ -0,0 +1,180 @@
+package store
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ybubnov/memhashd/container/hash"
+)
+
+func tempJournal(t *testing.T, config *JournalConfig) (*Journal, func()) {
+	dir, err := ioutil.TempDir("", "memhashd")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	config.Path = filepath.Join(dir, "journal")
+	j, err := OpenJournal(config)
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatalf("unexpected error: %s", err)
+	}
+	return j, func() { os.RemoveAll(dir) }
+}
+
+func TestFsyncPolicyOf(t *testing.T) {
+	for _, name := range []string{FsyncAlways, FsyncEverySec, FsyncNever} {
+		if _, err := FsyncPolicyOf(name); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	}
+	if _, err := FsyncPolicyOf("sometimes"); err == nil {
+		t.Fatalf("error expected for invalid fsync policy")
+	}
+}
+
+func TestJournalReplay(t *testing.T) {
+	j, cleanup := tempJournal(t, &JournalConfig{Fsync: FsyncAlways})
+	defer cleanup()
+
+	if !j.Empty() {
+		t.Fatalf("new journal should be empty")
+	}
+
+	s1 := newStore(&Config{Capacity: 16, Journal: j})
+	s1.Store("1", hash.Record{Data: "a"})
+	s1.Store("2", hash.Record{Data: "b"})
+	s1.Store("3", hash.Record{Data: "c", Meta: hash.Meta{
+		ExpireTime: 50 * time.Millisecond}})
+
+	if _, err := s1.Serve(&RequestIncr{Key: "4"}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if _, err := s1.Serve(&RequestDelete{Key: "2"}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := j.Close(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	time.Sleep(100 * time.Millisecond)
+
+	j, err := OpenJournal(&JournalConfig{Path: j.path})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer j.Close()
+
+	if j.Empty() {
+		t.Fatalf("journal should not be empty")
+	}
+
+	s2 := newStore(&Config{Capacity: 16, Journal: j})
+	size := j.size
+	if _, err := j.Replay(s2); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if j.size != size {
+		t.Fatalf("replay should not be recorded into the journal")
+	}
+
+	if rec, ok := s2.Peek("1"); !ok || rec.Data.(string) != "a" {
+		t.Fatalf("invalid record replayed: %v", rec)
+	}
+	if rec, ok := s2.Peek("4"); !ok || rec.Data.(float64) != 1 {
+		t.Fatalf("invalid record replayed: %v", rec)
+	}
+	if _, ok := s2.Peek("2"); ok {
+		t.Fatalf("deleted record should not be replayed")
+	}
+	if _, ok := s2.Peek("3"); ok {
+		t.Fatalf("expired record should not be replayed")
+	}
+}
+
+func TestJournalCompaction(t *testing.T) {
+	j, cleanup := tempJournal(t, &JournalConfig{
+		Fsync: FsyncNever, CompactSize: 1024})
+	defer cleanup()
+	defer j.Close()
+
+	s := newStore(&Config{Capacity: 16, Journal: j})
+	for i := 0; i < 100; i++ {
+		s.Store("1", hash.Record{Data: i})
+		// The journal is compacted in background.
+		j.rewrites.Wait()
+	}
+
+	if j.size > 1024 {
+		t.Fatalf("journal should be compacted: %d bytes", j.size)
+	}
+
+	s2 := newStore(&Config{Capacity: 16})
+	if _, err := j.Replay(s2); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if rec, ok := s2.Peek("1"); !ok || rec.Data.(float64) != 99 {
+		t.Fatalf("invalid record replayed: %v", rec)
+	}
+}
+
+func TestJournalCompactionPending(t *testing.T) {
+	j, cleanup := tempJournal(t, &JournalConfig{
+		Fsync: FsyncNever, CompactSize: 1024})
+	defer cleanup()
+	defer j.Close()
+
+	s := newStore(&Config{Capacity: 16, Journal: j})
+	for i := 0; i < 100; i++ {
+		s.Store(fmt.Sprintf("%d", i%10), hash.Record{Data: i})
+	}
+
+	// The modifications made during the compaction should be kept.
+	j.rewrites.Wait()
+	s2 := newStore(&Config{Capacity: 16})
+	if _, err := j.Replay(s2); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	for i := 90; i < 100; i++ {
+		rec, ok := s2.Peek(fmt.Sprintf("%d", i%10))
+		if !ok || rec.Data.(float64) != float64(i) {
+			t.Fatalf("invalid record replayed: %v", rec)
+		}
+	}
+}
+
+func TestJournalWriteAhead(t *testing.T) {
+	j, cleanup := tempJournal(t, &JournalConfig{Fsync: FsyncNever})
+	defer cleanup()
+
+	s := newStore(&Config{Capacity: 16, Journal: j})
+	s.Store("1", hash.Record{Data: "a"})
+
+	// Break the journal, so the modifications could not be recorded.
+	j.file.Close()
+
+	if _, err := s.Serve(&RequestStore{Key: "1", Data: "b"}); err == nil {
+		t.Fatalf("expected an error on failed append")
+	}
+	if _, err := s.Serve(&RequestStore{Key: "2", Data: "c"}); err == nil {
+		t.Fatalf("expected an error on failed append")
+	}
+	if _, err := s.Serve(&RequestDelete{Key: "1"}); err == nil {
+		t.Fatalf("expected an error on failed append")
+	}
+
+	if rec, ok := s.Peek("1"); !ok || rec.Data.(string) != "a" {
+		t.Fatalf("failed modification should not be applied: %v", rec)
+	}
+	if _, ok := s.Peek("2"); ok {
+		t.Fatalf("failed modification should not be applied")
+	}
+	if n := atomic.LoadInt64(&s.numKeys); n != 1 {
+		t.Fatalf("invalid number of keys: %d", n)
+	}
+}
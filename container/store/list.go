@@ -0,0 +1,396 @@
+package store
+
+import (
+	"fmt"
+	"reflect"
+
+	"github.com/ybubnov/memhashd/container/hash"
+)
+
+const (
+	// ActionListPush is an action to insert an element to a list.
+	ActionListPush = "push"
+
+	// ActionListPop is an action to extract an element from a list.
+	ActionListPop = "pop"
+
+	// ActionListSet is an action to replace an element of a list.
+	ActionListSet = "lset"
+
+	// ActionListRemove is an action to remove an element of a list.
+	ActionListRemove = "lrem"
+
+	// ActionListTrim is an action to trim a list to the given range.
+	ActionListTrim = "trim"
+
+	// ActionListRange is an action to access a range of a list.
+	ActionListRange = "range"
+)
+
+// listOf loads a list persisted under the given key. When the record
+// is missing and create flag is set, an empty list is returned,
+// otherwise an error is returned.
+func listOf(h hash.Hash, key string, create bool) (
+	hash.Record, reflect.Value, error) {
+
+	rec, ok := h.Load(key)
+	if !ok && create {
+		rec = hash.Record{Data: []interface{}{}}
+	}
+	if !ok && !create {
+		text := fmt.Sprintf("%s does not exist", key)
+		return hash.RecordZero, reflect.Value{}, &ErrMissing{text}
+	}
+
+	if rec.Data == nil || reflect.TypeOf(rec.Data).Kind() != reflect.Slice {
+		text := fmt.Sprintf("%s is not a list", key)
+		return hash.RecordZero, reflect.Value{}, &ErrConflict{text}
+	}
+	return rec, reflect.ValueOf(rec.Data), nil
+}
+
+// elementOf converts the given value to the element of the list. It
+// returns an error, when the value cannot be stored in the list.
+func elementOf(list reflect.Value, v interface{}) (reflect.Value, error) {
+	elemType := list.Type().Elem()
+	if v == nil {
+		return reflect.Zero(elemType), nil
+	}
+
+	val := reflect.ValueOf(v)
+	if !val.Type().AssignableTo(elemType) {
+		text := fmt.Sprintf("%v cannot be stored in a list of %s", v, elemType)
+		return reflect.Value{}, &ErrConflict{text}
+	}
+	return val, nil
+}
+
+// storeList persists a new version of the list under the given key. The
+// expiration settings of the record are preserved.
+func storeList(h hash.Hash, key string, rec hash.Record,
+	list reflect.Value) hash.Record {
+
+	return h.Store(key, hash.Record{
+		Data: list.Interface(), Meta: rec.Meta,
+	})
+}
+
+// sliceOf returns a copy of the list in the given range of positions.
+// The persisted lists are never modified in place, since they could be
+// referenced by the concurrent readers.
+func sliceOf(list reflect.Value, start, stop int) reflect.Value {
+	slice := reflect.MakeSlice(list.Type(), 0, stop-start+1)
+	return reflect.AppendSlice(slice, list.Slice(start, stop))
+}
+
+// rangeOf converts the start and stop positions into a range within
+// the bounds of the list. Negative positions are counted from the end
+// of the list, so -1 is the last element. Stop position is inclusive.
+func rangeOf(length int, start, stop int64) (int, int) {
+	n := int64(length)
+	if start < 0 {
+		start += n
+	}
+	if stop < 0 {
+		stop += n
+	}
+	if start < 0 {
+		start = 0
+	}
+	if stop >= n {
+		stop = n - 1
+	}
+	if start > stop {
+		return 0, 0
+	}
+	return int(start), int(stop) + 1
+}
+
+// RequestListPush defines a request to a store to insert an element to
+// the beginning or the end of the list. Missing list is created.
+type RequestListPush struct {
+	// ID is a request identifier.
+	ID string
+	// Key is a name of the key.
+	Key string
+	// Data is an element to insert.
+	Data interface{}
+	// Front defines whether the element is inserted to the beginning
+	// of the list, by default it is appended to the end.
+	Front bool
+}
+
+// Action implements Request interface.
+func (r *RequestListPush) Action() string {
+	return ActionListPush
+}
+
+// Hash implements Request interface.
+func (r *RequestListPush) Hash() string {
+	return r.Key
+}
+
+// String implements fmt.Stringer interface.
+func (r *RequestListPush) String() string {
+	return fmt.Sprintf("id: %s, type: list push, key: %s"+
+		", data: %v, front: %t", r.ID, r.Key, r.Data, r.Front)
+}
+
+// Process implements Request interface, it returns a list with the
+// inserted element.
+func (r *RequestListPush) Process(h hash.Hash) (hash.Record, error) {
+	rec, list, err := listOf(h, r.Key, true)
+	if err != nil {
+		return hash.RecordZero, err
+	}
+
+	elem, err := elementOf(list, r.Data)
+	if err != nil {
+		return hash.RecordZero, err
+	}
+
+	single := reflect.Append(reflect.MakeSlice(list.Type(), 0, 1), elem)
+	if r.Front {
+		list = reflect.AppendSlice(single, list)
+	} else {
+		list = reflect.Append(sliceOf(list, 0, list.Len()), elem)
+	}
+	return storeList(h, r.Key, rec, list), nil
+}
+
+// RequestListPop defines a request to a store to extract an element
+// from the beginning or the end of the list. When the list is empty,
+// an error is returned.
+type RequestListPop struct {
+	// ID is a request identifier.
+	ID string
+	// Key is a name of the key.
+	Key string
+	// Front defines whether the element is extracted from the
+	// beginning of the list, by default it is extracted from the end.
+	Front bool
+}
+
+// Action implements Request interface.
+func (r *RequestListPop) Action() string {
+	return ActionListPop
+}
+
+// Hash implements Request interface.
+func (r *RequestListPop) Hash() string {
+	return r.Key
+}
+
+// String implements fmt.Stringer interface.
+func (r *RequestListPop) String() string {
+	return fmt.Sprintf("id: %s, type: list pop, key: %s"+
+		", front: %t", r.ID, r.Key, r.Front)
+}
+
+// Process implements Request interface, it returns an extracted
+// element of the list.
+func (r *RequestListPop) Process(h hash.Hash) (hash.Record, error) {
+	rec, list, err := listOf(h, r.Key, false)
+	if err != nil {
+		return hash.RecordZero, err
+	}
+	if list.Len() == 0 {
+		text := fmt.Sprintf("%s is empty", r.Key)
+		return hash.RecordZero, &ErrMissing{text}
+	}
+
+	pos, start, stop := list.Len()-1, 0, list.Len()-1
+	if r.Front {
+		pos, start, stop = 0, 1, list.Len()
+	}
+
+	elem := list.Index(pos).Interface()
+	rec = storeList(h, r.Key, rec, sliceOf(list, start, stop))
+	rec.Data = elem
+	return rec, nil
+}
+
+// RequestListSet defines a request to a store to replace an element
+// of the list at the given position. When position exceeds an amount
+// of items in a list, an error is returned.
+type RequestListSet struct {
+	// ID is a request identifier.
+	ID string
+	// Key is a name of the key.
+	Key string
+	// Index is a position in a list.
+	Index uint64
+	// Data is a new value of the element.
+	Data interface{}
+}
+
+// Action implements Request interface.
+func (r *RequestListSet) Action() string {
+	return ActionListSet
+}
+
+// Hash implements Request interface.
+func (r *RequestListSet) Hash() string {
+	return r.Key
+}
+
+// String implements fmt.Stringer interface.
+func (r *RequestListSet) String() string {
+	return fmt.Sprintf("id: %s, type: list set, key: %s"+
+		", index: %d, data: %v", r.ID, r.Key, r.Index, r.Data)
+}
+
+// Process implements Request interface, it returns a list with the
+// replaced element.
+func (r *RequestListSet) Process(h hash.Hash) (hash.Record, error) {
+	rec, list, err := listOf(h, r.Key, false)
+	if err != nil {
+		return hash.RecordZero, err
+	}
+	if uint64(list.Len()) <= r.Index {
+		text := fmt.Sprintf("position %d is out of range", r.Index)
+		return hash.RecordZero, &ErrConflict{text}
+	}
+
+	elem, err := elementOf(list, r.Data)
+	if err != nil {
+		return hash.RecordZero, err
+	}
+
+	list = sliceOf(list, 0, list.Len())
+	list.Index(int(r.Index)).Set(elem)
+	return storeList(h, r.Key, rec, list), nil
+}
+
+// RequestListRemove defines a request to a store to remove an element
+// of the list at the given position. When position exceeds an amount
+// of items in a list, an error is returned.
+type RequestListRemove struct {
+	// ID is a request identifier.
+	ID string
+	// Key is a name of the key.
+	Key string
+	// Index is a position in a list.
+	Index uint64
+}
+
+// Action implements Request interface.
+func (r *RequestListRemove) Action() string {
+	return ActionListRemove
+}
+
+// Hash implements Request interface.
+func (r *RequestListRemove) Hash() string {
+	return r.Key
+}
+
+// String implements fmt.Stringer interface.
+func (r *RequestListRemove) String() string {
+	return fmt.Sprintf("id: %s, type: list remove, key: %s"+
+		", index: %d", r.ID, r.Key, r.Index)
+}
+
+// Process implements Request interface, it returns a removed element
+// of the list.
+func (r *RequestListRemove) Process(h hash.Hash) (hash.Record, error) {
+	rec, list, err := listOf(h, r.Key, false)
+	if err != nil {
+		return hash.RecordZero, err
+	}
+	if uint64(list.Len()) <= r.Index {
+		text := fmt.Sprintf("position %d is out of range", r.Index)
+		return hash.RecordZero, &ErrConflict{text}
+	}
+
+	pos := int(r.Index)
+	elem := list.Index(pos).Interface()
+
+	head := sliceOf(list, 0, pos)
+	list = reflect.AppendSlice(head, list.Slice(pos+1, list.Len()))
+
+	rec = storeList(h, r.Key, rec, list)
+	rec.Data = elem
+	return rec, nil
+}
+
+// RequestListTrim defines a request to a store to trim the list, so it
+// contains only elements in the specified range of positions.
+type RequestListTrim struct {
+	// ID is a request identifier.
+	ID string
+	// Key is a name of the key.
+	Key string
+	// Start is a first position of the range.
+	Start int64
+	// Stop is a last position of the range (inclusive).
+	Stop int64
+}
+
+// Action implements Request interface.
+func (r *RequestListTrim) Action() string {
+	return ActionListTrim
+}
+
+// Hash implements Request interface.
+func (r *RequestListTrim) Hash() string {
+	return r.Key
+}
+
+// String implements fmt.Stringer interface.
+func (r *RequestListTrim) String() string {
+	return fmt.Sprintf("id: %s, type: list trim, key: %s"+
+		", start: %d, stop: %d", r.ID, r.Key, r.Start, r.Stop)
+}
+
+// Process implements Request interface, it returns a trimmed list.
+func (r *RequestListTrim) Process(h hash.Hash) (hash.Record, error) {
+	rec, list, err := listOf(h, r.Key, false)
+	if err != nil {
+		return hash.RecordZero, err
+	}
+
+	start, stop := rangeOf(list.Len(), r.Start, r.Stop)
+	return storeList(h, r.Key, rec, sliceOf(list, start, stop)), nil
+}
+
+// RequestListRange defines a request to a store to retrieve elements of
+// the list in the specified range of positions.
+type RequestListRange struct {
+	// ID is a request identifier.
+	ID string
+	// Key is a name of the key.
+	Key string
+	// Start is a first position of the range.
+	Start int64
+	// Stop is a last position of the range (inclusive).
+	Stop int64
+}
+
+// Action implements Request interface.
+func (r *RequestListRange) Action() string {
+	return ActionListRange
+}
+
+// Hash implements Request interface.
+func (r *RequestListRange) Hash() string {
+	return r.Key
+}
+
+// String implements fmt.Stringer interface.
+func (r *RequestListRange) String() string {
+	return fmt.Sprintf("id: %s, type: list range, key: %s"+
+		", start: %d, stop: %d", r.ID, r.Key, r.Start, r.Stop)
+}
+
+// Process implements Request interface, it returns elements of the
+// list in the requested range.
+func (r *RequestListRange) Process(h hash.Hash) (hash.Record, error) {
+	rec, list, err := listOf(h, r.Key, false)
+	if err != nil {
+		return hash.RecordZero, err
+	}
+
+	start, stop := rangeOf(list.Len(), r.Start, r.Stop)
+	rec.Data = sliceOf(list, start, stop).Interface()
+	return rec, nil
+}
@@ -0,0 +1,186 @@
+package store
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/ybubnov/memhashd/container/hash"
+)
+
+func TestRangeOf(t *testing.T) {
+	tests := []struct {
+		Len         int
+		Start, Stop int64
+		Range       [2]int
+	}{
+		{5, 0, -1, [2]int{0, 5}},
+		{5, 1, 2, [2]int{1, 3}},
+		{5, -2, 10, [2]int{3, 5}},
+		{5, 3, 1, [2]int{0, 0}},
+		{5, 7, 9, [2]int{0, 0}},
+		{0, 0, -1, [2]int{0, 0}},
+	}
+
+	for _, tt := range tests {
+		start, stop := rangeOf(tt.Len, tt.Start, tt.Stop)
+		if [2]int{start, stop} != tt.Range {
+			t.Fatalf("invalid range of [%d:%d] for %d: [%d:%d]",
+				tt.Start, tt.Stop, tt.Len, start, stop)
+		}
+	}
+}
+
+func TestRequestListPush(t *testing.T) {
+	s := newStore(&Config{Capacity: 0})
+	req := &RequestListPush{Key: "1", Data: "a"}
+	if req.Action() != ActionListPush {
+		t.Fatalf("invalid request action: %s", req.Action())
+	}
+	if _, err := s.Serve(req); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	req = &RequestListPush{Key: "1", Data: "b", Front: true}
+	rec, err := s.Serve(req)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !reflect.DeepEqual(rec.Data, []interface{}{"b", "a"}) {
+		t.Fatalf("invalid list returned: %v", rec.Data)
+	}
+
+	s.Store("2", hash.Record{Data: []int{1}})
+	req = &RequestListPush{Key: "2", Data: "c"}
+	if _, err = s.Serve(req); err == nil {
+		t.Fatalf("expected an error on incompatible element")
+	}
+
+	s.Store("3", hash.Record{Data: 3})
+	req = &RequestListPush{Key: "3", Data: "c"}
+	_, err = s.Serve(req)
+	if err == nil || err.Error() != "3 is not a list" {
+		t.Fatalf("expected an error, %v", err)
+	}
+}
+
+func TestRequestListPop(t *testing.T) {
+	s := newStore(&Config{Capacity: 0})
+	list := []int{1, 2, 3}
+	s.Store("1", hash.Record{Data: list})
+
+	req := &RequestListPop{Key: "1"}
+	if req.Action() != ActionListPop {
+		t.Fatalf("invalid request action: %s", req.Action())
+	}
+	rec, err := s.Serve(req)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if rec.Data.(int) != 3 {
+		t.Fatalf("invalid element returned: %v", rec.Data)
+	}
+
+	req = &RequestListPop{Key: "1", Front: true}
+	rec, err = s.Serve(req)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if rec.Data.(int) != 1 {
+		t.Fatalf("invalid element returned: %v", rec.Data)
+	}
+
+	// The original list should not be modified in place.
+	if !reflect.DeepEqual(list, []int{1, 2, 3}) {
+		t.Fatalf("persisted list was modified: %v", list)
+	}
+
+	s.Serve(req)
+	_, err = s.Serve(req)
+	if err == nil || err.Error() != "1 is empty" {
+		t.Fatalf("expected an error, %v", err)
+	}
+}
+
+func TestRequestListSet(t *testing.T) {
+	s := newStore(&Config{Capacity: 0})
+	s.Store("1", hash.Record{Data: []int{1, 2, 3}})
+
+	req := &RequestListSet{Key: "1", Index: 1, Data: 5}
+	if req.Action() != ActionListSet {
+		t.Fatalf("invalid request action: %s", req.Action())
+	}
+	rec, err := s.Serve(req)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !reflect.DeepEqual(rec.Data, []int{1, 5, 3}) {
+		t.Fatalf("invalid list returned: %v", rec.Data)
+	}
+
+	req = &RequestListSet{Key: "1", Index: 3, Data: 5}
+	_, err = s.Serve(req)
+	if err == nil || err.Error() != "position 3 is out of range" {
+		t.Fatalf("expected an error, %v", err)
+	}
+}
+
+func TestRequestListRemove(t *testing.T) {
+	s := newStore(&Config{Capacity: 0})
+	s.Store("1", hash.Record{Data: []int{1, 2, 3}})
+
+	req := &RequestListRemove{Key: "1", Index: 1}
+	if req.Action() != ActionListRemove {
+		t.Fatalf("invalid request action: %s", req.Action())
+	}
+	rec, err := s.Serve(req)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if rec.Data.(int) != 2 {
+		t.Fatalf("invalid element returned: %v", rec.Data)
+	}
+	if rec, _ = s.Load("1"); !reflect.DeepEqual(rec.Data, []int{1, 3}) {
+		t.Fatalf("invalid list stored: %v", rec.Data)
+	}
+}
+
+func TestRequestListTrim(t *testing.T) {
+	s := newStore(&Config{Capacity: 0})
+	s.Store("1", hash.Record{Data: []int{1, 2, 3, 4}})
+
+	req := &RequestListTrim{Key: "1", Start: 1, Stop: -2}
+	if req.Action() != ActionListTrim {
+		t.Fatalf("invalid request action: %s", req.Action())
+	}
+	rec, err := s.Serve(req)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !reflect.DeepEqual(rec.Data, []int{2, 3}) {
+		t.Fatalf("invalid list returned: %v", rec.Data)
+	}
+}
+
+func TestRequestListRange(t *testing.T) {
+	s := newStore(&Config{Capacity: 0})
+	req := &RequestListRange{Key: "1", Start: 0, Stop: 1}
+	_, err := s.Serve(req)
+	if err == nil || err.Error() != "1 does not exist" {
+		t.Fatalf("expected an error, %v", err)
+	}
+
+	s.Store("1", hash.Record{Data: []int{1, 2, 3}})
+	if req.Action() != ActionListRange {
+		t.Fatalf("invalid request action: %s", req.Action())
+	}
+	rec, err := s.Serve(req)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !reflect.DeepEqual(rec.Data, []int{1, 2}) {
+		t.Fatalf("invalid list returned: %v", rec.Data)
+	}
+	if rec.Meta.Index != 1 {
+		t.Fatalf("range should not modify the record")
+	}
+}
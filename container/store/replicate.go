@@ -0,0 +1,137 @@
+package store
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/ybubnov/memhashd/container/hash"
+)
+
+const (
+	// ActionReplicate is an action to apply the changes of the records
+	// made on the primary copy to the replica.
+	ActionReplicate = "replicate"
+
+	// tombstoneTTL is a duration, while the metadata of the deleted
+	// record is kept, so the stale changes of the record are skipped.
+	tombstoneTTL = time.Minute
+)
+
+// tombstoneHash is implemented by the hashes, that keep the metadata of
+// the deleted records.
+type tombstoneHash interface {
+	// tombstone returns a metadata of the deleted record of the key,
+	// ok is false, when the key was not deleted recently.
+	tombstone(key string) (meta hash.Meta, ok bool)
+
+	// bury saves a metadata of the deleted record of the key.
+	bury(key string, meta hash.Meta)
+}
+
+// Newer reports whether the record of the first metadata is a later
+// version than the record of the second one. The index of the record
+// starts from one, when the record is created again after the deletion,
+// so the record created later is newer, and the versions of the same
+// record are ordered by the index. The diverged copies with the same
+// index are ordered by the update time.
+func Newer(a, b hash.Meta) bool {
+	if !a.CreatedAt.Equal(b.CreatedAt) {
+		return a.CreatedAt.After(b.CreatedAt)
+	}
+	if a.Index != b.Index {
+		return a.Index > b.Index
+	}
+	return a.UpdatedAt.After(b.UpdatedAt)
+}
+
+// RequestReplicate defines a request to a storage to apply the changes
+// of the records made by another store. The records are persisted as is,
+// including the metadata, so the copies of the records are identical.
+type RequestReplicate struct {
+	// ID is a request identifier.
+	ID string
+	// Changes is an ordered list of changes of the records.
+	Changes []Event
+	// Overwrite is set, when the changes are applied regardless of the
+	// records held by the store, so the copy converges to the sender.
+	Overwrite bool `json:",omitempty"`
+}
+
+// Action implements Request interface.
+func (r *RequestReplicate) Action() string {
+	return ActionReplicate
+}
+
+// Hash implements Request interface. Hash of the replicate request is
+// a key of the first change.
+func (r *RequestReplicate) Hash() string {
+	if len(r.Changes) == 0 {
+		return ""
+	}
+	return r.Changes[0].Key
+}
+
+// Keys implements MultiKeyRequest interface.
+func (r *RequestReplicate) Keys() []string {
+	var keys []string
+	seen := make(map[string]bool)
+	for _, ev := range r.Changes {
+		if !seen[ev.Key] {
+			seen[ev.Key] = true
+			keys = append(keys, ev.Key)
+		}
+	}
+	return keys
+}
+
+// String implements fmt.Stringer interface.
+func (r *RequestReplicate) String() string {
+	return fmt.Sprintf("id: %s, type: replicate, keys: %v",
+		r.ID, r.Keys())
+}
+
+// Process implements Request interface. The changes could arrive out of
+// order, therefore the record is not replaced by the older record. The
+// deleted, expired and evicted records carry the metadata of the deleted
+// record, which is kept by the hash, so the stale record is not
+// resurrected by the change delivered after the deletion. The changes of
+// the overwriting request are applied unconditionally.
+func (r *RequestReplicate) Process(h hash.Hash) (hash.Record, error) {
+	tombstones, _ := h.(tombstoneHash)
+	for _, ev := range r.Changes {
+		rec, ok := h.Peek(ev.Key)
+		meta := ev.Record.Meta
+
+		if ev.Type == EventStore {
+			if r.Overwrite {
+				h.Restore(ev.Key, ev.Record)
+				continue
+			}
+			if ok && Newer(rec.Meta, meta) {
+				continue
+			}
+			if !ok && tombstones != nil {
+				if dead, ok := tombstones.tombstone(ev.Key); ok && !Newer(meta, dead) {
+					continue
+				}
+			}
+			h.Restore(ev.Key, ev.Record)
+			continue
+		}
+
+		// The index of the missing record is zero, such deletion is
+		// applied unconditionally.
+		if meta.Index == 0 {
+			h.Delete(ev.Key)
+			continue
+		}
+		if ok && !r.Overwrite && Newer(rec.Meta, meta) {
+			continue
+		}
+		h.Delete(ev.Key)
+		if tombstones != nil {
+			tombstones.bury(ev.Key, meta)
+		}
+	}
+	return hash.RecordZero, resetErr(h)
+}
@@ -0,0 +1,196 @@
+package store
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ybubnov/memhashd/container/hash"
+)
+
+func TestStoreServeChanges(t *testing.T) {
+	s := newStore(&Config{Capacity: 16, Segments: 4})
+	s.Store("a", hash.Record{Data: int64(1)})
+
+	_, changes, err := s.ServeChanges(&RequestLoad{Key: "a"})
+	if err != nil || len(changes) != 0 {
+		t.Fatalf("read should not change records: %v, %v", changes, err)
+	}
+
+	_, changes, err = s.ServeChanges(&RequestTransaction{Requests: []Request{
+		&RequestIncr{Key: "a"},
+		&RequestDelete{Key: "b"},
+	}})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(changes) != 2 {
+		t.Fatalf("invalid number of changes: %v", changes)
+	}
+	if ev := changes[0]; ev.Type != EventStore || ev.Key != "a" ||
+		ev.Record.Data != int64(2) || ev.Record.Meta.Index != 2 {
+		t.Fatalf("invalid first change: %v", ev)
+	}
+	if ev := changes[1]; ev.Type != EventDelete || ev.Key != "b" {
+		t.Fatalf("invalid second change: %v", ev)
+	}
+}
+
+func TestRequestReplicate(t *testing.T) {
+	primary := newStore(&Config{Capacity: 16})
+	replica := newStore(&Config{Capacity: 16})
+	replica.Store("c", hash.Record{Data: "z"})
+
+	primary.Store("a", hash.Record{Data: "x"})
+	_, stale, _ := primary.ServeChanges(&RequestStore{Key: "b", Data: "y"})
+	_, changes, _ := primary.ServeChanges(&RequestTransaction{Requests: []Request{
+		&RequestStore{Key: "a", Data: "w"},
+		&RequestStore{Key: "b", Data: "v"},
+		&RequestDelete{Key: "c"},
+	}})
+
+	req := &RequestReplicate{Changes: changes}
+	if keys := req.Keys(); len(keys) != 3 {
+		t.Fatalf("invalid keys of request: %v", keys)
+	}
+	if _, err := replica.Serve(req); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	// Changes delivered out of order should be skipped.
+	if _, err := replica.Serve(&RequestReplicate{Changes: stale}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	for _, key := range []string{"a", "b"} {
+		expected, _ := primary.Peek(key)
+		rec, _ := replica.Peek(key)
+		if rec.Data != expected.Data || rec.Meta.Index != expected.Meta.Index {
+			t.Fatalf("invalid replica of %s: %v", key, rec)
+		}
+	}
+	if _, ok := replica.Peek("c"); ok {
+		t.Fatalf("deleted record should be removed from replica")
+	}
+}
+
+func TestRequestReplicateDelete(t *testing.T) {
+	primary := newStore(&Config{Capacity: 16})
+	replica := newStore(&Config{Capacity: 16})
+
+	_, created, _ := primary.ServeChanges(&RequestStore{Key: "a", Data: "x"})
+	_, updated, _ := primary.ServeChanges(&RequestStore{Key: "a", Data: "y"})
+	_, deleted, _ := primary.ServeChanges(&RequestDelete{Key: "a"})
+
+	w := replica.Watch("a")
+	defer w.Stop()
+
+	// The deletion is delivered before the last store of the record.
+	for _, changes := range [][]Event{created, deleted, updated} {
+		if _, err := replica.Serve(&RequestReplicate{Changes: changes}); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	}
+	if rec, ok := replica.Peek("a"); ok {
+		t.Fatalf("deleted record should not be resurrected: %v", rec)
+	}
+
+	// The stale copy is not restored on the store, which deleted the
+	// record by the request.
+	if _, err := primary.Serve(&RequestReplicate{Changes: updated}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if rec, ok := primary.Peek("a"); ok {
+		t.Fatalf("deleted record should not be restored: %v", rec)
+	}
+
+	// The replicated changes are delivered by the primary copy.
+	select {
+	case ev := <-w.C:
+		t.Fatalf("unexpected event received: %v", ev)
+	default:
+	}
+
+	// The newer record replaces the deleted one.
+	_, created, _ = primary.ServeChanges(&RequestStore{Key: "a", Data: "z"})
+	if _, err := replica.Serve(&RequestReplicate{Changes: created}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if rec, ok := replica.Peek("a"); !ok || rec.Data != "z" {
+		t.Fatalf("record should be replicated: %v", rec)
+	}
+
+	// The overwriting changes are applied even to the newer records.
+	_, err := replica.Serve(&RequestReplicate{Changes: updated, Overwrite: true})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if rec, ok := replica.Peek("a"); !ok || rec.Data != "y" {
+		t.Fatalf("record should be overwritten: %v", rec)
+	}
+}
+
+func TestStoreChanged(t *testing.T) {
+	var changes [][2]*hash.Record
+	s := newStore(&Config{Capacity: 16,
+		Changed: func(key string, prev, rec *hash.Record) {
+			changes = append(changes, [2]*hash.Record{prev, rec})
+		}})
+
+	s.Store("a", hash.Record{Data: "x"})
+	s.Store("a", hash.Record{Data: "y"})
+	s.Serve(&RequestReplicate{Changes: []Event{{
+		Type: EventDelete, Key: "a", Record: hash.Record{}}}})
+
+	if len(changes) != 3 {
+		t.Fatalf("three changes expected: %v", changes)
+	}
+	if c := changes[0]; c[0] != nil || c[1].Data != "x" {
+		t.Fatalf("invalid creation: %v, %v", c[0], c[1])
+	}
+	if c := changes[1]; c[0].Data != "x" || c[1].Data != "y" {
+		t.Fatalf("invalid update: %v, %v", c[0], c[1])
+	}
+	if c := changes[2]; c[0].Data != "y" || c[1] != nil {
+		t.Fatalf("invalid deletion: %v, %v", c[0], c[1])
+	}
+}
+
+func TestStoreRemoved(t *testing.T) {
+	var removed []Event
+	policy, _ := EvictionPolicyOf(PolicyLRU)
+	s := newStore(&Config{Capacity: 16, MaxKeys: 1, EvictionPolicy: policy,
+		Removed: func(ev Event) { removed = append(removed, ev) }})
+
+	s.Store("1", hash.Record{Data: "a"})
+	s.Store("2", hash.Record{Data: "b"})
+	s.Delete("2")
+
+	if len(removed) != 1 {
+		t.Fatalf("only evicted record expected: %v", removed)
+	}
+	if ev := removed[0]; ev.Type != EventEvict || ev.Key != "1" ||
+		ev.Record.Meta.Index != 1 {
+		t.Fatalf("invalid removal: %v", ev)
+	}
+}
+
+func TestNewer(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		a, b  hash.Meta
+		newer bool
+	}{
+		{hash.Meta{Index: 2, CreatedAt: now}, hash.Meta{Index: 1, CreatedAt: now}, true},
+		{hash.Meta{Index: 1, CreatedAt: now.Add(time.Second)}, hash.Meta{Index: 5, CreatedAt: now}, true},
+		{hash.Meta{Index: 5, CreatedAt: now}, hash.Meta{Index: 1, CreatedAt: now.Add(time.Second)}, false},
+		{hash.Meta{Index: 1, CreatedAt: now, UpdatedAt: now.Add(time.Second)},
+			hash.Meta{Index: 1, CreatedAt: now, UpdatedAt: now}, true},
+		{hash.Meta{Index: 1, CreatedAt: now}, hash.Meta{Index: 1, CreatedAt: now}, false},
+	}
+
+	for _, tt := range tests {
+		if newer := Newer(tt.a, tt.b); newer != tt.newer {
+			t.Fatalf("invalid comparison of %v and %v: %v", tt.a, tt.b, newer)
+		}
+	}
+}
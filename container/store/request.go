@@ -18,6 +18,10 @@ const (
 	// ActionStore is an action to persist record in a store.
 	ActionStore = "store"
 
+	// ActionCompareAndSwap is an action to persist record in a store
+	// only when the index of the stored record matches the expected one.
+	ActionCompareAndSwap = "cas"
+
 	// ActionDelete is an action to delete a record from a store.
 	ActionDelete = "delete"
 
@@ -36,6 +40,64 @@ var requestMap = map[string]RequestMaker{
 	ActionDelete:    requestMakerOf(RequestDelete{}),
 	ActionListIndex: requestMakerOf(RequestListIndex{}),
 	ActionDictItem:  requestMakerOf(RequestDictItem{}),
+
+	ActionCompareAndSwap: requestMakerOf(RequestCompareAndSwap{}),
+
+	ActionIncr: requestMakerOf(RequestIncr{}),
+	ActionDecr: requestMakerOf(RequestDecr{}),
+	ActionAdd:  requestMakerOf(RequestAdd{}),
+
+	ActionListPush:   requestMakerOf(RequestListPush{}),
+	ActionListPop:    requestMakerOf(RequestListPop{}),
+	ActionListSet:    requestMakerOf(RequestListSet{}),
+	ActionListRemove: requestMakerOf(RequestListRemove{}),
+	ActionListTrim:   requestMakerOf(RequestListTrim{}),
+	ActionListRange:  requestMakerOf(RequestListRange{}),
+
+	ActionDictSetItem:    requestMakerOf(RequestDictSetItem{}),
+	ActionDictDeleteItem: requestMakerOf(RequestDictDeleteItem{}),
+	ActionDictFields:     requestMakerOf(RequestDictFields{}),
+	ActionDictItems:      requestMakerOf(RequestDictItems{}),
+	ActionDictMerge:      requestMakerOf(RequestDictMerge{}),
+
+	ActionExpire:  requestMakerOf(RequestExpire{}),
+	ActionPersist: requestMakerOf(RequestPersist{}),
+	ActionTTL:     requestMakerOf(RequestTTL{}),
+
+	ActionScan: requestMakerOf(RequestScan{}),
+
+	ActionBatchLoad:  requestMakerOf(RequestBatchLoad{}),
+	ActionBatchStore: requestMakerOf(RequestBatchStore{}),
+
+	ActionTransaction: requestMakerOf(RequestTransaction{}),
+
+	ActionWatch:     requestMakerOf(RequestWatch{}),
+	ActionSubscribe: requestMakerOf(RequestSubscribe{}),
+
+	ActionReplicate: requestMakerOf(RequestReplicate{}),
+}
+
+// readActions is a set of actions of the requests, that do not modify
+// the records of the store.
+var readActions = map[string]bool{
+	ActionKeys:       true,
+	ActionLoad:       true,
+	ActionListIndex:  true,
+	ActionDictItem:   true,
+	ActionListRange:  true,
+	ActionDictFields: true,
+	ActionDictItems:  true,
+	ActionTTL:        true,
+	ActionScan:       true,
+	ActionBatchLoad:  true,
+	ActionWatch:      true,
+	ActionSubscribe:  true,
+}
+
+// ReadOnly reports whether the request does not modify the records of
+// the store, so it could be served by any copy of the records.
+func ReadOnly(r Request) bool {
+	return readActions[r.Action()]
 }
 
 // MakeRequest creates a new instance of the request by an action name.
@@ -126,6 +188,9 @@ type RequestStore struct {
 	ID string
 	// ExpireTime defines a record expiration time.
 	ExpireTime time.Duration
+	// Sliding defines whether the expiration time is counted from
+	// the last access to the record.
+	Sliding bool
 	// Key is a key used to store an element in a store.
 	Key string
 	// Data is a for the given key.
@@ -154,7 +219,64 @@ func (r *RequestStore) String() string {
 // operation.
 func (r *RequestStore) Process(h hash.Hash) (hash.Record, error) {
 	rec := h.Store(r.Key, hash.Record{
-		Data: r.Data, Meta: hash.Meta{ExpireTime: r.ExpireTime},
+		Data: r.Data, Meta: hash.Meta{
+			ExpireTime: r.ExpireTime, Sliding: r.Sliding},
+	})
+	return rec, nil
+}
+
+// RequestCompareAndSwap defines a request to a storage to store a value
+// by the given key only when the index of the persisted record is equal
+// to the expected one. The index of the missing record is zero.
+type RequestCompareAndSwap struct {
+	// ID is a request identifier.
+	ID string
+	// ExpireTime defines a record expiration time.
+	ExpireTime time.Duration
+	// Sliding defines whether the expiration time is counted from
+	// the last access to the record.
+	Sliding bool
+	// Key is a key used to store an element in a store.
+	Key string
+	// Data is a for the given key.
+	Data interface{}
+	// Index is an expected index of the persisted record.
+	Index int64
+}
+
+// Action implements Request interface.
+func (r *RequestCompareAndSwap) Action() string {
+	return ActionCompareAndSwap
+}
+
+// Hash implements Request interface.
+func (r *RequestCompareAndSwap) Hash() string {
+	return r.Key
+}
+
+// String implements fmt.Stringer interface.
+func (r *RequestCompareAndSwap) String() string {
+	return fmt.Sprintf("id: %s, type: cas, key: %s, index: %d"+
+		", data: %v, expire_time: %s",
+		r.ID, r.Key, r.Index, r.Data, r.ExpireTime)
+}
+
+// Process implements Request interface, it stores a value into the
+// given hash-map, when the index of the record matches the expected
+// one. Hash should not be concurrently changed during this operation.
+func (r *RequestCompareAndSwap) Process(h hash.Hash) (hash.Record, error) {
+	// The index of the missing record is treated as zero, so the
+	// caller could create a key only when it does not exist.
+	rec, _ := h.Load(r.Key)
+	if rec.Meta.Index != r.Index {
+		text := fmt.Sprintf("index of %s is %d, expected %d",
+			r.Key, rec.Meta.Index, r.Index)
+		return hash.RecordZero, &ErrConflict{text}
+	}
+
+	rec = h.Store(r.Key, hash.Record{
+		Data: r.Data, Meta: hash.Meta{
+			ExpireTime: r.ExpireTime, Sliding: r.Sliding},
 	})
 	return rec, nil
 }
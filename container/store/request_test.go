@@ -23,7 +23,7 @@ func TestMakeRequest(t *testing.T) {
 }
 
 func TestRequestKeys(t *testing.T) {
-	s := newStore(&Config{16})
+	s := newStore(&Config{Capacity: 16})
 	s.Store("1", hash.Record{Data: 1})
 	s.Store("2", hash.Record{Data: 2})
 	s.Store("3", hash.Record{Data: 3})
@@ -47,7 +47,7 @@ func TestRequestKeys(t *testing.T) {
 }
 
 func TestRequestStore(t *testing.T) {
-	s := newStore(&Config{16})
+	s := newStore(&Config{Capacity: 16})
 	req := &RequestStore{Key: "1", Data: 1}
 	if req.Action() != ActionStore {
 		t.Fatalf("invalid request action")
@@ -63,8 +63,44 @@ func TestRequestStore(t *testing.T) {
 	}
 }
 
+func TestRequestCompareAndSwap(t *testing.T) {
+	s := newStore(&Config{Capacity: 16})
+	req := &RequestCompareAndSwap{Key: "1", Data: 1}
+	if req.Action() != ActionCompareAndSwap {
+		t.Fatalf("invalid request action")
+	}
+	if req.Hash() != "1" {
+		t.Fatalf("invalid hash returned: %s", req.Hash())
+	}
+
+	rec, err := s.Serve(req)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if rec.Meta.Index != 1 {
+		t.Fatalf("invalid index of the record: %d", rec.Meta.Index)
+	}
+
+	req = &RequestCompareAndSwap{Key: "1", Data: 2}
+	_, err = s.Serve(req)
+	if err == nil || err.Error() != "index of 1 is 1, expected 0" {
+		t.Fatalf("expected an error, %v", err)
+	}
+	if _, ok := err.(*ErrConflict); !ok {
+		t.Fatalf("expected conflict error, got %T", err)
+	}
+
+	req = &RequestCompareAndSwap{Key: "1", Data: 3, Index: 1}
+	if _, err = s.Serve(req); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if rec, _ := s.Load("1"); rec.Data.(int) != 3 {
+		t.Fatalf("invalid data stored: %v", rec.Data)
+	}
+}
+
 func TestRequestLoad(t *testing.T) {
-	s := newStore(&Config{16})
+	s := newStore(&Config{Capacity: 16})
 	s.Store("2", hash.Record{Data: 2})
 
 	req := &RequestLoad{Key: "2"}
@@ -89,7 +125,7 @@ func TestRequestLoad(t *testing.T) {
 }
 
 func TestRequestDelete(t *testing.T) {
-	s := newStore(&Config{1})
+	s := newStore(&Config{Capacity: 1})
 	req := &RequestDelete{Key: "1"}
 	_, err := req.Process(s)
 	if err != nil {
@@ -107,7 +143,7 @@ func TestRequestDelete(t *testing.T) {
 }
 
 func TestRequestListIndex(t *testing.T) {
-	s := newStore(&Config{0})
+	s := newStore(&Config{Capacity: 0})
 	req := &RequestListIndex{Key: "1", Index: 2}
 	_, err := req.Process(s)
 	if err == nil || err.Error() != "1 does not exist" {
@@ -140,7 +176,7 @@ func TestRequestListIndex(t *testing.T) {
 }
 
 func TestRequestDictItem(t *testing.T) {
-	s := newStore(&Config{0})
+	s := newStore(&Config{Capacity: 0})
 	req := &RequestDictItem{Key: "2", Item: 3}
 	_, err := req.Process(s)
 	if err == nil || err.Error() != "2 does not exist" {
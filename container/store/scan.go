@@ -0,0 +1,341 @@
+package store
+
+import (
+	"container/heap"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/ybubnov/memhashd/container/hash"
+)
+
+const (
+	// ActionScan is an action to retrieve a page of keys.
+	ActionScan = "scan"
+
+	// DefaultScanLimit is a default number of keys in a single page
+	// of the scan.
+	DefaultScanLimit = 100
+)
+
+// ScanResult is a page of keys returned by the scan request.
+type ScanResult struct {
+	// Cursor is a cursor of the next page, it is empty, when all keys
+	// of the store are scanned.
+	Cursor string
+
+	// Keys is a list of keys of the page in a lexicographical order.
+	Keys []string
+}
+
+// segmentRequest is implemented by the requests without a key, that
+// access a single segment of the store selected by the request itself.
+type segmentRequest interface {
+	segment(n int) int
+}
+
+// segmentScanner is implemented by the hashes divided into segments.
+type segmentScanner interface {
+	// segmentKeys returns keys of the given segment and the total
+	// number of segments.
+	segmentKeys(i int) ([]string, int)
+}
+
+// RequestScan defines a request to a storage to retrieve a page of keys
+// starting from the given cursor. The store is scanned segment by
+// segment, so the page could contain less keys than the limit, even if
+// the scan is not finished yet.
+//
+// The cursor has a format "<segment>:<key>", where key is the last key
+// returned from the segment. Keys stored after the beginning of the scan
+// could be missed by the scan.
+type RequestScan struct {
+	// ID is a request identifier.
+	ID string
+	// Cursor is a cursor returned by the previous scan, empty cursor
+	// starts a new scan.
+	Cursor string
+	// Limit is a maximum number of keys in a page.
+	Limit int
+	// Prefix limits the scan to keys with the given prefix.
+	Prefix string
+	// Match limits the scan to keys matching the given glob pattern.
+	Match string
+}
+
+// Action implements Request interface.
+func (r *RequestScan) Action() string {
+	return ActionScan
+}
+
+// Hash implements Request interface. Hash for scan request is always
+// an empty string, which means this request can be processed by a
+// local shard.
+func (r *RequestScan) Hash() string {
+	return ""
+}
+
+// String implements fmt.Stringer interface.
+func (r *RequestScan) String() string {
+	return fmt.Sprintf("id: %s, type: scan, cursor: %s, limit: %d"+
+		", prefix: %s, match: %s",
+		r.ID, r.Cursor, r.Limit, r.Prefix, r.Match)
+}
+
+// segment implements segmentRequest interface.
+func (r *RequestScan) segment(n int) int {
+	segment, _, err := parseCursor(r.Cursor)
+	if err != nil || segment >= n {
+		return 0
+	}
+	return segment
+}
+
+// parseCursor returns a segment and the last key of the cursor.
+func parseCursor(cursor string) (int, string, error) {
+	if cursor == "" {
+		return 0, "", nil
+	}
+
+	pos := strings.Index(cursor, ":")
+	if pos < 0 {
+		return 0, "", fmt.Errorf("invalid cursor %s", cursor)
+	}
+
+	segment, err := strconv.Atoi(cursor[:pos])
+	if err != nil || segment < 0 {
+		return 0, "", fmt.Errorf("invalid cursor %s", cursor)
+	}
+	return segment, cursor[pos+1:], nil
+}
+
+// Process implements Request interface, it returns a page of keys of
+// the segment referenced by the cursor.
+func (r *RequestScan) Process(h hash.Hash) (hash.Record, error) {
+	segment, last, err := parseCursor(r.Cursor)
+	if err != nil {
+		return hash.RecordZero, &ErrConflict{err.Error()}
+	}
+	if _, err = MatchPattern(r.Match, ""); err != nil {
+		return hash.RecordZero, &ErrConflict{err.Error()}
+	}
+
+	limit := r.Limit
+	if limit <= 0 {
+		limit = DefaultScanLimit
+	}
+
+	keys, n := h.Keys(), 1
+	if scanner, ok := h.(segmentScanner); ok {
+		keys, n = scanner.segmentKeys(segment)
+	}
+	if segment >= n {
+		text := fmt.Sprintf("invalid cursor %s", r.Cursor)
+		return hash.RecordZero, &ErrConflict{text}
+	}
+
+	// Keys of the segment are not ordered, so only the lowest keys
+	// following the cursor are kept, and the key next to the page is
+	// kept to find out whether the segment is scanned completely.
+	var page keyHeap
+	for _, key := range keys {
+		if key <= last || !strings.HasPrefix(key, r.Prefix) {
+			continue
+		}
+		if len(page) > limit && key >= page[0] {
+			continue
+		}
+		if matched, _ := MatchPattern(r.Match, key); !matched {
+			continue
+		}
+		if _, ok := h.Peek(key); !ok {
+			continue
+		}
+		if len(page) > limit {
+			page[0] = key
+			heap.Fix(&page, 0)
+			continue
+		}
+		heap.Push(&page, key)
+	}
+	sort.Strings(page)
+
+	var result ScanResult
+	switch {
+	case len(page) > limit:
+		page = page[:limit]
+		result.Cursor = fmt.Sprintf("%d:%s", segment, page[limit-1])
+	case segment+1 < n:
+		result.Cursor = fmt.Sprintf("%d:", segment+1)
+	}
+
+	result.Keys = page
+	return hash.Record{Data: result}, nil
+}
+
+// keyHeap is a max-heap of keys, it is used to select the lowest keys.
+type keyHeap []string
+
+// Len implements sort.Interface interface.
+func (h keyHeap) Len() int {
+	return len(h)
+}
+
+// Less implements sort.Interface interface.
+func (h keyHeap) Less(i, j int) bool {
+	return h[i] > h[j]
+}
+
+// Swap implements sort.Interface interface.
+func (h keyHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+}
+
+// Push implements heap.Interface interface.
+func (h *keyHeap) Push(x interface{}) {
+	*h = append(*h, x.(string))
+}
+
+// Pop implements heap.Interface interface.
+func (h *keyHeap) Pop() interface{} {
+	old := *h
+	key := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return key
+}
+
+// MatchPattern reports whether the key matches the glob pattern. The
+// pattern supports '*' to match any sequence of characters, '?' to
+// match a single character, character classes '[a-z]' (negated with
+// '^' or '!') and '\' to escape special characters. Empty pattern
+// matches any key.
+func MatchPattern(pattern, key string) (bool, error) {
+	if pattern == "" {
+		return true, nil
+	}
+	if err := validPattern(pattern); err != nil {
+		return false, err
+	}
+	return matchGlob([]rune(pattern), []rune(key)), nil
+}
+
+// validPattern returns an error, when the pattern is malformed.
+func validPattern(pattern string) error {
+	p := []rune(pattern)
+	for i := 0; i < len(p); i++ {
+		switch p[i] {
+		case '\\':
+			if i++; i >= len(p) {
+				return fmt.Errorf("invalid pattern %s", pattern)
+			}
+		case '[':
+			_, n := matchClass(p[i:], 0)
+			if n < 0 {
+				return fmt.Errorf("invalid pattern %s", pattern)
+			}
+			i += n - 1
+		}
+	}
+	return nil
+}
+
+// matchGlob reports whether the string matches the valid pattern.
+func matchGlob(p, s []rune) bool {
+	var px, sx int
+	// Position of the last star in the pattern and position in the
+	// string to retry the match from.
+	starPx, starSx := -1, -1
+
+	for px < len(p) || sx < len(s) {
+		if px < len(p) {
+			switch p[px] {
+			case '*':
+				starPx, starSx = px, sx
+				px++
+				continue
+			case '?':
+				if sx < len(s) {
+					px++
+					sx++
+					continue
+				}
+			case '[':
+				if sx < len(s) {
+					if ok, n := matchClass(p[px:], s[sx]); ok {
+						px += n
+						sx++
+						continue
+					}
+				}
+			case '\\':
+				if sx < len(s) && s[sx] == p[px+1] {
+					px += 2
+					sx++
+					continue
+				}
+			default:
+				if sx < len(s) && s[sx] == p[px] {
+					px++
+					sx++
+					continue
+				}
+			}
+		}
+
+		// Let the last star consume one more character and retry.
+		if starPx >= 0 && starSx < len(s) {
+			starSx++
+			px, sx = starPx+1, starSx
+			continue
+		}
+		return false
+	}
+	return true
+}
+
+// matchClass matches the character against the character class at the
+// beginning of the pattern. It returns the length of the class in the
+// pattern, which is negative, when the class is malformed.
+func matchClass(p []rune, c rune) (bool, int) {
+	i := 1
+	negate := i < len(p) && (p[i] == '^' || p[i] == '!')
+	if negate {
+		i++
+	}
+
+	var matched bool
+	for first := true; ; first = false {
+		if i >= len(p) {
+			return false, -1
+		}
+		if p[i] == ']' && !first {
+			break
+		}
+
+		lo := p[i]
+		if lo == '\\' {
+			if i++; i >= len(p) {
+				return false, -1
+			}
+			lo = p[i]
+		}
+		i++
+
+		hi := lo
+		if i+1 < len(p) && p[i] == '-' && p[i+1] != ']' {
+			if hi = p[i+1]; hi == '\\' {
+				if i+2 >= len(p) {
+					return false, -1
+				}
+				hi = p[i+2]
+				i++
+			}
+			i += 2
+		}
+		if lo <= c && c <= hi {
+			matched = true
+		}
+	}
+	return matched != negate, i + 1
+}
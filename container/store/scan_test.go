@@ -0,0 +1,143 @@
+package store
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/ybubnov/memhashd/container/hash"
+)
+
+func TestMatchPattern(t *testing.T) {
+	tests := []struct {
+		Pattern string
+		Key     string
+		Matched bool
+		Err     bool
+	}{
+		{"", "anything", true, false},
+		{"user:*", "user:1/profile", true, false},
+		{"user:*", "session:1", false, false},
+		{"*:1", "user:1", true, false},
+		{"h?llo", "hello", true, false},
+		{"h?llo", "hllo", false, false},
+		{"h[ae]llo", "hallo", true, false},
+		{"h[^e]llo", "hello", false, false},
+		{"h[!e]llo", "hallo", true, false},
+		{"key[0-9]", "key7", true, false},
+		{"key[0-9]", "keyx", false, false},
+		{"a\\*b", "a*b", true, false},
+		{"a\\*b", "axb", false, false},
+		{"*a*b*", "xxaxxbxx", true, false},
+		{"h[a-", "ha", false, true},
+		{"a\\", "a", false, true},
+	}
+
+	for _, tt := range tests {
+		matched, err := MatchPattern(tt.Pattern, tt.Key)
+		if (err != nil) != tt.Err {
+			t.Fatalf("unexpected error for %s: %v", tt.Pattern, err)
+		}
+		if matched != tt.Matched {
+			t.Fatalf("invalid match of %s against %s: %v",
+				tt.Key, tt.Pattern, matched)
+		}
+	}
+}
+
+func TestRequestScan(t *testing.T) {
+	s := newStore(&Config{Capacity: 16, Segments: 4})
+	for i := 0; i < 50; i++ {
+		s.Store(fmt.Sprintf("user:%02d", i), hash.Record{Data: i})
+		s.Store(fmt.Sprintf("session:%02d", i), hash.Record{Data: i})
+	}
+
+	var (
+		keys  []string
+		pages int
+	)
+
+	req := &RequestScan{Limit: 7, Match: "user:*"}
+	if req.Action() != ActionScan {
+		t.Fatalf("invalid request action: %s", req.Action())
+	}
+
+	for {
+		rec, err := s.Serve(req)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+
+		result := rec.Data.(ScanResult)
+		if len(result.Keys) > req.Limit {
+			t.Fatalf("page exceeds the limit: %d", len(result.Keys))
+		}
+
+		keys = append(keys, result.Keys...)
+		if pages++; result.Cursor == "" {
+			break
+		}
+		req.Cursor = result.Cursor
+	}
+
+	if pages < 8 {
+		t.Fatalf("keys should be returned in multiple pages: %d", pages)
+	}
+
+	sort.Strings(keys)
+	expected := make([]string, 50)
+	for i := range expected {
+		expected[i] = fmt.Sprintf("user:%02d", i)
+	}
+	if !reflect.DeepEqual(keys, expected) {
+		t.Fatalf("invalid list of keys scanned: %v", keys)
+	}
+}
+
+func TestRequestScanPrefix(t *testing.T) {
+	s := newStore(&Config{Capacity: 16, Segments: 1})
+	s.Store("a1", hash.Record{Data: 1})
+	s.Store("b1", hash.Record{Data: 2})
+	s.Store("a2", hash.Record{Data: 3})
+
+	rec, err := s.Serve(&RequestScan{Prefix: "a"})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	result := rec.Data.(ScanResult)
+	if !reflect.DeepEqual(result.Keys, []string{"a1", "a2"}) {
+		t.Fatalf("invalid list of keys scanned: %v", result.Keys)
+	}
+	if result.Cursor != "" {
+		t.Fatalf("scan should be finished: %s", result.Cursor)
+	}
+
+	for _, req := range []*RequestScan{{Cursor: "x"}, {Match: "[a"}} {
+		_, err = s.Serve(req)
+		if _, ok := err.(*ErrConflict); !ok {
+			t.Fatalf("expected conflict error, got %v", err)
+		}
+	}
+
+	// The scan is served under the read lock of the segment, so it is
+	// not blocked by the other readers.
+	s.segments[0].mu.RLock()
+	defer s.segments[0].mu.RUnlock()
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := s.Serve(&RequestScan{Prefix: "a"})
+		done <- err
+	}()
+	select {
+	case err = <-done:
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("scan should be served under the read lock")
+	}
+}
@@ -0,0 +1,425 @@
+package store
+
+import (
+	"container/heap"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ybubnov/memhashd/container/hash"
+	"github.com/ybubnov/memhashd/system/log"
+)
+
+// errNoSpace is returned by the segment, when the record does not fit
+// into the limits of the store. The records of the other segments could
+// not be evicted while the segment is locked, so the store evicts them
+// after the segment is unlocked and retries the operation.
+type errNoSpace struct {
+	key   string
+	size  int64
+	isNew bool
+}
+
+// Error implements error interface.
+func (e *errNoSpace) Error() string {
+	return fmt.Sprintf("not enough space to store %s", e.key)
+}
+
+// segment is an independently locked part of the store. Each key is
+// persisted in the segment selected by the hash of the key.
+type segment struct {
+	// Store, which limits and journal are shared by the segments.
+	s *store
+
+	// Hash map in an underlying storage.
+	hashMap hash.Hash
+
+	// Heap used to order the expiration timers for the records
+	// persisted in the segment. Each time-point will be extracted
+	// in increasing order.
+	expireHeap  *timeHeap
+	expireTimer *refreshTimer
+	// An index of heap elements by the key, so the expiration time of
+	// the record could be updated in place.
+	expireIndex map[string]*timeHeapElement
+
+	// Usage of each record of the segment. It is also used to sample
+	// random keys for the eviction. The map is changed under the write
+	// lock, while the usage is updated atomically under the read lock.
+	usage map[string]*usage
+
+	// Metadata of the records deleted by the replication, so the stale
+	// changes of the records delivered out of order are skipped.
+	tombstones map[string]tombstone
+	purgedAt   time.Time
+
+	// A mutex to access elements of the segment. The records are read
+	// under the read lock.
+	mu sync.RWMutex
+}
+
+// tombstone is a metadata of the deleted record and the time of the
+// deletion.
+type tombstone struct {
+	meta      hash.Meta
+	deletedAt time.Time
+}
+
+// usage is a number of accesses to the record and the time of the last
+// access, both are accessed atomically.
+type usage struct {
+	hits       int64
+	accessedAt int64
+}
+
+// touch registers an access to the record.
+func (u *usage) touch() {
+	atomic.AddInt64(&u.hits, 1)
+	atomic.StoreInt64(&u.accessedAt, time.Now().UnixNano())
+}
+
+// newSegment creates a new segment of the store with the given initial
+// capacity.
+func newSegment(s *store, capacity int) *segment {
+	return &segment{
+		s:           s,
+		hashMap:     hash.NewUnsafeHash(capacity),
+		expireHeap:  newTimeHeap(capacity),
+		expireTimer: new(refreshTimer),
+		expireIndex: make(map[string]*timeHeapElement, capacity),
+		usage:       make(map[string]*usage, capacity),
+		tombstones:  make(map[string]tombstone),
+	}
+}
+
+// keys returns a list of keys, the segment mutex should be held by
+// the caller.
+func (g *segment) keys() []string {
+	return g.hashMap.Keys()
+}
+
+// load returns a record persisted under the given key, the segment
+// mutex should be held by the caller.
+func (g *segment) load(key string) (rec hash.Record, ok bool) {
+	rec, ok = g.record(key)
+	if !ok {
+		return rec, ok
+	}
+
+	// Remove an expired key to guarantee consistency of the storage.
+	if rec.IsExpired() {
+		log.DebugLogf("store/LOAD", "key %s is expired, deleting", key)
+		g.remove(key, EventExpire)
+		return hash.RecordZero, false
+	}
+	return g.touch(key, rec), true
+}
+
+// read returns a record persisted under the given key, the segment read
+// lock should be held by the caller. Expired records are not returned,
+// they are removed by the expiration timer.
+func (g *segment) read(key string) (rec hash.Record, ok bool) {
+	rec, ok = g.record(key)
+	if !ok || rec.IsExpired() {
+		return hash.RecordZero, false
+	}
+	return g.touch(key, rec), true
+}
+
+// touch registers an access to the given record. The expiration timer
+// of the sliding record is not moved forward, since the timer keeps the
+// records, which lifetime was extended after the timer was scheduled.
+func (g *segment) touch(key string, rec hash.Record) hash.Record {
+	if u, ok := g.usage[key]; ok {
+		u.touch()
+	}
+	rec.Meta.AccessedAt = time.Now()
+	return rec
+}
+
+// peek returns a record persisted under the given key, the segment
+// mutex should be held by the caller.
+func (g *segment) peek(key string) (rec hash.Record, ok bool) {
+	rec, ok = g.record(key)
+	if !ok || rec.IsExpired() {
+		return hash.RecordZero, false
+	}
+	return rec, ok
+}
+
+// record returns a record persisted under the given key including the
+// expired one. The access time of the record is taken from its usage.
+func (g *segment) record(key string) (rec hash.Record, ok bool) {
+	rec, ok = g.hashMap.Peek(key)
+	if !ok {
+		return rec, ok
+	}
+	if u, ok := g.usage[key]; ok {
+		accessedAt := atomic.LoadInt64(&u.accessedAt)
+		if accessedAt > rec.Meta.AccessedAt.UnixNano() {
+			rec.Meta.AccessedAt = time.Unix(0, accessedAt)
+		}
+	}
+	return rec, ok
+}
+
+// store persists a given record under the specified key, the segment
+// mutex should be held by the caller.
+func (g *segment) store(key string, rec hash.Record) (hash.Record, error) {
+	prevrec, exists := g.hashMap.Peek(key)
+	return g.put(key, hash.Update(prevrec, exists, rec))
+}
+
+// restore persists a given record under the specified key as is, the
+// segment mutex should be held by the caller.
+func (g *segment) restore(key string, rec hash.Record) (hash.Record, error) {
+	return g.put(key, rec)
+}
+
+// put persists a given record as is, when the record fits into the
+// limits of the store. The record is appended to the journal before it
+// is persisted, so the failed write is not visible to the clients.
+func (g *segment) put(key string, rec hash.Record) (hash.Record, error) {
+	size := sizeOf(key) + sizeOf(rec.Data)
+	prevrec, exists := g.hashMap.Peek(key)
+	if exists {
+		size -= sizeOf(key) + sizeOf(prevrec.Data)
+	}
+
+	// There is no reason to evict records, when the record itself
+	// does not fit into the limits.
+	if g.s.maxMemory > 0 && size > g.s.maxMemory {
+		text := fmt.Sprintf("not enough space to store %s", key)
+		return hash.RecordZero, &ErrFull{text}
+	}
+	if !g.s.reserve(size, !exists) {
+		return hash.RecordZero, &errNoSpace{key, size, !exists}
+	}
+
+	if g.s.journal != nil {
+		if err := g.s.journal.appendRestore(key, rec); err != nil {
+			g.s.unreserve(size, !exists)
+			text := fmt.Sprintf("failed to record %s, %s", key, err)
+			return hash.RecordZero, &ErrInternal{text}
+		}
+	}
+
+	// Store a new record into a storage.
+	rec = g.hashMap.Restore(key, rec)
+	delete(g.tombstones, key)
+	if g.s.changed != nil {
+		var prev *hash.Record
+		if exists {
+			prev = &prevrec
+		}
+		g.s.changed(key, prev, &rec)
+	}
+	if u, ok := g.usage[key]; ok {
+		atomic.AddInt64(&u.hits, 1)
+	} else {
+		g.usage[key] = &usage{hits: 1}
+	}
+
+	g.schedule(key, rec)
+	return rec, nil
+}
+
+// schedule updates the expiration time of the record persisted under
+// the given key. Permanent records are removed from the expiration heap.
+func (g *segment) schedule(key string, rec hash.Record) {
+	elem, ok := g.expireIndex[key]
+	if rec.IsPermanent() {
+		if ok {
+			heap.Remove(g.expireHeap, elem.index)
+			delete(g.expireIndex, key)
+		}
+		return
+	}
+
+	// For non-permanent records, calculate expiration time and schedule
+	// an timer, that will purge all records with lower lifetime.
+	cutoff := rec.ExpiresAt()
+	if ok {
+		elem.Time = cutoff
+		heap.Fix(g.expireHeap, elem.index)
+	} else {
+		elem = &timeHeapElement{Time: cutoff, Data: key}
+		heap.Push(g.expireHeap, elem)
+		g.expireIndex[key] = elem
+	}
+
+	log.DebugLogf("store/SCHEDULE",
+		"scheduling next run of timer in %s", cutoff)
+	g.expireTimer.AfterFunc(cutoff, func() { g.deleteAfter(cutoff) })
+}
+
+// deleteAfter removes all keys, which lifetime is less the specified
+// cut-off interval.
+func (g *segment) deleteAfter(cutoff time.Time) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.deleteExpiredKeys(cutoff)
+
+	// When the length of the heap is zero, there are no more temporary
+	// keys in it, therefore timer won't be started until a new record
+	// will be added to a heap.
+	if g.expireHeap.Len() == 0 {
+		return
+	}
+
+	// Peek next timer form the heap and schedule an expiration timer.
+	elem := g.expireHeap.Peek().(*timeHeapElement)
+	next := elem.Time
+	log.DebugLogf("store/DELETE_AFTER",
+		"re-scheduling next run of timer in %s", next)
+	g.expireTimer.AfterFunc(next, func() { g.deleteAfter(next) })
+}
+
+// deleteExpiredKeys removes expired keys from the segment and extracts
+// all timers that are less than a specified cutoff, the segment mutex
+// should be held by the caller.
+func (g *segment) deleteExpiredKeys(cutoff time.Time) {
+	log.DebugLogf("store/DELETE_EXPIRED_KEYS",
+		"starting deletion of expired keys")
+	for {
+		// Peek the next element and check if the saved record
+		// is already expired, so it has to be removed.
+		next, ok := g.expireHeap.Peek().(*timeHeapElement)
+		if !ok || next == nil || next.Time.After(cutoff) {
+			break
+		}
+
+		// Remove a keys from the storage and remove time from the heap of
+		// expiration times.
+		key := next.Data.(string)
+		heap.Pop(g.expireHeap)
+		delete(g.expireIndex, key)
+
+		// The lifetime of the record could be extended after the timer
+		// was scheduled, so keep the record, when it is not expired yet.
+		rec, ok := g.record(key)
+		if ok && !rec.IsExpired() {
+			g.schedule(key, rec)
+			continue
+		}
+
+		log.DebugLogf("store/DELETE_EXPIRED_KEYS",
+			"deleted expired key `%s`", key)
+		g.remove(key, EventExpire)
+	}
+	log.DebugLogf("store/DELETE_EXPIRED_KEYS",
+		"stopped deletion of expired keys")
+}
+
+// delete removes a given key from the segment. It returns the removed
+// record, ok is false, when the key is missing. The watchers are notified
+// by the caller after the completion of the request. The segment mutex
+// should be held by the caller.
+func (g *segment) delete(key string) (rec hash.Record, ok bool, err error) {
+	rec, ok = g.hashMap.Peek(key)
+	if !ok {
+		return hash.RecordZero, false, nil
+	}
+
+	// The deletion is appended to the journal first, so the record is
+	// kept, when the deletion could not be recorded.
+	if g.s.journal != nil {
+		if err := g.s.journal.appendDelete(key); err != nil {
+			text := fmt.Sprintf("failed to record deletion of %s, %s", key, err)
+			return hash.RecordZero, false, &ErrInternal{text}
+		}
+	}
+
+	if elem, ok := g.expireIndex[key]; ok {
+		heap.Remove(g.expireHeap, elem.index)
+		delete(g.expireIndex, key)
+	}
+
+	g.s.release(sizeOf(key) + sizeOf(rec.Data))
+	delete(g.usage, key)
+	g.hashMap.Delete(key)
+	if g.s.changed != nil {
+		g.s.changed(key, &rec, nil)
+	}
+	return rec, true, nil
+}
+
+// remove removes a given key from the segment and notifies the watchers
+// with the given event. It is used for the removals, which are not made
+// by the requests. The segment mutex should be held by the caller.
+func (g *segment) remove(key, event string) error {
+	rec, ok, err := g.delete(key)
+	if ok {
+		g.bury(key, rec.Meta)
+		g.s.watchers.notify(event, key, rec)
+		if g.s.removed != nil {
+			g.s.removed(Event{Type: event, Key: key, Record: rec})
+		}
+	}
+	return err
+}
+
+// tombstone returns a metadata of the deleted record of the given key,
+// ok is false, when there is no recent deletion of the key. The segment
+// mutex should be held by the caller.
+func (g *segment) tombstone(key string) (hash.Meta, bool) {
+	t, ok := g.tombstones[key]
+	if !ok || time.Since(t.deletedAt) > tombstoneTTL {
+		return hash.Meta{}, false
+	}
+	return t.meta, true
+}
+
+// bury saves a metadata of the deleted record of the given key. Outdated
+// tombstones are purged once per lifetime of the tombstone. The segment
+// mutex should be held by the caller.
+func (g *segment) bury(key string, meta hash.Meta) {
+	now := time.Now()
+	if now.Sub(g.purgedAt) > tombstoneTTL {
+		for k, t := range g.tombstones {
+			if now.Sub(t.deletedAt) > tombstoneTTL {
+				delete(g.tombstones, k)
+			}
+		}
+		g.purgedAt = now
+	}
+	g.tombstones[key] = tombstone{meta: meta, deletedAt: now}
+}
+
+// snapshot returns the records of the segment, that are not expired,
+// in the snapshot format. The segment mutex should be held by the caller.
+func (g *segment) snapshot() []snapshotEntry {
+	keys := g.keys()
+	entries := make([]snapshotEntry, 0, len(keys))
+	for _, key := range keys {
+		if rec, ok := g.peek(key); ok {
+			entries = append(entries, entryOf(key, rec))
+		}
+	}
+	return entries
+}
+
+// sample appends up to n candidates for the eviction from the segment.
+// It returns an expired key, when such key is found. The segment read
+// lock should be held by the caller.
+func (g *segment) sample(key string, n int,
+	candidates []Candidate) ([]Candidate, string) {
+
+	for k, u := range g.usage {
+		if len(candidates) >= n {
+			break
+		}
+		if k == key {
+			continue
+		}
+
+		rec, _ := g.record(k)
+		if rec.IsExpired() {
+			return candidates, k
+		}
+		hits := atomic.LoadInt64(&u.hits)
+		candidates = append(candidates, Candidate{k, rec, hits})
+	}
+	return candidates, ""
+}
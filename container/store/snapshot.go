@@ -0,0 +1,299 @@
+package store
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/ybubnov/memhashd/container/hash"
+	"github.com/ybubnov/memhashd/system/log"
+)
+
+const (
+	// SnapshotVersion is a version of the snapshot format.
+	SnapshotVersion = 1
+
+	// actionSnapshot is an action to collect records of the store.
+	actionSnapshot = "snapshot"
+)
+
+// snapshotHeader is a header of the snapshot file.
+type snapshotHeader struct {
+	// Version is a version of the snapshot format.
+	Version int `json:"version"`
+
+	// CreatedAt defines a moment when the snapshot was created.
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// snapshotEntry is a record of the store in a snapshot format.
+type snapshotEntry struct {
+	Key        string        `json:"key"`
+	Index      int64         `json:"index"`
+	ExpireTime time.Duration `json:"expire_time"`
+	Sliding    bool          `json:"sliding"`
+	AccessedAt time.Time     `json:"accessed_at"`
+	CreatedAt  time.Time     `json:"created_at"`
+	UpdatedAt  time.Time     `json:"updated_at"`
+	Data       interface{}   `json:"data"`
+}
+
+// entryOf converts the record into the snapshot format.
+func entryOf(key string, rec hash.Record) snapshotEntry {
+	return snapshotEntry{
+		Key:        key,
+		Index:      rec.Meta.Index,
+		ExpireTime: rec.Meta.ExpireTime,
+		Sliding:    rec.Meta.Sliding,
+		AccessedAt: rec.Meta.AccessedAt,
+		CreatedAt:  rec.Meta.CreatedAt,
+		UpdatedAt:  rec.Meta.UpdatedAt,
+		Data:       rec.Data,
+	}
+}
+
+// record converts the snapshot entry into the record of the store.
+func (e *snapshotEntry) record() hash.Record {
+	return hash.Record{Data: e.Data, Meta: hash.Meta{
+		Index:      e.Index,
+		ExpireTime: e.ExpireTime,
+		Sliding:    e.Sliding,
+		AccessedAt: e.AccessedAt,
+		CreatedAt:  e.CreatedAt,
+		UpdatedAt:  e.UpdatedAt,
+	}}
+}
+
+// requestSnapshot defines a request to a storage to collect all records
+// that are not expired. The records are collected atomically, so the
+// snapshot is consistent.
+type requestSnapshot struct {
+	entries []snapshotEntry
+}
+
+// Action implements Request interface.
+func (r *requestSnapshot) Action() string {
+	return actionSnapshot
+}
+
+// Hash implements Request interface.
+func (r *requestSnapshot) Hash() string {
+	return ""
+}
+
+// String implements fmt.Stringer interface.
+func (r *requestSnapshot) String() string {
+	return "type: snapshot"
+}
+
+// Process implements Request interface. The data of the records is not
+// copied, since the stored values are never changed in place.
+func (r *requestSnapshot) Process(h hash.Hash) (hash.Record, error) {
+	keys := h.Keys()
+	r.entries = make([]snapshotEntry, 0, len(keys))
+
+	for _, key := range keys {
+		rec, ok := h.Peek(key)
+		if !ok || rec.IsExpired() {
+			continue
+		}
+		r.entries = append(r.entries, entryOf(key, rec))
+	}
+	return hash.RecordZero, nil
+}
+
+// WriteSnapshot writes all records of the store that are not expired
+// into the given writer.
+func WriteSnapshot(w io.Writer, s Store) error {
+	req := new(requestSnapshot)
+	if _, err := s.Serve(req); err != nil {
+		return err
+	}
+
+	encoder := json.NewEncoder(w)
+	header := snapshotHeader{Version: SnapshotVersion, CreatedAt: time.Now()}
+	if err := encoder.Encode(header); err != nil {
+		return err
+	}
+
+	for _, entry := range req.entries {
+		if err := encoder.Encode(entry); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ReadSnapshot reads the records from the given reader and restores them
+// into the hash. Records expired since the snapshot creation are skipped.
+// It returns the number of restored records.
+func ReadSnapshot(r io.Reader, h hash.Hash) (int, error) {
+	decoder := json.NewDecoder(r)
+
+	var header snapshotHeader
+	if err := decoder.Decode(&header); err != nil {
+		return 0, err
+	}
+	if header.Version != SnapshotVersion {
+		err := fmt.Errorf("store: unsupported snapshot version %d",
+			header.Version)
+		return 0, err
+	}
+
+	var restored int
+	for {
+		var entry snapshotEntry
+		err := decoder.Decode(&entry)
+		if err == io.EOF {
+			return restored, nil
+		}
+		if err != nil {
+			return restored, err
+		}
+
+		rec := entry.record()
+		if rec.IsExpired() {
+			continue
+		}
+
+		h.Restore(entry.Key, rec)
+		restored++
+	}
+}
+
+// SnapshotConfig is a configuration of the snapshotter.
+type SnapshotConfig struct {
+	// Path is a path to the snapshot file.
+	Path string
+
+	// Interval is an interval between snapshots. When zero, snapshots
+	// are saved only on explicit call and on stop of the snapshotter.
+	Interval time.Duration
+}
+
+// Snapshotter periodically saves records of the store to the disk.
+type Snapshotter struct {
+	store    Store
+	path     string
+	interval time.Duration
+
+	// A mutex to serialize writes of the snapshot file.
+	saveMu sync.Mutex
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// NewSnapshotter creates a new instance of the snapshotter according
+// to the provided configuration.
+func NewSnapshotter(s Store, config *SnapshotConfig) *Snapshotter {
+	return &Snapshotter{
+		store:    s,
+		path:     config.Path,
+		interval: config.Interval,
+	}
+}
+
+// Load restores records of the store from the snapshot file. Missing
+// snapshot file is not considered as an error.
+func (s *Snapshotter) Load() error {
+	file, err := os.Open(s.path)
+	if os.IsNotExist(err) {
+		log.InfoLogf("store/SNAPSHOT_LOAD", "snapshot %s does not exist", s.path)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	restored, err := ReadSnapshot(bufio.NewReader(file), s.store)
+	if err != nil {
+		return fmt.Errorf("store: failed to read snapshot %s, %s", s.path, err)
+	}
+
+	log.InfoLogf("store/SNAPSHOT_LOAD",
+		"restored %d records from %s", restored, s.path)
+	return nil
+}
+
+// Save writes records of the store into the snapshot file. The snapshot
+// is written into a temporary file first, which then replaces the
+// previous snapshot, so the snapshot file is never partially written.
+func (s *Snapshotter) Save() error {
+	s.saveMu.Lock()
+	defer s.saveMu.Unlock()
+
+	dir, name := filepath.Split(s.path)
+	file, err := ioutil.TempFile(dir, "."+name)
+	if err != nil {
+		return err
+	}
+
+	// Remove the temporary file, when it was not renamed.
+	defer os.Remove(file.Name())
+	defer file.Close()
+
+	w := bufio.NewWriter(file)
+	if err = WriteSnapshot(w, s.store); err != nil {
+		return err
+	}
+	if err = w.Flush(); err != nil {
+		return err
+	}
+	if err = file.Sync(); err != nil {
+		return err
+	}
+	if err = file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(file.Name(), s.path)
+}
+
+// Start starts periodic saving of the snapshots.
+func (s *Snapshotter) Start() {
+	if s.interval <= 0 || s.stop != nil {
+		return
+	}
+
+	s.stop = make(chan struct{})
+	s.done = make(chan struct{})
+	go s.run()
+}
+
+// run saves the snapshots until the snapshotter is stopped.
+func (s *Snapshotter) run() {
+	defer close(s.done)
+
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+			if err := s.Save(); err != nil {
+				log.ErrorLogf("store/SNAPSHOT_RUN",
+					"failed to save snapshot %s, %s", s.path, err)
+			}
+		}
+	}
+}
+
+// Stop stops periodic saving of the snapshots and saves the final
+// snapshot of the store.
+func (s *Snapshotter) Stop() error {
+	if s.stop != nil {
+		close(s.stop)
+		<-s.done
+		s.stop = nil
+	}
+	return s.Save()
+}
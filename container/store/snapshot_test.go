@@ -0,0 +1,106 @@
+package store
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ybubnov/memhashd/container/hash"
+)
+
+func TestWriteReadSnapshot(t *testing.T) {
+	s1 := newStore(&Config{Capacity: 16})
+	s1.Store("1", hash.Record{Data: "a"})
+	s1.Store("1", hash.Record{Data: "b"})
+	s1.Store("2", hash.Record{Data: []interface{}{"c"}, Meta: hash.Meta{
+		ExpireTime: time.Hour, Sliding: true}})
+	s1.Store("3", hash.Record{Data: "d", Meta: hash.Meta{
+		ExpireTime: 50 * time.Millisecond}})
+
+	var buf bytes.Buffer
+	if err := WriteSnapshot(&buf, s1); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	time.Sleep(100 * time.Millisecond)
+
+	s2 := newStore(&Config{Capacity: 16})
+	restored, err := ReadSnapshot(&buf, s2)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if restored != 2 {
+		t.Fatalf("expired records should not be restored: %d", restored)
+	}
+
+	rec, ok := s2.Peek("1")
+	if !ok || rec.Data.(string) != "b" || rec.Meta.Index != 2 {
+		t.Fatalf("invalid record restored: %v", rec)
+	}
+
+	rec, ok = s2.Peek("2")
+	if !ok || !rec.Meta.Sliding || rec.Meta.ExpireTime != time.Hour {
+		t.Fatalf("invalid record restored: %v", rec)
+	}
+	if s2.segmentOf("2").expireHeap.Len() != 1 {
+		t.Fatalf("restored record should be scheduled for expiration")
+	}
+}
+
+func TestReadSnapshotVersion(t *testing.T) {
+	r := strings.NewReader(`{"version": 100}`)
+	_, err := ReadSnapshot(r, newStore(&Config{Capacity: 16}))
+	if err == nil {
+		t.Fatalf("error expected for unsupported snapshot version")
+	}
+}
+
+func TestSnapshotter(t *testing.T) {
+	dir, err := ioutil.TempDir("", "memhashd")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "snapshot")
+	s1 := newStore(&Config{Capacity: 16})
+	s1.Store("1", hash.Record{Data: "a"})
+
+	snap1 := NewSnapshotter(s1, &SnapshotConfig{
+		Path: path, Interval: 10 * time.Millisecond})
+	snap1.Start()
+
+	time.Sleep(50 * time.Millisecond)
+	if _, err := os.Stat(path); err != nil {
+		t.Fatalf("snapshot should be saved periodically: %s", err)
+	}
+
+	s1.Store("2", hash.Record{Data: "b"})
+	if err := snap1.Stop(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	s2 := newStore(&Config{Capacity: 16})
+	snap2 := NewSnapshotter(s2, &SnapshotConfig{Path: path})
+	if err := snap2.Load(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if keys := s2.Keys(); len(keys) != 2 {
+		t.Fatalf("invalid list of keys restored: %v", keys)
+	}
+
+	files, _ := ioutil.ReadDir(dir)
+	if len(files) != 1 {
+		t.Fatalf("temporary files should be removed: %d", len(files))
+	}
+
+	snap3 := NewSnapshotter(s2, &SnapshotConfig{
+		Path: filepath.Join(dir, "missing")})
+	if err := snap3.Load(); err != nil {
+		t.Fatalf("missing snapshot should not be an error: %s", err)
+	}
+}
@@ -1,14 +1,20 @@
 package store
 
 import (
-	"container/heap"
-	"sync"
+	"fmt"
+	"hash/fnv"
+	"math/rand"
+	"sort"
+	"sync/atomic"
 	"time"
 
 	"github.com/ybubnov/memhashd/container/hash"
 	"github.com/ybubnov/memhashd/system/log"
 )
 
+// defaultSegments is a default number of the store segments.
+const defaultSegments = 16
+
 // Store is an interface of the store.
 type Store interface {
 	hash.Hash
@@ -16,27 +22,103 @@ type Store interface {
 	// Serve serves the request and returns associated record
 	// as a response of processing.
 	Serve(r Request) (hash.Record, error)
+
+	// ServeChanges serves the request like Serve and returns the list
+	// of changes of the records made by the request, in the order of
+	// the modifications.
+	ServeChanges(r Request) (hash.Record, []Event, error)
+
+	// Watch returns a watcher of the changes of the given key.
+	Watch(key string) *Watcher
+
+	// WatchPrefix returns a watcher of the changes of the keys with
+	// the given prefix.
+	WatchPrefix(prefix string) *Watcher
 }
 
 // Config is a configuration of the store.
 type Config struct {
 	// Capacity is an initial capacity of the store.
 	Capacity int
+
+	// Segments is a number of independently locked segments of the
+	// store. By default the store is divided into 16 segments.
+	Segments int
+
+	// MaxMemory is a maximum estimated amount of memory in bytes
+	// occupied by the keys and data of the records. When zero, the
+	// amount of memory is not limited.
+	MaxMemory int64
+
+	// MaxKeys is a maximum number of keys in the store. When zero,
+	// the number of keys is not limited.
+	MaxKeys int
+
+	// EvictionPolicy defines which records are evicted from the store,
+	// when the limits are exceeded. By default records are not evicted.
+	EvictionPolicy EvictionPolicy
+
+	// Journal records modifications of the store. When nil, the
+	// modifications are not recorded.
+	Journal *Journal
+
+	// Removed is called with the records removed by the store itself,
+	// when the records are expired or evicted. The function is called
+	// under the lock of the segment, so it should not block.
+	Removed func(Event)
+
+	// Changed is called with the previous and the new record of the key
+	// on each modification of the store, including the replicated and
+	// restored records. The previous record is nil, when the record is
+	// created, the new one is nil, when the record is removed. The
+	// function is called under the lock of the segment, so it should
+	// not block.
+	Changed func(key string, prev, rec *hash.Record)
 }
 
-// store is a hash-table storage with keys expiration.
+func (c *Config) evictionPolicy() EvictionPolicy {
+	if c.EvictionPolicy != nil {
+		return c.EvictionPolicy
+	}
+	return noEviction{}
+}
+
+func (c *Config) segments() int {
+	if c.Segments > 0 {
+		return c.Segments
+	}
+	return defaultSegments
+}
+
+// store is a hash-table storage with keys expiration. The store is
+// divided into segments, so the requests to the keys of the different
+// segments are processed concurrently.
 type store struct {
-	// Hash map in an underlying storage.
-	hashMap hash.Hash
+	// Segments of the store, each key is persisted in the segment
+	// selected by the hash of the key.
+	segments []*segment
+
+	// Limits of the store and a policy used to evict records, when
+	// the limits are exceeded.
+	maxMemory int64
+	maxKeys   int64
+	policy    EvictionPolicy
 
-	// Heap used to order the expiration timers for the records
-	// persisted in the storage. Each time-point will be extracted
-	// in increasing order.
-	expireHeap  *timeHeap
-	expireTimer *refreshTimer
+	// An estimated amount of memory occupied by the records and the
+	// number of the records, both are accessed atomically.
+	memory  int64
+	numKeys int64
 
-	// A mutex to access elements of the storage.
-	worldMu sync.Mutex
+	// An append-only log of the store modifications.
+	journal *Journal
+
+	// Watchers of the changes of the records.
+	watchers watchers
+
+	// A function called with the expired and evicted records.
+	removed func(Event)
+	// A function called on each modification of the records.
+	changed func(key string, prev, rec *hash.Record)
 }
 
 // New creates a new instance of the store according to the provided
@@ -48,126 +130,538 @@ func New(config *Config) Store {
 // newStore creates a new instance of the store according to the
 // given configuration.
 func newStore(config *Config) *store {
-	return &store{
-		hashMap:     hash.NewUnsafeHash(config.Capacity),
-		expireHeap:  newTimeHeap(config.Capacity),
-		expireTimer: new(refreshTimer),
+	s := &store{
+		segments:  make([]*segment, config.segments()),
+		maxMemory: config.MaxMemory,
+		maxKeys:   int64(config.MaxKeys),
+		policy:    config.evictionPolicy(),
+		journal:   config.Journal,
+		removed:   config.Removed,
+		changed:   config.Changed,
 	}
+
+	capacity := config.Capacity / len(s.segments)
+	for i := range s.segments {
+		s.segments[i] = newSegment(s, capacity)
+	}
+	return s
 }
 
-// Keys returns a list of keys persisted in a store.
+// segmentOf returns a segment of the given key.
+func (s *store) segmentOf(key string) *segment {
+	if len(s.segments) == 1 {
+		return s.segments[0]
+	}
+
+	ha := fnv.New32()
+	ha.Write([]byte(key))
+	return s.segments[ha.Sum32()%uint32(len(s.segments))]
+}
+
+// segmentsOf returns a list of segments accessed by the request. The
+// requests without a key access all segments of the store, unless the
+// request selects a segment itself.
+func (s *store) segmentsOf(r Request) []*segment {
+	if mr, ok := r.(MultiKeyRequest); ok {
+		return s.segmentsOfKeys(mr.Keys())
+	}
+	if key := r.Hash(); key != "" {
+		return []*segment{s.segmentOf(key)}
+	}
+	if sr, ok := r.(segmentRequest); ok {
+		i := sr.segment(len(s.segments))
+		return s.segments[i : i+1]
+	}
+	return s.segments
+}
+
+// segmentsOfKeys returns a list of segments of the given keys in the
+// order of the store segments.
+func (s *store) segmentsOfKeys(keys []string) []*segment {
+	used := make(map[*segment]bool, len(keys))
+	for _, key := range keys {
+		used[s.segmentOf(key)] = true
+	}
+
+	segments := make([]*segment, 0, len(used))
+	for _, g := range s.segments {
+		if used[g] {
+			segments = append(segments, g)
+		}
+	}
+	return segments
+}
+
+// lock locks the given segments. The segments are always locked in
+// the order of the store segments to avoid deadlocks.
+func lock(segments []*segment) {
+	for _, g := range segments {
+		g.mu.Lock()
+	}
+}
+
+// unlock unlocks the given segments.
+func unlock(segments []*segment) {
+	for i := len(segments) - 1; i >= 0; i-- {
+		segments[i].mu.Unlock()
+	}
+}
+
+// rlock locks the given segments for reading in the order of the store
+// segments.
+func rlock(segments []*segment) {
+	for _, g := range segments {
+		g.mu.RLock()
+	}
+}
+
+// runlock unlocks the given segments locked for reading.
+func runlock(segments []*segment) {
+	for i := len(segments) - 1; i >= 0; i-- {
+		segments[i].mu.RUnlock()
+	}
+}
+
+// Keys returns a list of keys persisted in a store in a lexicographical
+// order.
 func (s *store) Keys() []string {
-	s.worldMu.Lock()
-	defer s.worldMu.Unlock()
-	return s.hashMap.Keys()
+	lock(s.segments)
+	defer unlock(s.segments)
+	return s.keys()
+}
+
+// keys returns a list of keys, the mutexes of all segments should be
+// held by the caller.
+func (s *store) keys() []string {
+	var keys []string
+	for _, g := range s.segments {
+		keys = append(keys, g.keys()...)
+	}
+
+	sort.Strings(keys)
+	return keys
 }
 
 // Load returns a record persisted under the given key. If the record
 // is expired and it was not deleted by a timer, it will be deleted
 // on attempt to read it.
 func (s *store) Load(key string) (rec hash.Record, ok bool) {
-	s.worldMu.Lock()
-	defer s.worldMu.Unlock()
+	g := s.segmentOf(key)
+	g.mu.RLock()
+	rec, ok = g.read(key)
+	_, exists := g.hashMap.Peek(key)
+	g.mu.RUnlock()
 
-	rec, ok = s.hashMap.Load(key)
-	if !ok {
-		return rec, ok
+	// The expired record is removed under the write lock.
+	if !ok && exists {
+		g.mu.Lock()
+		defer g.mu.Unlock()
+		return g.load(key)
 	}
-
-	// Remove an expired key to guarantee consistency of the storage.
-	if rec.IsExpired() {
-		log.DebugLogf("store/LOAD", "key %s is expired, deleting", key)
-		s.hashMap.Delete(key)
-		return hash.Record{}, false
-	}
-
 	return rec, ok
 }
 
+// Peek returns a record persisted under the given key without updating
+// the access time of the record.
+func (s *store) Peek(key string) (rec hash.Record, ok bool) {
+	g := s.segmentOf(key)
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	return g.peek(key)
+}
+
 // Store persists a give record under the specified key. If record is
 // not persistent, it will be scheduled for remove.
+//
+// When the limits of the store are exceeded and none of the records
+// could be evicted, the record is not stored and an empty record is
+// returned.
 func (s *store) Store(key string, rec hash.Record) hash.Record {
-	s.worldMu.Lock()
-	defer s.worldMu.Unlock()
+	g := s.segmentOf(key)
+	rec, err := s.serve([]*segment{g}, func() (hash.Record, error) {
+		return g.store(key, rec)
+	})
+	if err != nil {
+		log.ErrorLogf("store/STORE", "failed to store %s, %s", key, err)
+		return rec
+	}
+	s.watchers.notify(EventStore, key, rec)
+	return rec
+}
 
-	// Store a new record into a storage.
-	rec = s.hashMap.Store(key, rec)
-	if rec.IsPermanent() {
+// Restore persists a given record under the specified key including
+// the metadata of the record. If record is not persistent, it will be
+// scheduled for remove.
+func (s *store) Restore(key string, rec hash.Record) hash.Record {
+	g := s.segmentOf(key)
+	rec, err := s.serve([]*segment{g}, func() (hash.Record, error) {
+		return g.restore(key, rec)
+	})
+	if err != nil {
+		log.ErrorLogf("store/RESTORE", "failed to restore %s, %s", key, err)
 		return rec
 	}
+	s.watchers.notify(EventStore, key, rec)
+	return rec
+}
 
-	// For non-permanent records, calculate expiration time and schedule
-	// an timer, that will purge all records with lower lifetime.
-	cutoff := rec.ExpiresAt()
-	heap.Push(s.expireHeap, &timeHeapElement{cutoff, key})
+// Watch implements Store interface.
+func (s *store) Watch(key string) *Watcher {
+	return s.watchers.add(key, false)
+}
 
-	log.DebugLogf("store/STORE",
-		"scheduling next run of timer in %s", cutoff)
-	s.expireTimer.AfterFunc(cutoff, func() { s.deleteAfter(cutoff) })
-	return rec
+// WatchPrefix implements Store interface.
+func (s *store) WatchPrefix(prefix string) *Watcher {
+	return s.watchers.add(prefix, true)
 }
 
-// deleteAfter removes all keys, which lifetime is less the specified
-// cut-off interval.
-func (s *store) deleteAfter(cutoff time.Time) {
-	s.DeleteExpiredKeys(cutoff)
-	s.worldMu.Lock()
-	defer s.worldMu.Unlock()
+// Delete removes a given key from the store.
+func (s *store) Delete(key string) {
+	g := s.segmentOf(key)
+	g.mu.Lock()
+	rec, ok, err := g.delete(key)
+	g.mu.Unlock()
 
-	// When the length of the heap is zero, there are no more temporary
-	// keys in it, therefore timer won't be started until a new record
-	// will be added to a heap.
-	if s.expireHeap.Len() == 0 {
-		return
+	if err != nil {
+		log.ErrorLogf("store/DELETE", "failed to delete %s, %s", key, err)
 	}
-
-	// Peek next timer form the heap and schedule an expiration timer.
-	elem := s.expireHeap.Peek().(*timeHeapElement)
-	log.DebugLogf("store/DELETE_AFTER",
-		"re-scheduling next run of timer in %s", elem.Time)
-	s.expireTimer.AfterFunc(elem.Time, func() {
-		s.deleteAfter(elem.Time)
-	})
+	if ok {
+		s.watchers.notify(EventDelete, key, rec)
+	}
+	s.compact()
 }
 
 // DeleteExpiredKeys removes expired keys from the storage and extracts
 // all timers that are less than a specified cutoff.
 func (s *store) DeleteExpiredKeys(cutoff time.Time) {
-	s.worldMu.Lock()
-	defer s.worldMu.Unlock()
+	for _, g := range s.segments {
+		g.mu.Lock()
+		g.deleteExpiredKeys(cutoff)
+		g.mu.Unlock()
+	}
+}
+
+// Serve proceses a request. An access to the segments of the request
+// keys is synchronized, so the request is processed atomically.
+func (s *store) Serve(r Request) (hash.Record, error) {
+	rec, _, err := s.ServeChanges(r)
+	return rec, err
+}
+
+// ServeChanges implements Store interface. The changes are collected
+// only from the last attempt to process the request, since the request
+// is repeated after the eviction of the records.
+func (s *store) ServeChanges(r Request) (hash.Record, []Event, error) {
+	// The reads of a single key or a single segment do not modify the
+	// records, so they are served under the read lock of the segment.
+	_, single := r.(segmentRequest)
+	if ReadOnly(r) && (r.Hash() != "" || single) {
+		segments := s.segmentsOf(r)
+		rlock(segments)
+		defer runlock(segments)
+
+		rec, err := r.Process(&unlockedStore{s: s, shared: true})
+		return rec, nil, err
+	}
+
+	var changes []Event
+	rec, err := s.serve(s.segmentsOf(r), func() (hash.Record, error) {
+		u := &unlockedStore{s: s}
+		rec, err := r.Process(u)
+		changes = u.changes
+		if u.err != nil {
+			return hash.RecordZero, u.err
+		}
+		return rec, err
+	})
+
+	// The watchers are notified only about the changes of the completed
+	// request, so the changes reverted by the failed request and the
+	// changes of the repeated attempts are not delivered. The replicated
+	// changes are delivered by the primary copy of the records.
+	if _, replica := r.(*RequestReplicate); err == nil && !replica {
+		for _, ev := range changes {
+			// The deletion of the missing record is not delivered,
+			// the index of the existing record is never zero.
+			if ev.Type == EventDelete && ev.Record.Meta.Index == 0 {
+				continue
+			}
+			s.watchers.notify(ev.Type, ev.Key, ev.Record)
+		}
+	}
+	return rec, changes, err
+}
+
+// serve calls the function with locked segments. When the records do
+// not fit into the limits of the store, the segments are unlocked to
+// evict the records of the other segments and the call is repeated.
+func (s *store) serve(segments []*segment,
+	fn func() (hash.Record, error)) (hash.Record, error) {
+
+	for {
+		rec, err := call(segments, fn)
+
+		nospace, ok := err.(*errNoSpace)
+		if !ok {
+			s.compact()
+			return rec, err
+		}
+
+		err = s.evict(nospace.key, nospace.size, nospace.isNew)
+		if err != nil {
+			return hash.RecordZero, err
+		}
+	}
+}
+
+// call calls the function with locked segments. The segments are
+// unlocked, even when the function panics.
+func call(segments []*segment,
+	fn func() (hash.Record, error)) (hash.Record, error) {
+
+	lock(segments)
+	defer unlock(segments)
+	return fn()
+}
+
+// compact rewrites the journal to contain only the current state of
+// the records in background, when the journal is too large.
+func (s *store) compact() {
+	if s.journal == nil || !s.journal.beginRewrite() {
+		return
+	}
+	go s.rewrite()
+}
+
+// rewrite collects the records of the segments one by one and replaces
+// the journal with them. The modifications made meanwhile are buffered
+// by the journal and appended after the collected records, so none of
+// them is lost, while the segments are locked only for the copying.
+func (s *store) rewrite() {
+	var entries []snapshotEntry
+	for _, g := range s.segments {
+		g.mu.Lock()
+		entries = append(entries, g.snapshot()...)
+		g.mu.Unlock()
+	}
+
+	if err := s.journal.rewrite(entries); err != nil {
+		log.ErrorLogf("store/COMPACT", "failed to compact journal, %s", err)
+	}
+}
+
+// reserve reserves the space for a record of the given size in the
+// limits of the store. It returns false, when the limits are exceeded.
+func (s *store) reserve(size int64, isNew bool) bool {
+	if isNew && !reserveInt64(&s.numKeys, 1, s.maxKeys) {
+		return false
+	}
+	if !reserveInt64(&s.memory, size, s.maxMemory) {
+		if isNew {
+			atomic.AddInt64(&s.numKeys, -1)
+		}
+		return false
+	}
+	return true
+}
+
+// unreserve returns the space reserved for the record of the given size,
+// when the record was not persisted.
+func (s *store) unreserve(size int64, isNew bool) {
+	if isNew {
+		atomic.AddInt64(&s.numKeys, -1)
+	}
+	atomic.AddInt64(&s.memory, -size)
+}
+
+// release releases the space occupied by the record of the given size.
+func (s *store) release(size int64) {
+	atomic.AddInt64(&s.numKeys, -1)
+	atomic.AddInt64(&s.memory, -size)
+}
+
+// reserveInt64 atomically adds a delta to the value, when the result
+// does not exceed the limit. Non-positive limit means no limit.
+func reserveInt64(addr *int64, delta, limit int64) bool {
+	if limit <= 0 || delta <= 0 {
+		atomic.AddInt64(addr, delta)
+		return true
+	}
 
-	log.DebugLogf("store/DELETE_EXPIRED_KEYS",
-		"starting deletion of expired keys")
 	for {
-		// Peek the next element and check if the saved record
-		// is already expired, so it has to be removed.
-		next, ok := s.expireHeap.Peek().(*timeHeapElement)
-		if !ok || next == nil || next.Time.After(cutoff) {
+		val := atomic.LoadInt64(addr)
+		if val+delta > limit {
+			return false
+		}
+		if atomic.CompareAndSwapInt64(addr, val, val+delta) {
+			return true
+		}
+	}
+}
+
+// exceeded returns true, when a record of the given size does not fit
+// into the limits of the store.
+func (s *store) exceeded(size int64, isNew bool) bool {
+	if s.maxMemory > 0 && atomic.LoadInt64(&s.memory)+size > s.maxMemory {
+		return true
+	}
+	return isNew && s.maxKeys > 0 && atomic.LoadInt64(&s.numKeys) >= s.maxKeys
+}
+
+// evict evicts records from the store, until the record of the given
+// size fits into the limits of the store. The given key is never
+// evicted. An error is returned, when the limits could not be satisfied.
+func (s *store) evict(key string, size int64, isNew bool) error {
+	for s.exceeded(size, isNew) {
+		victim, ok := s.victim(key)
+		if !ok {
+			text := fmt.Sprintf("not enough space to store %s", key)
+			return &ErrFull{text}
+		}
+
+		log.DebugLogf("store/EVICT", "evicting key `%s`", victim)
+		g := s.segmentOf(victim)
+		g.mu.Lock()
+		err := g.remove(victim, EventEvict)
+		g.mu.Unlock()
+
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// victim selects a key to evict from a random sample of the keys. The
+// expired records are evicted first regardless of the eviction policy.
+// Segments are sampled one by one starting from a random segment.
+func (s *store) victim(key string) (string, bool) {
+	candidates := make([]Candidate, 0, evictionSamples)
+	start := rand.Intn(len(s.segments))
+
+	for i := 0; i < len(s.segments); i++ {
+		g := s.segments[(start+i)%len(s.segments)]
+
+		var expired string
+		g.mu.RLock()
+		candidates, expired = g.sample(key, evictionSamples, candidates)
+		g.mu.RUnlock()
+
+		if expired != "" {
+			return expired, true
+		}
+		if len(candidates) == evictionSamples {
 			break
 		}
+	}
+
+	pos := s.policy.Select(candidates)
+	if pos < 0 || pos >= len(candidates) {
+		return "", false
+	}
+	return candidates[pos].Key, true
+}
+
+// unlockedStore is an implementation of the hash.Hash interface, that
+// accesses the records of the store without acquiring the segment
+// mutexes. It is used to process requests, while the mutexes are held
+// by the caller.
+type unlockedStore struct {
+	s *store
+
+	// shared is true, when the segments are locked for reading, so
+	// the records are only read.
+	shared bool
 
-		// Remove a keys from the storage and remove time from the heap of
-		// expiration times.
-		key := next.Data.(string)
-		log.DebugLogf("store/DELETE_EXPIRED_KEYS",
-			"deleted expired key `%s`", key)
+	// err is the first error occurred on attempt to store a record,
+	// it overrides the result of the request processing.
+	err error
 
-		s.hashMap.Delete(key)
-		s.expireHeap.Pop()
+	// changes is a list of successful modifications of the records.
+	changes []Event
+}
+
+// Keys implements hash.Hash interface.
+func (u *unlockedStore) Keys() []string {
+	return u.s.keys()
+}
+
+// segmentKeys implements segmentScanner interface.
+func (u *unlockedStore) segmentKeys(i int) ([]string, int) {
+	if i < 0 || i >= len(u.s.segments) {
+		return nil, len(u.s.segments)
 	}
-	log.DebugLogf("store/DELETE_EXPIRED_KEYS",
-		"stopped deletion of expired keys")
+	return u.s.segments[i].keys(), len(u.s.segments)
 }
 
-// Delete removes a given key from the store.
-func (s *store) Delete(key string) {
-	s.worldMu.Lock()
-	defer s.worldMu.Unlock()
-	s.hashMap.Delete(key)
+// Load implements hash.Hash interface.
+func (u *unlockedStore) Load(key string) (hash.Record, bool) {
+	if u.shared {
+		return u.s.segmentOf(key).read(key)
+	}
+	return u.s.segmentOf(key).load(key)
 }
 
-// Serve proceses a request. An access to the store is synchronized
-// with a world mutex.
-func (s *store) Serve(r Request) (hash.Record, error) {
-	return r.Process(s)
+// Peek implements hash.Hash interface.
+func (u *unlockedStore) Peek(key string) (hash.Record, bool) {
+	return u.s.segmentOf(key).peek(key)
+}
+
+// Store implements hash.Hash interface.
+func (u *unlockedStore) Store(key string, rec hash.Record) hash.Record {
+	rec, err := u.s.segmentOf(key).store(key, rec)
+	u.setChange(EventStore, key, rec, err)
+	return rec
+}
+
+// Restore implements hash.Hash interface.
+func (u *unlockedStore) Restore(key string, rec hash.Record) hash.Record {
+	rec, err := u.s.segmentOf(key).restore(key, rec)
+	u.setChange(EventStore, key, rec, err)
+	return rec
+}
+
+// Delete implements hash.Hash interface. The metadata of the deleted
+// record is kept, so the stale copies of the record are not restored by
+// the replication.
+func (u *unlockedStore) Delete(key string) {
+	g := u.s.segmentOf(key)
+	rec, ok, err := g.delete(key)
+	if ok {
+		g.bury(key, rec.Meta)
+	}
+	u.setChange(EventDelete, key, rec, err)
+}
+
+// setChange records the modification of the record, when it succeeded,
+// otherwise the error is saved.
+func (u *unlockedStore) setChange(typ, key string, rec hash.Record, err error) {
+	if err != nil {
+		u.setErr(err)
+		return
+	}
+	u.changes = append(u.changes, Event{Type: typ, Key: key, Record: rec})
+}
+
+// tombstone implements tombstoneHash interface.
+func (u *unlockedStore) tombstone(key string) (hash.Meta, bool) {
+	return u.s.segmentOf(key).tombstone(key)
+}
+
+// bury implements tombstoneHash interface.
+func (u *unlockedStore) bury(key string, meta hash.Meta) {
+	u.s.segmentOf(key).bury(key, meta)
+}
+
+// resetErr implements errHash interface.
+func (u *unlockedStore) resetErr() (err error) {
+	err, u.err = u.err, nil
+	return err
+}
+
+// setErr saves the first occurred error.
+func (u *unlockedStore) setErr(err error) {
+	if err != nil && u.err == nil {
+		u.err = err
+	}
 }
@@ -1,7 +1,9 @@
 package store
 
 import (
+	"fmt"
 	"reflect"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -9,7 +11,7 @@ import (
 )
 
 func TestStoreStore(t *testing.T) {
-	s := newStore(&Config{16})
+	s := newStore(&Config{Capacity: 16})
 
 	now := time.Now()
 	expire := 60 * time.Minute
@@ -17,18 +19,19 @@ func TestStoreStore(t *testing.T) {
 	meta := hash.Meta{ExpireTime: expire}
 	s.Store("1", hash.Record{Data: 1, Meta: meta})
 
-	if s.expireTimer.cutoff.Before(now.Add(expire)) {
+	g := s.segmentOf("1")
+	if g.expireTimer.cutoff.Before(now.Add(expire)) {
 		t.Fatalf("timer scheduled incorrectly")
 	}
 
-	rec, ok := s.hashMap.Load("1")
+	rec, ok := g.hashMap.Load("1")
 	if !ok || rec.Data.(int) != 1 {
 		t.Fatalf("invalid record returned")
 	}
 }
 
 func TestStoreKeys(t *testing.T) {
-	s := newStore(&Config{16})
+	s := newStore(&Config{Capacity: 16})
 	s.Store("1", hash.Record{Data: 1})
 	s.Store("2", hash.Record{Data: 2})
 	s.Store("3", hash.Record{Data: 3})
@@ -41,7 +44,7 @@ func TestStoreKeys(t *testing.T) {
 }
 
 func TestStoreLoad(t *testing.T) {
-	s := newStore(&Config{16})
+	s := newStore(&Config{Capacity: 16})
 
 	s.Store("2", hash.Record{Data: 2})
 	s.Store("1", hash.Record{Data: 1, Meta: hash.Meta{
@@ -57,7 +60,7 @@ func TestStoreLoad(t *testing.T) {
 }
 
 func TestDeleteExpiredKeys(t *testing.T) {
-	s := newStore(&Config{16})
+	s := newStore(&Config{Capacity: 16})
 
 	s.Store("1", hash.Record{Data: 1, Meta: hash.Meta{
 		ExpireTime: 1 * time.Nanosecond}})
@@ -72,3 +75,155 @@ func TestDeleteExpiredKeys(t *testing.T) {
 		t.Fatalf("record should not be in a store")
 	}
 }
+
+func TestStoreLoadSliding(t *testing.T) {
+	s := newStore(&Config{Capacity: 16})
+
+	s.Store("1", hash.Record{Data: 1, Meta: hash.Meta{
+		ExpireTime: 200 * time.Millisecond, Sliding: true}})
+	s.Store("2", hash.Record{Data: 2, Meta: hash.Meta{
+		ExpireTime: 200 * time.Millisecond}})
+
+	// Access the sliding record periodically to extend its lifetime
+	// beyond the initial expiration time.
+	for i := 0; i < 4; i++ {
+		time.Sleep(100 * time.Millisecond)
+		if _, ok := s.Load("1"); !ok {
+			t.Fatalf("sliding record should be in a store")
+		}
+	}
+
+	if _, ok := s.Peek("2"); ok {
+		t.Fatalf("record should not be in a store")
+	}
+
+	time.Sleep(300 * time.Millisecond)
+	if _, ok := s.Peek("1"); ok {
+		t.Fatalf("sliding record should not be in a store")
+	}
+	for _, g := range s.segments {
+		if g.expireHeap.Len() != 0 || len(g.expireIndex) != 0 {
+			t.Fatalf("expiration heap should be empty")
+		}
+	}
+}
+
+func TestStoreReschedule(t *testing.T) {
+	s := newStore(&Config{Capacity: 16})
+
+	s.Store("1", hash.Record{Data: 1, Meta: hash.Meta{
+		ExpireTime: time.Hour}})
+	s.Store("1", hash.Record{Data: 2, Meta: hash.Meta{
+		ExpireTime: time.Minute}})
+
+	g := s.segmentOf("1")
+	if g.expireHeap.Len() != 1 {
+		t.Fatalf("expected a single timer, got %d", g.expireHeap.Len())
+	}
+
+	s.Store("1", hash.Record{Data: 3})
+	if g.expireHeap.Len() != 0 {
+		t.Fatalf("permanent record should not be scheduled")
+	}
+}
+
+// panicRequest is a request, which processing panics.
+type panicRequest struct {
+	RequestStore
+}
+
+// Process implements Request interface.
+func (r *panicRequest) Process(h hash.Hash) (hash.Record, error) {
+	panic("processing failed")
+}
+
+func TestStoreServePanic(t *testing.T) {
+	s := newStore(&Config{Capacity: 16})
+
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Fatalf("panic expected")
+			}
+		}()
+		s.Serve(&panicRequest{RequestStore{Key: "1"}})
+	}()
+
+	// The segment should be unlocked after the panic.
+	if _, err := s.Serve(&RequestStore{Key: "1", Data: 1}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if rec, ok := s.Load("1"); !ok || rec.Data.(int) != 1 {
+		t.Fatalf("invalid record returned: %v", rec)
+	}
+}
+
+func TestStoreLoadUsage(t *testing.T) {
+	s := newStore(&Config{Capacity: 16})
+	s.Store("1", hash.Record{Data: 1})
+
+	before, _ := s.Peek("1")
+	time.Sleep(time.Millisecond)
+	for i := 0; i < 3; i++ {
+		if _, err := s.Serve(&RequestLoad{Key: "1"}); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	}
+
+	after, _ := s.Peek("1")
+	if !after.Meta.AccessedAt.After(before.Meta.AccessedAt) {
+		t.Fatalf("access time should be updated: %v", after.Meta)
+	}
+	if hits := s.segmentOf("1").usage["1"].hits; hits != 4 {
+		t.Fatalf("invalid number of hits: %d", hits)
+	}
+}
+
+// benchmarkStore runs the given function against the store with the
+// specified number of segments from multiple go-routines.
+func benchmarkStore(b *testing.B, segments int, fn func(*store, int, string)) {
+	const numKeys = 1024
+
+	s := newStore(&Config{Capacity: numKeys, Segments: segments})
+	keys := make([]string, numKeys)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("%d", i)
+		s.Store(keys[i], hash.Record{Data: i})
+	}
+
+	var n int64
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		i := atomic.AddInt64(&n, 1)
+		for pb.Next() {
+			fn(s, int(i), keys[i%numKeys])
+			i++
+		}
+	})
+}
+
+func BenchmarkStoreLoadParallel(b *testing.B) {
+	load := func(s *store, i int, key string) { s.Load(key) }
+	for _, segments := range []int{1, defaultSegments} {
+		b.Run(fmt.Sprintf("segments-%d", segments), func(b *testing.B) {
+			benchmarkStore(b, segments, load)
+		})
+	}
+}
+
+func BenchmarkStoreServeParallel(b *testing.B) {
+	// Simulate a read-heavy workload, where each tenth request is
+	// a modification of the record.
+	serve := func(s *store, i int, key string) {
+		if i%10 == 0 {
+			s.Serve(&RequestStore{Key: key, Data: i})
+			return
+		}
+		s.Serve(&RequestLoad{Key: key})
+	}
+	for _, segments := range []int{1, defaultSegments} {
+		b.Run(fmt.Sprintf("segments-%d", segments), func(b *testing.B) {
+			benchmarkStore(b, segments, serve)
+		})
+	}
+}
@@ -12,6 +12,10 @@ type timeHeapElement struct {
 
 	// Data is a placeholder for arbitrary data.
 	Data interface{}
+
+	// index is a position of the element in a heap, it is maintained
+	// by the heap to update the time of the element in place.
+	index int
 }
 
 // timeHeap is a heap where time type is used for ordering of the
@@ -41,27 +45,33 @@ func (h *timeHeap) Less(i, j int) bool {
 // positions.
 func (h *timeHeap) Swap(i, j int) {
 	h.arr[i], h.arr[j] = h.arr[j], h.arr[i]
+	h.arr[i].index = i
+	h.arr[j].index = j
 }
 
 // Peek returns an element on the top of the heap and nil, when the
 // heap is empty.
 func (h *timeHeap) Peek() interface{} {
 	if h.Len() != 0 {
-		return h.arr[len(h.arr)-1]
+		return h.arr[0]
 	}
 	return nil
 }
 
 // Push inserts a new element into a time-ordered heap.
 func (h *timeHeap) Push(v interface{}) {
-	h.arr = append(h.arr, v.(*timeHeapElement))
+	elem := v.(*timeHeapElement)
+	elem.index = len(h.arr)
+	h.arr = append(h.arr, elem)
 }
 
 // Pop extracts an elements from the heap.
 func (h *timeHeap) Pop() interface{} {
 	n := len(h.arr)
 	val := h.arr[n-1]
+	h.arr[n-1] = nil
 	h.arr = h.arr[:n-1]
+	val.index = -1
 	return val
 }
 
@@ -14,9 +14,13 @@ func TestTimeHeap(t *testing.T) {
 	}
 
 	now := time.Now()
-	heap.Push(h, &timeHeapElement{now, 1})
-	heap.Push(h, &timeHeapElement{now.Add(10 * time.Hour), 2})
-	heap.Push(h, &timeHeapElement{now.Add(10 * time.Second), 3})
+	heap.Push(h, &timeHeapElement{Time: now, Data: 1})
+	heap.Push(h, &timeHeapElement{Time: now.Add(10 * time.Hour), Data: 2})
+	heap.Push(h, &timeHeapElement{Time: now.Add(10 * time.Second), Data: 3})
+
+	if e := h.Peek().(*timeHeapElement); e.Data.(int) != 1 {
+		t.Fatalf("invalid element on top of the heap: %v", e.Data)
+	}
 
 	tests := []struct {
 		Data int
@@ -32,6 +36,30 @@ func TestTimeHeap(t *testing.T) {
 	}
 }
 
+func TestTimeHeapFix(t *testing.T) {
+	h := newTimeHeap(0)
+
+	now := time.Now()
+	e1 := &timeHeapElement{Time: now, Data: 1}
+	e2 := &timeHeapElement{Time: now.Add(time.Hour), Data: 2}
+	heap.Push(h, e1)
+	heap.Push(h, e2)
+
+	e1.Time = now.Add(2 * time.Hour)
+	heap.Fix(h, e1.index)
+	if e := h.Peek().(*timeHeapElement); e.Data.(int) != 2 {
+		t.Fatalf("invalid element on top of the heap: %v", e.Data)
+	}
+
+	heap.Remove(h, e2.index)
+	if e := heap.Pop(h).(*timeHeapElement); e.Data.(int) != 1 {
+		t.Fatalf("invalid element on top of the heap: %v", e.Data)
+	}
+	if h.Len() != 0 {
+		t.Fatalf("heap should be empty")
+	}
+}
+
 func TestRefreshTimer(t *testing.T) {
 	rt := new(refreshTimer)
 	now := time.Now()
@@ -0,0 +1,232 @@
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"github.com/ybubnov/memhashd/container/hash"
+)
+
+// ActionTransaction is an action to apply multiple requests atomically.
+const ActionTransaction = "transaction"
+
+// MultiKeyRequest is implemented by the requests, that access multiple
+// keys of the store at once.
+type MultiKeyRequest interface {
+	Request
+
+	// Keys returns a list of keys accessed by the request.
+	Keys() []string
+}
+
+// errHash is implemented by the hashes, that record the errors of the
+// modifications instead of returning them.
+type errHash interface {
+	// resetErr returns the recorded error and resets it.
+	resetErr() error
+}
+
+// resetErr returns the error recorded by the hash, if any.
+func resetErr(h hash.Hash) error {
+	if eh, ok := h.(errHash); ok {
+		return eh.resetErr()
+	}
+	return nil
+}
+
+// Precondition defines an expected index of the record. The index is
+// zero for missing records.
+type Precondition struct {
+	// Key is a key of the record.
+	Key string
+	// Index is an expected index of the record.
+	Index int64
+}
+
+// RequestTransaction defines a request to a storage to apply an ordered
+// list of requests atomically: either all requests are applied or none
+// of them. The transaction is applied only when all preconditions are
+// satisfied.
+type RequestTransaction struct {
+	// ID is a request identifier.
+	ID string
+	// Requests is an ordered list of requests to apply.
+	Requests []Request
+	// Preconditions is a list of expected indices of the records.
+	Preconditions []Precondition
+}
+
+// transactionOp is a JSON representation of the request of the
+// transaction along with an action, so it could be decoded.
+type transactionOp struct {
+	Action  string
+	Request *json.RawMessage
+}
+
+// transactionJSON is a JSON representation of the transaction.
+type transactionJSON struct {
+	ID            string
+	Requests      []transactionOp
+	Preconditions []Precondition
+}
+
+// MarshalJSON implements json.Marshaler interface.
+func (r *RequestTransaction) MarshalJSON() ([]byte, error) {
+	tx := transactionJSON{ID: r.ID, Preconditions: r.Preconditions}
+	for _, req := range r.Requests {
+		b, err := json.Marshal(req)
+		if err != nil {
+			return nil, err
+		}
+		raw := json.RawMessage(b)
+		tx.Requests = append(tx.Requests, transactionOp{req.Action(), &raw})
+	}
+	return json.Marshal(tx)
+}
+
+// UnmarshalJSON implements json.Unmarshaler interface.
+func (r *RequestTransaction) UnmarshalJSON(b []byte) error {
+	var tx transactionJSON
+	if err := json.Unmarshal(b, &tx); err != nil {
+		return err
+	}
+
+	requests := make([]Request, 0, len(tx.Requests))
+	for _, op := range tx.Requests {
+		req, err := MakeRequest(op.Action)
+		if err != nil {
+			return err
+		}
+		if op.Request != nil {
+			if err = json.Unmarshal(*op.Request, req); err != nil {
+				return err
+			}
+		}
+		requests = append(requests, req)
+	}
+
+	r.ID = tx.ID
+	r.Requests = requests
+	r.Preconditions = tx.Preconditions
+	return nil
+}
+
+// Action implements Request interface.
+func (r *RequestTransaction) Action() string {
+	return ActionTransaction
+}
+
+// Hash implements Request interface. Hash of the transaction is a first
+// key of the transaction, the keys of the transaction are expected to
+// be owned by the same node.
+func (r *RequestTransaction) Hash() string {
+	if keys := r.Keys(); len(keys) != 0 {
+		return keys[0]
+	}
+	return ""
+}
+
+// Keys implements MultiKeyRequest interface.
+func (r *RequestTransaction) Keys() []string {
+	var keys []string
+	seen := make(map[string]bool)
+
+	add := func(key string) {
+		if key != "" && !seen[key] {
+			seen[key] = true
+			keys = append(keys, key)
+		}
+	}
+
+	for _, pre := range r.Preconditions {
+		add(pre.Key)
+	}
+	for _, req := range r.Requests {
+		add(req.Hash())
+	}
+	return keys
+}
+
+// String implements fmt.Stringer interface.
+func (r *RequestTransaction) String() string {
+	return fmt.Sprintf("id: %s, type: transaction, requests: %d"+
+		", preconditions: %d", r.ID, len(r.Requests), len(r.Preconditions))
+}
+
+// Process implements Request interface, it applies the requests of the
+// transaction in order. When any of the requests fails, the records
+// modified by the transaction are reverted. The record data contains
+// a list of records returned by each request.
+func (r *RequestTransaction) Process(h hash.Hash) (hash.Record, error) {
+	for _, req := range r.Requests {
+		_, isMulti := req.(MultiKeyRequest)
+		if req.Hash() == "" || isMulti {
+			text := fmt.Sprintf("%s request is not allowed in transaction",
+				req.Action())
+			return hash.RecordZero, &ErrConflict{text}
+		}
+	}
+
+	for _, pre := range r.Preconditions {
+		var index int64
+		if rec, ok := h.Peek(pre.Key); ok {
+			index = rec.Meta.Index
+		}
+		if index != pre.Index {
+			text := fmt.Sprintf("index of %s is %d, expected %d",
+				pre.Key, index, pre.Index)
+			return hash.RecordZero, &ErrConflict{text}
+		}
+	}
+
+	// Save the state of the records before the transaction, so it could
+	// be restored on failure.
+	undo := make(map[string]*hash.Record)
+	for _, key := range r.Keys() {
+		if rec, ok := h.Peek(key); ok {
+			undo[key] = &rec
+		} else {
+			undo[key] = nil
+		}
+	}
+
+	records := make([]hash.Record, 0, len(r.Requests))
+	for _, req := range r.Requests {
+		rec, err := req.Process(h)
+		if herr := resetErr(h); err == nil {
+			err = herr
+		}
+		if err != nil {
+			r.rollback(h, undo)
+			return hash.RecordZero, err
+		}
+		records = append(records, rec)
+	}
+
+	return hash.Record{Data: records}, nil
+}
+
+// rollback restores the state of the records saved before the
+// transaction.
+func (r *RequestTransaction) rollback(h hash.Hash, undo map[string]*hash.Record) {
+	keys := make([]string, 0, len(undo))
+	for key := range undo {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	// Delete the records first to release the space occupied by the
+	// transaction, so the previous records fit into the limits.
+	for _, key := range keys {
+		if undo[key] == nil {
+			h.Delete(key)
+		}
+	}
+	for _, key := range keys {
+		if rec := undo[key]; rec != nil {
+			h.Restore(key, *rec)
+		}
+	}
+	resetErr(h)
+}
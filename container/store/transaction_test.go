@@ -0,0 +1,108 @@
+package store
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/ybubnov/memhashd/container/hash"
+)
+
+func TestRequestTransaction(t *testing.T) {
+	s := newStore(&Config{Capacity: 16, Segments: 4})
+	s.Store("a", hash.Record{Data: int64(1)})
+
+	req := &RequestTransaction{
+		Requests: []Request{
+			&RequestIncr{Key: "a"},
+			&RequestStore{Key: "b", Data: "x"},
+			&RequestLoad{Key: "a"},
+		},
+		Preconditions: []Precondition{{Key: "a", Index: 1}, {Key: "b"}},
+	}
+
+	if req.Hash() != "a" {
+		t.Fatalf("invalid hash of transaction: %s", req.Hash())
+	}
+
+	rec, err := s.Serve(req)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	records := rec.Data.([]hash.Record)
+	if len(records) != 3 || records[2].Data != int64(2) {
+		t.Fatalf("invalid records returned: %v", records)
+	}
+	if rec, _ := s.Peek("b"); rec.Data != "x" {
+		t.Fatalf("transaction should be applied: %v", rec)
+	}
+
+	// Preconditions are not satisfied anymore.
+	_, err = s.Serve(req)
+	if _, ok := err.(*ErrConflict); !ok {
+		t.Fatalf("expected conflict error, got %v", err)
+	}
+	if rec, _ := s.Peek("a"); rec.Data != int64(2) {
+		t.Fatalf("transaction should not be applied: %v", rec)
+	}
+}
+
+func TestRequestTransactionRollback(t *testing.T) {
+	s := newStore(&Config{Capacity: 16, Segments: 4})
+	s.Store("a", hash.Record{Data: "x"})
+
+	_, err := s.Serve(&RequestTransaction{Requests: []Request{
+		&RequestStore{Key: "a", Data: "y"},
+		&RequestStore{Key: "b", Data: "z"},
+		&RequestIncr{Key: "a"},
+	}})
+	if err == nil {
+		t.Fatalf("transaction should fail")
+	}
+
+	rec, _ := s.Peek("a")
+	if rec.Data != "x" || rec.Meta.Index != 1 {
+		t.Fatalf("record should be restored: %v", rec)
+	}
+	if _, ok := s.Peek("b"); ok {
+		t.Fatalf("created record should be removed")
+	}
+	if keys := s.Keys(); len(keys) != 1 {
+		t.Fatalf("invalid list of keys: %v", keys)
+	}
+
+	_, err = s.Serve(&RequestTransaction{Requests: []Request{
+		&RequestKeys{},
+	}})
+	if _, ok := err.(*ErrConflict); !ok {
+		t.Fatalf("expected conflict error, got %v", err)
+	}
+}
+
+func TestRequestTransactionJSON(t *testing.T) {
+	req := &RequestTransaction{
+		ID: "1",
+		Requests: []Request{
+			&RequestStore{Key: "a", Data: "x"},
+			&RequestDelete{Key: "b"},
+		},
+		Preconditions: []Precondition{{Key: "a", Index: 3}},
+	}
+
+	b, err := json.Marshal(req)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	var decoded RequestTransaction
+	if err = json.Unmarshal(b, &decoded); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if len(decoded.Requests) != 2 || decoded.Preconditions[0].Index != 3 {
+		t.Fatalf("invalid transaction decoded: %s", &decoded)
+	}
+	if r, ok := decoded.Requests[1].(*RequestDelete); !ok || r.Key != "b" {
+		t.Fatalf("invalid request decoded: %v", decoded.Requests[1])
+	}
+}
@@ -0,0 +1,164 @@
+package store
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/ybubnov/memhashd/container/hash"
+)
+
+const (
+	// ActionExpire is an action to set a new time to live of the record.
+	ActionExpire = "expire"
+
+	// ActionPersist is an action to make the record permanent.
+	ActionPersist = "persist"
+
+	// ActionTTL is an action to query the remaining lifetime of the
+	// record.
+	ActionTTL = "ttl"
+)
+
+// recordOf returns a record persisted under the given key without
+// updating its access time, or an error when the record is missing.
+func recordOf(h hash.Hash, key string) (hash.Record, error) {
+	rec, ok := h.Peek(key)
+	if !ok {
+		text := fmt.Sprintf("%s does not exist", key)
+		return hash.RecordZero, &ErrMissing{text}
+	}
+	return rec, nil
+}
+
+// storeExpireTime persists the record with a new expiration time, the
+// data of the record remains untouched.
+func storeExpireTime(h hash.Hash, key string, rec hash.Record,
+	expire time.Duration) hash.Record {
+
+	meta := rec.Meta
+	meta.ExpireTime = expire
+	return h.Store(key, hash.Record{Data: rec.Data, Meta: meta})
+}
+
+// RequestExpire defines a request to a storage to set a new time to
+// live of the record. The record expires after the given amount of time
+// counted from the moment of the request processing.
+type RequestExpire struct {
+	// ID is a request identifier.
+	ID string
+	// Key is a name of the key.
+	Key string
+	// ExpireTime defines a new time to live of the record. When it is
+	// less than or equal to zero, the record becomes permanent.
+	ExpireTime time.Duration
+}
+
+// Action implements Request interface.
+func (r *RequestExpire) Action() string {
+	return ActionExpire
+}
+
+// Hash implements Request interface.
+func (r *RequestExpire) Hash() string {
+	return r.Key
+}
+
+// String implements fmt.Stringer interface.
+func (r *RequestExpire) String() string {
+	return fmt.Sprintf("id: %s, type: expire, key: %s, expire_time: %s",
+		r.ID, r.Key, r.ExpireTime)
+}
+
+// Process implements Request interface, it updates the expiration
+// time of the record.
+func (r *RequestExpire) Process(h hash.Hash) (hash.Record, error) {
+	rec, err := recordOf(h, r.Key)
+	if err != nil {
+		return hash.RecordZero, err
+	}
+
+	// The lifetime of the regular records is counted from the creation
+	// time, so extend the expiration time by the age of the record. The
+	// lifetime of the sliding records is counted from the update.
+	expire := r.ExpireTime
+	if expire > 0 && !rec.Meta.Sliding {
+		expire += time.Since(rec.Meta.CreatedAt)
+	}
+	return storeExpireTime(h, r.Key, rec, expire), nil
+}
+
+// RequestPersist defines a request to a storage to remove the
+// expiration time of the record, so it becomes permanent.
+type RequestPersist struct {
+	// ID is a request identifier.
+	ID string
+	// Key is a name of the key.
+	Key string
+}
+
+// Action implements Request interface.
+func (r *RequestPersist) Action() string {
+	return ActionPersist
+}
+
+// Hash implements Request interface.
+func (r *RequestPersist) Hash() string {
+	return r.Key
+}
+
+// String implements fmt.Stringer interface.
+func (r *RequestPersist) String() string {
+	return fmt.Sprintf("id: %s, type: persist, key: %s", r.ID, r.Key)
+}
+
+// Process implements Request interface, it makes the record permanent.
+func (r *RequestPersist) Process(h hash.Hash) (hash.Record, error) {
+	rec, err := recordOf(h, r.Key)
+	if err != nil {
+		return hash.RecordZero, err
+	}
+	return storeExpireTime(h, r.Key, rec, 0), nil
+}
+
+// RequestTTL defines a request to a storage to query the remaining
+// lifetime of the record. The data of the returned record is a
+// time.Duration, it is zero for permanent records.
+type RequestTTL struct {
+	// ID is a request identifier.
+	ID string
+	// Key is a name of the key.
+	Key string
+}
+
+// Action implements Request interface.
+func (r *RequestTTL) Action() string {
+	return ActionTTL
+}
+
+// Hash implements Request interface.
+func (r *RequestTTL) Hash() string {
+	return r.Key
+}
+
+// String implements fmt.Stringer interface.
+func (r *RequestTTL) String() string {
+	return fmt.Sprintf("id: %s, type: ttl, key: %s", r.ID, r.Key)
+}
+
+// Process implements Request interface, it returns the remaining
+// lifetime of the record. The access time of the record is not updated.
+func (r *RequestTTL) Process(h hash.Hash) (hash.Record, error) {
+	rec, err := recordOf(h, r.Key)
+	if err != nil {
+		return hash.RecordZero, err
+	}
+
+	var ttl time.Duration
+	if !rec.IsPermanent() {
+		ttl = rec.ExpiresAt().Sub(time.Now())
+		if ttl < 0 {
+			ttl = 0
+		}
+	}
+	return hash.Record{Data: ttl, Meta: rec.Meta}, nil
+}
@@ -0,0 +1,96 @@
+package store
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ybubnov/memhashd/container/hash"
+)
+
+func TestRequestExpire(t *testing.T) {
+	s := newStore(&Config{Capacity: 16})
+	req := &RequestExpire{Key: "1", ExpireTime: time.Hour}
+	if req.Action() != ActionExpire {
+		t.Fatalf("invalid request action: %s", req.Action())
+	}
+
+	_, err := s.Serve(req)
+	if _, ok := err.(*ErrMissing); !ok {
+		t.Fatalf("expected missing error, got %v", err)
+	}
+
+	s.Store("1", hash.Record{Data: 42, Meta: hash.Meta{
+		ExpireTime: time.Millisecond}})
+
+	rec, err := s.Serve(req)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if rec.Data.(int) != 42 {
+		t.Fatalf("data of the record should be preserved: %v", rec.Data)
+	}
+
+	time.Sleep(10 * time.Millisecond)
+	if _, ok := s.Load("1"); !ok {
+		t.Fatalf("record should be in a store")
+	}
+
+	ttl := rec.ExpiresAt().Sub(time.Now())
+	if ttl < 59*time.Minute || ttl > time.Hour {
+		t.Fatalf("invalid expiration time of the record: %s", ttl)
+	}
+}
+
+func TestRequestPersist(t *testing.T) {
+	s := newStore(&Config{Capacity: 16})
+	req := &RequestPersist{Key: "1"}
+	if req.Action() != ActionPersist {
+		t.Fatalf("invalid request action: %s", req.Action())
+	}
+
+	s.Store("1", hash.Record{Data: 42, Meta: hash.Meta{
+		ExpireTime: 50 * time.Millisecond}})
+
+	rec, err := s.Serve(req)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !rec.IsPermanent() {
+		t.Fatalf("record should be permanent")
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	if _, ok := s.Load("1"); !ok {
+		t.Fatalf("record should be in a store")
+	}
+}
+
+func TestRequestTTL(t *testing.T) {
+	s := newStore(&Config{Capacity: 16})
+	req := &RequestTTL{Key: "1"}
+	if req.Action() != ActionTTL {
+		t.Fatalf("invalid request action: %s", req.Action())
+	}
+
+	s.Store("1", hash.Record{Data: 42, Meta: hash.Meta{
+		ExpireTime: time.Hour}})
+
+	rec, err := s.Serve(req)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	ttl := rec.Data.(time.Duration)
+	if ttl < 59*time.Minute || ttl > time.Hour {
+		t.Fatalf("invalid remaining lifetime returned: %s", ttl)
+	}
+
+	s.Store("1", hash.Record{Data: 42})
+	rec, err = s.Serve(req)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if rec.Data.(time.Duration) != 0 {
+		t.Fatalf("permanent record should have zero lifetime: %v", rec.Data)
+	}
+}
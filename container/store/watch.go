@@ -0,0 +1,235 @@
+package store
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ybubnov/memhashd/container/hash"
+)
+
+const (
+	// ActionWatch is an action to wait for changes of the record.
+	ActionWatch = "watch"
+
+	// ActionSubscribe is an action to subscribe to the changes of the
+	// records.
+	ActionSubscribe = "subscribe"
+
+	// EventStore is an event of storing a record.
+	EventStore = "store"
+
+	// EventDelete is an event of deleting a record.
+	EventDelete = "delete"
+
+	// EventExpire is an event of deleting an expired record.
+	EventExpire = "expire"
+
+	// EventEvict is an event of evicting a record due to the limits
+	// of the store.
+	EventEvict = "evict"
+
+	// watchBuffer is a number of events buffered by the watcher.
+	watchBuffer = 64
+)
+
+// Event is a change of the record in a store.
+type Event struct {
+	// Type is a type of the event.
+	Type string
+
+	// Key is a key of the changed record.
+	Key string
+
+	// Record is a new state of the record. For the deleted records it
+	// is the last state of the record before deletion.
+	Record hash.Record
+}
+
+// Watcher receives the events of the keys it was created for. Events
+// are delivered to the channel without blocking the store, so when the
+// receiver falls behind and the buffer is full, the channel is closed.
+type Watcher struct {
+	// C is a channel of events.
+	C <-chan Event
+
+	c      chan Event
+	key    string
+	prefix bool
+
+	w *watchers
+
+	// Mutex guards the channel from sending to the closed channel.
+	mu     sync.Mutex
+	closed bool
+}
+
+// matches reports whether the watcher is interested in the key.
+func (w *Watcher) matches(key string) bool {
+	if w.prefix {
+		return strings.HasPrefix(key, w.key)
+	}
+	return w.key == key
+}
+
+// send sends the event to the watcher without blocking, the channel is
+// closed when the buffer is full.
+func (w *Watcher) send(ev Event) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return
+	}
+
+	select {
+	case w.c <- ev:
+	default:
+		w.closed = true
+		close(w.c)
+	}
+}
+
+// Stop stops the delivery of the events and closes the channel.
+func (w *Watcher) Stop() {
+	w.w.remove(w)
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if !w.closed {
+		w.closed = true
+		close(w.c)
+	}
+}
+
+// watchers is a registry of the store watchers.
+type watchers struct {
+	mu  sync.RWMutex
+	set map[*Watcher]struct{}
+}
+
+// add creates a new watcher of the key or key prefix.
+func (ws *watchers) add(key string, prefix bool) *Watcher {
+	c := make(chan Event, watchBuffer)
+	w := &Watcher{C: c, c: c, key: key, prefix: prefix, w: ws}
+
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	if ws.set == nil {
+		ws.set = make(map[*Watcher]struct{})
+	}
+	ws.set[w] = struct{}{}
+	return w
+}
+
+// remove removes the watcher from the registry.
+func (ws *watchers) remove(w *Watcher) {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	delete(ws.set, w)
+}
+
+// notify sends the event to all watchers of the key.
+func (ws *watchers) notify(typ, key string, rec hash.Record) {
+	ws.mu.RLock()
+	defer ws.mu.RUnlock()
+	for w := range ws.set {
+		if w.matches(key) {
+			w.send(Event{Type: typ, Key: key, Record: rec})
+		}
+	}
+}
+
+// RequestWatch defines a request to a storage to wait until the index
+// of the record exceeds the given one or the record is deleted. The index
+// restarts from one, when the record is created again after the deletion,
+// so the record with the index lower than the given one is a newer record
+// and it satisfies the request as well.
+//
+// The store does not block processing of this request, it returns the
+// current record, when the index of the record exceeds the given one.
+// Otherwise a zero record is returned and the caller should wait for the
+// changes using Watch method of the store.
+type RequestWatch struct {
+	// ID is a request identifier.
+	ID string
+	// Key is a name of the key.
+	Key string
+	// Index is the last known index of the record.
+	Index int64
+	// Timeout is a maximum duration of waiting for the changes. When
+	// zero, the request waits until it is canceled.
+	Timeout time.Duration
+}
+
+// Action implements Request interface.
+func (r *RequestWatch) Action() string {
+	return ActionWatch
+}
+
+// Hash implements Request interface.
+func (r *RequestWatch) Hash() string {
+	return r.Key
+}
+
+// String implements fmt.Stringer interface.
+func (r *RequestWatch) String() string {
+	return fmt.Sprintf("id: %s, type: watch, key: %s, index: %d",
+		r.ID, r.Key, r.Index)
+}
+
+// Process implements Request interface. It returns a record, when the
+// index of the record differs from the requested one. When the record was
+// deleted after the requested index, an error is returned.
+func (r *RequestWatch) Process(h hash.Hash) (hash.Record, error) {
+	rec, ok := h.Peek(r.Key)
+	if !ok && r.Index > 0 {
+		text := fmt.Sprintf("%s does not exist", r.Key)
+		return hash.RecordZero, &ErrMissing{text}
+	}
+	if ok && rec.Meta.Index != r.Index {
+		return rec, nil
+	}
+	return hash.RecordZero, nil
+}
+
+// Ready reports whether the event satisfies the watch request: the
+// record was deleted or its index differs from the requested one.
+func (r *RequestWatch) Ready(ev Event) bool {
+	return ev.Type != EventStore || ev.Record.Meta.Index != r.Index
+}
+
+// RequestSubscribe defines a request to subscribe to the changes of the
+// records with the given key prefix. The changes are streamed by the
+// server until the subscriber closes the connection, therefore the
+// store does not process this request.
+type RequestSubscribe struct {
+	// ID is a request identifier.
+	ID string
+	// Prefix is a prefix of the keys to watch.
+	Prefix string
+}
+
+// Action implements Request interface.
+func (r *RequestSubscribe) Action() string {
+	return ActionSubscribe
+}
+
+// Hash implements Request interface. Hash for subscribe request is
+// always an empty string, since each node streams own changes.
+func (r *RequestSubscribe) Hash() string {
+	return ""
+}
+
+// String implements fmt.Stringer interface.
+func (r *RequestSubscribe) String() string {
+	return fmt.Sprintf("id: %s, type: subscribe, prefix: %s",
+		r.ID, r.Prefix)
+}
+
+// Process implements Request interface, it returns an error, since the
+// changes should be retrieved using WatchPrefix method of the store.
+func (r *RequestSubscribe) Process(h hash.Hash) (hash.Record, error) {
+	text := fmt.Sprintf("%s request should be streamed", r.Action())
+	return hash.RecordZero, &ErrInternal{text}
+}
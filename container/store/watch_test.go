@@ -0,0 +1,140 @@
+package store
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ybubnov/memhashd/container/hash"
+)
+
+func assertEvent(t *testing.T, w *Watcher, typ, key string, index int64) {
+	select {
+	case ev := <-w.C:
+		if ev.Type != typ || ev.Key != key || ev.Record.Meta.Index != index {
+			t.Fatalf("invalid event received: %v", ev)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("event %s of %s expected", typ, key)
+	}
+}
+
+func TestStoreWatch(t *testing.T) {
+	policy, _ := EvictionPolicyOf(PolicyLRU)
+	s := newStore(&Config{Capacity: 16, MaxKeys: 2, EvictionPolicy: policy})
+
+	w := s.Watch("1")
+	defer w.Stop()
+	wp := s.WatchPrefix("user:")
+	defer wp.Stop()
+
+	s.Store("1", hash.Record{Data: "a"})
+	s.Store("1", hash.Record{Data: "b"})
+	s.Store("2", hash.Record{Data: "c"})
+	s.Serve(&RequestDelete{Key: "1"})
+
+	assertEvent(t, w, EventStore, "1", 1)
+	assertEvent(t, w, EventStore, "1", 2)
+	assertEvent(t, w, EventDelete, "1", 2)
+
+	s.Store("user:1", hash.Record{Data: "d", Meta: hash.Meta{
+		ExpireTime: 10 * time.Millisecond}})
+	assertEvent(t, wp, EventStore, "user:1", 1)
+	assertEvent(t, wp, EventExpire, "user:1", 1)
+
+	s.Store("user:2", hash.Record{Data: "e"})
+	s.Serve(&RequestStore{Key: "3", Data: "f"})
+	assertEvent(t, wp, EventStore, "user:2", 1)
+
+	// One of the keys is evicted to store the third one.
+	if _, ok := s.Peek("user:2"); !ok {
+		assertEvent(t, wp, EventEvict, "user:2", 1)
+	}
+}
+
+func TestStoreWatchRollback(t *testing.T) {
+	s := newStore(&Config{Capacity: 16})
+	w := s.Watch("1")
+	defer w.Stop()
+
+	// The change of the failed transaction is reverted, so it should
+	// not be delivered to the watchers.
+	_, err := s.Serve(&RequestTransaction{Requests: []Request{
+		&RequestStore{Key: "1", Data: "a"},
+		&RequestListIndex{Key: "2"},
+	}})
+	if err == nil {
+		t.Fatalf("transaction should fail")
+	}
+
+	s.Store("1", hash.Record{Data: "b"})
+	assertEvent(t, w, EventStore, "1", 1)
+
+	select {
+	case ev := <-w.C:
+		t.Fatalf("unexpected event received: %v", ev)
+	default:
+	}
+}
+
+func TestStoreWatchOverflow(t *testing.T) {
+	s := newStore(&Config{Capacity: 16})
+	w := s.Watch("1")
+
+	for i := 0; i <= watchBuffer; i++ {
+		s.Store("1", hash.Record{Data: i})
+	}
+
+	var n int
+	for range w.C {
+		n++
+	}
+	if n != watchBuffer {
+		t.Fatalf("invalid number of events received: %d", n)
+	}
+
+	// Stop of the closed watcher should not panic.
+	w.Stop()
+	if len(s.watchers.set) != 0 {
+		t.Fatalf("watcher should be removed")
+	}
+}
+
+func TestRequestWatch(t *testing.T) {
+	s := newStore(&Config{Capacity: 16})
+	s.Store("1", hash.Record{Data: "a"})
+
+	req := &RequestWatch{Key: "1", Index: 1}
+	if rec, err := s.Serve(req); err != nil || rec.Meta.Index != 0 {
+		t.Fatalf("record should not be returned: %v, %v", rec, err)
+	}
+
+	req.Index = 0
+	if rec, err := s.Serve(req); err != nil || rec.Meta.Index != 1 {
+		t.Fatalf("record should be returned: %v, %v", rec, err)
+	}
+
+	// The record is created again, while the previous index is known.
+	s.Delete("1")
+	s.Store("1", hash.Record{Data: "b"})
+	req.Index = 5
+	if rec, err := s.Serve(req); err != nil || rec.Data != "b" {
+		t.Fatalf("created record should be returned: %v, %v", rec, err)
+	}
+	if !req.Ready(Event{Type: EventStore, Record: hash.Record{
+		Meta: hash.Meta{Index: 1}}}) {
+		t.Fatalf("event of the created record should be ready")
+	}
+
+	req = &RequestWatch{Key: "2", Index: 1}
+	if _, err := s.Serve(req); err == nil {
+		t.Fatalf("error expected for deleted record")
+	}
+
+	if req.Ready(Event{Type: EventStore, Record: hash.Record{
+		Meta: hash.Meta{Index: 1}}}) {
+		t.Fatalf("event with the same index should not be ready")
+	}
+	if !req.Ready(Event{Type: EventDelete}) {
+		t.Fatalf("delete event should be ready")
+	}
+}
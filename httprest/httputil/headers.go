@@ -22,6 +22,15 @@ const (
 	// receiving a 401 (Unauthorized) response.
 	HeaderAuthorization = "Authorization"
 
+	// HeaderCacheControl is used to specify directives for caching
+	// mechanisms in both requests and responses.
+	HeaderCacheControl = "Cache-Control"
+
+	// HeaderConsistencyLevel defines how many copies of the record
+	// should acknowledge the write or answer the read: "ONE", "QUORUM"
+	// or "ALL".
+	HeaderConsistencyLevel = "X-Consistency-Level"
+
 	// HeaderContentEncoding indicates what content codings have been
 	// applied to the representation, beyond those inherent in the media
 	// type, and thus what decoding mechanisms have to be applied in
@@ -48,6 +57,21 @@ const (
 	// message semantics.
 	HeaderContentType = "Content-Type"
 
+	// HeaderIfMatch makes the request method conditional on the recipient
+	// origin server either having at least one current representation of
+	// the target resource, when the field value is "*", or having a
+	// current representation of the target resource that has an
+	// entity-tag matching a member of the list of entity-tags provided
+	// in the field value.
+	HeaderIfMatch = "If-Match"
+
+	// HeaderIfNoneMatch makes the request method conditional on the
+	// recipient origin server either not having any current
+	// representation of the target resource, when the field value is
+	// "*", or having a selected representation with an entity-tag that
+	// does not match any of those listed in the field value.
+	HeaderIfNoneMatch = "If-None-Match"
+
 	// HeaderLink provides a means for serialising one or more links in
 	// HTTP headers.
 	HeaderLink = "Link"
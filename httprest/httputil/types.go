@@ -19,4 +19,7 @@ const (
 
 	// TypeText is a text media type.
 	TypeText = "text/*"
+
+	// TypeTextEventStream is a media type of the server-sent events.
+	TypeTextEventStream = "text/event-stream"
 )
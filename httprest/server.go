@@ -2,9 +2,12 @@ package httprest
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"net"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/ybubnov/go-uuid"
@@ -65,13 +68,39 @@ func NewServer(config *Config) *Server {
 		tlsKeyFile:  config.TLSKeyFile,
 	}
 
+	s.mux.HandleFilterFunc(s.consistencyFilter)
 	s.mux.HandleFunc("GET", "/v1/keys", s.keysHandler)
 	s.mux.HandleFunc("GET", "/v1/keys/{key}", s.loadHandler)
 	s.mux.HandleFunc("GET", "/v1/keys/{key}/index", s.indexHandler)
 	s.mux.HandleFunc("GET", "/v1/keys/{key}/item", s.itemHandler)
 	s.mux.HandleFunc("PUT", "/v1/keys/{key}", s.storeHandler)
+	s.mux.HandleFunc("PATCH", "/v1/keys/{key}", s.mergeHandler)
+	s.mux.HandleFunc("PUT", "/v1/keys/{key}/item", s.setItemHandler)
+	s.mux.HandleFunc("DELETE", "/v1/keys/{key}/item", s.deleteItemHandler)
+	s.mux.HandleFunc("GET", "/v1/keys/{key}/items", s.itemsHandler)
+	s.mux.HandleFunc("GET", "/v1/keys/{key}/fields", s.fieldsHandler)
 	s.mux.HandleFunc("DELETE", "/v1/keys/{key}", s.deleteHandler)
+	s.mux.HandleFunc("POST", "/v1/keys/{key}/incr", s.incrHandler)
+	s.mux.HandleFunc("POST", "/v1/keys/{key}/decr", s.decrHandler)
+	s.mux.HandleFunc("GET", "/v1/keys/{key}/list", s.listRangeHandler)
+	s.mux.HandleFunc("POST", "/v1/keys/{key}/list/push", s.listPushHandler)
+	s.mux.HandleFunc("POST", "/v1/keys/{key}/list/pop", s.listPopHandler)
+	s.mux.HandleFunc("POST", "/v1/keys/{key}/list/trim", s.listTrimHandler)
+	s.mux.HandleFunc("PUT", "/v1/keys/{key}/list/index", s.listSetHandler)
+	s.mux.HandleFunc("DELETE", "/v1/keys/{key}/list/index", s.listRemoveHandler)
+	s.mux.HandleFunc("GET", "/v1/keys/{key}/ttl", s.ttlHandler)
+	s.mux.HandleFunc("PATCH", "/v1/keys/{key}/ttl", s.expireHandler)
+	s.mux.HandleFunc("DELETE", "/v1/keys/{key}/ttl", s.persistHandler)
+	s.mux.HandleFunc("POST", "/v1/batch/load", s.batchLoadHandler)
+	s.mux.HandleFunc("POST", "/v1/batch/store", s.batchStoreHandler)
+	s.mux.HandleFunc("POST", "/v1/transaction", s.transactionHandler)
+	s.mux.HandleFunc("GET", "/v1/events", s.eventsHandler)
+	s.mux.HandleFunc("POST", "/v1/channels/{name}", s.publishHandler)
+	s.mux.HandleFunc("GET", "/v1/channels/{name}", s.subscribeHandler)
 	s.mux.HandleFunc("GET", "/v1/nodes", s.nodesHandler)
+	s.mux.HandleFunc("POST", "/v1/nodes", s.joinHandler)
+	s.mux.HandleFunc("DELETE", "/v1/nodes/{addr}", s.leaveHandler)
+	s.mux.HandleFunc("GET", "/v1/partitions/divergent", s.divergentHandler)
 	return s
 }
 
@@ -93,11 +122,39 @@ func (s *Server) readReq(rw http.ResponseWriter, r *http.Request,
 	return nil
 }
 
+// consistencyFilter validates the consistency level of the request, so
+// the handlers do not process requests with the undefined levels.
+func (s *Server) consistencyFilter(rw http.ResponseWriter, r *http.Request) {
+	header := r.Header.Get(httputil.HeaderConsistencyLevel)
+	if _, err := store.ConsistencyOf(header); err != nil {
+		const text = "invalid %s header %s"
+		log.ErrorLogf("server/CONSISTENCY_FILTER", text,
+			httputil.HeaderConsistencyLevel, header)
+
+		body := client.Error{fmt.Sprintf(text,
+			httputil.HeaderConsistencyLevel, header)}
+		wf := &httputil.JSONFormatter{}
+		wf.Write(rw, body, http.StatusBadRequest)
+	}
+}
+
+// contextOf returns a context of the request processing with the
+// consistency level requested by the client.
+func (s *Server) contextOf(r *http.Request) context.Context {
+	header := r.Header.Get(httputil.HeaderConsistencyLevel)
+	level, err := store.ConsistencyOf(header)
+	if err != nil {
+		return s.ctx
+	}
+	return server.WithConsistency(s.ctx, level)
+}
+
 // metaOf returns a record metadata in a client format.
 func (s *Server) metaOf(resp *server.Response) client.Meta {
 	return client.Meta{
 		Index:      resp.Record.Meta.Index,
 		ExpireTime: client.Duration(resp.Record.Meta.ExpireTime),
+		Sliding:    resp.Record.Meta.Sliding,
 		AccessedAt: resp.Record.Meta.AccessedAt,
 		CreatedAt:  resp.Record.Meta.CreatedAt,
 		UpdatedAt:  resp.Record.Meta.UpdatedAt,
@@ -116,8 +173,32 @@ func (s *Server) keysHandler(rw http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	switch scope := httputil.Param(r, "scope"); scope {
+	case "", "local":
+	case "cluster":
+		s.clusterKeysHandler(rw, r, wf)
+		return
+	default:
+		const text = "invalid scope %s"
+		log.ErrorLogf("server/KEYS_HANDLER", text, scope)
+
+		body := client.Error{fmt.Sprintf(text, scope)}
+		wf.Write(rw, body, http.StatusBadRequest)
+		return
+	}
+
+	// Return a single page of keys, when the scan parameters are
+	// specified, otherwise return all keys at once.
+	query := r.URL.Query()
+	for _, name := range []string{"cursor", "limit", "match", "prefix"} {
+		if _, ok := query[name]; ok {
+			s.scanHandler(rw, r, wf)
+			return
+		}
+	}
+
 	req := &store.RequestKeys{ID: uuid.New()}
-	resp := s.server.Do(s.ctx, req)
+	resp := s.server.Do(s.contextOf(r), req)
 	if resp.Err() != nil {
 		const text = "unable to load keys, %s"
 		body := client.Error{fmt.Sprintf(text, resp.Err())}
@@ -145,6 +226,83 @@ func (s *Server) keysHandler(rw http.ResponseWriter, r *http.Request) {
 	wf.Write(rw, keys, http.StatusOK)
 }
 
+// clusterKeysHandler returns a list of keys stored on all nodes in
+// a cluster, the nodes failed to return keys are listed separately.
+func (s *Server) clusterKeysHandler(rw http.ResponseWriter, r *http.Request,
+	wf httputil.WriteFormatter) {
+
+	resp := s.server.Keys(s.ctx)
+	body := client.ClusterKeysResponse{
+		Keys:   resp.Keys,
+		Errors: make([]client.NodeError, 0, len(resp.Errors)),
+	}
+
+	// Force the server return empty list instead of nil.
+	if body.Keys == nil {
+		body.Keys = make([]string, 0)
+	}
+	for _, err := range resp.Errors {
+		body.Errors = append(body.Errors, client.NodeError{
+			Addr: err.Addr, Text: err.Error})
+	}
+	wf.Write(rw, body, http.StatusOK)
+}
+
+// scanHandler returns a page of keys starting from the given cursor.
+func (s *Server) scanHandler(rw http.ResponseWriter, r *http.Request,
+	wf httputil.WriteFormatter) {
+
+	params := httputil.Params(r, "cursor", "limit", "match", "prefix")
+	req := &store.RequestScan{
+		ID:     uuid.New(),
+		Cursor: params[0],
+		Match:  params[2],
+		Prefix: params[3],
+	}
+
+	if params[1] != "" {
+		limit, err := strconv.Atoi(params[1])
+		if err != nil || limit <= 0 {
+			const text = "invalid limit %s"
+			log.ErrorLogf("server/SCAN_HANDLER", text, params[1])
+
+			body := client.Error{fmt.Sprintf(text, params[1])}
+			wf.Write(rw, body, http.StatusBadRequest)
+			return
+		}
+		req.Limit = limit
+	}
+
+	resp := s.server.Do(s.contextOf(r), req)
+	if resp.Err() != nil {
+		const text = "unable to scan keys, %s"
+		body := client.Error{fmt.Sprintf(text, resp.Err())}
+
+		log.ErrorLogf("server/SCAN_HANDLER", "%s failed, %s", req, resp.Err())
+		wf.Write(rw, body, resp.Status)
+		return
+	}
+
+	result, ok := resp.Record.Data.(store.ScanResult)
+	if !ok {
+		const text = "invalid type of keys"
+		log.ErrorLogf("server/SCAN_HANDLER", text)
+
+		body := client.Error{text}
+		wf.Write(rw, body, http.StatusInternalServerError)
+		return
+	}
+
+	// Force the server return empty list instead of nil.
+	if result.Keys == nil {
+		result.Keys = make([]string, 0)
+	}
+	wf.Write(rw, client.ScanResponse{
+		Cursor: result.Cursor,
+		Keys:   result.Keys,
+	}, http.StatusOK)
+}
+
 // loadHandler loads a requested data from the store (depending on
 // requested action, it can return a partial data, like item in a list
 // or dictionary).
@@ -155,10 +313,16 @@ func (s *Server) loadHandler(rw http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Wait for the changes of the record, when requested.
+	if wait, _ := strconv.ParseBool(httputil.Param(r, "wait")); wait {
+		s.watchHandler(rw, r, wf)
+		return
+	}
+
 	// Create a new load request, assign an identifier to it, for easy
 	// tracking in the logs of the application.
 	req := &store.RequestLoad{ID: uuid.New(), Key: key}
-	resp := s.server.Do(s.ctx, req)
+	resp := s.server.Do(s.contextOf(r), req)
 	if resp.Err() != nil {
 		const text = "unable to load %s key, %s"
 		body := client.Error{fmt.Sprintf(text, req.Key, resp.Err())}
@@ -180,6 +344,48 @@ func (s *Server) loadHandler(rw http.ResponseWriter, r *http.Request) {
 	wf.Write(rw, cresp, http.StatusOK)
 }
 
+// watchHandler waits until the index of the record exceeds the given
+// one or the record is deleted, the response is returned only after
+// that. The request is canceled, when the client closes the connection.
+func (s *Server) watchHandler(rw http.ResponseWriter, r *http.Request,
+	wf httputil.WriteFormatter) {
+
+	key := httputil.Param(r, "key")
+	req := &store.RequestWatch{ID: uuid.New(), Key: key}
+
+	if param := httputil.Param(r, "index"); param != "" {
+		index, err := strconv.ParseInt(param, 10, 64)
+		if err != nil || index < 0 {
+			const text = "invalid index %s"
+			log.ErrorLogf("server/WATCH_HANDLER", text, param)
+
+			body := client.Error{fmt.Sprintf(text, param)}
+			wf.Write(rw, body, http.StatusBadRequest)
+			return
+		}
+		req.Index = index
+	}
+
+	resp := s.server.Do(r.Context(), req)
+	if resp.Err() != nil {
+		const text = "unable to watch %s key, %s"
+		body := client.Error{fmt.Sprintf(text, req.Key, resp.Err())}
+
+		log.ErrorLogf("server/WATCH_HANDLER",
+			"%s failed, %s", req.ID, resp.Err())
+		wf.Write(rw, body, resp.Status)
+		return
+	}
+
+	cresp := client.Response{
+		Action: resp.Event,
+		Data:   resp.Record.Data,
+		Node:   s.nodeOf(&resp),
+		Meta:   s.metaOf(&resp),
+	}
+	wf.Write(rw, cresp, http.StatusOK)
+}
+
 // storeHandler stores a given record in a key-value storage. It
 // returns a node where a record was created, creation and update time.
 func (s *Server) storeHandler(rw http.ResponseWriter, r *http.Request) {
@@ -194,24 +400,73 @@ func (s *Server) storeHandler(rw http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	req := &store.RequestStore{
-		ID:  uuid.New(),
+	id := uuid.New()
+	var req store.Request = &store.RequestStore{
+		ID:  id,
 		Key: key, Data: opts.Data,
 		ExpireTime: time.Duration(opts.ExpireTime),
+		Sliding:    opts.Sliding,
 	}
-	resp := s.server.Do(s.ctx, req)
+
+	// When the client provides an expected index of the record, the
+	// record is stored only if the persisted index matches it.
+	if etag := r.Header.Get(httputil.HeaderIfMatch); etag != "" {
+		index, err := strconv.ParseInt(strings.Trim(etag, `"`), 10, 64)
+		if err != nil {
+			const text = "invalid %s header, %s"
+			log.ErrorLogf("server/STORE_HANDLER", text,
+				httputil.HeaderIfMatch, err)
+
+			body := client.Error{fmt.Sprintf(text,
+				httputil.HeaderIfMatch, err)}
+			wf.Write(rw, body, http.StatusBadRequest)
+			return
+		}
+
+		req = &store.RequestCompareAndSwap{
+			ID:  id,
+			Key: key, Data: opts.Data, Index: index,
+			ExpireTime: time.Duration(opts.ExpireTime),
+			Sliding:    opts.Sliding,
+		}
+	}
+
+	// The record is created only if it does not exist, the index of the
+	// missing record is zero.
+	if etag := r.Header.Get(httputil.HeaderIfNoneMatch); etag != "" {
+		if etag != "*" || req.Action() != store.ActionStore {
+			const text = "invalid %s header, only * is supported" +
+				" without %s header"
+			log.ErrorLogf("server/STORE_HANDLER", text,
+				httputil.HeaderIfNoneMatch, httputil.HeaderIfMatch)
+
+			body := client.Error{fmt.Sprintf(text,
+				httputil.HeaderIfNoneMatch, httputil.HeaderIfMatch)}
+			wf.Write(rw, body, http.StatusBadRequest)
+			return
+		}
+
+		req = &store.RequestCompareAndSwap{
+			ID:  id,
+			Key: key, Data: opts.Data, Index: 0,
+			ExpireTime: time.Duration(opts.ExpireTime),
+			Sliding:    opts.Sliding,
+		}
+	}
+
+	resp := s.server.Do(s.contextOf(r), req)
 	if resp.Err() != nil {
 		const text = "unable to store %s key, %s"
-		body := client.Error{fmt.Sprintf(text, req.Key, resp.Err())}
+		body := client.Error{fmt.Sprintf(text, key, resp.Err())}
 
 		log.ErrorLogf("server/STORE_HANDLER",
-			"%s failed, %s", req.ID, resp.Err())
+			"%s failed, %s", id, resp.Err())
 		wf.Write(rw, body, resp.Status)
 		return
 	}
 
 	cresp := client.Response{
-		Action: "store",
+		Action: req.Action(),
 		Data:   resp.Record.Data,
 		Node:   s.nodeOf(&resp),
 		Meta:   s.metaOf(&resp),
@@ -232,7 +487,7 @@ func (s *Server) deleteHandler(rw http.ResponseWriter, r *http.Request) {
 	// Create a new delete request, assign an identifier to it for
 	// tracking.
 	req := &store.RequestDelete{ID: uuid.New(), Key: key}
-	resp := s.server.Do(s.ctx, req)
+	resp := s.server.Do(s.contextOf(r), req)
 	if resp.Err() != nil {
 		const text = "unable to delete %s key, %s"
 		body := client.Error{fmt.Sprintf(text, req.Key, resp.Err())}
@@ -269,7 +524,7 @@ func (s *Server) indexHandler(rw http.ResponseWriter, r *http.Request) {
 	req := &store.RequestListIndex{
 		ID: uuid.New(), Key: key, Index: opts.Index,
 	}
-	resp := s.server.Do(s.ctx, req)
+	resp := s.server.Do(s.contextOf(r), req)
 	if resp.Err() != nil {
 		const text = "unable to load value, %s"
 		body := client.Error{fmt.Sprintf(text, resp.Err())}
@@ -307,7 +562,7 @@ func (s *Server) itemHandler(rw http.ResponseWriter, r *http.Request) {
 	req := &store.RequestDictItem{
 		ID: uuid.New(), Key: key, Item: opts.Item,
 	}
-	resp := s.server.Do(s.ctx, req)
+	resp := s.server.Do(s.contextOf(r), req)
 	if resp.Err() != nil {
 		const text = "unable to load value, %s"
 		body := client.Error{fmt.Sprintf(text, resp.Err())}
@@ -327,22 +582,877 @@ func (s *Server) itemHandler(rw http.ResponseWriter, r *http.Request) {
 	wf.Write(rw, cresp, http.StatusOK)
 }
 
+// serveReq processes the request and writes the response back to the
+// client. When the processing fails, an error text is prefixed with the
+// given message.
+func (s *Server) serveReq(rw http.ResponseWriter, r *http.Request,
+	wf httputil.WriteFormatter, event, text string, req store.Request) {
+
+	resp := s.server.Do(s.contextOf(r), req)
+	if resp.Err() != nil {
+		body := client.Error{fmt.Sprintf("%s, %s", text, resp.Err())}
+
+		log.ErrorLogf(event, "%s failed, %s", req, resp.Err())
+		wf.Write(rw, body, resp.Status)
+		return
+	}
+
+	cresp := client.Response{
+		Action: req.Action(),
+		Data:   resp.Record.Data,
+		Node:   s.nodeOf(&resp),
+		Meta:   s.metaOf(&resp),
+	}
+	wf.Write(rw, cresp, http.StatusOK)
+}
+
+// batchLoadHandler loads multiple records at once.
+func (s *Server) batchLoadHandler(rw http.ResponseWriter, r *http.Request) {
+	_, wf, err := httputil.Format(rw, r)
+	if err != nil {
+		return
+	}
+
+	var opts client.LoadManyOptions
+	if err := s.readReq(rw, r, &opts); err != nil {
+		return
+	}
+
+	if err := s.validKeys(opts.Keys); err != nil {
+		log.ErrorLogf("server/BATCH_LOAD_HANDLER", "%s", err)
+		wf.Write(rw, client.Error{err.Error()}, http.StatusBadRequest)
+		return
+	}
+
+	req := &store.RequestBatchLoad{ID: uuid.New(), Keys: opts.Keys}
+	s.serveBatch(rw, r, wf, opts.Keys, req)
+}
+
+// batchStoreHandler persists multiple records at once.
+func (s *Server) batchStoreHandler(rw http.ResponseWriter, r *http.Request) {
+	_, wf, err := httputil.Format(rw, r)
+	if err != nil {
+		return
+	}
+
+	var opts client.StoreManyOptions
+	if err := s.readReq(rw, r, &opts); err != nil {
+		return
+	}
+
+	keys := make([]string, len(opts.Records))
+	req := &store.RequestBatchStore{
+		ID:      uuid.New(),
+		Records: make([]store.RequestStore, len(opts.Records)),
+	}
+
+	for i, rec := range opts.Records {
+		keys[i] = rec.Key
+		req.Records[i] = store.RequestStore{
+			Key: rec.Key, Data: rec.Data,
+			ExpireTime: time.Duration(rec.ExpireTime),
+			Sliding:    rec.Sliding,
+		}
+	}
+
+	if err := s.validKeys(keys); err != nil {
+		log.ErrorLogf("server/BATCH_STORE_HANDLER", "%s", err)
+		wf.Write(rw, client.Error{err.Error()}, http.StatusBadRequest)
+		return
+	}
+
+	s.serveBatch(rw, r, wf, keys, req)
+}
+
+// validKeys returns an error, when the list of keys of the batch
+// request contains an empty key.
+func (s *Server) validKeys(keys []string) error {
+	for i, key := range keys {
+		if key == "" {
+			return fmt.Errorf("empty key at position %d", i)
+		}
+	}
+	return nil
+}
+
+// serveBatch processes the batch request and writes the result of each
+// key into the response.
+func (s *Server) serveBatch(rw http.ResponseWriter, r *http.Request,
+	wf httputil.WriteFormatter, keys []string, req store.BatchRequest) {
+
+	resp := s.server.Do(s.contextOf(r), req)
+	if resp.Err() != nil {
+		const text = "unable to process batch, %s"
+		body := client.Error{fmt.Sprintf(text, resp.Err())}
+
+		log.ErrorLogf("server/SERVE_BATCH", "%s failed, %s", req, resp.Err())
+		wf.Write(rw, body, resp.Status)
+		return
+	}
+
+	body := client.BatchResponse{
+		Results: make([]client.BatchResult, len(keys)),
+	}
+	for i, key := range keys {
+		result := client.BatchResult{Key: key}
+
+		var res *server.Response
+		if i < len(resp.Responses) {
+			res = resp.Responses[i]
+		}
+
+		switch {
+		case res == nil:
+			result.Status = http.StatusInternalServerError
+			result.Error = "missing response"
+		case res.Err() != nil:
+			result.Status = res.Status
+			result.Error = res.Error
+		default:
+			node, meta := s.nodeOf(res), s.metaOf(res)
+			result.Status = http.StatusOK
+			result.Data = res.Record.Data
+			result.Node, result.Meta = &node, &meta
+		}
+		body.Results[i] = result
+	}
+	wf.Write(rw, body, http.StatusOK)
+}
+
+// requestOf creates a store request from the request of transaction.
+func (s *Server) requestOf(id string,
+	r *client.TransactionRequest) (store.Request, error) {
+
+	if r.Key == "" {
+		return nil, fmt.Errorf("empty key of %s request", r.Action)
+	}
+
+	switch r.Action {
+	case store.ActionLoad:
+		return &store.RequestLoad{ID: id, Key: r.Key}, nil
+	case store.ActionStore:
+		return &store.RequestStore{
+			ID:  id,
+			Key: r.Key, Data: r.Data,
+			ExpireTime: time.Duration(r.ExpireTime),
+			Sliding:    r.Sliding,
+		}, nil
+	case store.ActionDelete:
+		return &store.RequestDelete{ID: id, Key: r.Key}, nil
+	case store.ActionIncr:
+		if r.Delta != 0 {
+			return &store.RequestAdd{ID: id, Key: r.Key, Delta: r.Delta}, nil
+		}
+		return &store.RequestIncr{ID: id, Key: r.Key}, nil
+	case store.ActionDecr:
+		if r.Delta != 0 {
+			return &store.RequestAdd{ID: id, Key: r.Key, Delta: -r.Delta}, nil
+		}
+		return &store.RequestDecr{ID: id, Key: r.Key}, nil
+	}
+	return nil, fmt.Errorf("invalid action %s", r.Action)
+}
+
+// transactionHandler applies a list of requests atomically.
+func (s *Server) transactionHandler(rw http.ResponseWriter, r *http.Request) {
+	_, wf, err := httputil.Format(rw, r)
+	if err != nil {
+		return
+	}
+
+	var opts client.TransactionOptions
+	if err := s.readReq(rw, r, &opts); err != nil {
+		return
+	}
+
+	req := &store.RequestTransaction{ID: uuid.New()}
+	for i := range opts.Requests {
+		sreq, err := s.requestOf(req.ID, &opts.Requests[i])
+		if err != nil {
+			log.ErrorLogf("server/TRANSACTION_HANDLER", "%s", err)
+			wf.Write(rw, client.Error{err.Error()}, http.StatusBadRequest)
+			return
+		}
+		req.Requests = append(req.Requests, sreq)
+	}
+	for _, pre := range opts.Preconditions {
+		req.Preconditions = append(req.Preconditions, store.Precondition{
+			Key: pre.Key, Index: pre.Index})
+	}
+
+	resp := s.server.Do(s.contextOf(r), req)
+	if resp.Err() != nil {
+		const text = "unable to apply transaction, %s"
+		body := client.Error{fmt.Sprintf(text, resp.Err())}
+
+		log.ErrorLogf("server/TRANSACTION_HANDLER",
+			"%s failed, %s", req, resp.Err())
+		wf.Write(rw, body, resp.Status)
+		return
+	}
+
+	body := client.TransactionResponse{
+		Results: make([]client.Response, 0, len(resp.Responses)),
+	}
+	for i, r := range resp.Responses {
+		if r == nil || i >= len(req.Requests) {
+			continue
+		}
+		body.Results = append(body.Results, client.Response{
+			Action: req.Requests[i].Action(),
+			Data:   r.Record.Data,
+			Node:   s.nodeOf(r),
+			Meta:   s.metaOf(r),
+		})
+	}
+	wf.Write(rw, body, http.StatusOK)
+}
+
+// readOpts reads the parameters of the request, when the request body
+// is not empty. It is used for actions with optional parameters.
+func (s *Server) readOpts(rw http.ResponseWriter, r *http.Request,
+	val interface{}) error {
+
+	if r.ContentLength == 0 {
+		return nil
+	}
+	return s.readReq(rw, r, val)
+}
+
+// incrHandler increments a numeric value stored under the given key.
+// Missing key is created with a zero value before increment.
+func (s *Server) incrHandler(rw http.ResponseWriter, r *http.Request) {
+	key := httputil.Param(r, "key")
+	_, wf, err := httputil.Format(rw, r)
+	if err != nil {
+		return
+	}
+
+	var opts client.CounterOptions
+	if err := s.readOpts(rw, r, &opts); err != nil {
+		return
+	}
+
+	// Increment by one, unless the delta is specified explicitly.
+	var req store.Request = &store.RequestIncr{ID: uuid.New(), Key: key}
+	if opts.Delta != 0 {
+		req = &store.RequestAdd{ID: uuid.New(), Key: key, Delta: opts.Delta}
+	}
+
+	text := fmt.Sprintf("unable to increment %s key", key)
+	s.serveReq(rw, r, wf, "server/INCR_HANDLER", text, req)
+}
+
+// decrHandler decrements a numeric value stored under the given key.
+// Missing key is created with a zero value before decrement.
+func (s *Server) decrHandler(rw http.ResponseWriter, r *http.Request) {
+	key := httputil.Param(r, "key")
+	_, wf, err := httputil.Format(rw, r)
+	if err != nil {
+		return
+	}
+
+	var opts client.CounterOptions
+	if err := s.readOpts(rw, r, &opts); err != nil {
+		return
+	}
+
+	// Decrement by one, unless the delta is specified explicitly.
+	var req store.Request = &store.RequestDecr{ID: uuid.New(), Key: key}
+	if opts.Delta != 0 {
+		req = &store.RequestAdd{ID: uuid.New(), Key: key, Delta: -opts.Delta}
+	}
+
+	text := fmt.Sprintf("unable to decrement %s key", key)
+	s.serveReq(rw, r, wf, "server/DECR_HANDLER", text, req)
+}
+
+// expireHandler sets a new time to live of the record stored under the
+// given key without changing its data.
+func (s *Server) expireHandler(rw http.ResponseWriter, r *http.Request) {
+	key := httputil.Param(r, "key")
+	_, wf, err := httputil.Format(rw, r)
+	if err != nil {
+		return
+	}
+
+	var opts client.ExpireOptions
+	if err := s.readReq(rw, r, &opts); err != nil {
+		return
+	}
+
+	req := &store.RequestExpire{
+		ID: uuid.New(), Key: key,
+		ExpireTime: time.Duration(opts.ExpireTime),
+	}
+
+	text := fmt.Sprintf("unable to expire %s key", key)
+	s.serveReq(rw, r, wf, "server/EXPIRE_HANDLER", text, req)
+}
+
+// persistHandler removes the expiration time of the record stored under
+// the given key.
+func (s *Server) persistHandler(rw http.ResponseWriter, r *http.Request) {
+	key := httputil.Param(r, "key")
+	_, wf, err := httputil.Format(rw, r)
+	if err != nil {
+		return
+	}
+
+	req := &store.RequestPersist{ID: uuid.New(), Key: key}
+	text := fmt.Sprintf("unable to persist %s key", key)
+	s.serveReq(rw, r, wf, "server/PERSIST_HANDLER", text, req)
+}
+
+// ttlHandler returns the remaining lifetime of the record stored under
+// the given key.
+func (s *Server) ttlHandler(rw http.ResponseWriter, r *http.Request) {
+	key := httputil.Param(r, "key")
+	_, wf, err := httputil.Format(rw, r)
+	if err != nil {
+		return
+	}
+
+	req := &store.RequestTTL{ID: uuid.New(), Key: key}
+	resp := s.server.Do(s.contextOf(r), req)
+	if resp.Err() != nil {
+		const text = "unable to retrieve ttl of %s key, %s"
+		body := client.Error{fmt.Sprintf(text, key, resp.Err())}
+
+		log.ErrorLogf("server/TTL_HANDLER", "%s failed, %s", req, resp.Err())
+		wf.Write(rw, body, resp.Status)
+		return
+	}
+
+	cresp := client.Response{
+		Action: req.Action(),
+		Data:   durationOf(resp.Record.Data),
+		Node:   s.nodeOf(&resp),
+		Meta:   s.metaOf(&resp),
+	}
+	wf.Write(rw, cresp, http.StatusOK)
+}
+
+// durationOf converts the duration to the client format. The duration
+// returned by a remote node is decoded from JSON as a number.
+func durationOf(v interface{}) client.Duration {
+	switch d := v.(type) {
+	case time.Duration:
+		return client.Duration(d)
+	case float64:
+		return client.Duration(d)
+	}
+	return client.Duration(0)
+}
+
+// setItemHandler sets an item of the dictionary stored at a specified
+// key. Missing dictionary is created.
+func (s *Server) setItemHandler(rw http.ResponseWriter, r *http.Request) {
+	key := httputil.Param(r, "key")
+	_, wf, err := httputil.Format(rw, r)
+	if err != nil {
+		return
+	}
+
+	var opts client.DictSetItemOptions
+	if err := s.readReq(rw, r, &opts); err != nil {
+		return
+	}
+
+	req := &store.RequestDictSetItem{
+		ID: uuid.New(), Key: key, Item: opts.Item, Data: opts.Data,
+	}
+	s.serveReq(rw, r, wf, "server/SET_ITEM_HANDLER",
+		"unable to set value", req)
+}
+
+// deleteItemHandler deletes an item of the dictionary stored at a
+// specified key and returns a removed value.
+func (s *Server) deleteItemHandler(rw http.ResponseWriter, r *http.Request) {
+	key := httputil.Param(r, "key")
+	_, wf, err := httputil.Format(rw, r)
+	if err != nil {
+		return
+	}
+
+	var opts client.DictDeleteItemOptions
+	if err := s.readReq(rw, r, &opts); err != nil {
+		return
+	}
+
+	req := &store.RequestDictDeleteItem{
+		ID: uuid.New(), Key: key, Item: opts.Item,
+	}
+	s.serveReq(rw, r, wf, "server/DELETE_ITEM_HANDLER",
+		"unable to delete value", req)
+}
+
+// itemsHandler returns multiple items of the dictionary stored at a
+// specified key. Missing items are omitted from the response.
+func (s *Server) itemsHandler(rw http.ResponseWriter, r *http.Request) {
+	key := httputil.Param(r, "key")
+	_, wf, err := httputil.Format(rw, r)
+	if err != nil {
+		return
+	}
+
+	var opts client.DictItemsOptions
+	if err := s.readReq(rw, r, &opts); err != nil {
+		return
+	}
+
+	req := &store.RequestDictItems{
+		ID: uuid.New(), Key: key, Items: opts.Items,
+	}
+	s.serveReq(rw, r, wf, "server/ITEMS_HANDLER",
+		"unable to load value", req)
+}
+
+// fieldsHandler returns a list of keys of the dictionary stored at a
+// specified key.
+func (s *Server) fieldsHandler(rw http.ResponseWriter, r *http.Request) {
+	key := httputil.Param(r, "key")
+	_, wf, err := httputil.Format(rw, r)
+	if err != nil {
+		return
+	}
+
+	req := &store.RequestDictFields{ID: uuid.New(), Key: key}
+	s.serveReq(rw, r, wf, "server/FIELDS_HANDLER",
+		"unable to load value", req)
+}
+
+// mergeHandler merges a partial dictionary into the dictionary stored
+// at a specified key. Missing dictionary is created.
+func (s *Server) mergeHandler(rw http.ResponseWriter, r *http.Request) {
+	key := httputil.Param(r, "key")
+	_, wf, err := httputil.Format(rw, r)
+	if err != nil {
+		return
+	}
+
+	var opts client.DictMergeOptions
+	if err := s.readReq(rw, r, &opts); err != nil {
+		return
+	}
+
+	req := &store.RequestDictMerge{ID: uuid.New(), Key: key, Data: opts.Data}
+	text := fmt.Sprintf("unable to merge %s key", key)
+	s.serveReq(rw, r, wf, "server/MERGE_HANDLER", text, req)
+}
+
+// listRangeHandler returns elements of the list in the requested range
+// of positions. The stop position is inclusive.
+func (s *Server) listRangeHandler(rw http.ResponseWriter, r *http.Request) {
+	key := httputil.Param(r, "key")
+	_, wf, err := httputil.Format(rw, r)
+	if err != nil {
+		return
+	}
+
+	var opts client.ListRangeOptions
+	if err := s.readReq(rw, r, &opts); err != nil {
+		return
+	}
+
+	req := &store.RequestListRange{
+		ID: uuid.New(), Key: key, Start: opts.Start, Stop: opts.Stop,
+	}
+	s.serveReq(rw, r, wf, "server/LIST_RANGE_HANDLER",
+		"unable to load value", req)
+}
+
+// listPushHandler inserts an element to the beginning or the end of
+// the list. Missing list is created.
+func (s *Server) listPushHandler(rw http.ResponseWriter, r *http.Request) {
+	key := httputil.Param(r, "key")
+	_, wf, err := httputil.Format(rw, r)
+	if err != nil {
+		return
+	}
+
+	var opts client.ListPushOptions
+	if err := s.readReq(rw, r, &opts); err != nil {
+		return
+	}
+
+	req := &store.RequestListPush{
+		ID: uuid.New(), Key: key, Data: opts.Data, Front: opts.Front,
+	}
+	s.serveReq(rw, r, wf, "server/LIST_PUSH_HANDLER",
+		"unable to push value", req)
+}
+
+// listPopHandler extracts an element from the beginning or the end of
+// the list. Method returns an error, when the list is empty.
+func (s *Server) listPopHandler(rw http.ResponseWriter, r *http.Request) {
+	key := httputil.Param(r, "key")
+	_, wf, err := httputil.Format(rw, r)
+	if err != nil {
+		return
+	}
+
+	var opts client.ListPopOptions
+	if err := s.readOpts(rw, r, &opts); err != nil {
+		return
+	}
+
+	req := &store.RequestListPop{
+		ID: uuid.New(), Key: key, Front: opts.Front,
+	}
+	s.serveReq(rw, r, wf, "server/LIST_POP_HANDLER",
+		"unable to pop value", req)
+}
+
+// listTrimHandler trims the list, so it contains only elements in the
+// requested range of positions.
+func (s *Server) listTrimHandler(rw http.ResponseWriter, r *http.Request) {
+	key := httputil.Param(r, "key")
+	_, wf, err := httputil.Format(rw, r)
+	if err != nil {
+		return
+	}
+
+	var opts client.ListTrimOptions
+	if err := s.readReq(rw, r, &opts); err != nil {
+		return
+	}
+
+	req := &store.RequestListTrim{
+		ID: uuid.New(), Key: key, Start: opts.Start, Stop: opts.Stop,
+	}
+	s.serveReq(rw, r, wf, "server/LIST_TRIM_HANDLER",
+		"unable to trim value", req)
+}
+
+// listSetHandler replaces an element of the list at the given position.
+func (s *Server) listSetHandler(rw http.ResponseWriter, r *http.Request) {
+	key := httputil.Param(r, "key")
+	_, wf, err := httputil.Format(rw, r)
+	if err != nil {
+		return
+	}
+
+	var opts client.ListSetOptions
+	if err := s.readReq(rw, r, &opts); err != nil {
+		return
+	}
+
+	req := &store.RequestListSet{
+		ID: uuid.New(), Key: key, Index: opts.Index, Data: opts.Data,
+	}
+	s.serveReq(rw, r, wf, "server/LIST_SET_HANDLER",
+		"unable to set value", req)
+}
+
+// listRemoveHandler removes an element of the list at the given
+// position and returns it back to the client.
+func (s *Server) listRemoveHandler(rw http.ResponseWriter, r *http.Request) {
+	key := httputil.Param(r, "key")
+	_, wf, err := httputil.Format(rw, r)
+	if err != nil {
+		return
+	}
+
+	var opts client.ListRemoveOptions
+	if err := s.readReq(rw, r, &opts); err != nil {
+		return
+	}
+
+	req := &store.RequestListRemove{
+		ID: uuid.New(), Key: key, Index: opts.Index,
+	}
+	s.serveReq(rw, r, wf, "server/LIST_REMOVE_HANDLER",
+		"unable to remove value", req)
+}
+
+// eventsKeepAlive is an interval between the comments sent to the
+// subscribers of the events, so the idle connections are not closed by
+// the proxies.
+const eventsKeepAlive = 15 * time.Second
+
+// eventTypes is a list of supported types of the events.
+var eventTypes = []string{
+	store.EventStore, store.EventDelete, store.EventExpire, store.EventEvict,
+}
+
+// eventsHandler streams the changes of the records in a cluster as the
+// server-sent events. The events could be filtered by the key prefix and
+// type of the change.
+func (s *Server) eventsHandler(rw http.ResponseWriter, r *http.Request) {
+	// Errors are always returned in JSON format, since the client
+	// accepts only the stream of events.
+	wf := &httputil.JSONFormatter{}
+
+	flusher, ok := rw.(http.Flusher)
+	if !ok {
+		const text = "streaming is not supported"
+		log.ErrorLogf("server/EVENTS_HANDLER", text)
+		wf.Write(rw, client.Error{text}, http.StatusInternalServerError)
+		return
+	}
+
+	types := make(map[string]bool)
+	if param := httputil.Param(r, "types"); param != "" {
+		for _, typ := range strings.Split(param, ",") {
+			types[typ] = true
+		}
+	}
+	for typ := range types {
+		if !s.validEventType(typ) {
+			const text = "invalid event type %s"
+			log.ErrorLogf("server/EVENTS_HANDLER", text, typ)
+
+			body := client.Error{fmt.Sprintf(text, typ)}
+			wf.Write(rw, body, http.StatusBadRequest)
+			return
+		}
+	}
+
+	header := rw.Header()
+	header.Set(httputil.HeaderContentType, httputil.TypeTextEventStream)
+	header.Set(httputil.HeaderCacheControl, "no-cache")
+	rw.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	ticker := time.NewTicker(eventsKeepAlive)
+	defer ticker.Stop()
+
+	events := s.server.Events(r.Context(), httputil.Param(r, "prefix"))
+	for {
+		select {
+		case resp, ok := <-events:
+			if !ok {
+				return
+			}
+			if len(types) != 0 && !types[resp.Event] {
+				continue
+			}
+
+			b, err := json.Marshal(client.Event{
+				Type: resp.Event,
+				Key:  resp.Key,
+				Data: resp.Record.Data,
+				Node: s.nodeOf(resp),
+				Meta: s.metaOf(resp),
+			})
+			if err != nil {
+				log.ErrorLogf("server/EVENTS_HANDLER",
+					"failed to encode event of %s, %s", resp.Key, err)
+				continue
+			}
+			fmt.Fprintf(rw, "event: %s\ndata: %s\n\n", resp.Event, b)
+		case <-ticker.C:
+			fmt.Fprint(rw, ": keep-alive\n\n")
+		}
+		flusher.Flush()
+	}
+}
+
+// validEventType reports whether the type of event is supported.
+func (s *Server) validEventType(typ string) bool {
+	for _, t := range eventTypes {
+		if t == typ {
+			return true
+		}
+	}
+	return false
+}
+
+// publishHandler sends the message to the subscribers of the channel on
+// all nodes in a cluster, the nodes failed to deliver the message are
+// listed separately.
+func (s *Server) publishHandler(rw http.ResponseWriter, r *http.Request) {
+	_, wf, err := httputil.Format(rw, r)
+	if err != nil {
+		return
+	}
+
+	var opts client.PublishOptions
+	if err := s.readReq(rw, r, &opts); err != nil {
+		return
+	}
+
+	name := httputil.Param(r, "name")
+	resp := s.server.Publish(s.ctx, name, opts.Data)
+	body := client.PublishResponse{
+		Receivers: resp.Receivers,
+		Errors:    make([]client.NodeError, 0, len(resp.Errors)),
+	}
+
+	for _, err := range resp.Errors {
+		body.Errors = append(body.Errors, client.NodeError{
+			Addr: err.Addr, Text: err.Error})
+	}
+	wf.Write(rw, body, http.StatusOK)
+}
+
+// subscribeHandler streams the messages published to the channel as the
+// server-sent events.
+func (s *Server) subscribeHandler(rw http.ResponseWriter, r *http.Request) {
+	// Errors are always returned in JSON format, since the client
+	// accepts only the stream of messages.
+	wf := &httputil.JSONFormatter{}
+
+	flusher, ok := rw.(http.Flusher)
+	if !ok {
+		const text = "streaming is not supported"
+		log.ErrorLogf("server/SUBSCRIBE_HANDLER", text)
+		wf.Write(rw, client.Error{text}, http.StatusInternalServerError)
+		return
+	}
+
+	header := rw.Header()
+	header.Set(httputil.HeaderContentType, httputil.TypeTextEventStream)
+	header.Set(httputil.HeaderCacheControl, "no-cache")
+	rw.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	ticker := time.NewTicker(eventsKeepAlive)
+	defer ticker.Stop()
+
+	name := httputil.Param(r, "name")
+	messages := s.server.Subscribe(r.Context(), name)
+	for {
+		select {
+		case msg, ok := <-messages:
+			if !ok {
+				return
+			}
+
+			b, err := json.Marshal(client.Message{
+				Channel: msg.Channel,
+				Data:    msg.Data,
+			})
+			if err != nil {
+				log.ErrorLogf("server/SUBSCRIBE_HANDLER",
+					"failed to encode message of %s, %s", name, err)
+				continue
+			}
+			fmt.Fprintf(rw, "event: message\ndata: %s\n\n", b)
+		case <-ticker.C:
+			fmt.Fprint(rw, ": keep-alive\n\n")
+		}
+		flusher.Flush()
+	}
+}
+
 // nodesHandler returns a list of nodes in a cluster, so the clients
 // can easily communicate with each one.
 func (s *Server) nodesHandler(rw http.ResponseWriter, r *http.Request) {
+	_, wf, err := httputil.Format(rw, r)
+	if err != nil {
+		return
+	}
+	s.writeNodes(rw, wf)
+}
+
+// writeNodes writes a list of nodes in a cluster.
+func (s *Server) writeNodes(rw http.ResponseWriter, wf httputil.WriteFormatter) {
 	var nodes []*client.Node
 	for _, node := range s.server.Nodes() {
 		nodes = append(nodes, &client.Node{
-			ID:   node.ID,
-			Addr: node.Addr.String(),
+			ID:        node.ID,
+			Addr:      node.Addr.String(),
+			Primaries: node.Primaries,
+			Replicas:  node.Replicas,
+			State:     node.State,
 		})
 	}
+	wf.Write(rw, nodes, http.StatusOK)
+}
 
+// joinHandler adds a node to the cluster, it returns an updated list
+// of nodes in a cluster.
+func (s *Server) joinHandler(rw http.ResponseWriter, r *http.Request) {
 	_, wf, err := httputil.Format(rw, r)
 	if err != nil {
 		return
 	}
-	wf.Write(rw, nodes, http.StatusOK)
+
+	var opts client.JoinOptions
+	if err := s.readReq(rw, r, &opts); err != nil {
+		return
+	}
+
+	addr, err := net.ResolveTCPAddr("tcp", opts.Addr)
+	if err != nil {
+		const text = "invalid address %s, %s"
+		log.ErrorLogf("server/JOIN_HANDLER", text, opts.Addr, err)
+
+		body := client.Error{fmt.Sprintf(text, opts.Addr, err)}
+		wf.Write(rw, body, http.StatusBadRequest)
+		return
+	}
+
+	resp := s.server.Join(s.ctx, addr)
+	if resp.Err() != nil {
+		const text = "unable to join %s node, %s"
+		body := client.Error{fmt.Sprintf(text, addr, resp.Err())}
+
+		log.ErrorLogf("server/JOIN_HANDLER",
+			"join of %s failed, %s", addr, resp.Err())
+		wf.Write(rw, body, resp.Status)
+		return
+	}
+	s.writeNodes(rw, wf)
+}
+
+// leaveHandler removes a node from the cluster, it returns an updated
+// list of nodes in a cluster.
+func (s *Server) leaveHandler(rw http.ResponseWriter, r *http.Request) {
+	_, wf, err := httputil.Format(rw, r)
+	if err != nil {
+		return
+	}
+
+	param := httputil.Param(r, "addr")
+	addr, err := net.ResolveTCPAddr("tcp", param)
+	if err != nil {
+		const text = "invalid address %s, %s"
+		log.ErrorLogf("server/LEAVE_HANDLER", text, param, err)
+
+		body := client.Error{fmt.Sprintf(text, param, err)}
+		wf.Write(rw, body, http.StatusBadRequest)
+		return
+	}
+
+	resp := s.server.Leave(s.ctx, addr)
+	if resp.Err() != nil {
+		const text = "unable to leave %s node, %s"
+		body := client.Error{fmt.Sprintf(text, addr, resp.Err())}
+
+		log.ErrorLogf("server/LEAVE_HANDLER",
+			"leave of %s failed, %s", addr, resp.Err())
+		wf.Write(rw, body, resp.Status)
+		return
+	}
+	s.writeNodes(rw, wf)
+}
+
+// divergentHandler returns a list of partitions, which copies differ
+// from the primary copy.
+func (s *Server) divergentHandler(rw http.ResponseWriter, r *http.Request) {
+	_, wf, err := httputil.Format(rw, r)
+	if err != nil {
+		return
+	}
+
+	resp := s.server.Divergent(s.ctx)
+	body := client.PartitionsResponse{
+		Partitions: make([]client.Partition, 0, len(resp.Partitions)),
+		Errors:     make([]client.NodeError, 0, len(resp.Errors)),
+	}
+
+	for _, p := range resp.Partitions {
+		body.Partitions = append(body.Partitions, client.Partition{
+			Index: p.Index, Nodes: p.Nodes, Divergent: p.Divergent})
+	}
+	for _, err := range resp.Errors {
+		body.Errors = append(body.Errors, client.NodeError{
+			Addr: err.Addr, Text: err.Error})
+	}
+	wf.Write(rw, body, http.StatusOK)
 }
 
 // ListenAndServe starts an HTTP server at the configured endpoint.
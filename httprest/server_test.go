@@ -14,13 +14,30 @@ import (
 
 	"github.com/ybubnov/memhashd/client"
 	"github.com/ybubnov/memhashd/container/hash"
+	"github.com/ybubnov/memhashd/container/pubsub"
 	"github.com/ybubnov/memhashd/container/store"
+	"github.com/ybubnov/memhashd/httprest/httputil"
 	"github.com/ybubnov/memhashd/server"
 )
 
 type stubServer struct {
 	Request  store.Request
 	Response server.Response
+	// KeysResponse is returned on cluster-wide keys request.
+	KeysResponse server.KeysResponse
+	// Stream is a list of changes returned on the events request.
+	Stream []*server.Response
+	Prefix string
+	// Messages is a list of messages returned on the subscribe request.
+	Messages []*pubsub.Message
+	Channel  string
+	Data     interface{}
+	// PublishResponse is returned on publish request.
+	PublishResponse server.PublishResponse
+	// PartitionsResponse is returned on divergent partitions request.
+	PartitionsResponse server.PartitionsResponse
+	// Addr is an address of the joining or leaving node.
+	Addr *net.TCPAddr
 }
 
 func (s *stubServer) ID() string   { return "" }
@@ -30,7 +47,56 @@ func (s *stubServer) Stop() error  { return nil }
 func (s *stubServer) Nodes() server.Nodes {
 	return server.Nodes{{Addr: &net.TCPAddr{
 		IP: net.ParseIP("127.0.0.1"), Port: 2371,
-	}}}
+	}, Primaries: 4, Replicas: 2, State: server.NodeAlive}}
+}
+
+func (s *stubServer) Keys(context.Context) server.KeysResponse {
+	return s.KeysResponse
+}
+
+func (s *stubServer) Events(_ context.Context,
+	prefix string) <-chan *server.Response {
+
+	s.Prefix = prefix
+	events := make(chan *server.Response, len(s.Stream))
+	for _, ev := range s.Stream {
+		events <- ev
+	}
+	close(events)
+	return events
+}
+
+func (s *stubServer) Publish(_ context.Context, channel string,
+	data interface{}) server.PublishResponse {
+
+	s.Channel, s.Data = channel, data
+	return s.PublishResponse
+}
+
+func (s *stubServer) Subscribe(_ context.Context,
+	channel string) <-chan *pubsub.Message {
+
+	s.Channel = channel
+	messages := make(chan *pubsub.Message, len(s.Messages))
+	for _, msg := range s.Messages {
+		messages <- msg
+	}
+	close(messages)
+	return messages
+}
+
+func (s *stubServer) Join(_ context.Context, addr *net.TCPAddr) server.Response {
+	s.Addr = addr
+	return s.Response
+}
+
+func (s *stubServer) Leave(_ context.Context, addr *net.TCPAddr) server.Response {
+	s.Addr = addr
+	return s.Response
+}
+
+func (s *stubServer) Divergent(context.Context) server.PartitionsResponse {
+	return s.PartitionsResponse
 }
 
 func (s *stubServer) Do(_ context.Context, req store.Request) server.Response {
@@ -103,6 +169,184 @@ func TestKeysHandler(t *testing.T) {
 	assertError(t, rw, http.StatusInternalServerError, body)
 }
 
+func TestKeysHandlerCluster(t *testing.T) {
+	stub := &stubServer{KeysResponse: server.KeysResponse{
+		Keys:   []string{"1", "2"},
+		Errors: []server.NodeError{{Addr: "127.0.0.1:2372", Error: "boom"}},
+	}}
+	s := NewServer(&Config{Server: stub})
+
+	rw := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/v1/keys?scope=cluster", nil)
+
+	s.keysHandler(rw, req)
+	var res client.ClusterKeysResponse
+
+	json.Unmarshal(rw.Body.Bytes(), &res)
+	if !reflect.DeepEqual(res.Keys, []string{"1", "2"}) {
+		t.Fatalf("invalid keys are returned: %v", res.Keys)
+	}
+	errs := []client.NodeError{{Addr: "127.0.0.1:2372", Text: "boom"}}
+	if !reflect.DeepEqual(res.Errors, errs) {
+		t.Fatalf("invalid errors are returned: %v", res.Errors)
+	}
+
+	rw = httptest.NewRecorder()
+	req = httptest.NewRequest("GET", "/v1/keys?scope=world", nil)
+	s.keysHandler(rw, req)
+
+	body := "{\"text\":\"invalid scope world\"}"
+	assertError(t, rw, http.StatusBadRequest, body)
+}
+
+func TestKeysHandlerScan(t *testing.T) {
+	result := store.ScanResult{Cursor: "0:b", Keys: []string{"a", "b"}}
+	resp := server.Response{Record: hash.Record{Data: result}}
+
+	stub := &stubServer{Response: resp}
+	s := NewServer(&Config{Server: stub})
+
+	rw := httptest.NewRecorder()
+	req := httptest.NewRequest("GET",
+		"/v1/keys?cursor=0:&limit=2&match=*&prefix=a", nil)
+
+	s.keysHandler(rw, req)
+	var res client.ScanResponse
+
+	json.Unmarshal(rw.Body.Bytes(), &res)
+	if res.Cursor != result.Cursor || !reflect.DeepEqual(res.Keys, result.Keys) {
+		t.Fatalf("invalid page of keys returned: %v", res)
+	}
+
+	scan, ok := stub.Request.(*store.RequestScan)
+	if !ok {
+		t.Fatalf("invalid request type: %T", stub.Request)
+	}
+	if scan.Cursor != "0:" || scan.Limit != 2 ||
+		scan.Match != "*" || scan.Prefix != "a" {
+		t.Fatalf("invalid scan request: %s", scan)
+	}
+
+	rw = httptest.NewRecorder()
+	req = httptest.NewRequest("GET", "/v1/keys?limit=x", nil)
+	s.keysHandler(rw, req)
+
+	body := "{\"text\":\"invalid limit x\"}"
+	assertError(t, rw, http.StatusBadRequest, body)
+
+	stub.Response = server.Response{
+		Error: "boom", Status: http.StatusConflict}
+	rw = httptest.NewRecorder()
+	req = httptest.NewRequest("GET", "/v1/keys?cursor=", nil)
+	s.keysHandler(rw, req)
+
+	body = "{\"text\":\"unable to scan keys, boom\"}"
+	assertError(t, rw, stub.Response.Status, body)
+}
+
+func TestBatchHandlers(t *testing.T) {
+	addr := &net.TCPAddr{IP: net.ParseIP("127.0.0.1"), Port: 2371}
+	res := server.Response{Responses: []*server.Response{
+		{Record: hash.Record{Data: 42}, Node: server.Node{Addr: addr}},
+		{Error: "missing", Status: http.StatusNotFound},
+	}}
+
+	stub := &stubServer{Response: res}
+	s := NewServer(&Config{Server: stub})
+
+	rw := httptest.NewRecorder()
+	rd := strings.NewReader(`{"keys": ["1", "2"]}`)
+	req := httptest.NewRequest("POST", "/v1/batch/load", rd)
+
+	s.batchLoadHandler(rw, req)
+	var resp client.BatchResponse
+	json.Unmarshal(rw.Body.Bytes(), &resp)
+
+	if len(resp.Results) != 2 {
+		t.Fatalf("invalid number of results: %d", len(resp.Results))
+	}
+	if r := resp.Results[0]; r.Key != "1" || r.Data.(float64) != 42 {
+		t.Fatalf("invalid result returned: %v", r)
+	}
+	if r := resp.Results[1]; r.Status != http.StatusNotFound {
+		t.Fatalf("invalid status of result: %d", r.Status)
+	}
+
+	rw = httptest.NewRecorder()
+	rd = strings.NewReader(`{"records": [
+		{"key": "1", "data": 42, "expire_time": "1s"}, {"key": "2"}]}`)
+	req = httptest.NewRequest("POST", "/v1/batch/store", rd)
+	s.batchStoreHandler(rw, req)
+
+	batch, ok := stub.Request.(*store.RequestBatchStore)
+	if !ok || len(batch.Records) != 2 {
+		t.Fatalf("invalid request type: %T", stub.Request)
+	}
+	if batch.Records[0].ExpireTime != time.Second {
+		t.Fatalf("invalid expiration time: %s", batch.Records[0].ExpireTime)
+	}
+
+	rw = httptest.NewRecorder()
+	rd = strings.NewReader(`{"keys": ["1", ""]}`)
+	req = httptest.NewRequest("POST", "/v1/batch/load", rd)
+	s.batchLoadHandler(rw, req)
+
+	body := "{\"text\":\"empty key at position 1\"}"
+	assertError(t, rw, http.StatusBadRequest, body)
+}
+
+func TestTransactionHandler(t *testing.T) {
+	addr := &net.TCPAddr{IP: net.ParseIP("127.0.0.1"), Port: 2371}
+	res := server.Response{Responses: []*server.Response{
+		{Record: hash.Record{Data: "a"}, Node: server.Node{Addr: addr}},
+		{Record: hash.Record{Data: int64(2)}, Node: server.Node{Addr: addr}},
+	}}
+
+	stub := &stubServer{Response: res}
+	s := NewServer(&Config{Server: stub})
+
+	rw := httptest.NewRecorder()
+	rd := strings.NewReader(`{"requests": [
+		{"action": "store", "key": "{u}:a", "data": "a"},
+		{"action": "incr", "key": "{u}:b", "delta": 2}],
+		"preconditions": [{"key": "{u}:a", "index": 1}]}`)
+	req := httptest.NewRequest("POST", "/v1/transaction", rd)
+
+	s.transactionHandler(rw, req)
+	var resp client.TransactionResponse
+	json.Unmarshal(rw.Body.Bytes(), &resp)
+
+	if len(resp.Results) != 2 || resp.Results[0].Action != store.ActionStore {
+		t.Fatalf("invalid results returned: %v", resp.Results)
+	}
+
+	tx, ok := stub.Request.(*store.RequestTransaction)
+	if !ok || len(tx.Requests) != 2 || len(tx.Preconditions) != 1 {
+		t.Fatalf("invalid request: %v", stub.Request)
+	}
+	if add, ok := tx.Requests[1].(*store.RequestAdd); !ok || add.Delta != 2 {
+		t.Fatalf("invalid request of transaction: %v", tx.Requests[1])
+	}
+
+	rw = httptest.NewRecorder()
+	rd = strings.NewReader(`{"requests": [{"action": "scan", "key": "a"}]}`)
+	req = httptest.NewRequest("POST", "/v1/transaction", rd)
+	s.transactionHandler(rw, req)
+
+	body := "{\"text\":\"invalid action scan\"}"
+	assertError(t, rw, http.StatusBadRequest, body)
+
+	stub.Response = server.Response{
+		Error: "conflict", Status: http.StatusConflict}
+	rw = httptest.NewRecorder()
+	rd = strings.NewReader(`{"requests": [{"action": "load", "key": "a"}]}`)
+	req = httptest.NewRequest("POST", "/v1/transaction", rd)
+	s.transactionHandler(rw, req)
+
+	body = "{\"text\":\"unable to apply transaction, conflict\"}"
+	assertError(t, rw, http.StatusConflict, body)
+}
+
 func TestLoadHandler(t *testing.T) {
 	res := server.Response{Record: hash.Record{Data: 42}}
 	stub := &stubServer{Response: res}
@@ -127,6 +371,279 @@ func TestLoadHandler(t *testing.T) {
 	assertError(t, rw, stub.Response.Status, body)
 }
 
+func TestConsistencyFilter(t *testing.T) {
+	s := NewServer(&Config{Server: &stubServer{}})
+
+	rw := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/v1/keys/1", nil)
+	req.Header.Set(httputil.HeaderConsistencyLevel, "quorum")
+
+	s.consistencyFilter(rw, req)
+	if rw.Code != http.StatusOK || rw.Body.Len() != 0 {
+		t.Fatalf("valid level should be accepted: %d", rw.Code)
+	}
+
+	rw = httptest.NewRecorder()
+	req.Header.Set(httputil.HeaderConsistencyLevel, "TWO")
+	s.consistencyFilter(rw, req)
+
+	body := "{\"text\":\"invalid X-Consistency-Level header TWO\"}"
+	assertError(t, rw, http.StatusBadRequest, body)
+}
+
+func TestWatchHandler(t *testing.T) {
+	addr := &net.TCPAddr{IP: net.ParseIP("127.0.0.1"), Port: 2371}
+	res := server.Response{
+		Record: hash.Record{Data: 42, Meta: hash.Meta{Index: 4}},
+		Node:   server.Node{Addr: addr},
+		Event:  store.EventStore,
+	}
+	stub := &stubServer{Response: res}
+	s := NewServer(&Config{Server: stub})
+
+	rw := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/v1/keys?key=1&wait=true&index=3", nil)
+
+	s.loadHandler(rw, req)
+	resp := assertResponse(t, rw, store.EventStore)
+
+	if resp.Meta.Index != 4 {
+		t.Fatalf("invalid index returned: %d", resp.Meta.Index)
+	}
+	watch, ok := stub.Request.(*store.RequestWatch)
+	if !ok || watch.Key != "1" || watch.Index != 3 {
+		t.Fatalf("invalid request: %v", stub.Request)
+	}
+
+	rw = httptest.NewRecorder()
+	req = httptest.NewRequest("GET", "/v1/keys?key=1&wait=true&index=x", nil)
+	s.loadHandler(rw, req)
+
+	body := "{\"text\":\"invalid index x\"}"
+	assertError(t, rw, http.StatusBadRequest, body)
+}
+
+func TestEventsHandler(t *testing.T) {
+	addr := &net.TCPAddr{IP: net.ParseIP("127.0.0.1"), Port: 2371}
+	stub := &stubServer{Stream: []*server.Response{
+		{Key: "user:1", Event: store.EventStore, Node: server.Node{Addr: addr},
+			Record: hash.Record{Data: 42.0, Meta: hash.Meta{Index: 1}}},
+		{Key: "user:2", Event: store.EventEvict, Node: server.Node{Addr: addr}},
+		{Key: "user:1", Event: store.EventDelete, Node: server.Node{Addr: addr}},
+	}}
+	s := NewServer(&Config{Server: stub})
+
+	rw := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/v1/events?prefix=user:&types=store,delete", nil)
+
+	s.eventsHandler(rw, req)
+	if rw.Code != http.StatusOK {
+		t.Fatalf("wrong status code returned: %d", rw.Code)
+	}
+	if typ := rw.Header().Get("Content-Type"); typ != "text/event-stream" {
+		t.Fatalf("invalid content type returned: %s", typ)
+	}
+	if stub.Prefix != "user:" {
+		t.Fatalf("invalid prefix requested: %s", stub.Prefix)
+	}
+
+	var events []client.Event
+	for _, line := range strings.Split(rw.Body.String(), "\n") {
+		if !strings.HasPrefix(line, "data: ") {
+			continue
+		}
+		var ev client.Event
+		json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &ev)
+		events = append(events, ev)
+	}
+	if len(events) != 2 {
+		t.Fatalf("invalid number of events returned: %d", len(events))
+	}
+	if events[0].Type != store.EventStore || events[0].Data != 42.0 {
+		t.Fatalf("invalid first event returned: %v", events[0])
+	}
+	if events[1].Type != store.EventDelete || events[1].Key != "user:1" {
+		t.Fatalf("invalid second event returned: %v", events[1])
+	}
+
+	rw = httptest.NewRecorder()
+	req = httptest.NewRequest("GET", "/v1/events?types=update", nil)
+	s.eventsHandler(rw, req)
+
+	body := "{\"text\":\"invalid event type update\"}"
+	assertError(t, rw, http.StatusBadRequest, body)
+}
+
+func TestPublishHandler(t *testing.T) {
+	stub := &stubServer{PublishResponse: server.PublishResponse{
+		Receivers: 3,
+		Errors:    []server.NodeError{{Addr: "127.0.0.1:2372", Error: "EOF"}},
+	}}
+	s := NewServer(&Config{Server: stub})
+
+	rw := httptest.NewRecorder()
+	rd := strings.NewReader(`{"data": "a"}`)
+	req := httptest.NewRequest("POST", "/v1/channels?name=news", rd)
+
+	s.publishHandler(rw, req)
+	if rw.Code != http.StatusOK {
+		t.Fatalf("wrong status code returned: %d", rw.Code)
+	}
+	if stub.Channel != "news" || stub.Data != "a" {
+		t.Fatalf("invalid message published: %s %v", stub.Channel, stub.Data)
+	}
+
+	var resp client.PublishResponse
+	json.Unmarshal(rw.Body.Bytes(), &resp)
+
+	expected := client.PublishResponse{Receivers: 3, Errors: []client.NodeError{
+		{Addr: "127.0.0.1:2372", Text: "EOF"}}}
+	if !reflect.DeepEqual(resp, expected) {
+		t.Fatalf("invalid response returned: %v", resp)
+	}
+}
+
+func TestSubscribeHandler(t *testing.T) {
+	stub := &stubServer{Messages: []*pubsub.Message{
+		{Channel: "news", Data: "a"},
+		{Channel: "news", Data: 42.0},
+	}}
+	s := NewServer(&Config{Server: stub})
+
+	rw := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/v1/channels?name=news", nil)
+
+	s.subscribeHandler(rw, req)
+	if rw.Code != http.StatusOK {
+		t.Fatalf("wrong status code returned: %d", rw.Code)
+	}
+	if typ := rw.Header().Get("Content-Type"); typ != "text/event-stream" {
+		t.Fatalf("invalid content type returned: %s", typ)
+	}
+	if stub.Channel != "news" {
+		t.Fatalf("invalid channel requested: %s", stub.Channel)
+	}
+
+	var messages []client.Message
+	for _, line := range strings.Split(rw.Body.String(), "\n") {
+		if !strings.HasPrefix(line, "data: ") {
+			continue
+		}
+		var msg client.Message
+		json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &msg)
+		messages = append(messages, msg)
+	}
+
+	expected := []client.Message{
+		{Channel: "news", Data: "a"},
+		{Channel: "news", Data: 42.0},
+	}
+	if !reflect.DeepEqual(messages, expected) {
+		t.Fatalf("invalid messages returned: %v", messages)
+	}
+}
+
+func TestNodesHandler(t *testing.T) {
+	s := NewServer(&Config{Server: &stubServer{}})
+
+	rw := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/v1/nodes", nil)
+
+	s.nodesHandler(rw, req)
+	var nodes []client.Node
+	json.Unmarshal(rw.Body.Bytes(), &nodes)
+
+	expected := []client.Node{
+		{Addr: "127.0.0.1:2371", Primaries: 4, Replicas: 2, State: "alive"},
+	}
+	if !reflect.DeepEqual(nodes, expected) {
+		t.Fatalf("invalid list of nodes returned: %v", nodes)
+	}
+}
+
+func TestJoinHandler(t *testing.T) {
+	stub := &stubServer{}
+	s := NewServer(&Config{Server: stub})
+
+	rw := httptest.NewRecorder()
+	rd := strings.NewReader(`{"addr": "127.0.0.1:2372"}`)
+	req := httptest.NewRequest("POST", "/v1/nodes", rd)
+
+	s.joinHandler(rw, req)
+	if rw.Code != http.StatusOK || stub.Addr.String() != "127.0.0.1:2372" {
+		t.Fatalf("node should join: %d, %s", rw.Code, stub.Addr)
+	}
+
+	rw = httptest.NewRecorder()
+	rd = strings.NewReader(`{"addr": "127.0.0.1:2372"}`)
+	req = httptest.NewRequest("POST", "/v1/nodes", rd)
+
+	stub.Response = server.Response{
+		Error: "zap!", Status: http.StatusConflict}
+	s.joinHandler(rw, req)
+
+	body := "{\"text\":\"unable to join 127.0.0.1:2372 node, zap!\"}"
+	assertError(t, rw, http.StatusConflict, body)
+}
+
+func TestLeaveHandler(t *testing.T) {
+	stub := &stubServer{}
+	s := NewServer(&Config{Server: stub})
+
+	rw := httptest.NewRecorder()
+	req := httptest.NewRequest("DELETE", "/v1/nodes?addr=127.0.0.1:2372", nil)
+
+	s.leaveHandler(rw, req)
+	var nodes []client.Node
+	json.Unmarshal(rw.Body.Bytes(), &nodes)
+
+	if rw.Code != http.StatusOK || stub.Addr.String() != "127.0.0.1:2372" {
+		t.Fatalf("node should leave: %d, %s", rw.Code, stub.Addr)
+	}
+	if len(nodes) != 1 {
+		t.Fatalf("list of nodes expected: %v", nodes)
+	}
+
+	rw = httptest.NewRecorder()
+	req = httptest.NewRequest("DELETE", "/v1/nodes?addr=zap", nil)
+	s.leaveHandler(rw, req)
+
+	if rw.Code != http.StatusBadRequest {
+		t.Fatalf("invalid address should be rejected: %d", rw.Code)
+	}
+}
+
+func TestDivergentHandler(t *testing.T) {
+	stub := &stubServer{PartitionsResponse: server.PartitionsResponse{
+		Partitions: []server.Partition{{
+			Index:     3,
+			Nodes:     []string{"127.0.0.1:2371", "127.0.0.1:2372"},
+			Divergent: []string{"127.0.0.1:2372"},
+		}},
+		Errors: []server.NodeError{{Addr: "127.0.0.1:2373", Error: "zap!"}},
+	}}
+	s := NewServer(&Config{Server: stub})
+
+	rw := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/v1/partitions/divergent", nil)
+
+	s.divergentHandler(rw, req)
+	var resp client.PartitionsResponse
+	json.Unmarshal(rw.Body.Bytes(), &resp)
+
+	expected := client.PartitionsResponse{
+		Partitions: []client.Partition{{
+			Index:     3,
+			Nodes:     []string{"127.0.0.1:2371", "127.0.0.1:2372"},
+			Divergent: []string{"127.0.0.1:2372"},
+		}},
+		Errors: []client.NodeError{{Addr: "127.0.0.1:2373", Text: "zap!"}},
+	}
+	if !reflect.DeepEqual(resp, expected) {
+		t.Fatalf("invalid partitions returned: %v", resp)
+	}
+}
+
 func TestStoreHandler(t *testing.T) {
 	res := server.Response{
 		Record: hash.Record{
@@ -161,6 +678,102 @@ func TestStoreHandler(t *testing.T) {
 	assertError(t, rw, stub.Response.Status, body)
 }
 
+func TestStoreHandlerSliding(t *testing.T) {
+	res := server.Response{Record: hash.Record{
+		Data: 42, Meta: hash.Meta{ExpireTime: time.Minute, Sliding: true},
+	}}
+	stub := &stubServer{Response: res}
+	s := NewServer(&Config{Server: stub})
+
+	rw := httptest.NewRecorder()
+	rd := strings.NewReader(`{"data": 42, "expire_time": "1m", "sliding": true}`)
+	req := httptest.NewRequest("PUT", "/v1/keys?key=1", rd)
+
+	s.storeHandler(rw, req)
+	resp := assertResponse(t, rw, store.ActionStore)
+
+	if !resp.Meta.Sliding {
+		t.Fatalf("record expiration should be sliding")
+	}
+
+	sreq, ok := stub.Request.(*store.RequestStore)
+	if !ok || !sreq.Sliding {
+		t.Fatalf("invalid request passed to the server: %v", stub.Request)
+	}
+}
+
+func TestStoreHandlerIfMatch(t *testing.T) {
+	res := server.Response{Record: hash.Record{Data: 42}}
+	stub := &stubServer{Response: res}
+	s := NewServer(&Config{Server: stub})
+
+	rw := httptest.NewRecorder()
+	rd := strings.NewReader(`{"data": 42}`)
+	req := httptest.NewRequest("PUT", "/v1/keys?key=1", rd)
+	req.Header.Set("If-Match", `"3"`)
+
+	s.storeHandler(rw, req)
+	assertResponse(t, rw, store.ActionCompareAndSwap)
+
+	cas, ok := stub.Request.(*store.RequestCompareAndSwap)
+	if !ok {
+		t.Fatalf("invalid request type: %T", stub.Request)
+	}
+	if cas.Index != 3 {
+		t.Fatalf("invalid expected index: %d", cas.Index)
+	}
+
+	rw = httptest.NewRecorder()
+	req.Body = ioutil.NopCloser(strings.NewReader(`{"data": 44}`))
+	req.Header.Set("If-Match", "*")
+
+	s.storeHandler(rw, req)
+	if rw.Code != http.StatusBadRequest {
+		t.Fatalf("wrong status code returned: %d", rw.Code)
+	}
+}
+
+func TestStoreHandlerIfNoneMatch(t *testing.T) {
+	res := server.Response{Record: hash.Record{Data: 42}}
+	stub := &stubServer{Response: res}
+	s := NewServer(&Config{Server: stub})
+
+	rw := httptest.NewRecorder()
+	rd := strings.NewReader(`{"data": 42}`)
+	req := httptest.NewRequest("PUT", "/v1/keys?key=1", rd)
+	req.Header.Set("If-None-Match", "*")
+
+	s.storeHandler(rw, req)
+	assertResponse(t, rw, store.ActionCompareAndSwap)
+
+	cas, ok := stub.Request.(*store.RequestCompareAndSwap)
+	if !ok {
+		t.Fatalf("invalid request type: %T", stub.Request)
+	}
+	if cas.Index != 0 {
+		t.Fatalf("record should be expected missing: %d", cas.Index)
+	}
+
+	rw = httptest.NewRecorder()
+	req.Body = ioutil.NopCloser(strings.NewReader(`{"data": 44}`))
+	req.Header.Set("If-None-Match", `"3"`)
+
+	s.storeHandler(rw, req)
+	if rw.Code != http.StatusBadRequest {
+		t.Fatalf("wrong status code returned: %d", rw.Code)
+	}
+
+	rw = httptest.NewRecorder()
+	req.Body = ioutil.NopCloser(strings.NewReader(`{"data": 44}`))
+	req.Header.Set("If-None-Match", "*")
+	req.Header.Set("If-Match", `"3"`)
+
+	s.storeHandler(rw, req)
+	if rw.Code != http.StatusBadRequest {
+		t.Fatalf("wrong status code returned: %d", rw.Code)
+	}
+}
+
 func TestDeleteHandler(t *testing.T) {
 	stub := &stubServer{}
 	s := NewServer(&Config{Server: stub})
@@ -230,3 +843,181 @@ func TestItemHandler(t *testing.T) {
 	bodyText := "{\"text\":\"unable to load value, bang\"}"
 	assertError(t, rw, stub.Response.Status, bodyText)
 }
+
+func TestIncrHandler(t *testing.T) {
+	res := server.Response{Record: hash.Record{Data: 1}}
+	stub := &stubServer{Response: res}
+	s := NewServer(&Config{Server: stub})
+
+	rw := httptest.NewRecorder()
+	req := httptest.NewRequest("POST", "/v1/keys?key=1", nil)
+
+	s.incrHandler(rw, req)
+	assertResponse(t, rw, store.ActionIncr)
+
+	body := strings.NewReader(`{"delta": 5}`)
+	req = httptest.NewRequest("POST", "/v1/keys?key=1", body)
+	rw = httptest.NewRecorder()
+
+	s.incrHandler(rw, req)
+	assertResponse(t, rw, store.ActionAdd)
+	if add := stub.Request.(*store.RequestAdd); add.Delta != 5 {
+		t.Fatalf("invalid delta of the request: %d", add.Delta)
+	}
+
+	stub.Response = server.Response{
+		Error: "nan", Status: http.StatusConflict}
+	rw = httptest.NewRecorder()
+	req = httptest.NewRequest("POST", "/v1/keys?key=1", nil)
+
+	s.incrHandler(rw, req)
+	bodyText := "{\"text\":\"unable to increment 1 key, nan\"}"
+	assertError(t, rw, stub.Response.Status, bodyText)
+}
+
+func TestTTLHandlers(t *testing.T) {
+	res := server.Response{Record: hash.Record{Data: 42}}
+	stub := &stubServer{Response: res}
+	s := NewServer(&Config{Server: stub})
+
+	rw := httptest.NewRecorder()
+	body := strings.NewReader(`{"expire_time": "10s"}`)
+	req := httptest.NewRequest("PATCH", "/v1/keys?key=1", body)
+
+	s.expireHandler(rw, req)
+	assertResponse(t, rw, store.ActionExpire)
+	if exp := stub.Request.(*store.RequestExpire); exp.ExpireTime != 10*time.Second {
+		t.Fatalf("invalid expiration time of the request: %s", exp.ExpireTime)
+	}
+
+	rw = httptest.NewRecorder()
+	req = httptest.NewRequest("DELETE", "/v1/keys?key=1", nil)
+
+	s.persistHandler(rw, req)
+	assertResponse(t, rw, store.ActionPersist)
+
+	stub.Response = server.Response{Record: hash.Record{Data: float64(time.Minute)}}
+	rw = httptest.NewRecorder()
+	req = httptest.NewRequest("GET", "/v1/keys?key=1", nil)
+
+	s.ttlHandler(rw, req)
+	resp := assertResponse(t, rw, store.ActionTTL)
+	if resp.Data.(string) != "1m0s" {
+		t.Fatalf("invalid remaining lifetime returned: %v", resp.Data)
+	}
+
+	stub.Response = server.Response{
+		Error: "missing", Status: http.StatusNotFound}
+	rw = httptest.NewRecorder()
+	req = httptest.NewRequest("GET", "/v1/keys?key=1", nil)
+
+	s.ttlHandler(rw, req)
+	bodyText := "{\"text\":\"unable to retrieve ttl of 1 key, missing\"}"
+	assertError(t, rw, stub.Response.Status, bodyText)
+}
+
+func TestDecrHandler(t *testing.T) {
+	res := server.Response{Record: hash.Record{Data: 1}}
+	stub := &stubServer{Response: res}
+	s := NewServer(&Config{Server: stub})
+
+	rw := httptest.NewRecorder()
+	req := httptest.NewRequest("POST", "/v1/keys?key=1", nil)
+
+	s.decrHandler(rw, req)
+	assertResponse(t, rw, store.ActionDecr)
+
+	body := strings.NewReader(`{"delta": 5}`)
+	req = httptest.NewRequest("POST", "/v1/keys?key=1", body)
+	rw = httptest.NewRecorder()
+
+	s.decrHandler(rw, req)
+	assertResponse(t, rw, store.ActionAdd)
+	if add := stub.Request.(*store.RequestAdd); add.Delta != -5 {
+		t.Fatalf("invalid delta of the request: %d", add.Delta)
+	}
+}
+
+func TestListHandlers(t *testing.T) {
+	res := server.Response{Record: hash.Record{Data: []int{1}}}
+	stub := &stubServer{Response: res}
+	s := NewServer(&Config{Server: stub})
+
+	tests := []struct {
+		Handler http.HandlerFunc
+		Method  string
+		Body    string
+		Action  string
+	}{
+		{s.listRangeHandler, "GET", `{"start": 0, "stop": -1}`, store.ActionListRange},
+		{s.listPushHandler, "POST", `{"data": 1, "front": true}`, store.ActionListPush},
+		{s.listPopHandler, "POST", ``, store.ActionListPop},
+		{s.listTrimHandler, "POST", `{"start": 1, "stop": 2}`, store.ActionListTrim},
+		{s.listSetHandler, "PUT", `{"index": 0, "data": 2}`, store.ActionListSet},
+		{s.listRemoveHandler, "DELETE", `{"index": 0}`, store.ActionListRemove},
+	}
+
+	for _, tt := range tests {
+		body := strings.NewReader(tt.Body)
+		req := httptest.NewRequest(tt.Method, "/v1/keys?key=6", body)
+		rw := httptest.NewRecorder()
+
+		tt.Handler(rw, req)
+		assertResponse(t, rw, tt.Action)
+
+		if stub.Request.Hash() != "6" {
+			t.Fatalf("invalid hash of the request: %s", stub.Request.Hash())
+		}
+	}
+
+	stub.Response = server.Response{
+		Error: "empty", Status: http.StatusNotFound}
+	rw := httptest.NewRecorder()
+	req := httptest.NewRequest("POST", "/v1/keys?key=6", nil)
+
+	s.listPopHandler(rw, req)
+	bodyText := "{\"text\":\"unable to pop value, empty\"}"
+	assertError(t, rw, stub.Response.Status, bodyText)
+}
+
+func TestDictHandlers(t *testing.T) {
+	res := server.Response{Record: hash.Record{Data: 1}}
+	stub := &stubServer{Response: res}
+	s := NewServer(&Config{Server: stub})
+
+	tests := []struct {
+		Handler http.HandlerFunc
+		Method  string
+		Body    string
+		Action  string
+	}{
+		{s.setItemHandler, "PUT", `{"item": "a", "data": 1}`, store.ActionDictSetItem},
+		{s.deleteItemHandler, "DELETE", `{"item": "a"}`, store.ActionDictDeleteItem},
+		{s.itemsHandler, "GET", `{"items": ["a", "b"]}`, store.ActionDictItems},
+		{s.fieldsHandler, "GET", ``, store.ActionDictFields},
+		{s.mergeHandler, "PATCH", `{"data": {"a": 2}}`, store.ActionDictMerge},
+	}
+
+	for _, tt := range tests {
+		body := strings.NewReader(tt.Body)
+		req := httptest.NewRequest(tt.Method, "/v1/keys?key=8", body)
+		rw := httptest.NewRecorder()
+
+		tt.Handler(rw, req)
+		assertResponse(t, rw, tt.Action)
+
+		if stub.Request.Hash() != "8" {
+			t.Fatalf("invalid hash of the request: %s", stub.Request.Hash())
+		}
+	}
+
+	stub.Response = server.Response{
+		Error: "type", Status: http.StatusConflict}
+	rw := httptest.NewRecorder()
+	body := strings.NewReader(`{"data": 1}`)
+	req := httptest.NewRequest("PATCH", "/v1/keys?key=8", body)
+
+	s.mergeHandler(rw, req)
+	bodyText := "{\"text\":\"unable to merge 8 key, type\"}"
+	assertError(t, rw, stub.Response.Status, bodyText)
+}
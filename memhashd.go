@@ -5,8 +5,13 @@ import (
 	"fmt"
 	"net"
 	"os"
+	"os/signal"
+	"path/filepath"
 	"strings"
+	"syscall"
+	"time"
 
+	"github.com/ybubnov/memhashd/container/store"
 	"github.com/ybubnov/memhashd/httprest"
 	"github.com/ybubnov/memhashd/server"
 	"github.com/ybubnov/memhashd/system/log"
@@ -71,6 +76,17 @@ func main() {
 		flTLSKey        string
 		flTLSCert       string
 		flNumPartitions int
+		flReplication   int
+		flAntiEntropy   time.Duration
+		flProbe         time.Duration
+		flMaxMemory     int64
+		flMaxKeys       int
+		flEviction      string
+		flDataDir       string
+		flSnapshot      time.Duration
+		flJournal       bool
+		flJournalFsync  string
+		flJournalSize   int64
 	)
 
 	flag.BoolVar(&flHelp, "help", false, "print usage")
@@ -81,6 +97,19 @@ func main() {
 	flag.StringVar(&flTLSKey, "tls-key", "", "path to the TLS key file")
 	flag.StringVar(&flTLSCert, "tls-cert", "", "path to the TLS key file")
 	flag.IntVar(&flNumPartitions, "num-partitions", 16384, "number of the data partitions")
+	flag.IntVar(&flReplication, "replication-factor", 1, "number of the nodes holding copies of each partition")
+	flag.DurationVar(&flAntiEntropy, "anti-entropy-interval", time.Minute, "interval between comparisons of the partition copies")
+	flag.DurationVar(&flProbe, "probe-interval", time.Second, "interval between the pings of the cluster members")
+	flag.Int64Var(&flMaxMemory, "max-memory", 0, "maximum memory in bytes used by the data")
+	flag.IntVar(&flMaxKeys, "max-keys", 0, "maximum number of the keys")
+	flag.StringVar(&flEviction, "eviction-policy", store.PolicyNoEviction, "eviction policy (noeviction, lru, lfu, volatile-ttl)")
+
+	flag.StringVar(&flDataDir, "data-dir", "", "directory to persist snapshots of the data")
+	flag.DurationVar(&flSnapshot, "snapshot-interval", time.Minute, "interval between snapshots of the data")
+
+	flag.BoolVar(&flJournal, "journal", false, "record modifications of the data into a journal")
+	flag.StringVar(&flJournalFsync, "journal-fsync", store.FsyncEverySec, "journal fsync policy (always, everysec, never)")
+	flag.Int64Var(&flJournalSize, "journal-compact-size", 64<<20, "size of the journal in bytes to trigger compaction")
 
 	flag.Parse()
 
@@ -89,6 +118,27 @@ func main() {
 		return
 	}
 
+	policy, err := store.EvictionPolicyOf(flEviction)
+	if err != nil {
+		log.FatalLogf("memhashd/MAIN", err.Error())
+	}
+
+	var journal *store.Journal
+	if flJournal {
+		if flDataDir == "" {
+			log.FatalLogf("memhashd/MAIN", "journal requires a data directory")
+		}
+
+		journal, err = store.OpenJournal(&store.JournalConfig{
+			Path:        filepath.Join(flDataDir, "memhashd.journal"),
+			Fsync:       flJournalFsync,
+			CompactSize: flJournalSize,
+		})
+		if err != nil {
+			log.FatalLogf("memhashd/MAIN", err.Error())
+		}
+	}
+
 	// Construct a list of neighbor adjacencies.
 	var nodes server.Nodes
 	for _, addr := range flJoin {
@@ -96,12 +146,22 @@ func main() {
 	}
 
 	s := server.New(&server.Config{
-		NumPartitions: flNumPartitions,
-		NumRetries:    flJoinRetries,
-		Nodes:         nodes,
-		LocalAddr:     &flServerAddr.TCPAddr,
-		TLSCertFile:   flTLSCert,
-		TLSKeyFile:    flTLSKey,
+		NumPartitions:     flNumPartitions,
+		ReplicationFactor: flReplication,
+		NumRetries:        flJoinRetries,
+		Nodes:             nodes,
+		LocalAddr:         &flServerAddr.TCPAddr,
+		TLSCertFile:       flTLSCert,
+		TLSKeyFile:        flTLSKey,
+		MaxMemory:         flMaxMemory,
+		MaxKeys:           flMaxKeys,
+		EvictionPolicy:    policy,
+		DataDir:           flDataDir,
+		SnapshotInterval:  flSnapshot,
+		Journal:           journal,
+
+		AntiEntropyInterval: flAntiEntropy,
+		ProbeInterval:       flProbe,
 	})
 
 	defer s.Stop()
@@ -109,13 +169,26 @@ func main() {
 		log.FatalLogf("memhashd/MAIN", err.Error())
 	}
 
+	// Stop the server on termination, so the final snapshot of the
+	// data is saved to the disk.
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		sig := <-sigs
+		log.InfoLogf("memhashd/MAIN", "received %s, stopping", sig)
+		if err := s.Stop(); err != nil {
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}()
+
 	hs := httprest.NewServer(&httprest.Config{
 		Server:      s,
 		TLSCertFile: flTLSCert,
 		TLSKeyFile:  flTLSKey,
 		LocalAddr:   &flClientAddr.TCPAddr,
 	})
-	err := hs.ListenAndServe()
+	err = hs.ListenAndServe()
 	if err != nil {
 		log.FatalLogf("memhashd/MAIN", err.Error())
 	}
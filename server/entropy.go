@@ -0,0 +1,560 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/ybubnov/go-uuid"
+	"github.com/ybubnov/memhashd/container/hash"
+	"github.com/ybubnov/memhashd/container/merkle"
+	"github.com/ybubnov/memhashd/container/ring"
+	"github.com/ybubnov/memhashd/container/store"
+	"github.com/ybubnov/memhashd/system/log"
+)
+
+const (
+	// actionDigest is an action to retrieve the root hashes of the
+	// trees of the partitions.
+	actionDigest = "digest"
+
+	// actionTree is an action to retrieve the hash tree of a single
+	// partition.
+	actionTree = "tree"
+
+	// actionRecords is an action to retrieve the records of the leaves
+	// of the partition tree.
+	actionRecords = "records"
+
+	// treeDepth is a depth of the hash tree of each partition.
+	treeDepth = 4
+)
+
+// entropyActions is a set of actions of the anti-entropy process.
+var entropyActions = map[string]bool{
+	actionDigest:  true,
+	actionTree:    true,
+	actionRecords: true,
+}
+
+// requestDigest defines a request to the remote node to calculate the
+// root hashes of the trees of the given partitions.
+type requestDigest struct {
+	// ID is a request identifier.
+	ID string
+	// Partitions is a list of the partitions indices.
+	Partitions []int
+}
+
+// Action implements message interface.
+func (r *requestDigest) Action() string {
+	return actionDigest
+}
+
+// String implements fmt.Stringer interface.
+func (r *requestDigest) String() string {
+	return fmt.Sprintf("id: %s, type: digest, partitions: %d",
+		r.ID, len(r.Partitions))
+}
+
+// requestTree defines a request to the remote node to build a hash tree
+// of the partition.
+type requestTree struct {
+	// ID is a request identifier.
+	ID string
+	// Partition is an index of the partition.
+	Partition int
+}
+
+// Action implements message interface.
+func (r *requestTree) Action() string {
+	return actionTree
+}
+
+// String implements fmt.Stringer interface.
+func (r *requestTree) String() string {
+	return fmt.Sprintf("id: %s, type: tree, partition: %d",
+		r.ID, r.Partition)
+}
+
+// requestRecords defines a request to the remote node to return the
+// records of the given leaves of the partition tree.
+type requestRecords struct {
+	// ID is a request identifier.
+	ID string
+	// Partition is an index of the partition.
+	Partition int
+	// Leaves is a list of the leaves indices.
+	Leaves []int
+}
+
+// Action implements message interface.
+func (r *requestRecords) Action() string {
+	return actionRecords
+}
+
+// String implements fmt.Stringer interface.
+func (r *requestRecords) String() string {
+	return fmt.Sprintf("id: %s, type: records, partition: %d, leaves: %v",
+		r.ID, r.Partition, r.Leaves)
+}
+
+// Partition describes the copies of a single partition of the ring.
+type Partition struct {
+	// Index is an index of the partition in the ring.
+	Index int
+
+	// Nodes is a list of addresses of the nodes holding the copies of
+	// the partition, the primary copy is the first one.
+	Nodes []string
+
+	// Divergent is a list of addresses of the nodes, which copies of
+	// the partition differ from the primary copy.
+	Divergent []string
+}
+
+// PartitionsResponse is a list of partitions, which copies diverged.
+type PartitionsResponse struct {
+	// Partitions is a list of divergent partitions ordered by index.
+	Partitions []Partition
+
+	// Errors is a list of nodes failed to return the hashes of the
+	// partitions. The copies of these nodes are not compared.
+	Errors []NodeError
+}
+
+// digestOf returns a digest of the record content and its lifetime. The
+// access and update times are not included, since they are not necessary
+// the same on all copies of the record.
+func digestOf(rec hash.Record) []byte {
+	b, _ := json.Marshal(rec.Data)
+	digest := strconv.AppendInt(nil, rec.Meta.Index, 10)
+	digest = strconv.AppendInt(append(digest, ':'), int64(rec.Meta.ExpireTime), 10)
+	digest = strconv.AppendBool(append(digest, ':'), rec.Meta.Sliding)
+	return append(append(digest, ':'), b...)
+}
+
+// partitions returns the preference lists of the nodes of each partition
+// in the order of the partitions.
+func (s *server) partitions() []Nodes {
+	r, _ := s.topology()
+	return s.preferences(r)
+}
+
+// preferences returns the preference lists of the nodes of each partition
+// of the given ring in the order of the partitions.
+func (s *server) preferences(r ring.Ring) []Nodes {
+	partitions := make([]Nodes, 0)
+	for _, elements := range r.Partitions(s.replicas) {
+		pnodes := make(Nodes, 0, len(elements))
+		for _, elem := range elements {
+			pnodes = append(pnodes, elem.Value.(*Node))
+		}
+		partitions = append(partitions, pnodes)
+	}
+	return partitions
+}
+
+// changed updates the hash tree and the key index of the partition of the
+// key with the change of the record made by the local store.
+func (s *server) changed(key string, prev, rec *hash.Record) {
+	p := s.partitioner.Partition(ring.StringHasher(key))
+
+	s.treesMu.Lock()
+	defer s.treesMu.Unlock()
+	if prev != nil {
+		s.trees[p].Remove(key, digestOf(*prev))
+		delete(s.keys[p], key)
+	}
+	if rec != nil {
+		s.trees[p].Insert(key, digestOf(*rec))
+		s.keys[p][key] = struct{}{}
+	}
+}
+
+// treesOf returns the copies of the hash trees of the given partitions.
+// The trees are updated on each change of the local store.
+func (s *server) treesOf(partitions []int) map[int]*merkle.Tree {
+	s.treesMu.Lock()
+	defer s.treesMu.Unlock()
+
+	trees := make(map[int]*merkle.Tree, len(partitions))
+	for _, p := range partitions {
+		if p >= 0 && p < len(s.trees) {
+			trees[p] = s.trees[p].Copy()
+		}
+	}
+	return trees
+}
+
+// digests returns the root hashes of the trees of the given partitions.
+func (s *server) digests(partitions []int) map[int]uint64 {
+	digests := make(map[int]uint64, len(partitions))
+	for p, tree := range s.treesOf(partitions) {
+		digests[p] = tree.Root()
+	}
+	return digests
+}
+
+// records returns the records of the local store, which belong to the
+// given leaves of the partition tree. The keys are taken from the index
+// of the partition, so the rest of the store is not scanned.
+func (s *server) records(partition int, leaves []int) map[string]hash.Record {
+	var (
+		tree    = merkle.New(treeDepth)
+		records = make(map[string]hash.Record)
+		lset    = make(map[int]bool, len(leaves))
+		keys    []string
+	)
+
+	for _, leaf := range leaves {
+		lset[leaf] = true
+	}
+
+	s.treesMu.Lock()
+	if partition >= 0 && partition < len(s.keys) {
+		for key := range s.keys[partition] {
+			if lset[tree.Leaf(key)] {
+				keys = append(keys, key)
+			}
+		}
+	}
+	s.treesMu.Unlock()
+
+	// The store is queried without the lock of the trees held, since
+	// the changes of the store are applied to the trees under the lock
+	// of the store segment.
+	for _, key := range keys {
+		if rec, ok := s.store.Peek(key); ok {
+			records[key] = rec
+		}
+	}
+	return records
+}
+
+// handleEntropy serves the requests of the anti-entropy process sent by
+// the remote node and returns the response to the node.
+func (s *server) handleEntropy(ev *eventRequest) Response {
+	var (
+		resp = Response{Status: http.StatusOK}
+		err  error
+	)
+
+	switch ev.Action {
+	case actionDigest:
+		var req requestDigest
+		if err = json.Unmarshal(*ev.Request, &req); err == nil {
+			resp.Digests = s.digests(req.Partitions)
+		}
+	case actionTree:
+		var req requestTree
+		if err = json.Unmarshal(*ev.Request, &req); err == nil {
+			resp.Tree = s.treesOf([]int{req.Partition})[req.Partition]
+		}
+	case actionRecords:
+		var req requestRecords
+		if err = json.Unmarshal(*ev.Request, &req); err == nil {
+			for key, rec := range s.records(req.Partition, req.Leaves) {
+				resp.Responses = append(resp.Responses, &Response{
+					Status: http.StatusOK,
+					Key:    key,
+					Record: rec,
+				})
+			}
+		}
+	}
+
+	if err != nil {
+		log.ErrorLogf("server/HANDLE",
+			"failed unmarshal request, %s", err)
+		return Response{
+			Status: http.StatusBadRequest,
+			Error:  err.Error(),
+		}
+	}
+	return resp
+}
+
+// antiEntropy periodically converges the copies of the partitions, which
+// primary copy is held by the local node, until the server is stopped.
+func (s *server) antiEntropy(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if n := s.repair(); n != 0 {
+				log.InfoLogf("server/ANTI_ENTROPY", "repaired %d records", n)
+			}
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// repair compares the hash trees of the partitions, which primary copy is
+// held by the local node, with the trees of the replicas and converges
+// the differing records. It returns the number of repaired records.
+//
+// The newest copy of each record wins, so the records are pulled from the
+// replicas as well, and the records are not lost, when the local store
+// is empty after the restart. The partitions being moved to or from the
+// local node are not repaired until the migration is completed.
+func (s *server) repair() int {
+	var (
+		repaired int
+		local    []int
+		groups   = make(map[*Node][]int)
+	)
+
+	for p, nodes := range s.partitions() {
+		if nodes[0].conn() != nil || s.migrating(p) {
+			continue
+		}
+		local = append(local, p)
+		for _, node := range nodes[1:] {
+			groups[node] = append(groups[node], p)
+		}
+	}
+	if len(groups) == 0 {
+		return 0
+	}
+
+	trees := s.treesOf(local)
+	for node, partitions := range groups {
+		n, err := s.repairNode(node, partitions, trees)
+		if err != nil {
+			log.ErrorLogf("server/ANTI_ENTROPY",
+				"repair of %s failed with %s", node.Addr, err)
+		}
+		repaired += n
+	}
+	return repaired
+}
+
+// repairNode compares the root hashes of the partitions with the replica
+// node, and repairs the partitions with differing hashes.
+func (s *server) repairNode(node *Node, partitions []int,
+	trees map[int]*merkle.Tree) (int, error) {
+
+	req := &requestDigest{ID: uuid.New(), Partitions: partitions}
+	resp, err := s.roundTrip(node, req, "")
+	if err == nil {
+		err = resp.Err()
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	var repaired int
+	for _, p := range partitions {
+		if root, ok := resp.Digests[p]; ok && root == trees[p].Root() {
+			continue
+		}
+
+		n, err := s.repairPartition(node, p, trees[p])
+		repaired += n
+		if err != nil {
+			return repaired, err
+		}
+	}
+	return repaired, nil
+}
+
+// repairPartition converges the records of the differing leaves of the
+// partition tree on the local and the replica nodes. The newer records of
+// the replica are pulled, unless the local store keeps the tombstones of
+// them, then the newer local records are pushed to the replica, and the
+// records deleted locally are deleted from the replica.
+func (s *server) repairPartition(node *Node, partition int,
+	tree *merkle.Tree) (int, error) {
+
+	resp, err := s.roundTrip(node, &requestTree{
+		ID: uuid.New(), Partition: partition}, "")
+	if err == nil {
+		err = resp.Err()
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	other := resp.Tree
+	if other == nil {
+		other = merkle.New(treeDepth)
+	}
+	leaves := tree.Diff(other)
+	if len(leaves) == 0 {
+		return 0, nil
+	}
+
+	req := &requestRecords{ID: uuid.New(), Partition: partition, Leaves: leaves}
+	if resp, err = s.roundTrip(node, req, ""); err == nil {
+		err = resp.Err()
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	var (
+		remote   = make(map[string]hash.Record, len(resp.Responses))
+		local    = s.records(partition, leaves)
+		repaired = make(map[string]bool)
+		pull     []store.Event
+		push     []store.Event
+	)
+
+	for _, r := range resp.Responses {
+		remote[r.Key] = r.Record
+		if rec, ok := local[r.Key]; !ok || store.Newer(r.Record.Meta, rec.Meta) {
+			pull = append(pull, store.Event{
+				Type: store.EventStore, Key: r.Key, Record: r.Record})
+		}
+	}
+
+	// The pulled records are applied as the replicated changes, so the
+	// changes made locally in the meantime and the deleted records are
+	// not overwritten by the stale copies.
+	if len(pull) != 0 {
+		rreq := &store.RequestReplicate{ID: uuid.New(), Changes: pull}
+		if _, _, err := s.store.ServeChanges(rreq); err != nil {
+			return 0, err
+		}
+		for _, ev := range pull {
+			repaired[ev.Key] = true
+		}
+		local = s.records(partition, leaves)
+	}
+
+	for key, rec := range local {
+		r, ok := remote[key]
+		delete(remote, key)
+
+		if !ok || !bytes.Equal(digestOf(rec), digestOf(r)) {
+			push = append(push, store.Event{
+				Type: store.EventStore, Key: key, Record: rec})
+		}
+	}
+	for key, r := range remote {
+		push = append(push, store.Event{
+			Type: store.EventDelete, Key: key, Record: r})
+	}
+
+	if len(push) != 0 {
+		rreq := &store.RequestReplicate{ID: uuid.New(), Changes: push}
+		if resp, err = s.roundTrip(node, rreq, ""); err == nil {
+			err = resp.Err()
+		}
+		if err != nil {
+			return len(repaired), err
+		}
+		for _, ev := range push {
+			repaired[ev.Key] = true
+		}
+	}
+	return len(repaired), nil
+}
+
+// Divergent implements Server interface. It retrieves the root hashes of
+// the partitions from all nodes in parallel, and compares the copies of
+// each partition with the primary copy.
+func (s *server) Divergent(ctx context.Context) PartitionsResponse {
+	type result struct {
+		node    *Node
+		digests map[int]uint64
+		err     error
+	}
+
+	var (
+		partitions = s.partitions()
+		held       = make(map[*Node][]int)
+	)
+
+	for p, nodes := range partitions {
+		for _, node := range nodes {
+			held[node] = append(held[node], p)
+		}
+	}
+
+	results := make(chan result, len(held))
+	for node, indices := range held {
+		go func(n *Node, indices []int) {
+			if n.conn() == nil {
+				results <- result{n, s.digests(indices), nil}
+				return
+			}
+
+			req := &requestDigest{ID: uuid.New(), Partitions: indices}
+			resp, err := s.roundTrip(n, req, "")
+			if err == nil {
+				err = resp.Err()
+			}
+			results <- result{n, resp.Digests, err}
+		}(node, indices)
+	}
+
+	var (
+		resp    PartitionsResponse
+		digests = make(map[*Node]map[int]uint64, len(held))
+		pending = make(map[*Node]bool, len(held))
+	)
+
+	for node := range held {
+		pending[node] = true
+	}
+
+	for len(pending) != 0 {
+		select {
+		case r := <-results:
+			delete(pending, r.node)
+			if r.err != nil {
+				log.ErrorLogf("server/DIVERGENT",
+					"failed to retrieve digests from %s, %s", r.node.Addr, r.err)
+				resp.Errors = append(resp.Errors, NodeError{
+					Addr: r.node.Addr.String(), Error: r.err.Error()})
+				continue
+			}
+			digests[r.node] = r.digests
+		case <-ctx.Done():
+			// Report all nodes, which did not reply in time as failed.
+			for node := range pending {
+				delete(pending, node)
+				resp.Errors = append(resp.Errors, NodeError{
+					Addr: node.Addr.String(), Error: ctx.Err().Error()})
+			}
+		}
+	}
+
+	for p, nodes := range partitions {
+		primary, ok := digests[nodes[0]]
+		if !ok {
+			continue
+		}
+
+		var divergent []string
+		for _, node := range nodes[1:] {
+			d, ok := digests[node]
+			if ok && d[p] != primary[p] {
+				divergent = append(divergent, node.Addr.String())
+			}
+		}
+		if divergent == nil {
+			continue
+		}
+
+		addrs := make([]string, 0, len(nodes))
+		for _, node := range nodes {
+			addrs = append(addrs, node.Addr.String())
+		}
+		resp.Partitions = append(resp.Partitions, Partition{
+			Index: p, Nodes: addrs, Divergent: divergent})
+	}
+
+	sort.Sort(nodeErrors(resp.Errors))
+	return resp
+}
@@ -0,0 +1,185 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/ybubnov/memhashd/container/hash"
+	"github.com/ybubnov/memhashd/container/merkle"
+	"github.com/ybubnov/memhashd/container/ring"
+	"github.com/ybubnov/memhashd/container/store"
+)
+
+func TestServerRepair(t *testing.T) {
+	s1, s2, conn := newTestCluster()
+	defer conn.Close()
+	s1.replicas = 2
+
+	// Find the keys, which primary copy is held by the first node.
+	var keys []string
+	for i := 0; len(keys) < 3; i++ {
+		if key := fmt.Sprintf("key%d", i); s1.nodeOfKey(key).Conn == nil {
+			keys = append(keys, key)
+		}
+	}
+
+	s1.store.Store(keys[0], hash.Record{Data: "a"})
+	s1.store.Store(keys[1], hash.Record{Data: "b"})
+	s2.store.Store(keys[1], hash.Record{Data: "c"})
+	s2.store.Store(keys[1], hash.Record{Data: "d"})
+	s2.store.Store(keys[2], hash.Record{Data: "e"})
+
+	resp := s1.Divergent(context.Background())
+	if len(resp.Errors) != 0 || len(resp.Partitions) == 0 {
+		t.Fatalf("divergent partitions expected: %v", resp)
+	}
+	for _, p := range resp.Partitions {
+		if len(p.Divergent) != 1 || p.Divergent[0] != p.Nodes[1] {
+			t.Fatalf("replica should diverge: %v", p)
+		}
+	}
+
+	if n := s1.repair(); n != 3 {
+		t.Fatalf("invalid number of repaired records: %d", n)
+	}
+
+	// The newest copy of each record is kept by both nodes.
+	for key, data := range map[string]string{
+		keys[0]: "a", keys[1]: "d", keys[2]: "e"} {
+		r1, _ := s1.store.Peek(key)
+		r2, _ := s2.store.Peek(key)
+		if r1.Data != data || r2.Data != data {
+			t.Fatalf("invalid records of %s: %v, %v", key, r1, r2)
+		}
+	}
+
+	// The deleted record is not pulled back, but deleted from the
+	// replica, which missed the deletion.
+	if _, err := s1.store.Serve(&store.RequestDelete{Key: keys[2]}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if n := s1.repair(); n != 1 {
+		t.Fatalf("invalid number of repaired records: %d", n)
+	}
+	if rec, ok := s2.store.Peek(keys[2]); ok {
+		t.Fatalf("record should be deleted from replica: %v", rec)
+	}
+	if _, ok := s1.store.Peek(keys[2]); ok {
+		t.Fatalf("deleted record should not be pulled from replica")
+	}
+
+	if n := s1.repair(); n != 0 {
+		t.Fatalf("converged copies should not be repaired: %d", n)
+	}
+	if resp = s1.Divergent(context.Background()); len(resp.Partitions) != 0 {
+		t.Fatalf("copies should converge: %v", resp.Partitions)
+	}
+
+	conn.Close()
+	resp = s1.Divergent(context.Background())
+	if len(resp.Errors) != 1 {
+		t.Fatalf("unavailable node should be reported: %v", resp)
+	}
+}
+
+func TestServerRepairRestarted(t *testing.T) {
+	s1, s2, conn := newTestCluster()
+	defer conn.Close()
+	s1.replicas = 2
+
+	// The primary node is restarted with an empty store, so the records
+	// are held only by the replica.
+	var keys []string
+	for i := 0; len(keys) < 8; i++ {
+		if key := fmt.Sprintf("key%d", i); s1.nodeOfKey(key).Conn == nil {
+			keys = append(keys, key)
+			s2.store.Store(key, hash.Record{Data: key})
+		}
+	}
+
+	if n := s1.repair(); n != len(keys) {
+		t.Fatalf("invalid number of repaired records: %d", n)
+	}
+	for _, key := range keys {
+		r1, _ := s1.store.Peek(key)
+		r2, _ := s2.store.Peek(key)
+		if r1.Data != key || r2.Data != key {
+			t.Fatalf("records of %s should be kept: %v, %v", key, r1, r2)
+		}
+	}
+}
+
+func TestServerRepairMigrating(t *testing.T) {
+	s1, s2, conn := newTestCluster()
+	defer conn.Close()
+	s1.replicas = 2
+
+	var key string
+	for i := 0; key == ""; i++ {
+		if k := fmt.Sprintf("key%d", i); s1.nodeOfKey(k).Conn == nil {
+			key = k
+		}
+	}
+	s2.store.Store(key, hash.Record{Data: "a"})
+
+	// The partition being moved to the local node is not repaired.
+	p := s1.ring.Partition(ring.StringHasher(key))
+	s1.expect(s1.partitions(), map[int]*Node{p: s1.nodes[1]})
+	if n := s1.repair(); n != 0 {
+		t.Fatalf("migrating partition should not be repaired: %d", n)
+	}
+
+	s1.migrated([]int{p})
+	if n := s1.repair(); n != 1 {
+		t.Fatalf("migrated partition should be repaired: %d", n)
+	}
+}
+
+func TestServerRecords(t *testing.T) {
+	s := newServer(&Config{NumPartitions: 4})
+	s.store.Store("a", hash.Record{Data: "a"})
+	s.store.Store("b", hash.Record{Data: "b"})
+	s.store.Delete("b")
+
+	leaves := make([]int, merkle.New(treeDepth).Leaves())
+	for i := range leaves {
+		leaves[i] = i
+	}
+
+	var records []string
+	for p := 0; p < s.numPartitions; p++ {
+		for key, rec := range s.records(p, leaves) {
+			if s.partitioner.Partition(ring.StringHasher(key)) != p {
+				t.Fatalf("key %s returned for partition %d", key, p)
+			}
+			records = append(records, fmt.Sprint(key, rec.Data))
+		}
+	}
+	if len(records) != 1 || records[0] != "aa" {
+		t.Fatalf("only stored records should be returned: %v", records)
+	}
+}
+
+func TestDigestOf(t *testing.T) {
+	rec := hash.Record{Data: "a", Meta: hash.Meta{Index: 1}}
+	expiring := rec
+	expiring.Meta.ExpireTime = time.Minute
+	sliding := expiring
+	sliding.Meta.Sliding = true
+
+	digests := make(map[string]bool)
+	for _, r := range []hash.Record{rec, expiring, sliding} {
+		digests[string(digestOf(r))] = true
+	}
+	if len(digests) != 3 {
+		t.Fatalf("lifetime of the record should change the digest: %v", digests)
+	}
+
+	accessed := rec
+	accessed.Meta.AccessedAt = time.Now()
+	if string(digestOf(accessed)) != string(digestOf(rec)) {
+		t.Fatalf("access time should not change the digest")
+	}
+}
@@ -0,0 +1,467 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"net"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/ybubnov/go-uuid"
+	"github.com/ybubnov/memhashd/container/store"
+	"github.com/ybubnov/memhashd/system/log"
+)
+
+const (
+	// NodeAlive is a state of the node, which answers the pings.
+	NodeAlive = "alive"
+
+	// NodeSuspect is a state of the node, which failed to answer the
+	// direct and the indirect pings. The node could refute the suspicion
+	// until the suspicion timeout.
+	NodeSuspect = "suspect"
+
+	// NodeDead is a state of the node, which did not refute the
+	// suspicion in time. The requests are not routed to the dead nodes.
+	NodeDead = "dead"
+)
+
+const (
+	// actionPing is an action to check the liveness of the node and
+	// exchange the states of the members.
+	actionPing = "ping"
+
+	// actionPingReq is an action to ask the node to ping another node
+	// on behalf of the sender.
+	actionPingReq = "ping-req"
+
+	// indirectProbes is a number of members asked to ping the node,
+	// which failed to answer the direct ping.
+	indirectProbes = 3
+
+	// suspectPeriods is a number of the probe intervals, after which
+	// the suspected node is considered dead.
+	suspectPeriods = 5
+)
+
+// gossipActions is a set of actions of the failure detector.
+var gossipActions = map[string]bool{
+	actionPing:    true,
+	actionPingReq: true,
+}
+
+// Health describes a state of the cluster member as seen by the failure
+// detector. The states with greater incarnation override the others, the
+// node increments own incarnation to refute the suspicion.
+type Health struct {
+	// Addr is an address of the member.
+	Addr string
+
+	// State is a state of the member: alive, suspect or dead.
+	State string
+
+	// Incarnation is a number of times the member refuted the suspicion.
+	Incarnation int64
+}
+
+// rank returns an order of the state, the states of the same incarnation
+// override the states of the lower rank.
+func rank(state string) int {
+	switch state {
+	case NodeSuspect:
+		return 1
+	case NodeDead:
+		return 2
+	}
+	return 0
+}
+
+// supersedes reports whether the state a overrides the state b of the
+// same member.
+func supersedes(a, b Health) bool {
+	if a.Incarnation != b.Incarnation {
+		return a.Incarnation > b.Incarnation
+	}
+	return rank(a.State) > rank(b.State)
+}
+
+// requestPing defines a ping of the remote node. The states of the members
+// known by the sender are piggybacked on the ping.
+type requestPing struct {
+	// ID is a request identifier.
+	ID string
+	// Health is a list of the members states.
+	Health []Health
+}
+
+// Action implements message interface.
+func (r *requestPing) Action() string {
+	return actionPing
+}
+
+// String implements fmt.Stringer interface.
+func (r *requestPing) String() string {
+	return fmt.Sprintf("id: %s, type: ping, members: %d",
+		r.ID, len(r.Health))
+}
+
+// requestPingReq defines a request to the remote node to ping the target
+// node and return its acknowledgement.
+type requestPingReq struct {
+	// ID is a request identifier.
+	ID string
+	// Target is an address of the node to ping.
+	Target string
+	// Timeout is a time to wait for the acknowledgement of the target.
+	Timeout time.Duration
+	// Health is a list of the members states.
+	Health []Health
+}
+
+// Action implements message interface.
+func (r *requestPingReq) Action() string {
+	return actionPingReq
+}
+
+// String implements fmt.Stringer interface.
+func (r *requestPingReq) String() string {
+	return fmt.Sprintf("id: %s, type: ping-req, target: %s", r.ID, r.Target)
+}
+
+// healthEntry is a state of the member, the time of the suspicion is used
+// to declare the member dead.
+type healthEntry struct {
+	Health
+	since time.Time
+}
+
+// healthEvent is a change of the member state.
+type healthEvent struct {
+	// Node is a member of the cluster.
+	Node *Node
+	// State is a new state of the member.
+	State string
+}
+
+// watchHealth registers the function called on each change of the member
+// state. The function is called by the failure detector, so it should not
+// block for a long time.
+func (s *server) watchHealth(fn func(healthEvent)) {
+	s.healthMu.Lock()
+	defer s.healthMu.Unlock()
+	s.healthWatchers = append(s.healthWatchers, fn)
+}
+
+// notify calls the registered functions for each event.
+func (s *server) notify(events []healthEvent) {
+	s.healthMu.Lock()
+	watchers := s.healthWatchers
+	s.healthMu.Unlock()
+
+	for _, ev := range events {
+		for _, fn := range watchers {
+			fn(ev)
+		}
+	}
+}
+
+// route updates the state of the node used to route the requests.
+func (s *server) route(ev healthEvent) {
+	s.nodesMu.Lock()
+	ev.Node.State = ev.State
+	s.nodesMu.Unlock()
+
+	log.InfoLogf("server/GOSSIP", "node %s is %s", ev.Node.Addr, ev.State)
+}
+
+// stateOf returns the state of the node. The state is empty, when the
+// failure detector is disabled.
+func (s *server) stateOf(node *Node) string {
+	s.nodesMu.RLock()
+	defer s.nodesMu.RUnlock()
+	return node.State
+}
+
+// reconcile adds the new members of the cluster to the health table as
+// alive and removes the former ones. It returns the members by address.
+// The health mutex should be held by the caller.
+func (s *server) reconcile() (map[string]*Node, []healthEvent) {
+	var (
+		nodes  = make(map[string]*Node)
+		events []healthEvent
+	)
+
+	for _, node := range s.Nodes() {
+		addr := node.Addr.String()
+		nodes[addr] = node
+		if _, ok := s.health[addr]; !ok {
+			s.health[addr] = &healthEntry{Health: Health{
+				Addr: addr, State: NodeAlive}}
+			events = append(events, healthEvent{node, NodeAlive})
+		}
+	}
+	for addr := range s.health {
+		if _, ok := nodes[addr]; !ok {
+			delete(s.health, addr)
+		}
+	}
+	return nodes, events
+}
+
+// merge applies the states of the members received from the remote node.
+// The suspicion of the local node is refuted by the increment of its
+// incarnation.
+func (s *server) merge(updates []Health) {
+	self := s.self().Addr.String()
+
+	s.healthMu.Lock()
+	nodes, events := s.reconcile()
+	for _, h := range updates {
+		entry, ok := s.health[h.Addr]
+		if !ok || !supersedes(h, entry.Health) {
+			continue
+		}
+		if h.Addr == self {
+			if h.State != NodeAlive {
+				entry.Incarnation = h.Incarnation + 1
+				log.InfoLogf("server/GOSSIP",
+					"refuted %s state of the local node", h.State)
+			}
+			continue
+		}
+
+		if entry.State != h.State {
+			events = append(events, healthEvent{nodes[h.Addr], h.State})
+			entry.since = time.Now()
+		}
+		entry.Health = h
+	}
+	s.healthMu.Unlock()
+	s.notify(events)
+}
+
+// declare changes the state of the node in the current incarnation.
+func (s *server) declare(node *Node, state string) {
+	addr := node.Addr.String()
+	s.healthMu.Lock()
+	entry, ok := s.health[addr]
+	var incarnation int64
+	if ok {
+		incarnation = entry.Incarnation
+	}
+	s.healthMu.Unlock()
+
+	if ok {
+		s.merge([]Health{{addr, state, incarnation}})
+	}
+}
+
+// gossip returns the states of all members ordered by address.
+func (s *server) gossip() []Health {
+	s.healthMu.Lock()
+	_, events := s.reconcile()
+	addrs := make([]string, 0, len(s.health))
+	for addr := range s.health {
+		addrs = append(addrs, addr)
+	}
+
+	sort.Strings(addrs)
+	health := make([]Health, 0, len(addrs))
+	for _, addr := range addrs {
+		health = append(health, s.health[addr].Health)
+	}
+	s.healthMu.Unlock()
+
+	s.notify(events)
+	return health
+}
+
+// detect probes a single member of the cluster each interval, until the
+// server is stopped. The members are probed in a random order, each one
+// is probed once per round.
+func (s *server) detect(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var round Nodes
+	for {
+		select {
+		case <-ticker.C:
+			if len(round) == 0 {
+				round = s.round()
+			}
+			if len(round) != 0 {
+				s.probe(round[0], interval/2)
+				round = round[1:]
+			}
+			s.expire(suspectPeriods * interval)
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// round returns the remote members of the cluster in a random order.
+func (s *server) round() Nodes {
+	var nodes Nodes
+	for _, node := range s.Nodes() {
+		if node.conn() != nil {
+			nodes = append(nodes, node)
+		}
+	}
+	for i := range nodes {
+		j := rand.Intn(i + 1)
+		nodes[i], nodes[j] = nodes[j], nodes[i]
+	}
+	return nodes
+}
+
+// probe pings the node directly, and then through the other members, so
+// the failure of a single link is not taken for the failure of the node.
+// The node is suspected, when all pings fail. It reports whether the node
+// answered.
+func (s *server) probe(node *Node, timeout time.Duration) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	resp, err := s.ping(ctx, node)
+	cancel()
+	if err == nil {
+		s.merge(resp.Health)
+		return true
+	}
+	log.DebugLogf("server/GOSSIP",
+		"ping of %s failed with %s", node.Addr, err)
+
+	helpers := s.helpers(node, indirectProbes)
+	ctx, cancel = context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	acks := make(chan *Response, len(helpers))
+	for _, helper := range helpers {
+		go func(n *Node) {
+			req := &requestPingReq{ID: uuid.New(), Target: node.Addr.String(),
+				Timeout: timeout, Health: s.gossip()}
+			resp, err := s.roundTripCtx(ctx, n, req)
+			if err == nil {
+				err = resp.Err()
+			}
+			if err != nil {
+				acks <- nil
+				return
+			}
+			acks <- &resp
+		}(helper)
+	}
+
+	for range helpers {
+		if resp := <-acks; resp != nil {
+			s.merge(resp.Health)
+			return true
+		}
+	}
+
+	s.declare(node, NodeSuspect)
+	return false
+}
+
+// ping sends a ping with the states of the members to the given node
+// through a dedicated connection.
+func (s *server) ping(ctx context.Context, node *Node) (Response, error) {
+	req := &requestPing{ID: uuid.New(), Health: s.gossip()}
+	resp, err := s.roundTripCtx(ctx, node, req)
+	if err == nil {
+		err = resp.Err()
+	}
+	return resp, err
+}
+
+// helpers returns up to n random alive members, except the given node
+// and the local node.
+func (s *server) helpers(node *Node, n int) Nodes {
+	var nodes Nodes
+	for _, helper := range s.round() {
+		if helper != node && s.stateOf(helper) != NodeDead &&
+			s.stateOf(helper) != NodeSuspect {
+			nodes = append(nodes, helper)
+		}
+	}
+	if len(nodes) > n {
+		nodes = nodes[:n]
+	}
+	return nodes
+}
+
+// expire declares dead the members, which were suspected longer than
+// the given timeout.
+func (s *server) expire(timeout time.Duration) {
+	var expired []Health
+	s.healthMu.Lock()
+	for _, entry := range s.health {
+		if entry.State == NodeSuspect && time.Since(entry.since) > timeout {
+			expired = append(expired, Health{
+				entry.Addr, NodeDead, entry.Incarnation})
+		}
+	}
+	s.healthMu.Unlock()
+
+	if expired != nil {
+		s.merge(expired)
+	}
+}
+
+// handleGossip serves the pings sent by the remote node and returns the
+// acknowledgement to the node.
+func (s *server) handleGossip(ev *eventRequest) Response {
+	var (
+		resp = Response{Status: http.StatusOK}
+		err  error
+	)
+
+	switch ev.Action {
+	case actionPing:
+		var req requestPing
+		if err = json.Unmarshal(*ev.Request, &req); err == nil {
+			s.merge(req.Health)
+			resp.Health = s.gossip()
+		}
+	case actionPingReq:
+		var req requestPingReq
+		if err = json.Unmarshal(*ev.Request, &req); err == nil {
+			s.merge(req.Health)
+			resp = s.pingReq(&req)
+		}
+	}
+
+	if err != nil {
+		log.ErrorLogf("server/HANDLE",
+			"failed unmarshal request, %s", err)
+		return Response{
+			Status: http.StatusBadRequest,
+			Error:  err.Error(),
+		}
+	}
+	return resp
+}
+
+// pingReq pings the target node on behalf of the remote node and returns
+// the acknowledgement of the target.
+func (s *server) pingReq(req *requestPingReq) Response {
+	addr, err := net.ResolveTCPAddr("tcp", req.Target)
+	if err != nil {
+		return Response{Status: http.StatusBadRequest, Error: err.Error()}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), req.Timeout)
+	defer cancel()
+
+	resp, err := s.ping(ctx, &Node{Addr: addr})
+	if err != nil {
+		const text = "node %s did not answer, %s"
+		err = &store.ErrUnavailable{Text: fmt.Sprintf(text, req.Target, err)}
+		return Response{Status: statusOf(err), Error: err.Error()}
+	}
+	s.merge(resp.Health)
+	return Response{Status: http.StatusOK, Health: resp.Health}
+}
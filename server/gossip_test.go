@@ -0,0 +1,124 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ybubnov/memhashd/container/store"
+)
+
+func TestSupersedes(t *testing.T) {
+	tests := []struct {
+		a, b       Health
+		supersedes bool
+	}{
+		{Health{State: NodeSuspect}, Health{State: NodeAlive}, true},
+		{Health{State: NodeDead}, Health{State: NodeSuspect}, true},
+		{Health{State: NodeAlive}, Health{State: NodeSuspect}, false},
+		{Health{State: NodeAlive, Incarnation: 1}, Health{State: NodeDead}, true},
+		{Health{State: NodeDead}, Health{State: NodeAlive, Incarnation: 1}, false},
+		{Health{State: NodeAlive}, Health{State: NodeAlive}, false},
+	}
+
+	for _, tt := range tests {
+		if supersedes(tt.a, tt.b) != tt.supersedes {
+			t.Fatalf("invalid precedence of %v over %v", tt.a, tt.b)
+		}
+	}
+}
+
+func TestServerGossip(t *testing.T) {
+	var (
+		servers   []*server
+		listeners []net.Listener
+	)
+	for i := 0; i < 3; i++ {
+		s, ln := newTestMember(t)
+		defer ln.Close()
+		servers = append(servers, s)
+		listeners = append(listeners, ln)
+	}
+
+	s1, s2, s3 := servers[0], servers[1], servers[2]
+	defer s2.Stop()
+
+	ctx := context.Background()
+	for _, s := range servers[1:] {
+		if resp := s1.Join(ctx, s.laddr); resp.Err() != nil {
+			t.Fatalf("unexpected error: %s", resp.Err())
+		}
+	}
+	for _, s := range servers {
+		s.migrations.Wait()
+	}
+
+	var (
+		mu     sync.Mutex
+		events = make(map[string]string)
+	)
+	s1.watchHealth(func(ev healthEvent) {
+		mu.Lock()
+		defer mu.Unlock()
+		events[ev.Node.Addr.String()] = ev.State
+	})
+
+	nodeOf := func(s *server) *Node {
+		for _, node := range s1.Nodes() {
+			if node.Addr.String() == s.laddr.String() {
+				return node
+			}
+		}
+		t.Fatalf("node %s is not a member", s.laddr)
+		return nil
+	}
+
+	n2, n3 := nodeOf(s2), nodeOf(s3)
+	if !s1.probe(n2, time.Second) || s1.stateOf(n2) != NodeAlive {
+		t.Fatalf("node should be alive: %s", s1.stateOf(n2))
+	}
+
+	// The suspected node should refute the suspicion on the next ping.
+	s1.declare(n2, NodeSuspect)
+	if s1.stateOf(n2) != NodeSuspect {
+		t.Fatalf("node should be suspected: %s", s1.stateOf(n2))
+	}
+	if !s1.probe(n2, time.Second) || s1.stateOf(n2) != NodeAlive {
+		t.Fatalf("suspicion should be refuted: %s", s1.stateOf(n2))
+	}
+
+	// The stopped node fails both direct and indirect pings.
+	listeners[2].Close()
+	s3.Stop()
+
+	if s1.probe(n3, 100*time.Millisecond) || s1.stateOf(n3) != NodeSuspect {
+		t.Fatalf("node should be suspected: %s", s1.stateOf(n3))
+	}
+	s1.expire(0)
+	if s1.stateOf(n3) != NodeDead {
+		t.Fatalf("node should be dead: %s", s1.stateOf(n3))
+	}
+
+	mu.Lock()
+	if events[n2.Addr.String()] != NodeAlive || events[n3.Addr.String()] != NodeDead {
+		t.Fatalf("changes of the members state should be emitted: %v", events)
+	}
+	mu.Unlock()
+
+	// The requests to the dead node should fail without waiting.
+	for i := 0; ; i++ {
+		key := fmt.Sprintf("key%d", i)
+		if s1.nodeOfKey(key) != n3 {
+			continue
+		}
+		resp := s1.Do(ctx, &store.RequestStore{Key: key, Data: "a"})
+		if resp.Status != http.StatusServiceUnavailable {
+			t.Fatalf("request to dead node should fail: %v", resp)
+		}
+		break
+	}
+}
@@ -0,0 +1,468 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ybubnov/go-uuid"
+	"github.com/ybubnov/memhashd/container/ring"
+	"github.com/ybubnov/memhashd/container/store"
+	"github.com/ybubnov/memhashd/system/log"
+)
+
+// actionMembers is an action to replace the list of the cluster members.
+const actionMembers = "members"
+
+// requestMembers defines a request to the remote node to replace the
+// list of the cluster members.
+type requestMembers struct {
+	// ID is a request identifier.
+	ID string
+	// Addrs is a list of addresses of the cluster members.
+	Addrs []string
+	// Previous is a list of addresses of the nodes holding the primary
+	// copies of the partitions before the change, in the order of the
+	// partitions.
+	Previous []string
+	// Owners is a list of addresses of the nodes holding the primary
+	// copies of the partitions after the change, in the order of the
+	// partitions.
+	Owners []string
+	// Epoch is an epoch of the members after the change. The change is
+	// rejected by the nodes, which members are of the same or a later
+	// epoch.
+	Epoch uint64
+}
+
+// Action implements message interface.
+func (r *requestMembers) Action() string {
+	return actionMembers
+}
+
+// String implements fmt.Stringer interface.
+func (r *requestMembers) String() string {
+	return fmt.Sprintf("id: %s, type: members, addrs: %v, epoch: %d",
+		r.ID, r.Addrs, r.Epoch)
+}
+
+// Join implements Server interface. The new node receives the list of
+// members first, so the cluster is not changed, when the node is not
+// reachable.
+func (s *server) Join(ctx context.Context, addr *net.TCPAddr) Response {
+	var addrs []string
+	nodes, epoch := s.membership()
+	for _, node := range nodes {
+		if node.Addr.String() == addr.String() {
+			const text = "node %s is already a member of the cluster"
+			err := &store.ErrConflict{Text: fmt.Sprintf(text, addr)}
+			return Response{Status: statusOf(err), Error: err.Error()}
+		}
+		addrs = append(addrs, node.Addr.String())
+	}
+
+	previous, owners := s.assignment(addr.String(), true)
+	req := &requestMembers{
+		ID:       uuid.New(),
+		Addrs:    append(addrs, addr.String()),
+		Previous: previous,
+		Owners:   owners,
+		Epoch:    epoch + 1,
+	}
+	return s.spread(ctx, req, nodes, &Node{Addr: addr})
+}
+
+// Leave implements Server interface. The leaving node receives the list
+// of members as well, so it could move the records to the remaining
+// members of the cluster.
+func (s *server) Leave(ctx context.Context, addr *net.TCPAddr) Response {
+	var (
+		addrs []string
+		found bool
+	)
+
+	nodes, epoch := s.membership()
+	for _, node := range nodes {
+		if node.Addr.String() == addr.String() {
+			found = true
+			continue
+		}
+		addrs = append(addrs, node.Addr.String())
+	}
+
+	if !found {
+		const text = "node %s is not a member of the cluster"
+		err := &store.ErrMissing{Text: fmt.Sprintf(text, addr)}
+		return Response{Status: statusOf(err), Error: err.Error()}
+	}
+	if len(addrs) == 0 {
+		const text = "node %s is the last member of the cluster"
+		err := &store.ErrConflict{Text: fmt.Sprintf(text, addr)}
+		return Response{Status: statusOf(err), Error: err.Error()}
+	}
+
+	previous, owners := s.assignment(addr.String(), false)
+	req := &requestMembers{
+		ID:       uuid.New(),
+		Addrs:    addrs,
+		Previous: previous,
+		Owners:   owners,
+		Epoch:    epoch + 1,
+	}
+	return s.spread(ctx, req, nodes, nil)
+}
+
+// assignment returns the owners of the partitions before and after the
+// insertion (or the removal) of the node with the given address. Only
+// the partitions of the inserted (or removed) node change the owner.
+func (s *server) assignment(addr string, insert bool) (previous, owners []string) {
+	var elements []*ring.Element
+	for _, nodes := range s.partitions() {
+		previous = append(previous, nodes[0].Addr.String())
+		elements = append(elements, &ring.Element{Value: nodes[0].Addr.String()})
+	}
+
+	r := ring.New(len(elements))
+	r.Assign(elements)
+	if insert {
+		r.Insert(&ring.Element{Value: addr})
+	} else {
+		r.Remove(&ring.Element{Value: addr})
+	}
+
+	for _, elements := range r.Partitions(1) {
+		owners = append(owners, elements[0].Value.(string))
+	}
+	return previous, owners
+}
+
+// membership returns the members of the cluster and their epoch.
+func (s *server) membership() (Nodes, uint64) {
+	s.nodesMu.RLock()
+	defer s.nodesMu.RUnlock()
+	return s.nodes, s.epoch
+}
+
+// spread sends the list of members to the given node, to the remote
+// nodes of the cluster and then applies it to the local node. When any
+// of the nodes fails to apply the list, the change is rolled back on the
+// nodes, which applied it, so the members of the nodes do not diverge.
+// The failed and the rolled back members are reported in the response.
+func (s *server) spread(ctx context.Context, req *requestMembers,
+	nodes Nodes, node *Node) Response {
+
+	var targets Nodes
+	if node != nil {
+		targets = append(targets, node)
+	}
+	for _, n := range nodes {
+		if n.conn() != nil {
+			targets = append(targets, n)
+		}
+	}
+
+	var (
+		applied Nodes
+		failure error
+	)
+	for _, n := range targets {
+		if failure = s.deliver(ctx, n, req); failure != nil {
+			log.ErrorLogf("server/MEMBERS",
+				"failed to send %s to %s, %s", req, n.Addr, failure)
+			failure = fmt.Errorf("%s: %s", n.Addr, failure)
+			break
+		}
+		applied = append(applied, n)
+	}
+	if failure == nil {
+		if err := s.members(req); err != nil {
+			failure = fmt.Errorf("%s: %s", s.self().Addr, err)
+		}
+	}
+	if failure == nil {
+		return Response{Status: http.StatusOK}
+	}
+
+	// The nodes, which applied the change, are returned to the previous
+	// members within the next epoch.
+	var addrs []string
+	for _, n := range nodes {
+		addrs = append(addrs, n.Addr.String())
+	}
+	undo := &requestMembers{
+		ID:       uuid.New(),
+		Addrs:    addrs,
+		Previous: req.Owners,
+		Owners:   req.Previous,
+		Epoch:    req.Epoch + 1,
+	}
+
+	errors := []string{failure.Error()}
+	for _, n := range applied {
+		if err := s.deliver(ctx, n, undo); err != nil {
+			log.ErrorLogf("server/MEMBERS",
+				"failed to roll back %s on %s, %s", req, n.Addr, err)
+			errors = append(errors, fmt.Sprintf(
+				"%s: roll back failed, %s", n.Addr, err))
+		}
+	}
+
+	const text = "failed to update members, %s"
+	err := &store.ErrUnavailable{Text: fmt.Sprintf(text,
+		strings.Join(errors, ", "))}
+	return Response{Status: statusOf(err), Error: err.Error()}
+}
+
+// deliver sends the list of members to the node through a dedicated
+// connection. The unreachable node is retried with the backoff, until
+// the retries are exhausted or the context is canceled, the node, which
+// rejected the list, is not retried.
+func (s *server) deliver(ctx context.Context, node *Node,
+	req *requestMembers) error {
+
+	for attempt := 0; ; attempt++ {
+		resp, err := s.roundTripCtx(ctx, node, req)
+		if err == nil {
+			if err = resp.Err(); resp.Status != http.StatusServiceUnavailable {
+				return err
+			}
+		}
+		if attempt >= s.retries {
+			return err
+		}
+
+		select {
+		case <-time.After(s.backoff(attempt)):
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-s.done:
+			return err
+		}
+	}
+}
+
+// handleMembers replaces the members of the cluster with the list sent
+// by the remote node and returns the result to the node.
+func (s *server) handleMembers(ev *eventRequest) Response {
+	var req requestMembers
+	if err := json.Unmarshal(*ev.Request, &req); err != nil {
+		log.ErrorLogf("server/HANDLE",
+			"failed unmarshal request, %s", err)
+		return Response{
+			Status: http.StatusBadRequest,
+			Error:  err.Error(),
+		}
+	}
+
+	resp := Response{Status: http.StatusOK}
+	if err := s.members(&req); err != nil {
+		resp = Response{Status: statusOf(err), Error: err.Error()}
+	}
+	return resp
+}
+
+// ringOf sorts the given nodes and creates a new ring of them.
+func (s *server) ringOf(nodes Nodes) ring.Ring {
+	sort.Sort(nodes)
+	r := ring.New(s.numPartitions)
+	for _, node := range nodes {
+		r.Insert(&ring.Element{Value: node})
+	}
+	return r
+}
+
+// ownersOf returns the nodes of the given addresses.
+func ownersOf(known map[string]*Node, addrs []string) (Nodes, error) {
+	if len(addrs) == 0 {
+		return nil, fmt.Errorf("server: assignment of partitions is empty")
+	}
+
+	nodes := make(Nodes, 0, len(addrs))
+	for _, addr := range addrs {
+		node, ok := known[addr]
+		if !ok {
+			const text = "server: partition is assigned to unknown node %s"
+			return nil, fmt.Errorf(text, addr)
+		}
+		nodes = append(nodes, node)
+	}
+	return nodes, nil
+}
+
+// members replaces the members of the cluster with the nodes of the given
+// addresses. The connections to the new nodes are established before the
+// ring is changed, so the requests are not routed to the unreachable
+// nodes. Each new node is dialed once, the change is rejected, when the
+// node is not reachable, and it is retried by the node spreading the
+// change. The partitions are assigned to the nodes as requested, so all
+// members share the same ring. The changes of the earlier epochs are
+// rejected.
+//
+// The records of the moved partitions are sent to the new owners in
+// background, meanwhile the new owners pull the requested records from
+// the previous ones. When the local node is not in the list, it leaves
+// the cluster after all records are moved to the remaining members.
+func (s *server) members(req *requestMembers) error {
+	s.membersMu.Lock()
+	defer s.membersMu.Unlock()
+
+	if _, epoch := s.membership(); req.Epoch <= epoch {
+		const text = "members of epoch %d are stale, the epoch is %d"
+		return &store.ErrConflict{Text: fmt.Sprintf(text, req.Epoch, epoch)}
+	}
+
+	var (
+		self    = s.self()
+		known   = make(map[string]*Node)
+		current = make(map[string]*Node)
+		nodes   Nodes
+		dialed  Nodes
+		leaving = true
+	)
+
+	for _, node := range s.Nodes() {
+		current[node.Addr.String()] = node
+		known[node.Addr.String()] = node
+	}
+
+	for _, addr := range req.Addrs {
+		if node, ok := current[addr]; ok {
+			delete(current, addr)
+			nodes = append(nodes, node)
+			leaving = leaving && node.conn() != nil
+			continue
+		}
+
+		tcpAddr, err := net.ResolveTCPAddr("tcp", addr)
+		if err != nil {
+			closeNodes(dialed)
+			return err
+		}
+		node := &Node{Addr: tcpAddr}
+		if node.Conn, err = s.dial(node); err != nil {
+			closeNodes(dialed)
+			const text = "failed to dial %s, %s"
+			return &store.ErrUnavailable{Text: fmt.Sprintf(text, addr, err)}
+		}
+
+		log.InfoLogf("server/MEMBERS", "connected to %s", tcpAddr)
+		node.ID = uuid.New()
+		known[addr] = node
+		dialed = append(dialed, node)
+		nodes = append(nodes, node)
+	}
+
+	owners, err := ownersOf(known, req.Owners)
+	if err == nil && len(owners) != s.numPartitions {
+		const text = "server: %d partitions assigned instead of %d"
+		err = fmt.Errorf(text, len(owners), s.numPartitions)
+	}
+	var previous Nodes
+	if err == nil {
+		previous, err = ownersOf(known, req.Previous)
+	}
+	if err != nil {
+		closeNodes(dialed)
+		return err
+	}
+
+	elements := make([]*ring.Element, 0, len(owners))
+	for _, node := range owners {
+		elements = append(elements, &ring.Element{Value: node})
+	}
+
+	r := ring.New(s.numPartitions)
+	r.Assign(elements)
+	sort.Sort(nodes)
+
+	// Previous owners are known before the ring is replaced, so the
+	// records are pulled from the first request to the partition.
+	m := plan(s.partitions(), s.preferences(r), previous)
+	s.expect(s.preferences(r), m.incoming)
+
+	s.nodesMu.Lock()
+	s.nodes, s.ring, s.epoch = nodes, r, req.Epoch
+	s.place()
+	s.nodesMu.Unlock()
+
+	// Close the connections to the removed nodes, the requests are not
+	// routed to them anymore.
+	for _, node := range current {
+		s.release(node)
+	}
+
+	log.InfoLogf("server/MEMBERS", "members changed to %v, %d partitions "+
+		"are moved to the local node", req.Addrs, len(m.incoming))
+
+	s.migrations.Add(1)
+	done := s.track(m)
+	go func() {
+		defer s.migrations.Done()
+		defer done()
+		if err := s.migrate(m); err != nil {
+			log.ErrorLogf("server/MEMBERS",
+				"migration of partitions failed with %s", err)
+			return
+		}
+		if leaving {
+			s.detach(self, req.Epoch)
+		}
+	}()
+	return nil
+}
+
+// detach makes the local node the only member of own cluster, when the
+// records are moved to the remaining members. The node is not detached,
+// when the members are changed again after the leave of the given epoch.
+// The epoch of the detached node starts over, so the node could join any
+// cluster.
+func (s *server) detach(self *Node, epoch uint64) {
+	s.membersMu.Lock()
+	defer s.membersMu.Unlock()
+
+	nodes := Nodes{self}
+	r := s.ringOf(nodes)
+
+	s.nodesMu.Lock()
+	if s.epoch != epoch {
+		s.nodesMu.Unlock()
+		return
+	}
+	removed := s.nodes
+	s.nodes, s.ring, s.epoch = nodes, r, 0
+	s.place()
+	s.nodesMu.Unlock()
+
+	for _, node := range removed {
+		s.release(node)
+	}
+	log.InfoLogf("server/MEMBERS", "detached from the cluster")
+}
+
+// holder reports whether the local node is in the given list of nodes.
+func holder(nodes Nodes) bool {
+	for _, node := range nodes {
+		if node.conn() == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// closeNodes closes the connections to the given remote nodes.
+func closeNodes(nodes Nodes) {
+	for _, node := range nodes {
+		conn := node.conn()
+		if conn == nil {
+			continue
+		}
+		closeWire(conn)
+		log.DebugLogf("server/MEMBERS",
+			"connection to %s closed", node.Addr)
+	}
+}
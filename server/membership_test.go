@@ -0,0 +1,294 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/ybubnov/memhashd/container/hash"
+	"github.com/ybubnov/memhashd/container/ring"
+	"github.com/ybubnov/memhashd/container/store"
+)
+
+// newTestMember creates a single node cluster, which accepts connections
+// from the other nodes.
+func newTestMember(t testing.TB) (*server, net.Listener) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	s := newServer(&Config{NumPartitions: 4})
+	s.laddr = ln.Addr().(*net.TCPAddr)
+	s.nodes = Nodes{{Addr: s.laddr}}
+	s.ring.Insert(&ring.Element{Value: s.nodes[0]})
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go s.handle(conn)
+		}
+	}()
+	return s, ln
+}
+
+func TestServerMembership(t *testing.T) {
+	s1, ln1 := newTestMember(t)
+	defer ln1.Close()
+	s2, ln2 := newTestMember(t)
+	defer ln2.Close()
+	defer s2.Stop()
+
+	keys := make([]string, 16)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("key%d", i)
+		s1.store.Store(keys[i], hash.Record{Data: keys[i]})
+	}
+
+	ctx := context.Background()
+	if resp := s1.Join(ctx, s2.laddr); resp.Err() != nil {
+		t.Fatalf("unexpected error: %s", resp.Err())
+	}
+	if len(s1.Nodes()) != 2 || len(s2.Nodes()) != 2 {
+		t.Fatalf("both nodes should be members: %v, %v", s1.Nodes(), s2.Nodes())
+	}
+	if resp := s2.Join(ctx, s1.laddr); resp.Status != http.StatusConflict {
+		t.Fatalf("existing member should not join: %v", resp)
+	}
+
+	// The records of the moved partitions should be handed off.
+	s1.migrations.Wait()
+	s2.migrations.Wait()
+	if len(s2.incoming) != 0 {
+		t.Fatalf("migration should be completed: %v", s2.incoming)
+	}
+	if len(s2.store.Keys()) == 0 {
+		t.Fatalf("records should be handed off to the new node")
+	}
+	if n := len(s1.store.Keys()) + len(s2.store.Keys()); n != len(keys) {
+		t.Fatalf("invalid number of records: %d", n)
+	}
+	for _, key := range keys {
+		for _, s := range []*server{s1, s2} {
+			resp := s.Do(ctx, &store.RequestLoad{Key: key})
+			if resp.Err() != nil || resp.Record.Data != key {
+				t.Fatalf("invalid record of %s: %v", key, resp)
+			}
+		}
+	}
+
+	// Leave the cluster through the remaining node.
+	if resp := s1.Leave(ctx, s2.laddr); resp.Err() != nil {
+		t.Fatalf("unexpected error: %s", resp.Err())
+	}
+	s2.migrations.Wait()
+	if len(s1.Nodes()) != 1 || len(s2.Nodes()) != 1 {
+		t.Fatalf("nodes should be detached: %v, %v", s1.Nodes(), s2.Nodes())
+	}
+	if n := len(s2.store.Keys()); n != 0 {
+		t.Fatalf("records of the leaving node should be handed off: %d", n)
+	}
+	if n := len(s1.store.Keys()); n != len(keys) {
+		t.Fatalf("invalid number of records: %d", n)
+	}
+
+	if resp := s1.Leave(ctx, s2.laddr); resp.Status != http.StatusNotFound {
+		t.Fatalf("missing member should not leave: %v", resp)
+	}
+	if resp := s1.Leave(ctx, s1.laddr); resp.Status != http.StatusConflict {
+		t.Fatalf("last member should not leave: %v", resp)
+	}
+}
+
+func TestServerMembershipRollback(t *testing.T) {
+	s1, ln1 := newTestMember(t)
+	defer ln1.Close()
+	s2, ln2 := newTestMember(t)
+	defer ln2.Close()
+	defer s2.Stop()
+	s3, ln3 := newTestMember(t)
+	defer ln3.Close()
+	defer s3.Stop()
+
+	ctx := context.Background()
+	if resp := s1.Join(ctx, s2.laddr); resp.Err() != nil {
+		t.Fatalf("unexpected error: %s", resp.Err())
+	}
+	s1.migrations.Wait()
+	s2.migrations.Wait()
+
+	// The stale change of the members is rejected.
+	_, epoch := s2.membership()
+	err := s2.members(&requestMembers{Addrs: []string{s2.laddr.String()}, Epoch: epoch})
+	if _, ok := err.(*store.ErrConflict); !ok {
+		t.Fatalf("stale members should be rejected: %v", err)
+	}
+
+	// The members of the second node are changed concurrently, so the
+	// joined node is rolled back.
+	s2.nodesMu.Lock()
+	s2.epoch += 10
+	s2.nodesMu.Unlock()
+
+	if resp := s1.Join(ctx, s3.laddr); resp.Status != http.StatusServiceUnavailable {
+		t.Fatalf("join should fail: %v", resp)
+	}
+	s3.migrations.Wait()
+	if len(s1.Nodes()) != 2 || len(s2.Nodes()) != 2 || len(s3.Nodes()) != 1 {
+		t.Fatalf("members should be rolled back: %v, %v, %v",
+			s1.Nodes(), s2.Nodes(), s3.Nodes())
+	}
+}
+
+func TestServerMigration(t *testing.T) {
+	s1, ln1 := newTestMember(t)
+	defer ln1.Close()
+	s2, ln2 := newTestMember(t)
+	defer ln2.Close()
+	defer s2.Stop()
+
+	keys := make([]string, 16)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("key%d", i)
+		s1.store.Store(keys[i], hash.Record{Data: keys[i]})
+		s1.store.Store(keys[i], hash.Record{Data: keys[i]})
+	}
+
+	// Only the new node knows about the change, so the records are not
+	// sent by the previous owner and should be pulled on request.
+	previous, owners := s1.assignment(s2.laddr.String(), true)
+	err := s2.members(&requestMembers{
+		Addrs:    []string{s1.laddr.String(), s2.laddr.String()},
+		Previous: previous,
+		Owners:   owners,
+		Epoch:    1,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	s2.migrations.Wait()
+	if len(s2.incoming) == 0 {
+		t.Fatalf("partitions should be moved to the new node")
+	}
+
+	ctx := context.Background()
+	for _, key := range keys {
+		resp := s2.Do(ctx, &store.RequestLoad{Key: key})
+		if resp.Err() != nil || resp.Record.Data != key {
+			t.Fatalf("invalid record of %s: %v", key, resp)
+		}
+	}
+	if len(s2.store.Keys()) == 0 {
+		t.Fatalf("records should be pulled by the new owner")
+	}
+
+	// The changes of the pulled records should continue the history of
+	// the previous owner.
+	var key string
+	for _, k := range keys {
+		if s2.nodeOfKey(k).Conn == nil {
+			key = k
+			break
+		}
+	}
+	s2.store.Delete(key)
+	resp := s2.Do(ctx, &store.RequestStore{Key: key, Data: "x"})
+	if resp.Err() != nil || resp.Record.Meta.Index != 3 {
+		t.Fatalf("record should be pulled before the change: %v", resp)
+	}
+
+	var partitions []int
+	for p := range s2.incoming {
+		partitions = append(partitions, p)
+	}
+	s2.migrated(partitions)
+	if len(s2.incoming) != 0 {
+		t.Fatalf("migration should be completed: %v", s2.incoming)
+	}
+}
+
+func TestServerTakeInflight(t *testing.T) {
+	s1, _, conn := newTestCluster()
+	defer conn.Close()
+
+	// Find the key, which copy is not held by the first node.
+	var key string
+	for i := 0; key == ""; i++ {
+		if k := fmt.Sprintf("key%d", i); s1.nodeOfKey(k).Conn != nil {
+			key = k
+		}
+	}
+	s1.store.Store(key, hash.Record{Data: "a"})
+
+	// The new owner does not read the batch, so it stays in flight.
+	c1, c2 := net.Pipe()
+	errc := make(chan error, 1)
+	go func() {
+		_, err := s1.transferBatch(c1, []string{key})
+		errc <- err
+	}()
+
+	for inflight := 0; inflight == 0; time.Sleep(time.Millisecond) {
+		s1.outgoingMu.Lock()
+		inflight = s1.inflight[key]
+		s1.outgoingMu.Unlock()
+	}
+
+	// The record is taken without waiting for the batch, but it is
+	// not removed, until the batch is sent.
+	if rec, ok := s1.take(key); !ok || rec.Data != "a" {
+		t.Fatalf("record should be taken: %v", rec)
+	}
+	if _, ok := s1.store.Peek(key); !ok {
+		t.Fatalf("record in flight should not be removed")
+	}
+
+	c2.Close()
+	if err := <-errc; err == nil {
+		t.Fatalf("error expected for the failed batch")
+	}
+	if _, ok := s1.take(key); !ok {
+		t.Fatalf("record should be kept after the failed batch")
+	}
+	if _, ok := s1.store.Peek(key); ok {
+		t.Fatalf("taken record should be removed")
+	}
+}
+
+func TestPlan(t *testing.T) {
+	c1, c2 := net.Pipe()
+	defer c1.Close()
+
+	var (
+		local = &Node{}
+		n1    = &Node{Conn: c1}
+		n2    = &Node{Conn: c2}
+	)
+
+	before := []Nodes{{local, n1}, {local, n1}, {n1, local}}
+	after := []Nodes{{local, n2}, {n1, local}, {n1, n2}}
+	m := plan(before, after, Nodes{local, local, n1})
+
+	// The replica of the first partition and the primary copy of the
+	// second one are sent by the local node.
+	if p := m.outgoing[n2]; len(p) != 1 || p[0] != 0 {
+		t.Fatalf("replica should be sent to the new holder: %v", p)
+	}
+	if p := m.outgoing[n1]; len(p) != 1 || p[0] != 1 {
+		t.Fatalf("primary copy should be sent to the new owner: %v", p)
+	}
+	if len(m.dropped) != 1 || !m.dropped[2] {
+		t.Fatalf("replica of the third partition should be dropped: %v",
+			m.dropped)
+	}
+	if len(m.incoming) != 0 {
+		t.Fatalf("no partitions should be moved to the local node: %v",
+			m.incoming)
+	}
+}
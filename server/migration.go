@@ -0,0 +1,480 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/ybubnov/go-uuid"
+	"github.com/ybubnov/memhashd/container/hash"
+	"github.com/ybubnov/memhashd/container/ring"
+	"github.com/ybubnov/memhashd/container/store"
+	"github.com/ybubnov/memhashd/system/log"
+)
+
+const (
+	// actionTake is an action to retrieve the record of the partition,
+	// which is moved to the new owner, before it is sent by the stream.
+	actionTake = "take"
+
+	// actionMigrated is an action to notify the new owner, that all
+	// records of the partitions are sent.
+	actionMigrated = "migrated"
+
+	// migrationBatch is a maximum number of records sent to the new
+	// owner of the partition within a single request.
+	migrationBatch = 256
+)
+
+// migrationActions is a set of actions of the partitions migration.
+var migrationActions = map[string]bool{
+	actionTake:     true,
+	actionMigrated: true,
+}
+
+// requestTake defines a request to the previous owner of the partition
+// to return the record of the key. The record is removed from the
+// previous owner, when it does not hold a copy of the partition anymore.
+type requestTake struct {
+	// ID is a request identifier.
+	ID string
+	// Key is a key of the record.
+	Key string
+}
+
+// Action implements message interface.
+func (r *requestTake) Action() string {
+	return actionTake
+}
+
+// String implements fmt.Stringer interface.
+func (r *requestTake) String() string {
+	return fmt.Sprintf("id: %s, type: take, key: %s", r.ID, r.Key)
+}
+
+// requestMigrated defines a notification of the new owner, that all
+// records of the given partitions are sent.
+type requestMigrated struct {
+	// ID is a request identifier.
+	ID string
+	// Partitions is a list of the partitions indices.
+	Partitions []int
+}
+
+// Action implements message interface.
+func (r *requestMigrated) Action() string {
+	return actionMigrated
+}
+
+// String implements fmt.Stringer interface.
+func (r *requestMigrated) String() string {
+	return fmt.Sprintf("id: %s, type: migrated, partitions: %d",
+		r.ID, len(r.Partitions))
+}
+
+// migration describes the movement of the partitions of the local node
+// caused by the change of the cluster members.
+type migration struct {
+	// outgoing maps the new holders to the partitions, which primary
+	// copy is held by the local node before the change, and either the
+	// primary copy or the replica is moved to them.
+	outgoing map[*Node][]int
+
+	// incoming maps the partitions, which primary copy is moved to the
+	// local node, to the previous owners.
+	incoming map[int]*Node
+
+	// dropped is a set of partitions, which replicas are not held by
+	// the local node anymore.
+	dropped map[int]bool
+}
+
+// plan compares the preference lists of the partitions before and after
+// the change of the members. The previous owners are the holders of the
+// primary copies in the cluster, which might differ from the preference
+// lists of the joining node.
+//
+// The previous holder of the primary copy sends the records to the new
+// holder of the primary copy and to the new holders of the replicas, the
+// other previous holders of the replicas just remove their copies.
+func plan(before, after []Nodes, previous Nodes) *migration {
+	m := &migration{
+		outgoing: make(map[*Node][]int),
+		incoming: make(map[int]*Node),
+		dropped:  make(map[int]bool),
+	}
+
+	for p := range after {
+		switch {
+		case before[p][0].conn() == nil:
+			for ii, node := range after[p] {
+				if node.conn() == nil || (ii != 0 && member(before[p], node)) {
+					continue
+				}
+				m.outgoing[node] = append(m.outgoing[node], p)
+			}
+		case holder(before[p]) && !holder(after[p]):
+			m.dropped[p] = true
+		}
+		if after[p][0].conn() == nil && previous[p].conn() != nil {
+			m.incoming[p] = previous[p]
+		}
+	}
+	return m
+}
+
+// member reports whether the node is in the given list of nodes.
+func member(nodes Nodes, node *Node) bool {
+	for _, n := range nodes {
+		if n == node {
+			return true
+		}
+	}
+	return false
+}
+
+// expect replaces the previous owners of the partitions, which records
+// are pulled on the first access. The partitions, which primary copy is
+// not held by the local node anymore, are not pulled.
+func (s *server) expect(after []Nodes, incoming map[int]*Node) {
+	s.incomingMu.Lock()
+	defer s.incomingMu.Unlock()
+
+	for p, nodes := range after {
+		if nodes[0].conn() != nil {
+			delete(s.incoming, p)
+		}
+	}
+	for p, node := range incoming {
+		s.incoming[p] = node
+	}
+}
+
+// track marks the partitions moved from the local node by the given
+// migration as outgoing, until the returned function is called.
+func (s *server) track(m *migration) func() {
+	var partitions []int
+	for _, pp := range m.outgoing {
+		partitions = append(partitions, pp...)
+	}
+	for p := range m.dropped {
+		partitions = append(partitions, p)
+	}
+
+	s.outgoingMu.Lock()
+	defer s.outgoingMu.Unlock()
+	for _, p := range partitions {
+		s.outgoing[p]++
+	}
+
+	return func() {
+		s.outgoingMu.Lock()
+		defer s.outgoingMu.Unlock()
+		for _, p := range partitions {
+			if s.outgoing[p]--; s.outgoing[p] == 0 {
+				delete(s.outgoing, p)
+			}
+		}
+	}
+}
+
+// migrating reports whether the records of the given partition are being
+// moved to or from the local node.
+func (s *server) migrating(p int) bool {
+	s.incomingMu.Lock()
+	_, incoming := s.incoming[p]
+	s.incomingMu.Unlock()
+
+	s.outgoingMu.Lock()
+	defer s.outgoingMu.Unlock()
+	return incoming || s.outgoing[p] != 0
+}
+
+// migrate sends the records of the outgoing partitions to their new
+// owners and removes the records of the dropped partitions. The new
+// owners are notified, when all records of the partitions are sent.
+func (s *server) migrate(m *migration) error {
+	r, _ := s.topology()
+	targets := make(map[int]bool)
+	for _, partitions := range m.outgoing {
+		for _, p := range partitions {
+			targets[p] = true
+		}
+	}
+
+	keys := make(map[int][]string)
+	for _, key := range s.store.Keys() {
+		p := r.Partition(ring.StringHasher(key))
+		if m.dropped[p] {
+			s.store.Delete(key)
+			continue
+		}
+		if targets[p] {
+			keys[p] = append(keys[p], key)
+		}
+	}
+
+	var errors []string
+	for node, partitions := range m.outgoing {
+		n, err := s.transfer(node, partitions, keys)
+		log.InfoLogf("server/MIGRATE",
+			"%d records of %d partitions moved to %s",
+			n, len(partitions), node.Addr)
+		if err != nil {
+			log.ErrorLogf("server/MIGRATE",
+				"migration to %s failed with %s", node.Addr, err)
+			errors = append(errors, fmt.Sprintf("%s: %s", node.Addr, err))
+		}
+	}
+
+	if errors != nil {
+		const text = "failed to migrate partitions, %s"
+		return &store.ErrUnavailable{Text: fmt.Sprintf(text,
+			strings.Join(errors, ", "))}
+	}
+	return nil
+}
+
+// transfer sends the records of the partitions to the new owner through
+// a dedicated connection, so the shared connection is not blocked by the
+// stream. It returns the number of sent records.
+func (s *server) transfer(node *Node, partitions []int,
+	keys map[int][]string) (int, error) {
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-s.done:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	conn, closeConn, err := s.dialCtx(ctx, node)
+	if err != nil {
+		return 0, err
+	}
+	defer closeConn()
+
+	var sent int
+	for _, p := range partitions {
+		for pkeys := keys[p]; len(pkeys) != 0; {
+			n := migrationBatch
+			if n > len(pkeys) {
+				n = len(pkeys)
+			}
+
+			m, err := s.transferBatch(conn, pkeys[:n])
+			if sent += m; err != nil {
+				return sent, err
+			}
+			pkeys = pkeys[n:]
+		}
+	}
+
+	req := &requestMigrated{ID: uuid.New(), Partitions: partitions}
+	resp, err := s.exchange(conn, req, "")
+	if err == nil {
+		err = resp.Err()
+	}
+	return sent, err
+}
+
+// transferBatch sends the records of the given keys through the given
+// connection and removes the records, which copies are not held by the
+// local node anymore. The lock is not held during the round trip, so the
+// records are taken by the new owners meanwhile, but the keys are marked
+// in flight, so the records are removed only after they are sent.
+func (s *server) transferBatch(conn net.Conn, keys []string) (int, error) {
+	var changes []store.Event
+	s.outgoingMu.Lock()
+	for _, key := range keys {
+		if rec, ok := s.store.Peek(key); ok {
+			changes = append(changes, store.Event{
+				Type: store.EventStore, Key: key, Record: rec})
+			s.inflight[key]++
+		}
+	}
+	s.outgoingMu.Unlock()
+
+	if len(changes) == 0 {
+		return 0, nil
+	}
+
+	req := &store.RequestReplicate{ID: uuid.New(), Changes: changes}
+	resp, err := s.exchange(conn, req, "")
+	if err == nil {
+		err = resp.Err()
+	}
+
+	s.outgoingMu.Lock()
+	defer s.outgoingMu.Unlock()
+	for _, ev := range changes {
+		if s.inflight[ev.Key]--; s.inflight[ev.Key] == 0 {
+			delete(s.inflight, ev.Key)
+		}
+		if err == nil && !holder(s.nodesOfKey(ev.Key)) {
+			s.store.Delete(ev.Key)
+		}
+	}
+	if err != nil {
+		return 0, err
+	}
+	return len(changes), nil
+}
+
+// take returns the record of the given key to the new owner of the
+// partition. The record is removed, when the local node does not hold
+// a copy of the partition anymore, unless the record is in flight.
+func (s *server) take(key string) (hash.Record, bool) {
+	s.outgoingMu.Lock()
+	defer s.outgoingMu.Unlock()
+
+	rec, ok := s.store.Peek(key)
+	if ok && s.inflight[key] == 0 && !holder(s.nodesOfKey(key)) {
+		s.store.Delete(key)
+	}
+	return rec, ok
+}
+
+// pull retrieves the records of the request keys, which belong to the
+// incoming partitions and are missing in the local store, from the
+// previous owners of the partitions. So the requests are served by both
+// owners, until all records of the partition are moved.
+func (s *server) pull(req store.Request) {
+	var keys []string
+	if mreq, ok := req.(store.MultiKeyRequest); ok {
+		keys = mreq.Keys()
+	} else if key := req.Hash(); key != "" {
+		keys = []string{key}
+	}
+
+	// The previous owners are looked up under the lock, but the records
+	// are taken without it, so the requests to the other partitions are
+	// not blocked by the round trips.
+	type source struct {
+		key  string
+		node *Node
+	}
+
+	var sources []source
+	r, _ := s.topology()
+	s.incomingMu.Lock()
+	for _, key := range keys {
+		if node, ok := s.incoming[r.Partition(ring.StringHasher(key))]; ok {
+			sources = append(sources, source{key, node})
+		}
+	}
+	s.incomingMu.Unlock()
+
+	for _, src := range sources {
+		key, node := src.key, src.node
+		if _, ok := s.store.Peek(key); ok {
+			continue
+		}
+
+		treq := &requestTake{ID: uuid.New(), Key: key}
+		resp, err := s.roundTrip(node, treq, "")
+		if err == nil {
+			err = resp.Err()
+		}
+		if _, missing := err.(*store.ErrMissing); missing {
+			continue
+		}
+		if err != nil {
+			log.ErrorLogf("server/MIGRATE",
+				"failed to take %s from %s, %s", key, node.Addr, err)
+			continue
+		}
+
+		// The record is replicated, since the local node holds the
+		// primary copy of the partition. The record is not restored,
+		// when it is changed locally during the round trip.
+		rreq := &store.RequestReplicate{ID: uuid.New(), Changes: []store.Event{{
+			Type: store.EventStore, Key: key, Record: resp.Record}}}
+		if _, changes, err := s.store.ServeChanges(rreq); err == nil {
+			s.replicate(context.Background(), changes)
+		}
+	}
+}
+
+// migrated completes the migration of the given partitions. The
+// connections to the previous owners, which are not members of the
+// cluster anymore, are closed.
+func (s *server) migrated(partitions []int) {
+	var sources Nodes
+	s.incomingMu.Lock()
+	for _, p := range partitions {
+		if node, ok := s.incoming[p]; ok {
+			sources = append(sources, node)
+			delete(s.incoming, p)
+		}
+	}
+	s.incomingMu.Unlock()
+
+	for _, node := range sources {
+		s.release(node)
+	}
+}
+
+// release closes the connection to the given node, unless it is a member
+// of the cluster or the records of the incoming partitions are still
+// pulled from it.
+func (s *server) release(node *Node) {
+	for _, n := range s.Nodes() {
+		if n == node {
+			return
+		}
+	}
+
+	s.incomingMu.Lock()
+	defer s.incomingMu.Unlock()
+	for _, source := range s.incoming {
+		if source == node {
+			return
+		}
+	}
+	closeNodes(Nodes{node})
+}
+
+// handleMigration serves the requests of the partitions migration sent
+// by the remote node and returns the response to the node.
+func (s *server) handleMigration(ev *eventRequest) Response {
+	var (
+		resp = Response{Status: http.StatusOK}
+		err  error
+	)
+
+	switch ev.Action {
+	case actionTake:
+		var req requestTake
+		if err = json.Unmarshal(*ev.Request, &req); err == nil {
+			rec, ok := s.take(req.Key)
+			if !ok {
+				const text = "record of %s is missing"
+				merr := &store.ErrMissing{Text: fmt.Sprintf(text, req.Key)}
+				resp = Response{Status: statusOf(merr), Error: merr.Error()}
+			}
+			resp.Record = rec
+		}
+	case actionMigrated:
+		var req requestMigrated
+		if err = json.Unmarshal(*ev.Request, &req); err == nil {
+			s.migrated(req.Partitions)
+		}
+	}
+
+	if err != nil {
+		log.ErrorLogf("server/HANDLE",
+			"failed unmarshal request, %s", err)
+		return Response{
+			Status: http.StatusBadRequest,
+			Error:  err.Error(),
+		}
+	}
+	return resp
+}
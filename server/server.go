@@ -8,6 +8,7 @@ import (
 	"io"
 	"net"
 	"net/http"
+	"path/filepath"
 	"sort"
 	"strings"
 	"sync"
@@ -15,6 +16,8 @@ import (
 
 	"github.com/ybubnov/go-uuid"
 	"github.com/ybubnov/memhashd/container/hash"
+	"github.com/ybubnov/memhashd/container/merkle"
+	"github.com/ybubnov/memhashd/container/pubsub"
 	"github.com/ybubnov/memhashd/container/ring"
 	"github.com/ybubnov/memhashd/container/store"
 	"github.com/ybubnov/memhashd/system/log"
@@ -32,10 +35,73 @@ type Node struct {
 	// Conn represents a connection instance to the remote node of
 	// the cluster.
 	Conn net.Conn `json"-"`
-	// Mutex is used for a mutually exclusive access to the remote
-	// instance. Each round-trip request should lock a communication
-	// channel before processing a request.
+
+	// Primaries is a number of partitions, where the node holds the
+	// primary copy of the records.
+	Primaries int `json:",omitempty"`
+
+	// Replicas is a number of partitions, where the node holds the
+	// replica of the records.
+	Replicas int `json:",omitempty"`
+
+	// State is a state of the node detected by the failure detector,
+	// it is empty, when the failure detection is disabled.
+	State string `json:",omitempty"`
+
+	// Down is set, when the connection to the remote node is broken.
+	// The requests to the node fail, until the connection is
+	// re-established by the supervisor.
+	down bool
+
+	// Requests sent through the connection, which wait for responses,
+	// by the correlation identifier. The responses are dispatched by
+	// the reader of the connection.
+	pending map[uint64]*waiter
+	// A correlation identifier of the last sent request.
+	seq uint64
+	// A connection, which responses are read by the reader.
+	reading net.Conn
+
+	// Changes waiting to be sent to the node holding the replicas. The
+	// queue is drained by a single sender, so the changes are applied
+	// by the replica in the order of the primary copy.
+	queue   []*replication
+	sending bool
+	queueMu sync.Mutex
+
+	// Mutex is used for a mutually exclusive access to the connection
+	// and the pending requests of the remote instance.
 	mu sync.Mutex
+	// The requests are written to the connection under the write lock,
+	// but the responses are awaited without it, so many requests could
+	// be in flight at once.
+	wmu sync.Mutex
+}
+
+// conn returns the connection to the node, it is nil for the local node.
+// The connection is read under the node lock, since it is replaced, when
+// the broken connection is re-established.
+func (n *Node) conn() net.Conn {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.Conn
+}
+
+// waiter is a request waiting for the response from the remote node.
+type waiter struct {
+	// conn is a connection used to send the request.
+	conn net.Conn
+	// C receives the response, it is closed without response, when the
+	// connection breaks.
+	C chan *Response
+}
+
+// replication is a batch of changes queued for the node holding the
+// replicas.
+type replication struct {
+	changes []store.Event
+	// C receives the result of the replication.
+	C chan<- error
 }
 
 // Nodes is a list of cluster nodes. This types is used to order the
@@ -71,6 +137,24 @@ type eventRequest struct {
 	// multiple implementations of the Request type, therefore we have to
 	// use a raw format.
 	Request *json.RawMessage
+
+	// Consistency is a consistency level of the request, it defines
+	// how many copies of the record should acknowledge the request.
+	Consistency store.Consistency `json:",omitempty"`
+
+	// CorrelationID identifies the request among the requests sent
+	// through the same connection, the response carries the same
+	// identifier.
+	CorrelationID uint64 `json:",omitempty"`
+}
+
+// message is a message sent to the remote node, the action of the
+// message defines how the remote node decodes it.
+type message interface {
+	fmt.Stringer
+
+	// Action returns an action of the message.
+	Action() string
 }
 
 // Response defines an envelope of the response being exchanged between
@@ -90,6 +174,38 @@ type Response struct {
 	// Record is hash-table record, it keeps important metadata, like
 	// creation, access and update time as well as expiration timeout.
 	Record hash.Record
+
+	// Responses is a list of responses to the individual requests of
+	// the batch request or transaction, in the same order as requests.
+	Responses []*Response `json:",omitempty"`
+
+	// Event is a type of the record change, it is set only in response
+	// to the watch request and in the stream of changes.
+	Event string `json:",omitempty"`
+
+	// Key is a key of the changed record, it is set only in the stream
+	// of changes.
+	Key string `json:",omitempty"`
+
+	// Receivers is a number of subscribers received the published
+	// message, it is set only in response to the publish request.
+	Receivers int `json:",omitempty"`
+
+	// Digests is a list of the root hashes of the partition trees, it
+	// is set only in response to the digest request.
+	Digests map[int]uint64 `json:",omitempty"`
+
+	// Tree is a hash tree of the partition, it is set only in response
+	// to the tree request.
+	Tree *merkle.Tree `json:",omitempty"`
+
+	// Health is a list of the states of the cluster members, it is set
+	// only in response to the ping request.
+	Health []Health `json:",omitempty"`
+
+	// CorrelationID is an identifier of the request, which the response
+	// belongs to.
+	CorrelationID uint64 `json:",omitempty"`
 }
 
 // Err returns an error instance, when the request finished with an
@@ -101,6 +217,36 @@ func (r *Response) Err() error {
 	return nil
 }
 
+// NodeError is an error occurred on a single node of the cluster.
+type NodeError struct {
+	// Addr is an address of the failed node.
+	Addr string
+
+	// Error is an error message returned by the node.
+	Error string
+}
+
+// KeysResponse is a list of keys stored on all nodes of the cluster.
+type KeysResponse struct {
+	// Keys is a sorted list of unique keys retrieved from the nodes.
+	Keys []string
+
+	// Errors is a list of nodes failed to return the keys. The keys
+	// of these nodes are missing in the response.
+	Errors []NodeError
+}
+
+// PublishResponse is a result of publishing a message to all nodes of
+// the cluster.
+type PublishResponse struct {
+	// Receivers is a total number of subscribers received the message.
+	Receivers int
+
+	// Errors is a list of nodes failed to deliver the message to their
+	// subscribers.
+	Errors []NodeError
+}
+
 // Server describes key-value server type.
 type Server interface {
 	// ID returns a server identifier.
@@ -117,10 +263,65 @@ type Server interface {
 	// response with a requested data.
 	Do(ctx context.Context, r store.Request) Response
 
+	// Events returns a stream of changes of the records with the given
+	// key prefix stored on all nodes in a cluster. The channel is closed,
+	// when the context is canceled or any of the nodes fails to stream
+	// the changes.
+	Events(ctx context.Context, prefix string) <-chan *Response
+
+	// Keys returns a list of keys stored on all nodes in a cluster.
+	// Failures of the individual nodes do not fail the whole call,
+	// they are reported in the response instead.
+	Keys(ctx context.Context) KeysResponse
+
+	// Publish sends the message to the subscribers of the channel on
+	// all nodes in a cluster. Failures of the individual nodes do not
+	// fail the whole call, they are reported in the response instead.
+	Publish(ctx context.Context, channel string, data interface{}) PublishResponse
+
+	// Subscribe returns a stream of messages published to the channel
+	// on any node in a cluster. The channel is closed, when the context
+	// is canceled or the subscriber falls behind the publishers.
+	Subscribe(ctx context.Context, channel string) <-chan *pubsub.Message
+
+	// Join adds a node with the given address to the cluster. The
+	// updated list of nodes is sent to all members of the cluster, so
+	// the node could be added through any member.
+	Join(ctx context.Context, addr *net.TCPAddr) Response
+
+	// Leave removes a node with the given address from the cluster. The
+	// records are handed off to the remaining members, before the node
+	// is detached from the cluster.
+	Leave(ctx context.Context, addr *net.TCPAddr) Response
+
+	// Divergent returns a list of partitions, which copies differ from
+	// the primary copy. Failures of the individual nodes do not fail the
+	// whole call, they are reported in the response instead.
+	Divergent(ctx context.Context) PartitionsResponse
+
 	// Stop stops the server an all established neighbor connections.
 	Stop() error
 }
 
+const (
+	// snapshotName is a name of the snapshot file in the data directory.
+	snapshotName = "memhashd.snapshot"
+
+	// watchTimeout is a timeout of the watch request redirected to the
+	// remote node. The request is repeated after the timeout, so the
+	// remote node releases the resources of the abandoned requests.
+	watchTimeout = 30 * time.Second
+
+	// defaultIOTimeout is a maximum duration of writing a message to
+	// the remote node and of waiting for the response from it.
+	defaultIOTimeout = 30 * time.Second
+
+	// orderedBacklog is a maximum number of the replication and
+	// migration requests of a single connection waiting to be served,
+	// the next requests are not read, until the backlog is drained.
+	orderedBacklog = 64
+)
+
 // Config describes configuration of the key-value server.
 type Config struct {
 	// LocalAddr is an address to listen to for a server.
@@ -134,16 +335,81 @@ type Config struct {
 	// should be greater than zero.
 	NumPartitions int
 
+	// ReplicationFactor defines a number of distinct nodes holding the
+	// copies of each partition. When less than two, the records are
+	// not replicated.
+	ReplicationFactor int
+
+	// AntiEntropyInterval is an interval between the comparisons of the
+	// partition copies with the replicas. When zero, the copies are not
+	// compared in background.
+	AntiEntropyInterval time.Duration
+
+	// ProbeInterval is an interval between the pings of the cluster
+	// members by the failure detector. When zero, the failures of the
+	// members are not detected.
+	ProbeInterval time.Duration
+
 	// NumRetries defines an amount of retries to the remove shards
 	// before giving up on attempts to establish connections.
 	NumRetries int
 
+	// MaxMemory is a maximum estimated amount of memory in bytes
+	// occupied by the records of the local store. When zero, the
+	// amount of memory is not limited.
+	MaxMemory int64
+
+	// MaxKeys is a maximum number of keys in the local store. When
+	// zero, the number of keys is not limited.
+	MaxKeys int
+
+	// EvictionPolicy defines which records are evicted from the local
+	// store, when the limits are exceeded.
+	EvictionPolicy store.EvictionPolicy
+
+	// DataDir is a directory used to persist the snapshots of the local
+	// store. When empty, the records are kept only in memory.
+	DataDir string
+
+	// SnapshotInterval is an interval between the snapshots of the local
+	// store. When zero, the snapshot is saved only on server stop.
+	SnapshotInterval time.Duration
+
+	// Journal records modifications of the local store. The journal is
+	// replayed on server start, when nil, modifications are not recorded.
+	Journal *store.Journal
+
 	// Path to TLS certificate and key files. When both values are not
 	// empty these parameters will be used to configure TLS.
 	TLSCertFile string
 	TLSKeyFile  string
 }
 
+func (c *Config) replicationFactor() int {
+	if c.ReplicationFactor > 1 {
+		return c.ReplicationFactor
+	}
+	return 1
+}
+
+// consistencyKey is a key of the consistency level in the context.
+type consistencyKey struct{}
+
+// WithConsistency returns a copy of the context with the consistency
+// level of the requests processed within the context.
+func WithConsistency(ctx context.Context, level store.Consistency) context.Context {
+	return context.WithValue(ctx, consistencyKey{}, level)
+}
+
+// consistencyOf returns a consistency level stored in the context. By
+// default a single copy of the record is required.
+func consistencyOf(ctx context.Context) store.Consistency {
+	if level, ok := ctx.Value(consistencyKey{}).(store.Consistency); ok {
+		return level
+	}
+	return store.ConsistencyOne
+}
+
 // statusOf translates an error into a response status code.
 func statusOf(err error) int {
 	switch err.(type) {
@@ -155,6 +421,10 @@ func statusOf(err error) int {
 		return http.StatusConflict
 	case *store.ErrMissing:
 		return http.StatusNotFound
+	case *store.ErrFull:
+		return http.StatusInsufficientStorage
+	case *store.ErrUnavailable:
+		return http.StatusServiceUnavailable
 	}
 	return http.StatusInternalServerError
 }
@@ -173,14 +443,79 @@ type server struct {
 	// A list of cluster nodes.
 	nodes   Nodes
 	nodesMu sync.RWMutex
-	retries int
+	// An epoch of the cluster members, it is increased by each change of
+	// the members, so the stale changes are rejected. The changes of the
+	// members are applied one at a time.
+	epoch     uint64
+	membersMu sync.Mutex
+	retries   int
+	// An interval to wait after the first failed attempt to dial a
+	// node, the interval is doubled after each next failure.
+	dialBackoff time.Duration
+	// A maximum duration of the input and output operations with the
+	// remote nodes, the connection is considered broken, when the node
+	// does not respond in time.
+	ioTimeout time.Duration
 
 	// A ring, that implements virtual consistent hashing approach
 	// of balancing the load across the cluster of multiple nodes.
 	ring ring.Ring
+	// A number of copies of each partition.
+	replicas int
+	// A number of partitions of the ring.
+	numPartitions int
+	// A ring used to find the partitions of the keys, the partition of
+	// the key does not depend on the members of the cluster.
+	partitioner ring.Ring
+	// Hash trees of the partitions by index, maintained on each change
+	// of the local store, they are compared with the replicas. The keys
+	// of the local store are indexed by partition along with the trees.
+	trees   []*merkle.Tree
+	keys    []map[string]struct{}
+	treesMu sync.Mutex
+	// An interval between the repairs of the partition copies.
+	entropyInterval time.Duration
+	// An interval between the pings of the cluster members.
+	probeInterval time.Duration
 
 	// Store is an actual storage of the server.
 	store store.Store
+	// Snapshotter persists the records of the store to the disk, it is
+	// nil, when the data directory is not configured.
+	snapshotter *store.Snapshotter
+	// Journal of the store modifications, it is nil, when the journal
+	// is disabled.
+	journal *store.Journal
+
+	// Broker delivers the published messages to the local subscribers.
+	broker *pubsub.Broker
+
+	// Previous owners of the partitions, which primary copy is moved
+	// to the local node. The records missing in the local store are
+	// pulled from them, until the migration is completed.
+	incoming   map[int]*Node
+	incomingMu sync.Mutex
+	// Partitions moved from the local node by the migrations in progress,
+	// the copies of these partitions are not repaired. The keys of the
+	// records being sent to the new owners are in flight, such records
+	// are removed by the sender, when they are taken by the new owners.
+	outgoing   map[int]int
+	inflight   map[string]int
+	outgoingMu sync.Mutex
+	// Migrations of the partitions from the local node in progress.
+	migrations sync.WaitGroup
+
+	// Health of the cluster members by address, maintained by the
+	// failure detector, and the functions called on each change of
+	// the member state.
+	health         map[string]*healthEntry
+	healthWatchers []func(healthEvent)
+	healthMu       sync.Mutex
+
+	// Done is closed, when the server is stopped, so the background
+	// processes could exit.
+	done     chan struct{}
+	stopOnce sync.Once
 
 	// TLS configuration used to setup an encryption for a channels
 	// between nodes in a cluster.
@@ -191,18 +526,59 @@ type server struct {
 // newServer creates a new instance of the clustered key-value server
 // according to the specified configuration.
 func newServer(config *Config) *server {
-	return &server{
+	s := &server{
 		id:          uuid.New(),
 		nodes:       config.Nodes,
 		laddr:       config.LocalAddr,
 		ring:        ring.New(config.NumPartitions),
+		replicas:    config.replicationFactor(),
 		retries:     config.NumRetries,
+		dialBackoff: defaultBackoff,
+		ioTimeout:   defaultIOTimeout,
 		tlsCertFile: config.TLSCertFile,
 		tlsKeyFile:  config.TLSKeyFile,
-		store: store.New(&store.Config{
-			Capacity: config.NumPartitions,
-		}),
+		journal:     config.Journal,
+		broker:      pubsub.New(),
+		incoming:    make(map[int]*Node),
+		outgoing:    make(map[int]int),
+		inflight:    make(map[string]int),
+		health:      make(map[string]*healthEntry),
+		done:        make(chan struct{}),
+
+		numPartitions:   config.NumPartitions,
+		partitioner:     ring.New(config.NumPartitions),
+		trees:           make([]*merkle.Tree, config.NumPartitions),
+		keys:            make([]map[string]struct{}, config.NumPartitions),
+		entropyInterval: config.AntiEntropyInterval,
+		probeInterval:   config.ProbeInterval,
+	}
+
+	for p := range s.trees {
+		s.trees[p] = merkle.New(treeDepth)
+		s.keys[p] = make(map[string]struct{})
 	}
+
+	s.store = store.New(&store.Config{
+		Capacity:       config.NumPartitions,
+		MaxMemory:      config.MaxMemory,
+		MaxKeys:        config.MaxKeys,
+		EvictionPolicy: config.EvictionPolicy,
+		Journal:        config.Journal,
+		Removed:        s.removed,
+		Changed:        s.changed,
+	})
+
+	// Changes of the members state are applied to the routing of the
+	// requests.
+	s.watchHealth(s.route)
+
+	if config.DataDir != "" {
+		s.snapshotter = store.NewSnapshotter(s.store, &store.SnapshotConfig{
+			Path:     filepath.Join(config.DataDir, snapshotName),
+			Interval: config.SnapshotInterval,
+		})
+	}
+	return s
 }
 
 // New creates a new instance of the Server. By default it is a sharded
@@ -219,6 +595,15 @@ func (s *server) Nodes() Nodes {
 	return s.nodes
 }
 
+// topology returns the ring and the list of nodes in a cluster, the
+// values of the ring elements are the nodes of the list. Both are
+// replaced altogether, when the members of the cluster change.
+func (s *server) topology() (ring.Ring, Nodes) {
+	s.nodesMu.RLock()
+	defer s.nodesMu.RUnlock()
+	return s.ring, s.nodes
+}
+
 // ID returns a server identifier.
 func (s *server) ID() string {
 	return s.id
@@ -268,8 +653,8 @@ func (s *server) joinN(nodes Nodes) error {
 	if errors != nil {
 		for _, node := range s.nodes {
 			defer func(n *Node) {
-				if n.Conn != nil {
-					n.Conn.Close()
+				if conn := n.conn(); conn != nil {
+					closeWire(conn)
 				}
 				log.DebugLogf("server/JOIN",
 					"connection to %s closed", n.Addr)
@@ -288,37 +673,43 @@ func (s *server) joinN(nodes Nodes) error {
 // According to the server configuration, it will attempt multiple
 // time, increasing a sleep interval twice after each failure.
 func (s *server) join(node *Node) (net.Conn, error) {
-	var (
-		retries int
-		backoff = time.Second
-	)
-
-	for retries <= s.retries {
+	for retries := 0; retries <= s.retries; retries++ {
 		log.DebugLogf("server/JOIN", "dialing %s node", node.Addr)
-		config := netutil.TLSConfig(s.tlsCertFile, s.tlsKeyFile)
-
-		laddr := &net.TCPAddr{IP: net.IPv4zero}
-		conn, err := netutil.Dial(laddr, node.Addr, config)
+		conn, err := s.dial(node)
 		if err == nil {
-			// Write as a hello-message a local IP address as an
-			// identifier of the
 			return conn, nil
 		}
 
 		if retries < s.retries {
 			const text = "dialing of %s failed, %s, next attempt in %s"
+			backoff := s.backoff(retries)
 			log.ErrorLogf("server/JOIN", text, node.Addr, err, backoff)
 			time.Sleep(backoff)
 		}
-		// Increase interval twice after each failure.
-		backoff *= 2
-		retries++
 	}
 
 	const text = "server: all connection attempts failed to %s"
 	return nil, fmt.Errorf(text, node.Addr)
 }
 
+// dial makes a single attempt to establish a connection to the node. The
+// attempt fails, when the connection is not established within the I/O
+// timeout.
+func (s *server) dial(node *Node) (net.Conn, error) {
+	return s.dialContext(context.Background(), node)
+}
+
+// dialContext makes a single attempt to establish a connection to the node
+// like dial, but the attempt is aborted, when the context is canceled.
+func (s *server) dialContext(ctx context.Context, node *Node) (net.Conn, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.ioTimeout)
+	defer cancel()
+
+	config := netutil.TLSConfig(s.tlsCertFile, s.tlsKeyFile)
+	laddr := &net.TCPAddr{IP: net.IPv4zero}
+	return netutil.DialContext(ctx, laddr, node.Addr, config)
+}
+
 // listenAndServe starts a listener on the configured endpoint. This
 // listener is used for communication with the rest of the nodes in a
 // cluster.
@@ -355,45 +746,151 @@ func (s *server) listenAndServe() (err error) {
 	return nil
 }
 
-// handle handles requests from the remote nodes.
+// handle handles requests from the remote nodes. The requests are served
+// concurrently, so a single connection carries many requests at once. The
+// responses are written in the order of completion, each response holds
+// the correlation identifier of the request.
+//
+// The replication and migration requests are served one by one in the
+// order of arrival, so the changes are applied in the order of sending.
 func (s *server) handle(conn net.Conn) {
-	// Close connection when the handling is finished.
+	var (
+		wg sync.WaitGroup
+		mu sync.Mutex
+		// The decoder is kept for the whole connection, since it reads
+		// ahead the requests sent without waiting for the responses.
+		decoder = json.NewDecoder(conn)
+		ordered = make(chan *eventRequest, orderedBacklog)
+	)
+
+	respond := func(ev *eventRequest) {
+		resp := s.dispatch(ev)
+		resp.CorrelationID = ev.CorrelationID
+
+		mu.Lock()
+		defer mu.Unlock()
+		conn.SetWriteDeadline(time.Now().Add(s.ioTimeout))
+		if err := s.writeWire(conn, &resp); err != nil {
+			log.ErrorLogf("server/HANDLE",
+				"submission of response failed with %s", err)
+		}
+	}
+
+	wg.Add(1)
+	go func(queue <-chan *eventRequest) {
+		defer wg.Done()
+		for ev := range queue {
+			respond(ev)
+		}
+	}(ordered)
+
+	// Close connection when the handling is finished, the responses to
+	// the pending requests are written before.
 	defer conn.Close()
+	defer wg.Wait()
+	defer func() {
+		if ordered != nil {
+			close(ordered)
+		}
+	}()
 
 	for {
 		var ev eventRequest
-		if err := s.readWire(conn, &ev); err != nil {
+		if err := decoder.Decode(&ev); err != nil {
 			log.ErrorLogf("server/HANDLE",
 				"reading of request failed with %s", err)
 			break
 		}
-		// Create a new request instance based on the retrieved action.
-		req, err := store.MakeRequest(ev.Action)
-		if err != nil {
-			log.ErrorLogf("server/HANDLE", err.Error())
-			continue
+		// The connection is dedicated to the stream of changes, so
+		// the connection is closed after the end of the stream.
+		if ev.Action == store.ActionSubscribe {
+			var sub store.RequestSubscribe
+			if err := json.Unmarshal(*ev.Request, &sub); err != nil {
+				log.ErrorLogf("server/HANDLE",
+					"failed unmarshal request, %s", err)
+				break
+			}
+			close(ordered)
+			ordered = nil
+			wg.Wait()
+
+			conn.SetWriteDeadline(time.Time{})
+			s.stream(conn, &sub)
+			break
 		}
-		if err = json.Unmarshal(*ev.Request, req); err != nil {
-			log.ErrorLogf("server/HANDLE",
-				"failed unmarshal request, %s", err)
+
+		if ev.Action == store.ActionReplicate || migrationActions[ev.Action] {
+			ordered <- &ev
 			continue
 		}
-		resp := s.Do(context.Background(), req)
-		if err := s.writeWire(conn, resp); err != nil {
-			log.ErrorLogf("server/HANDLE",
-				"submission of response %s failed with %s", req, err)
-			break
-		}
+
+		wg.Add(1)
+		go func(ev *eventRequest) {
+			defer wg.Done()
+			respond(ev)
+		}(&ev)
 	}
 
 	log.DebugLogf("server/HANDLE",
 		"closing remote connection: %s", conn.RemoteAddr())
 }
 
+// dispatch serves the request of the remote node according to the action
+// of the request.
+func (s *server) dispatch(ev *eventRequest) Response {
+	switch {
+	// Messages of the channels are not related to the store, so they
+	// are delivered to the local subscribers directly.
+	case ev.Action == pubsub.ActionPublish:
+		return s.handlePublish(ev)
+	// Messages of the anti-entropy process are served from the hash
+	// trees of the partitions.
+	case entropyActions[ev.Action]:
+		return s.handleEntropy(ev)
+	// Pings of the failure detector are answered with the states of
+	// the cluster members.
+	case gossipActions[ev.Action]:
+		return s.handleGossip(ev)
+	// Migration of the partitions moves the records between the
+	// previous and the new owners.
+	case migrationActions[ev.Action]:
+		return s.handleMigration(ev)
+	// Membership changes replace the ring of the local node.
+	case ev.Action == actionMembers:
+		return s.handleMembers(ev)
+	}
+
+	// Create a new request instance based on the retrieved action.
+	req, err := store.MakeRequest(ev.Action)
+	if err == nil {
+		err = json.Unmarshal(*ev.Request, req)
+	}
+	if err != nil {
+		log.ErrorLogf("server/HANDLE",
+			"failed unmarshal request, %s", err)
+		return Response{
+			Status: http.StatusBadRequest,
+			Error:  err.Error(),
+		}
+	}
+
+	ctx := context.Background()
+	if ev.Consistency != "" {
+		ctx = WithConsistency(ctx, ev.Consistency)
+	}
+	return s.doRemote(ctx, req)
+}
+
 // Start implements Server interface. It starts a listener for
 // communication with remote nodes and setups neighbor connections
 // with them.
 func (s *server) Start() (err error) {
+	// Restore the records of the local store, before accepting any
+	// requests from the clients and other nodes.
+	if err = s.restore(); err != nil {
+		return err
+	}
+
 	// Start listening for incoming requests from the other nodes.
 	go func() {
 		if err := s.listenAndServe(); err != nil {
@@ -417,23 +914,90 @@ func (s *server) Start() (err error) {
 	sort.Sort(s.nodes)
 
 	// Insert a new nodes into a sharding ring.
-	for ii := 0; ii < len(s.nodes); ii++ {
-		s.ring.Insert(&ring.Element{Value: ii})
+	for _, node := range s.nodes {
+		s.ring.Insert(&ring.Element{Value: node})
+	}
+
+	s.place()
+
+	// Copies of the partitions are compared only by the holder of the
+	// primary copy.
+	if s.replicas > 1 && s.entropyInterval > 0 {
+		go s.antiEntropy(s.entropyInterval)
+	}
+	if s.probeInterval > 0 {
+		go s.detect(s.probeInterval)
+	}
+	return nil
+}
+
+// place counts the partitions held by each node of the cluster as the
+// primary copy and as the replica. The nodes mutex should be held by
+// the caller.
+func (s *server) place() {
+	for _, node := range s.nodes {
+		node.Primaries, node.Replicas = 0, 0
+	}
+
+	for _, elements := range s.ring.Partitions(s.replicas) {
+		for ii, elem := range elements {
+			node := elem.Value.(*Node)
+			if ii == 0 {
+				node.Primaries++
+				continue
+			}
+			node.Replicas++
+		}
+	}
+}
+
+// restore restores the records of the local store. The journal contains
+// all modifications since the last compaction, therefore the snapshot
+// is loaded only when the journal is disabled or empty.
+func (s *server) restore() error {
+	if s.journal != nil {
+		if _, err := s.journal.Replay(s.store); err != nil {
+			log.ErrorLogf("server/RESTORE",
+				"failed to replay a journal, %s", err)
+			return err
+		}
+	}
+
+	if s.snapshotter == nil {
+		return nil
+	}
+	if s.journal == nil || s.journal.Empty() {
+		if err := s.snapshotter.Load(); err != nil {
+			log.ErrorLogf("server/RESTORE",
+				"failed to restore a snapshot, %s", err)
+			return err
+		}
 	}
 
+	s.snapshotter.Start()
 	return nil
 }
 
 // Stop terminates connections with remote nodes of the cluster and
-// stops a listener.
-func (s *server) Stop() error {
+// stops a listener. The server is stopped only once, the subsequent calls
+// do nothing.
+func (s *server) Stop() (err error) {
+	s.stopOnce.Do(func() { err = s.stop() })
+	return err
+}
+
+// stop releases the resources of the server.
+func (s *server) stop() error {
+	close(s.done)
+
 	// Close all connections to the neighbors, to clean-up resources.
-	for _, node := range s.nodes {
+	for _, node := range s.Nodes() {
 		defer func(n *Node) {
-			if n.Conn == nil {
+			conn := n.conn()
+			if conn == nil {
 				return
 			}
-			n.Conn.Close()
+			closeWire(conn)
 			log.DebugLogf("server/STOP",
 				"connection to %s closed", n.Addr)
 		}(node)
@@ -441,6 +1005,23 @@ func (s *server) Stop() error {
 	if s.ln != nil {
 		s.ln.Close()
 	}
+
+	// Save the final snapshot of the local store.
+	if s.snapshotter != nil {
+		if err := s.snapshotter.Stop(); err != nil {
+			log.ErrorLogf("server/STOP",
+				"failed to save a snapshot, %s", err)
+			return err
+		}
+	}
+
+	if s.journal != nil {
+		if err := s.journal.Close(); err != nil {
+			log.ErrorLogf("server/STOP",
+				"failed to close a journal, %s", err)
+			return err
+		}
+	}
 	return nil
 }
 
@@ -457,11 +1038,177 @@ func (s *server) writeWire(w io.Writer, val interface{}) error {
 }
 
 // roundTrip sends a request to the given node and waits for a response.
-// This method locks a node, which means, it is not possible to use this
-// node for communication until node will reply with a response.
-func (s *server) roundTrip(node *Node, req store.Request) (Response, error) {
+// The requests to the same node share a single connection, the responses
+// are matched with the requests by the correlation identifier, so the
+// requests do not wait for each other.
+//
+// The request fails, when the response is not received in time, and the
+// connection is considered broken, when the node does not respond at all.
+// The broken connection is re-established in background. Meanwhile the
+// requests to the node fail without using the connection.
+func (s *server) roundTrip(node *Node, req message,
+	level store.Consistency) (Response, error) {
+
+	b, err := json.Marshal(req)
+	if err != nil {
+		log.ErrorLogf("server/ROUND_TRIP",
+			"failed to submit request: %s", err)
+		return Response{}, err
+	}
+
+	raw := json.RawMessage(b)
+	ev := eventRequest{Action: req.Action(), Request: &raw, Consistency: level}
+	w := &waiter{C: make(chan *Response, 1)}
+
+	node.mu.Lock()
+	if node.down {
+		node.mu.Unlock()
+		const text = "connection to %s is down, reconnecting"
+		return Response{}, &store.ErrUnavailable{
+			Text: fmt.Sprintf(text, node.Addr)}
+	}
+
+	// Start reading the responses of the new connection.
+	conn := node.Conn
+	if node.reading != conn {
+		node.reading = conn
+		go s.read(node, conn)
+	}
+	if node.pending == nil {
+		node.pending = make(map[uint64]*waiter)
+	}
+
+	node.seq++
+	ev.CorrelationID, w.conn = node.seq, conn
+	node.pending[ev.CorrelationID] = w
+	// The connection is considered broken, when the node does not
+	// respond in time after the last sent request.
+	conn.SetReadDeadline(time.Now().Add(s.ioTimeout))
+	node.mu.Unlock()
+
+	node.wmu.Lock()
+	conn.SetWriteDeadline(time.Now().Add(s.ioTimeout))
+	err = s.writeWire(conn, ev)
+	node.wmu.Unlock()
+
+	if err != nil {
+		node.mu.Lock()
+		s.broken(node, conn)
+		node.mu.Unlock()
+	}
+
+	timer := time.NewTimer(s.ioTimeout)
+	defer timer.Stop()
+
+	select {
+	case resp, ok := <-w.C:
+		if !ok {
+			const text = "connection to %s is broken"
+			err := &store.ErrUnavailable{Text: fmt.Sprintf(text, node.Addr)}
+			log.ErrorLogf("server/ROUND_TRIP",
+				"failed to retrieve response: %s", err)
+			return Response{}, err
+		}
+		return *resp, nil
+	case <-timer.C:
+	}
+
+	// The response could be dispatched concurrently with the expiration
+	// of the timer, then it is already in the channel.
 	node.mu.Lock()
-	defer node.mu.Unlock()
+	_, pending := node.pending[ev.CorrelationID]
+	delete(node.pending, ev.CorrelationID)
+	node.mu.Unlock()
+
+	if !pending {
+		if resp, ok := <-w.C; ok {
+			return *resp, nil
+		}
+	}
+
+	const text = "no response from %s in %s"
+	err = &store.ErrUnavailable{Text: fmt.Sprintf(text, node.Addr, s.ioTimeout)}
+	log.ErrorLogf("server/ROUND_TRIP",
+		"failed to retrieve response: %s", err)
+	return Response{}, err
+}
+
+// read dispatches the responses received through the connection to the
+// requests waiting for them, until the connection breaks.
+func (s *server) read(node *Node, conn net.Conn) {
+	decoder := json.NewDecoder(conn)
+	for {
+		var resp Response
+		if err := decoder.Decode(&resp); err != nil {
+			log.DebugLogf("server/ROUND_TRIP",
+				"reading of responses from %s failed, %s", node.Addr, err)
+
+			node.mu.Lock()
+			s.broken(node, conn)
+			node.mu.Unlock()
+			return
+		}
+
+		node.mu.Lock()
+		w, ok := node.pending[resp.CorrelationID]
+		delete(node.pending, resp.CorrelationID)
+		// The connection is idle, when there are no pending requests,
+		// so the reader waits for the next response without deadline.
+		deadline := time.Now().Add(s.ioTimeout)
+		if !waiting(node, conn) {
+			deadline = time.Time{}
+		}
+		conn.SetReadDeadline(deadline)
+		node.mu.Unlock()
+
+		if ok {
+			w.C <- &resp
+		}
+	}
+}
+
+// waiting reports whether there are requests sent through the given
+// connection waiting for the responses. The node mutex should be held by
+// the caller.
+func waiting(node *Node, conn net.Conn) bool {
+	for _, w := range node.pending {
+		if w.conn == conn {
+			return true
+		}
+	}
+	return false
+}
+
+// broken fails the requests sent through the given connection. When the
+// connection is still used by the node, it is closed and re-established
+// in background. The node mutex should be held by the caller.
+func (s *server) broken(node *Node, conn net.Conn) {
+	for id, w := range node.pending {
+		if w.conn == conn {
+			delete(node.pending, id)
+			close(w.C)
+		}
+	}
+
+	if node.Conn == conn && !node.down {
+		node.down = true
+		closeWire(conn)
+		go s.reconnect(node)
+	}
+}
+
+// exchange sends a request through the given connection and waits for
+// a response. The consistency level is sent along with the request, when
+// it is not empty. The watch request is awaited for its timeout on top of
+// the input and output timeout.
+func (s *server) exchange(conn net.Conn, req message,
+	level store.Consistency) (Response, error) {
+
+	timeout := s.ioTimeout
+	if wreq, ok := req.(*store.RequestWatch); ok {
+		timeout += wreq.Timeout
+	}
+	conn.SetDeadline(time.Now().Add(timeout))
 
 	b, err := json.Marshal(req)
 	if err != nil {
@@ -472,15 +1219,15 @@ func (s *server) roundTrip(node *Node, req store.Request) (Response, error) {
 	// Write an event message to the remote host altogether with an
 	// action type, so the neighbor can easily decode the message.
 	raw := json.RawMessage(b)
-	ev := eventRequest{Action: req.Action(), Request: &raw}
+	ev := eventRequest{Action: req.Action(), Request: &raw, Consistency: level}
 	// Submit created message as a regular JSON message.
-	if err := s.writeWire(node.Conn, ev); err != nil {
+	if err := s.writeWire(conn, ev); err != nil {
 		log.ErrorLogf("server/ROUND_TRIP",
 			"failed to submit request: %s", err)
 		return Response{}, err
 	}
 	var resp Response
-	if err := s.readWire(node.Conn, &resp); err != nil {
+	if err := s.readWire(conn, &resp); err != nil {
 		log.ErrorLogf("server/ROUND_TRIP",
 			"failed to retrieve response: %s", err)
 		return Response{}, err
@@ -494,31 +1241,47 @@ func (s *server) roundTrip(node *Node, req store.Request) (Response, error) {
 func (s *server) Do(ctx context.Context, req store.Request) Response {
 	log.DebugLogf("server/PROCESSING_REQUEST",
 		"started processing request %s", req)
-	// Find a nodes, that is in charge of handling an arrived request.
-	elem := s.ring.Find(ring.StringHasher(req.Hash()))
-	index := elem.Value.(int)
+	if batch, ok := req.(store.BatchRequest); ok {
+		return s.doBatch(ctx, batch)
+	}
+	if mreq, ok := req.(store.MultiKeyRequest); ok {
+		return s.doMultiKey(ctx, mreq)
+	}
+	if wreq, ok := req.(*store.RequestWatch); ok {
+		return s.doWatch(ctx, wreq)
+	}
+
+	// Reads requiring multiple copies of the record are sent to all
+	// nodes holding the copies.
+	level := consistencyOf(ctx)
+	if req.Hash() != "" && store.ReadOnly(req) && level != store.ConsistencyOne {
+		return s.doRead(ctx, req)
+	}
 
-	node := s.nodes[index]
-	if node.Conn == nil || req.Hash() == "" {
+	// Find a nodes, that is in charge of handling an arrived request.
+	node := s.nodeOf(req)
+	if node.conn() == nil || req.Hash() == "" {
 		// Handle a local call.
-		rec, err := s.store.Serve(req)
-		if err != nil {
-			log.ErrorLogf("server/PROCESSING_REQUEST",
-				"%s failed with %s", req, err)
-			return Response{
-				Status: statusOf(err),
-				Error:  err.Error(),
-			}
-		}
-		return Response{
-			Record: rec,
-			Node:   *node,
-			Status: statusOf(err),
-		}
+		return s.serve(ctx, node, req)
 	}
 
-	// Handle a redirect of the request to another node.
-	resp, err := s.roundTrip(node, req)
+	// Handle a redirect of the request to another node. The requests
+	// are not sent to the dead node, since it is not reachable.
+	var (
+		resp Response
+		err  error
+	)
+	if s.stateOf(node) == NodeDead {
+		const text = "node %s is dead"
+		err = &store.ErrUnavailable{Text: fmt.Sprintf(text, node.Addr)}
+	} else {
+		resp, err = s.roundTrip(node, req, level)
+	}
+	if err != nil && store.ReadOnly(req) {
+		// The primary copy is not available, so try to read the
+		// record from the replicas.
+		resp, err = s.doReplica(req, err)
+	}
 	if err != nil {
 		log.ErrorLogf("service/PROCESSING_REQUEST",
 			"redirect of %s failed with %s", req, err)
@@ -529,3 +1292,952 @@ func (s *server) Do(ctx context.Context, req store.Request) Response {
 	}
 	return resp
 }
+
+// doReplica processes the read request by the replicas of the key in
+// the order of preference. It returns the given error of the primary
+// node, when all replicas fail.
+func (s *server) doReplica(req store.Request, err error) (Response, error) {
+	for _, node := range s.nodesOfKey(req.Hash())[1:] {
+		if node.conn() == nil {
+			return s.serve(context.Background(), node, req), nil
+		}
+		if s.stateOf(node) == NodeDead {
+			continue
+		}
+
+		resp, rerr := s.roundTrip(node, req, "")
+		if rerr == nil {
+			return resp, nil
+		}
+		log.ErrorLogf("service/PROCESSING_REQUEST",
+			"read of %s from replica %s failed with %s", req, node.Addr, rerr)
+	}
+	return Response{}, err
+}
+
+// doRemote processes the request redirected by the remote node. The
+// replicated changes and the reads of the keys, which copies are held
+// by the local node, are served by the local store, since the remote
+// node has already chosen this node as a holder of the key.
+func (s *server) doRemote(ctx context.Context, req store.Request) Response {
+	switch req.(type) {
+	case *store.RequestReplicate:
+		return s.serve(ctx, s.self(), req)
+	case store.MultiKeyRequest, store.BatchRequest, *store.RequestWatch:
+		return s.Do(ctx, req)
+	}
+
+	if key := req.Hash(); key != "" && store.ReadOnly(req) {
+		for _, node := range s.nodesOfKey(key) {
+			if node.conn() == nil {
+				return s.serve(ctx, node, req)
+			}
+		}
+	}
+	return s.Do(ctx, req)
+}
+
+// doRead sends the read request to all nodes holding the copies of the
+// key and waits for the number of answers required by the consistency
+// level. The most recent record among the answers is returned.
+func (s *server) doRead(ctx context.Context, req store.Request) Response {
+	type result struct {
+		resp Response
+		err  error
+	}
+
+	var (
+		nodes    = s.nodesOfKey(req.Hash())
+		level    = consistencyOf(ctx)
+		required = level.Required(len(nodes))
+		results  = make(chan result, len(nodes))
+	)
+
+	for _, node := range nodes {
+		go func(n *Node) {
+			if n.conn() == nil {
+				results <- result{s.serve(ctx, n, req), nil}
+				return
+			}
+			resp, err := s.roundTrip(n, req, "")
+			results <- result{resp, err}
+		}(node)
+	}
+
+	var answers []Response
+	for failed := 0; len(answers) < required; {
+		r := <-results
+		if r.err != nil {
+			log.ErrorLogf("service/PROCESSING_REQUEST",
+				"read of %s failed with %s", req, r.err)
+			if failed++; len(nodes)-failed < required {
+				break
+			}
+			continue
+		}
+		answers = append(answers, r.resp)
+	}
+
+	if len(answers) < required {
+		const text = "%d of %d copies of %s answered, %s requires %d"
+		err := &store.ErrUnavailable{Text: fmt.Sprintf(text,
+			len(answers), len(nodes), req.Hash(), level, required)}
+		return Response{Status: statusOf(err), Error: err.Error()}
+	}
+	return newest(answers)
+}
+
+// newest returns the response with the most recent record. The records
+// are compared the same way as the replicated changes. The successful
+// responses are preferred over the failed ones, so the missing copies
+// do not hide the existing record.
+func newest(resps []Response) Response {
+	resp := resps[0]
+	for _, r := range resps[1:] {
+		switch {
+		case r.Err() != nil:
+		case resp.Err() != nil:
+			resp = r
+		case store.Newer(r.Record.Meta, resp.Record.Meta):
+			resp = r
+		}
+	}
+	return resp
+}
+
+// Keys implements Server interface. It retrieves the keys from all
+// nodes in parallel and merges them into a single list.
+func (s *server) Keys(ctx context.Context) KeysResponse {
+	type result struct {
+		node *Node
+		keys []string
+		err  error
+	}
+
+	nodes := s.Nodes()
+	results := make(chan result, len(nodes))
+
+	for _, node := range nodes {
+		go func(n *Node) {
+			keys, err := s.nodeKeys(n)
+			results <- result{n, keys, err}
+		}(node)
+	}
+
+	var (
+		resp    KeysResponse
+		seen    = make(map[string]bool)
+		pending = make(map[*Node]bool, len(nodes))
+	)
+
+	for _, node := range nodes {
+		pending[node] = true
+	}
+
+	for len(pending) != 0 {
+		select {
+		case r := <-results:
+			delete(pending, r.node)
+			if r.err != nil {
+				log.ErrorLogf("server/KEYS",
+					"failed to retrieve keys from %s, %s", r.node.Addr, r.err)
+				resp.Errors = append(resp.Errors, NodeError{
+					Addr: r.node.Addr.String(), Error: r.err.Error()})
+				continue
+			}
+
+			for _, key := range r.keys {
+				if !seen[key] {
+					seen[key] = true
+					resp.Keys = append(resp.Keys, key)
+				}
+			}
+		case <-ctx.Done():
+			// Report all nodes, which did not reply in time as failed.
+			for node := range pending {
+				delete(pending, node)
+				resp.Errors = append(resp.Errors, NodeError{
+					Addr: node.Addr.String(), Error: ctx.Err().Error()})
+			}
+		}
+	}
+
+	sort.Strings(resp.Keys)
+	sort.Sort(nodeErrors(resp.Errors))
+	return resp
+}
+
+// nodeKeys retrieves a list of keys from the given node.
+func (s *server) nodeKeys(node *Node) ([]string, error) {
+	req := &store.RequestKeys{ID: uuid.New()}
+	if node.conn() == nil {
+		rec, err := s.store.Serve(req)
+		if err != nil {
+			return nil, err
+		}
+		return keysOf(rec.Data)
+	}
+
+	resp, err := s.roundTrip(node, req, "")
+	if err != nil {
+		return nil, err
+	}
+	if err = resp.Err(); err != nil {
+		return nil, err
+	}
+	return keysOf(resp.Record.Data)
+}
+
+// keysOf converts the data of the keys request into a list of keys. The
+// keys of the remote nodes are decoded as a list of interfaces.
+func keysOf(data interface{}) ([]string, error) {
+	switch data := data.(type) {
+	case nil:
+		return nil, nil
+	case []string:
+		return data, nil
+	case []interface{}:
+		keys := make([]string, 0, len(data))
+		for _, key := range data {
+			k, ok := key.(string)
+			if !ok {
+				return nil, fmt.Errorf("invalid type of key %v", key)
+			}
+			keys = append(keys, k)
+		}
+		return keys, nil
+	}
+	return nil, fmt.Errorf("invalid type of keys %T", data)
+}
+
+// nodeErrors is a list of node errors ordered by the node address.
+type nodeErrors []NodeError
+
+// Len implements sort.Interface interface.
+func (e nodeErrors) Len() int {
+	return len(e)
+}
+
+// Less implements sort.Interface interface.
+func (e nodeErrors) Less(i, j int) bool {
+	return strings.Compare(e[i].Addr, e[j].Addr) < 0
+}
+
+// Swap implements sort.Interface interface.
+func (e nodeErrors) Swap(i, j int) {
+	e[i], e[j] = e[j], e[i]
+}
+
+// nodeOf returns a node, that is in charge of handling the request.
+func (s *server) nodeOf(req store.Request) *Node {
+	return s.nodeOfKey(req.Hash())
+}
+
+// nodeOfKey returns a node, that owns the given key.
+func (s *server) nodeOfKey(key string) *Node {
+	r, _ := s.topology()
+	elem := r.Find(ring.StringHasher(key))
+	return elem.Value.(*Node)
+}
+
+// nodesOfKey returns a preference list of the nodes holding the copies
+// of the given key. The first node holds the primary copy.
+func (s *server) nodesOfKey(key string) Nodes {
+	r, _ := s.topology()
+	elements := r.FindN(ring.StringHasher(key), s.replicas)
+	nodes := make(Nodes, 0, len(elements))
+	for _, elem := range elements {
+		nodes = append(nodes, elem.Value.(*Node))
+	}
+	return nodes
+}
+
+// serve processes the request by the local store. The changes of the
+// records are replicated, before the response is returned. The request
+// fails, when the changes are not acknowledged by the number of replicas
+// required by the consistency level, even though the changes are applied
+// to the local store.
+func (s *server) serve(ctx context.Context, node *Node,
+	req store.Request) Response {
+
+	// The records are not pulled for the replicated changes, since
+	// they are sent by the primary copy holder or the previous owner.
+	if _, ok := req.(*store.RequestReplicate); !ok {
+		s.pull(req)
+	}
+
+	rec, changes, err := s.store.ServeChanges(req)
+	if len(changes) != 0 {
+		if rerr := s.replicate(ctx, changes); err == nil {
+			err = rerr
+		}
+	}
+	if err != nil {
+		log.ErrorLogf("server/PROCESSING_REQUEST",
+			"%s failed with %s", req, err)
+		return Response{
+			Status: statusOf(err),
+			Error:  err.Error(),
+		}
+	}
+	// The counters of the partitions are updated under the nodes lock,
+	// when the members of the cluster change.
+	s.nodesMu.RLock()
+	defer s.nodesMu.RUnlock()
+	return Response{
+		Record: rec,
+		Node: Node{
+			ID:        node.ID,
+			Addr:      node.Addr,
+			Primaries: node.Primaries,
+			Replicas:  node.Replicas,
+			State:     node.State,
+		},
+		Status: statusOf(err),
+	}
+}
+
+// replicate sends the changes of the records to the replicas. Only the
+// changes of the keys, which primary copy is held by the local node, are
+// sent, so the replicas do not propagate the changes any further.
+//
+// The method waits for the number of acknowledgements required by the
+// consistency level, the rest of the replicas receive the changes in
+// background. The primary copy counts as one acknowledgement. The changes
+// are sent through the queue of each replica, so they are delivered in
+// the order of the calls, even when the acknowledgements are not awaited.
+func (s *server) replicate(ctx context.Context, changes []store.Event) error {
+	if s.replicas < 2 {
+		return nil
+	}
+
+	var (
+		level    = consistencyOf(ctx)
+		required int
+		groups   = make(map[*Node][]store.Event)
+	)
+
+	for _, ev := range changes {
+		nodes := s.nodesOfKey(ev.Key)
+		if nodes[0].conn() != nil {
+			continue
+		}
+		if n := level.Required(len(nodes)) - 1; n > required {
+			required = n
+		}
+		for _, node := range nodes[1:] {
+			groups[node] = append(groups[node], ev)
+		}
+	}
+
+	errs := make(chan error, len(groups))
+	for node, changes := range groups {
+		s.enqueue(node, &replication{changes: changes, C: errs})
+	}
+
+	var acks, failed int
+	for acks < required {
+		if err := <-errs; err != nil {
+			if failed++; len(groups)-failed < required {
+				const text = "%d of %d replicas acknowledged, %s requires %d"
+				return &store.ErrUnavailable{Text: fmt.Sprintf(text,
+					acks, len(groups), level, required)}
+			}
+			continue
+		}
+		acks++
+	}
+	return nil
+}
+
+// enqueue appends the changes to the replication queue of the node, the
+// sender of the queue is started, when it is not running.
+func (s *server) enqueue(node *Node, r *replication) {
+	node.queueMu.Lock()
+	defer node.queueMu.Unlock()
+
+	node.queue = append(node.queue, r)
+	if !node.sending {
+		node.sending = true
+		go s.send(node)
+	}
+}
+
+// send sends the queued changes to the node, until the queue is empty.
+// The changes queued while the previous request is in flight are sent
+// within a single request, the next request is sent only after the
+// response to the previous one.
+func (s *server) send(node *Node) {
+	for {
+		node.queueMu.Lock()
+		queue := node.queue
+		node.queue = nil
+		if len(queue) == 0 {
+			node.sending = false
+			node.queueMu.Unlock()
+			return
+		}
+		node.queueMu.Unlock()
+
+		var changes []store.Event
+		for _, r := range queue {
+			changes = append(changes, r.changes...)
+		}
+
+		req := &store.RequestReplicate{ID: uuid.New(), Changes: changes}
+		resp, err := s.roundTrip(node, req, "")
+		if err == nil {
+			err = resp.Err()
+		}
+		if err != nil {
+			log.ErrorLogf("server/REPLICATE",
+				"replication of %s to %s failed with %s", req, node.Addr, err)
+		}
+		for _, r := range queue {
+			r.C <- err
+		}
+	}
+}
+
+// removed replicates the records expired or evicted by the local store.
+// The method is called under the lock of the store segment, therefore the
+// acknowledgements of the replicas are not awaited.
+func (s *server) removed(ev store.Event) {
+	ctx := WithConsistency(context.Background(), store.ConsistencyOne)
+	s.replicate(ctx, []store.Event{ev})
+}
+
+// doBatch splits the batch request by the owner nodes of the keys, and
+// sends a single request to each owner. The responses are reassembled in
+// the order of the requests in the batch.
+func (s *server) doBatch(ctx context.Context, batch store.BatchRequest) Response {
+	var (
+		reqs   = batch.Requests()
+		resps  = make([]*Response, len(reqs))
+		groups = make(map[*Node][]int)
+		wg     sync.WaitGroup
+	)
+
+	for i, req := range reqs {
+		node := s.nodeOf(req)
+		groups[node] = append(groups[node], i)
+	}
+
+	for node, indices := range groups {
+		wg.Add(1)
+		go func(n *Node, indices []int) {
+			defer wg.Done()
+			if n.conn() == nil {
+				for _, i := range indices {
+					resp := s.serve(ctx, n, reqs[i])
+					resps[i] = &resp
+				}
+				return
+			}
+
+			resp, err := s.roundTrip(n, batch.Subset(indices), consistencyOf(ctx))
+			if err == nil && len(resp.Responses) != len(indices) {
+				const text = "invalid number of responses from %s: %d"
+				err = fmt.Errorf(text, n.Addr, len(resp.Responses))
+			}
+			if err != nil {
+				log.ErrorLogf("server/PROCESSING_REQUEST",
+					"redirect of %s failed with %s", batch, err)
+			}
+
+			for j, i := range indices {
+				if err != nil {
+					resps[i] = &Response{
+						Status: statusOf(err),
+						Error:  err.Error(),
+					}
+					continue
+				}
+				resps[i] = resp.Responses[j]
+			}
+		}(node, indices)
+	}
+
+	wg.Wait()
+	return Response{Status: http.StatusOK, Responses: resps}
+}
+
+// doMultiKey processes the request to multiple keys. The keys should be
+// owned by the same node, otherwise the request is rejected. Use hash
+// tags to place the keys onto the same node.
+func (s *server) doMultiKey(ctx context.Context,
+	req store.MultiKeyRequest) Response {
+
+	var node *Node
+	for _, key := range req.Keys() {
+		owner := s.nodeOfKey(key)
+		if node != nil && node != owner {
+			const text = "keys of %s belong to different nodes"
+			err := &store.ErrConflict{Text: fmt.Sprintf(text, req.Action())}
+
+			log.ErrorLogf("server/PROCESSING_REQUEST",
+				"%s failed with %s", req, err)
+			return Response{Status: statusOf(err), Error: err.Error()}
+		}
+		node = owner
+	}
+
+	if node == nil {
+		node = s.nodeOf(req)
+	}
+	if node.conn() != nil {
+		resp, err := s.roundTrip(node, req, consistencyOf(ctx))
+		if err != nil {
+			log.ErrorLogf("service/PROCESSING_REQUEST",
+				"redirect of %s failed with %s", req, err)
+			return Response{
+				Status: statusOf(err),
+				Error:  err.Error(),
+			}
+		}
+		return resp
+	}
+
+	resp := s.serve(ctx, node, req)
+	records, ok := resp.Record.Data.([]hash.Record)
+	if !ok {
+		return resp
+	}
+
+	// Return the result of each request as a separate response, so
+	// the records could be sent to another node.
+	for _, rec := range records {
+		resp.Responses = append(resp.Responses, &Response{
+			Status: http.StatusOK,
+			Node:   Node{ID: node.ID, Addr: node.Addr},
+			Record: rec,
+		})
+	}
+	resp.Record = hash.RecordZero
+	return resp
+}
+
+// doWatch waits until the record changes according to the request. The
+// request to the remote node is sent through a dedicated connection, so
+// the shared connection is not blocked while waiting.
+func (s *server) doWatch(ctx context.Context, req *store.RequestWatch) Response {
+	if req.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, req.Timeout)
+		defer cancel()
+	}
+
+	node := s.nodeOf(req)
+	if node.conn() != nil {
+		return s.watchRemote(ctx, node, req)
+	}
+
+	for {
+		// Subscribe to the changes before checking the record, so no
+		// changes are missed in between.
+		w := s.store.Watch(req.Key)
+		resp, done := s.watchLocal(ctx, node, req, w)
+		w.Stop()
+
+		if done {
+			return resp
+		}
+	}
+}
+
+// watchLocal waits for the changes of the local record. It returns false,
+// when the watcher was closed and the wait should be repeated.
+func (s *server) watchLocal(ctx context.Context, node *Node,
+	req *store.RequestWatch, w *store.Watcher) (Response, bool) {
+
+	resp := s.serve(ctx, node, req)
+	if resp.Err() != nil || resp.Record.Meta.Index != 0 {
+		if resp.Err() == nil {
+			resp.Event = store.EventStore
+		}
+		return resp, true
+	}
+
+	for {
+		select {
+		case ev, ok := <-w.C:
+			if !ok {
+				return Response{}, false
+			}
+			if !req.Ready(ev) {
+				continue
+			}
+			return Response{
+				Status: http.StatusOK,
+				Node:   Node{ID: node.ID, Addr: node.Addr},
+				Record: ev.Record,
+				Event:  ev.Type,
+			}, true
+		case <-ctx.Done():
+			const text = "no changes of %s, %s"
+			return Response{
+				Status: http.StatusRequestTimeout,
+				Error:  fmt.Sprintf(text, req.Key, ctx.Err()),
+			}, true
+		}
+	}
+}
+
+// watchRemote sends the watch request to the remote node. The request is
+// repeated after the timeout until the context is canceled.
+func (s *server) watchRemote(ctx context.Context, node *Node,
+	req *store.RequestWatch) Response {
+
+	r := *req
+	r.Timeout = watchTimeout
+
+	for {
+		resp, err := s.roundTripCtx(ctx, node, &r)
+		if err != nil {
+			if ctx.Err() != nil {
+				err = ctx.Err()
+			}
+			log.ErrorLogf("service/PROCESSING_REQUEST",
+				"redirect of %s failed with %s", req, err)
+			return Response{
+				Status: http.StatusRequestTimeout,
+				Error:  err.Error(),
+			}
+		}
+		if resp.Status != http.StatusRequestTimeout || ctx.Err() != nil {
+			return resp
+		}
+	}
+}
+
+// roundTripCtx sends a request to the given node through a dedicated
+// connection. The connection is closed, when the context is canceled.
+func (s *server) roundTripCtx(ctx context.Context, node *Node,
+	req message) (Response, error) {
+
+	conn, closeConn, err := s.dialCtx(ctx, node)
+	if err != nil {
+		return Response{}, err
+	}
+	defer closeConn()
+	return s.exchange(conn, req, "")
+}
+
+// dialCtx establishes a dedicated connection to the given node. The
+// connection is closed, when the context is canceled or the returned
+// function is called.
+func (s *server) dialCtx(ctx context.Context,
+	node *Node) (net.Conn, func(), error) {
+
+	conn, err := s.dialContext(ctx, node)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-done:
+		}
+		closeWire(conn)
+	}()
+
+	var once sync.Once
+	return conn, func() { once.Do(func() { close(done) }) }, nil
+}
+
+// closeWire closes the given connection. The keep-alive setup switches the
+// descriptor into the blocking mode, so the pending read has to be
+// interrupted explicitly, otherwise the close waits for it to complete.
+func closeWire(conn net.Conn) error {
+	if c, ok := conn.(*net.TCPConn); ok {
+		c.CloseRead()
+	}
+	return conn.Close()
+}
+
+// Events implements Server interface. The changes of the remote nodes
+// are streamed through the dedicated connections.
+func (s *server) Events(ctx context.Context, prefix string) <-chan *Response {
+	var (
+		wg     sync.WaitGroup
+		events = make(chan *Response)
+	)
+
+	// Stop the whole stream, when any of the nodes fails, so the
+	// subscriber does not miss the changes silently.
+	ctx, cancel := context.WithCancel(ctx)
+	for _, node := range s.Nodes() {
+		wg.Add(1)
+		go func(n *Node) {
+			defer wg.Done()
+			defer cancel()
+
+			if n.conn() == nil {
+				s.localEvents(ctx, n, prefix, events)
+				return
+			}
+			if err := s.remoteEvents(ctx, n, prefix, events); err != nil {
+				log.ErrorLogf("server/EVENTS",
+					"stream of changes from %s failed, %s", n.Addr, err)
+			}
+		}(node)
+	}
+
+	go func() {
+		wg.Wait()
+		cancel()
+		close(events)
+	}()
+	return events
+}
+
+// localEvents sends the changes of the local records to the channel until
+// the context is canceled.
+func (s *server) localEvents(ctx context.Context, node *Node,
+	prefix string, events chan<- *Response) {
+
+	w := s.store.WatchPrefix(prefix)
+	defer w.Stop()
+
+	for {
+		select {
+		case ev, ok := <-w.C:
+			if !ok {
+				log.ErrorLogf("server/EVENTS",
+					"subscriber of %s changes is too slow", prefix)
+				return
+			}
+			// Each copy of the record is expired and evicted by its
+			// holder, the removals are delivered only by the holder of
+			// the primary copy, so they are not duplicated.
+			if ev.Type == store.EventExpire || ev.Type == store.EventEvict {
+				if s.nodeOfKey(ev.Key).conn() != nil {
+					continue
+				}
+			}
+
+			resp := &Response{
+				Status: http.StatusOK,
+				Node:   Node{ID: node.ID, Addr: node.Addr},
+				Record: ev.Record,
+				Event:  ev.Type,
+				Key:    ev.Key,
+			}
+			select {
+			case events <- resp:
+			case <-ctx.Done():
+				return
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// remoteEvents sends the changes of the remote records to the channel
+// until the context is canceled.
+func (s *server) remoteEvents(ctx context.Context, node *Node,
+	prefix string, events chan<- *Response) error {
+
+	conn, closeConn, err := s.dialCtx(ctx, node)
+	if err != nil {
+		return err
+	}
+	defer closeConn()
+
+	b, err := json.Marshal(&store.RequestSubscribe{
+		ID: uuid.New(), Prefix: prefix})
+	if err != nil {
+		return err
+	}
+
+	raw := json.RawMessage(b)
+	ev := eventRequest{Action: store.ActionSubscribe, Request: &raw}
+	if err = s.writeWire(conn, ev); err != nil {
+		return err
+	}
+
+	// Use the same decoder for the whole stream, since the decoder
+	// could read multiple responses into the buffer.
+	decoder := json.NewDecoder(conn)
+	for {
+		resp := new(Response)
+		if err = decoder.Decode(resp); err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+
+		select {
+		case events <- resp:
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// stream writes the changes of the local records to the connection until
+// the remote node closes the connection.
+func (s *server) stream(conn net.Conn, req *store.RequestSubscribe) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// The remote node does not send anything after the subscription,
+	// so the read returns only when the connection is closed.
+	go func() {
+		var b [1]byte
+		conn.Read(b[:])
+		cancel()
+	}()
+
+	events := make(chan *Response)
+	go func() {
+		defer close(events)
+		s.localEvents(ctx, s.self(), req.Prefix, events)
+	}()
+
+	for resp := range events {
+		if err := s.writeWire(conn, resp); err != nil {
+			log.ErrorLogf("server/STREAM",
+				"submission of changes to %s failed, %s",
+				conn.RemoteAddr(), err)
+			cancel()
+			break
+		}
+	}
+
+	// Drain the channel, so the sender is not blocked.
+	for range events {
+	}
+}
+
+// Publish implements Server interface. The message is delivered to the
+// local subscribers and sent to all remote nodes in parallel, so each
+// node delivers it to own subscribers.
+func (s *server) Publish(ctx context.Context, channel string,
+	data interface{}) PublishResponse {
+
+	type result struct {
+		node      *Node
+		receivers int
+		err       error
+	}
+
+	req := &pubsub.RequestPublish{ID: uuid.New(), Channel: channel, Data: data}
+	nodes := s.Nodes()
+	results := make(chan result, len(nodes))
+
+	for _, node := range nodes {
+		go func(n *Node) {
+			if n.conn() == nil {
+				results <- result{n, s.broker.Publish(req.Message()), nil}
+				return
+			}
+
+			resp, err := s.roundTrip(n, req, "")
+			if err == nil {
+				err = resp.Err()
+			}
+			results <- result{n, resp.Receivers, err}
+		}(node)
+	}
+
+	var (
+		resp    PublishResponse
+		pending = make(map[*Node]bool, len(nodes))
+	)
+
+	for _, node := range nodes {
+		pending[node] = true
+	}
+
+	for len(pending) != 0 {
+		select {
+		case r := <-results:
+			delete(pending, r.node)
+			if r.err != nil {
+				log.ErrorLogf("server/PUBLISH",
+					"failed to publish %s to %s, %s", req, r.node.Addr, r.err)
+				resp.Errors = append(resp.Errors, NodeError{
+					Addr: r.node.Addr.String(), Error: r.err.Error()})
+				continue
+			}
+			resp.Receivers += r.receivers
+		case <-ctx.Done():
+			// Report all nodes, which did not reply in time as failed.
+			for node := range pending {
+				delete(pending, node)
+				resp.Errors = append(resp.Errors, NodeError{
+					Addr: node.Addr.String(), Error: ctx.Err().Error()})
+			}
+		}
+	}
+
+	sort.Sort(nodeErrors(resp.Errors))
+	return resp
+}
+
+// handlePublish delivers the message sent by the remote node to the local
+// subscribers and returns the number of receivers to the node.
+func (s *server) handlePublish(ev *eventRequest) Response {
+	var req pubsub.RequestPublish
+	if err := json.Unmarshal(*ev.Request, &req); err != nil {
+		log.ErrorLogf("server/HANDLE",
+			"failed unmarshal request, %s", err)
+		return Response{
+			Status: http.StatusBadRequest,
+			Error:  err.Error(),
+		}
+	}
+
+	return Response{
+		Status:    http.StatusOK,
+		Receivers: s.broker.Publish(req.Message()),
+	}
+}
+
+// Subscribe implements Server interface. Each message is published to
+// all nodes in a cluster, therefore only the local subscription is made.
+func (s *server) Subscribe(ctx context.Context,
+	channel string) <-chan *pubsub.Message {
+
+	sub := s.broker.Subscribe(channel)
+	messages := make(chan *pubsub.Message)
+
+	go func() {
+		defer close(messages)
+		defer sub.Stop()
+
+		for {
+			select {
+			case msg, ok := <-sub.C:
+				if !ok {
+					log.ErrorLogf("server/SUBSCRIBE",
+						"subscriber of %s channel is too slow", channel)
+					return
+				}
+				select {
+				case messages <- &msg:
+				case <-ctx.Done():
+					return
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return messages
+}
+
+// self returns a local node of the cluster.
+func (s *server) self() *Node {
+	for _, node := range s.Nodes() {
+		if node.conn() == nil {
+			return node
+		}
+	}
+	return &Node{Addr: s.laddr}
+}
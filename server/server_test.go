@@ -1,7 +1,20 @@
 package server
 
 import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"reflect"
+	"sync"
 	"testing"
+	"time"
+
+	"github.com/ybubnov/memhashd/container/hash"
+	"github.com/ybubnov/memhashd/container/pubsub"
+	"github.com/ybubnov/memhashd/container/ring"
+	"github.com/ybubnov/memhashd/container/store"
 )
 
 func TestServerStart(t *testing.T) {
@@ -9,3 +22,627 @@ func TestServerStart(t *testing.T) {
 
 func TestServerDo(t *testing.T) {
 }
+
+func TestServerKeys(t *testing.T) {
+	addrOf := func(port int) *net.TCPAddr {
+		return &net.TCPAddr{IP: net.ParseIP("127.0.0.1"), Port: port}
+	}
+
+	s1 := newServer(&Config{NumPartitions: 4})
+	s1.store.Store("1", hash.Record{Data: "a"})
+	s1.store.Store("2", hash.Record{Data: "b"})
+
+	s2 := newServer(&Config{NumPartitions: 4})
+	s2.store.Store("2", hash.Record{Data: "b"})
+	s2.store.Store("3", hash.Record{Data: "c"})
+	s2.nodes = Nodes{{Addr: addrOf(2372)}}
+	s2.ring.Insert(&ring.Element{Value: s2.nodes[0]})
+
+	// Connect the first server to the second one, and the third
+	// node is not reachable.
+	c1, c2 := net.Pipe()
+	defer c1.Close()
+	go s2.handle(c2)
+
+	c3, c4 := net.Pipe()
+	c3.Close()
+	c4.Close()
+
+	s1.nodes = Nodes{
+		{Addr: addrOf(2371)},
+		{Addr: addrOf(2372), Conn: c1},
+		{Addr: addrOf(2373), Conn: c3},
+	}
+
+	resp := s1.Keys(context.Background())
+	if !reflect.DeepEqual(resp.Keys, []string{"1", "2", "3"}) {
+		t.Fatalf("invalid list of keys returned: %v", resp.Keys)
+	}
+	if len(resp.Errors) != 1 || resp.Errors[0].Addr != "127.0.0.1:2373" {
+		t.Fatalf("failure of the node should be reported: %v", resp.Errors)
+	}
+}
+
+// newTestCluster creates two servers, where the first one redirects
+// a part of the requests to the second one.
+func newTestCluster() (s1, s2 *server, conn net.Conn) {
+	addrOf := func(port int) *net.TCPAddr {
+		return &net.TCPAddr{IP: net.ParseIP("127.0.0.1"), Port: port}
+	}
+
+	s2 = newServer(&Config{NumPartitions: 4})
+	s2.nodes = Nodes{{Addr: addrOf(2372)}}
+	s2.ring.Insert(&ring.Element{Value: s2.nodes[0]})
+
+	c1, c2 := net.Pipe()
+	go s2.handle(c2)
+
+	s1 = newServer(&Config{NumPartitions: 4})
+	s1.nodes = Nodes{{Addr: addrOf(2371)}, {Addr: addrOf(2372), Conn: c1}}
+	s1.ring.Insert(&ring.Element{Value: s1.nodes[0]})
+	s1.ring.Insert(&ring.Element{Value: s1.nodes[1]})
+	return s1, s2, c1
+}
+
+// listen accepts dedicated connections to the second server of the test
+// cluster.
+func listen(t *testing.T, s1, s2 *server) net.Listener {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go s2.handle(conn)
+		}
+	}()
+
+	s1.nodes[1].Addr = ln.Addr().(*net.TCPAddr)
+	return ln
+}
+
+func TestServerDoBatch(t *testing.T) {
+	s1, s2, conn := newTestCluster()
+	defer conn.Close()
+
+	var (
+		keys    []string
+		records []store.RequestStore
+	)
+	for i := 0; i < 20; i++ {
+		key := fmt.Sprintf("key%d", i)
+		keys = append(keys, key)
+		records = append(records, store.RequestStore{Key: key, Data: key})
+	}
+
+	resp := s1.Do(context.Background(), &store.RequestBatchStore{
+		ID: "1", Records: records})
+	if len(resp.Responses) != len(keys) {
+		t.Fatalf("invalid number of responses: %d", len(resp.Responses))
+	}
+
+	// Both nodes should own a part of the keys.
+	local, remote := len(s1.store.Keys()), len(s2.store.Keys())
+	if local == 0 || remote == 0 || local+remote != len(keys) {
+		t.Fatalf("keys should be split between nodes: %d, %d", local, remote)
+	}
+
+	keys = append(keys, "missing")
+	resp = s1.Do(context.Background(), &store.RequestBatchLoad{
+		ID: "2", Keys: keys})
+	if len(resp.Responses) != len(keys) {
+		t.Fatalf("invalid number of responses: %d", len(resp.Responses))
+	}
+
+	for i, key := range keys[:len(keys)-1] {
+		if data := resp.Responses[i].Record.Data; data != key {
+			t.Fatalf("invalid data of %s returned: %v", key, data)
+		}
+	}
+	if resp.Responses[len(keys)-1].Status != http.StatusNotFound {
+		t.Fatalf("missing key should be reported: %v",
+			resp.Responses[len(keys)-1])
+	}
+}
+
+func TestServerDoTransaction(t *testing.T) {
+	s1, s2, conn := newTestCluster()
+	defer conn.Close()
+
+	// Find the keys owned by the different nodes.
+	var local, next, remote string
+	for i := 0; local == "" || next == "" || remote == ""; i++ {
+		key := fmt.Sprintf("key%d", i)
+		switch {
+		case s1.nodeOfKey(key).Conn != nil:
+			remote = key
+		case local == "":
+			local = key
+		default:
+			next = key
+		}
+	}
+
+	resp := s1.Do(context.Background(), &store.RequestTransaction{
+		Requests: []store.Request{
+			&store.RequestStore{Key: local, Data: "a"},
+			&store.RequestStore{Key: remote, Data: "b"},
+		},
+	})
+	if resp.Status != http.StatusConflict {
+		t.Fatalf("transaction of different nodes should be rejected")
+	}
+
+	// Keys with the same hash tag are owned by the same node.
+	tag := fmt.Sprintf("{%s}", remote)
+	resp = s1.Do(context.Background(), &store.RequestTransaction{
+		Requests: []store.Request{
+			&store.RequestStore{Key: tag + ":a", Data: "a"},
+			&store.RequestStore{Key: tag + ":b", Data: "b"},
+		},
+	})
+	if resp.Err() != nil {
+		t.Fatalf("unexpected error: %s", resp.Err())
+	}
+	if len(resp.Responses) != 2 || resp.Responses[1].Record.Data != "b" {
+		t.Fatalf("invalid responses returned: %v", resp.Responses)
+	}
+	if keys := s2.store.Keys(); len(keys) != 2 {
+		t.Fatalf("transaction should be applied on remote node: %v", keys)
+	}
+}
+
+func TestServerDoWatch(t *testing.T) {
+	s1, s2, conn := newTestCluster()
+	defer conn.Close()
+
+	ln := listen(t, s1, s2)
+	defer ln.Close()
+
+	var local, next, remote string
+	for i := 0; local == "" || next == "" || remote == ""; i++ {
+		key := fmt.Sprintf("key%d", i)
+		switch {
+		case s1.nodeOfKey(key).Conn != nil:
+			remote = key
+		case local == "":
+			local = key
+		default:
+			next = key
+		}
+	}
+
+	tests := []struct {
+		Key   string
+		Store *server
+	}{
+		{local, s1},
+		{remote, s2},
+	}
+
+	for _, tt := range tests {
+		tt.Store.store.Store(tt.Key, hash.Record{Data: "a"})
+		go func(s *server, key string) {
+			time.Sleep(50 * time.Millisecond)
+			s.store.Store(key, hash.Record{Data: "b"})
+		}(tt.Store, tt.Key)
+
+		req := &store.RequestWatch{Key: tt.Key, Index: 1}
+		resp := s1.Do(context.Background(), req)
+		if resp.Err() != nil {
+			t.Fatalf("unexpected error: %s", resp.Err())
+		}
+		if resp.Event != store.EventStore || resp.Record.Data != "b" {
+			t.Fatalf("invalid response to watch: %v", resp.Record)
+		}
+	}
+
+	req := &store.RequestWatch{Key: local, Index: 2, Timeout: time.Millisecond}
+	resp := s1.Do(context.Background(), req)
+	if resp.Status != http.StatusRequestTimeout {
+		t.Fatalf("watch should be timed out: %d", resp.Status)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	resp = s1.Do(ctx, &store.RequestWatch{Key: remote, Index: 2})
+	if resp.Status != http.StatusRequestTimeout {
+		t.Fatalf("watch should be canceled: %d", resp.Status)
+	}
+}
+
+func TestServerEvents(t *testing.T) {
+	s1, s2, conn := newTestCluster()
+	defer conn.Close()
+
+	ln := listen(t, s1, s2)
+	defer ln.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	events := s1.Events(ctx, "user:")
+
+	// Wait for the subscription of the remote node: the record is
+	// stored again, until the event of the record is received.
+	deadline := time.After(5 * time.Second)
+	for subscribed := false; !subscribed; {
+		s2.store.Store("user:0", hash.Record{Data: "a"})
+		select {
+		case resp := <-events:
+			subscribed = resp.Key == "user:0"
+		case <-time.After(10 * time.Millisecond):
+		case <-deadline:
+			t.Fatalf("subscription of the remote node expected")
+		}
+	}
+
+	s1.store.Store("session:1", hash.Record{Data: "b"})
+	s1.store.Store("user:1", hash.Record{Data: "c"})
+	s2.store.Delete("user:0")
+
+	// The events of the repeated stores could be received before the
+	// deletion of the record.
+	received := make(map[string]string)
+	for received["user:1"] == "" || received["user:0"] != store.EventDelete {
+		select {
+		case resp := <-events:
+			received[resp.Key] = resp.Event
+		case <-time.After(time.Second):
+			t.Fatalf("events expected, received: %v", received)
+		}
+	}
+
+	expected := map[string]string{
+		"user:1": store.EventStore,
+		"user:0": store.EventDelete,
+	}
+	if !reflect.DeepEqual(received, expected) {
+		t.Fatalf("invalid events received: %v", received)
+	}
+
+	cancel()
+	for range events {
+	}
+}
+
+func TestServerEventsRemoved(t *testing.T) {
+	s1, _, conn := newTestCluster()
+	defer conn.Close()
+	s1.replicas = 2
+
+	var local, remote string
+	for i := 0; local == "" || remote == ""; i++ {
+		key := fmt.Sprintf("key%d", i)
+		if s1.nodeOfKey(key).Conn == nil {
+			local = key
+		} else {
+			remote = key
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	events := make(chan *Response)
+	go s1.localEvents(ctx, s1.self(), "", events)
+
+	// Both copies are expired by the local node, but only the removal
+	// of the primary copy is delivered.
+	meta := hash.Meta{ExpireTime: time.Millisecond}
+	s1.store.Store(local, hash.Record{Data: "a", Meta: meta})
+	s1.store.Store(remote, hash.Record{Data: "b", Meta: meta})
+
+	removed := make(map[string]string)
+	for timeout := time.After(200 * time.Millisecond); ; {
+		select {
+		case resp := <-events:
+			if resp.Event != store.EventStore {
+				removed[resp.Key] = resp.Event
+			}
+			continue
+		case <-timeout:
+		}
+		break
+	}
+
+	expected := map[string]string{local: store.EventExpire}
+	if !reflect.DeepEqual(removed, expected) {
+		t.Fatalf("invalid removals delivered: %v", removed)
+	}
+}
+
+func TestServerPublish(t *testing.T) {
+	s1, s2, conn := newTestCluster()
+	defer conn.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	local := s1.Subscribe(ctx, "news")
+	remote := s2.Subscribe(ctx, "news")
+	other := s2.Subscribe(ctx, "sports")
+
+	resp := s1.Publish(context.Background(), "news", "a")
+	if resp.Receivers != 2 || len(resp.Errors) != 0 {
+		t.Fatalf("message should be delivered to both nodes: %v", resp)
+	}
+
+	for _, messages := range []<-chan *pubsub.Message{local, remote} {
+		select {
+		case msg := <-messages:
+			if msg.Channel != "news" || msg.Data != "a" {
+				t.Fatalf("invalid message received: %v", msg)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("message expected")
+		}
+	}
+
+	select {
+	case msg := <-other:
+		t.Fatalf("unexpected message received: %v", msg)
+	default:
+	}
+
+	cancel()
+	if _, ok := <-local; ok {
+		t.Fatalf("channel of canceled subscription should be closed")
+	}
+}
+
+func TestServerReplication(t *testing.T) {
+	s1, s2, conn := newTestCluster()
+	defer conn.Close()
+
+	s1.replicas = 2
+	s1.place()
+	if n := s1.nodes[0]; n.Primaries != 2 || n.Replicas != 2 {
+		t.Fatalf("invalid placement of the first node: %d, %d",
+			n.Primaries, n.Replicas)
+	}
+
+	var local, next, remote string
+	for i := 0; local == "" || next == "" || remote == ""; i++ {
+		key := fmt.Sprintf("key%d", i)
+		switch {
+		case s1.nodeOfKey(key).Conn != nil:
+			remote = key
+		case local == "":
+			local = key
+		default:
+			next = key
+		}
+	}
+
+	// Wait for the acknowledgement of the replica.
+	ctx := WithConsistency(context.Background(), store.ConsistencyAll)
+	resp := s1.Do(ctx, &store.RequestStore{Key: local, Data: "a"})
+	if resp.Err() != nil {
+		t.Fatalf("unexpected error: %s", resp.Err())
+	}
+
+	rec, ok := s2.store.Peek(local)
+	if !ok || rec.Data != "a" || rec.Meta.Index != resp.Record.Meta.Index {
+		t.Fatalf("record should be replicated: %v", rec)
+	}
+
+	// The changes are sent to the replica in order, so the changes,
+	// which acknowledgements are not awaited, are delivered before the
+	// acknowledged one.
+	for i := 0; i < 10; i++ {
+		data := fmt.Sprintf("a%d", i)
+		s1.Do(context.Background(), &store.RequestStore{Key: local, Data: data})
+	}
+	resp = s1.Do(ctx, &store.RequestStore{Key: next, Data: "b"})
+	if resp.Err() != nil {
+		t.Fatalf("unexpected error: %s", resp.Err())
+	}
+
+	rec, ok = s2.store.Peek(local)
+	if !ok || rec.Data != "a9" || rec.Meta.Index != 11 {
+		t.Fatalf("changes should be replicated in order: %v", rec)
+	}
+
+	// The primary node is not reachable, so the read falls back to the
+	// local replica, while the write fails.
+	s1.store.Store(remote, hash.Record{Data: "b"})
+	conn.Close()
+
+	resp = s1.Do(context.Background(), &store.RequestLoad{Key: remote})
+	if resp.Err() != nil || resp.Record.Data != "b" {
+		t.Fatalf("read should fall back to replica: %v", resp)
+	}
+
+	resp = s1.Do(context.Background(), &store.RequestStore{Key: remote, Data: "c"})
+	if resp.Err() == nil {
+		t.Fatalf("write to unreachable primary should fail")
+	}
+}
+
+func TestServerConsistency(t *testing.T) {
+	s1, s2, conn := newTestCluster()
+	defer conn.Close()
+	s1.replicas = 2
+
+	var key string
+	for i := 0; key == ""; i++ {
+		if k := fmt.Sprintf("key%d", i); s1.nodeOfKey(k).Conn != nil {
+			key = k
+		}
+	}
+
+	// The copies of the record diverged, the most recent one should be
+	// returned regardless of the node holding it.
+	s1.store.Store(key, hash.Record{Data: "a"})
+	s2.store.Store(key, hash.Record{Data: "b"})
+	s2.store.Store(key, hash.Record{Data: "c"})
+
+	ctx := WithConsistency(context.Background(), store.ConsistencyQuorum)
+	resp := s1.Do(ctx, &store.RequestLoad{Key: key})
+	if resp.Err() != nil || resp.Record.Data != "c" {
+		t.Fatalf("the most recent record expected: %v", resp)
+	}
+
+	s2.store.Delete(key)
+	resp = s1.Do(ctx, &store.RequestLoad{Key: key})
+	if resp.Err() != nil || resp.Record.Data != "a" {
+		t.Fatalf("the existing record expected: %v", resp)
+	}
+
+	// Only the local copy is available.
+	conn.Close()
+	ctx = WithConsistency(context.Background(), store.ConsistencyAll)
+	resp = s1.Do(ctx, &store.RequestLoad{Key: key})
+	if resp.Status != http.StatusServiceUnavailable {
+		t.Fatalf("read should fail with unavailable status: %v", resp)
+	}
+
+	var local string
+	for i := 0; local == ""; i++ {
+		if k := fmt.Sprintf("key%d", i); s1.nodeOfKey(k).Conn == nil {
+			local = k
+		}
+	}
+
+	resp = s1.Do(ctx, &store.RequestStore{Key: local, Data: "d"})
+	if resp.Status != http.StatusServiceUnavailable {
+		t.Fatalf("write should fail with unavailable status: %v", resp)
+	}
+
+	ctx = WithConsistency(context.Background(), store.ConsistencyOne)
+	resp = s1.Do(ctx, &store.RequestStore{Key: local, Data: "e"})
+	if resp.Err() != nil {
+		t.Fatalf("write should succeed with a single copy: %v", resp)
+	}
+}
+
+// benchmarkForward measures the throughput of the requests forwarded to
+// the remote node by the given function from many goroutines at once.
+func benchmarkForward(b *testing.B,
+	forward func(s *server, node *Node, req message) (Response, error)) {
+
+	s1, ln1 := newTestMember(b)
+	defer ln1.Close()
+	s2, ln2 := newTestMember(b)
+	defer ln2.Close()
+	defer s2.Stop()
+	defer s1.Stop()
+
+	if resp := s1.Join(context.Background(), s2.laddr); resp.Err() != nil {
+		b.Fatalf("unexpected error: %s", resp.Err())
+	}
+	s1.migrations.Wait()
+	s2.migrations.Wait()
+
+	var key string
+	for i := 0; key == ""; i++ {
+		if k := fmt.Sprintf("key%d", i); s1.nodeOfKey(k).Conn != nil {
+			key = k
+		}
+	}
+	s2.store.Store(key, hash.Record{Data: "a"})
+	node := s1.nodeOfKey(key)
+
+	b.SetParallelism(16)
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			resp, err := forward(s1, node, &store.RequestLoad{Key: key})
+			if err == nil {
+				err = resp.Err()
+			}
+			if err != nil {
+				b.Errorf("unexpected error: %s", err)
+				return
+			}
+		}
+	})
+}
+
+func BenchmarkServerForwardParallel(b *testing.B) {
+	b.Run("lockstep", func(b *testing.B) {
+		// Only a single request is in flight to the remote node, the
+		// next one is sent, when the response is read.
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		var mu sync.Mutex
+		var conns = make(map[*Node]net.Conn)
+		benchmarkForward(b, func(s *server, node *Node,
+			req message) (Response, error) {
+
+			mu.Lock()
+			defer mu.Unlock()
+
+			conn, ok := conns[node]
+			if !ok {
+				var err error
+				if conn, _, err = s.dialCtx(ctx, node); err != nil {
+					return Response{}, err
+				}
+				conns[node] = conn
+			}
+			return s.exchange(conn, req, "")
+		})
+	})
+	b.Run("multiplexed", func(b *testing.B) {
+		benchmarkForward(b, func(s *server, node *Node,
+			req message) (Response, error) {
+			return s.roundTrip(node, req, "")
+		})
+	})
+}
+
+func TestServerStop(t *testing.T) {
+	s := newServer(&Config{NumPartitions: 4})
+	if err := s.Stop(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	// The server is already stopped, so the call does nothing.
+	if err := s.Stop(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+}
+
+func TestServerHandleOrder(t *testing.T) {
+	s := newServer(&Config{NumPartitions: 4})
+	c1, c2 := net.Pipe()
+	defer c1.Close()
+	go s.handle(c2)
+
+	// The overwriting changes are applied regardless of the index, so
+	// the last record is persisted only when the changes are applied
+	// in the order of sending.
+	const n = 32
+	go func() {
+		for i := 1; i <= n; i++ {
+			req := &store.RequestReplicate{Overwrite: true, Changes: []store.Event{{
+				Type: store.EventStore, Key: "a",
+				Record: hash.Record{Meta: hash.Meta{Index: int64(i)}}}}}
+			b, _ := json.Marshal(req)
+			raw := json.RawMessage(b)
+			s.writeWire(c1, eventRequest{Action: req.Action(),
+				Request: &raw, CorrelationID: uint64(i)})
+		}
+	}()
+
+	decoder := json.NewDecoder(c1)
+	for i := 1; i <= n; i++ {
+		var resp Response
+		if err := decoder.Decode(&resp); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if resp.CorrelationID != uint64(i) {
+			t.Fatalf("responses should be in order: %d, %d", resp.CorrelationID, i)
+		}
+	}
+
+	if rec, _ := s.store.Peek("a"); rec.Meta.Index != n {
+		t.Fatalf("changes should be applied in order: %v", rec)
+	}
+}
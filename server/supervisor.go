@@ -0,0 +1,88 @@
+package server
+
+import (
+	"time"
+
+	"github.com/ybubnov/memhashd/system/log"
+)
+
+const (
+	// defaultBackoff is an interval to wait after the first failed
+	// attempt to dial a node.
+	defaultBackoff = time.Second
+
+	// maxBackoff is a maximum interval between the attempts to dial
+	// a node.
+	maxBackoff = time.Minute
+)
+
+// backoff returns an interval to wait after the given number of failed
+// attempts to dial a node. The interval is doubled after each failure.
+func (s *server) backoff(attempt int) time.Duration {
+	if attempt > 30 {
+		return maxBackoff
+	}
+	backoff := s.dialBackoff << uint(attempt)
+	if backoff <= 0 || backoff > maxBackoff {
+		return maxBackoff
+	}
+	return backoff
+}
+
+// reconnect re-establishes the broken connection to the node. The node is
+// dialed with the same backoff as on join, until the connection succeeds,
+// the node is removed from the cluster or the server is stopped. The new
+// connection replaces the broken one under the node lock, so no requests
+// are sent through the broken connection.
+func (s *server) reconnect(node *Node) {
+	log.ErrorLogf("server/RECONNECT",
+		"connection to %s is broken, reconnecting", node.Addr)
+
+	for attempt := 0; s.attached(node); attempt++ {
+		conn, err := s.dial(node)
+		if err == nil {
+			node.mu.Lock()
+			node.Conn, node.down = conn, false
+			node.mu.Unlock()
+
+			log.InfoLogf("server/RECONNECT", "reconnected to %s", node.Addr)
+			return
+		}
+
+		const text = "dialing of %s failed, %s, next attempt in %s"
+		backoff := s.backoff(attempt)
+		log.ErrorLogf("server/RECONNECT", text, node.Addr, err, backoff)
+
+		select {
+		case <-time.After(backoff):
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// attached reports whether the connection to the node is still used: the
+// node is a member of the cluster or the records of the incoming partitions
+// are pulled from it, and the server is not stopped.
+func (s *server) attached(node *Node) bool {
+	select {
+	case <-s.done:
+		return false
+	default:
+	}
+
+	for _, n := range s.Nodes() {
+		if n == node {
+			return true
+		}
+	}
+
+	s.incomingMu.Lock()
+	defer s.incomingMu.Unlock()
+	for _, source := range s.incoming {
+		if source == node {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,153 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ybubnov/memhashd/container/hash"
+	"github.com/ybubnov/memhashd/container/store"
+)
+
+func TestServerBackoff(t *testing.T) {
+	s := newServer(&Config{NumPartitions: 4})
+	tests := []struct {
+		attempt int
+		backoff time.Duration
+	}{
+		{0, time.Second},
+		{1, 2 * time.Second},
+		{5, 32 * time.Second},
+		{6, maxBackoff},
+		{64, maxBackoff},
+	}
+
+	for _, tt := range tests {
+		if backoff := s.backoff(tt.attempt); backoff != tt.backoff {
+			t.Fatalf("invalid backoff of attempt %d: %s", tt.attempt, backoff)
+		}
+	}
+}
+
+func TestServerReconnect(t *testing.T) {
+	s1, ln1 := newTestMember(t)
+	defer ln1.Close()
+	s2, ln2 := newTestMember(t)
+	defer s2.Stop()
+	defer s1.Stop()
+
+	s1.dialBackoff = 10 * time.Millisecond
+	ctx := context.Background()
+	if resp := s1.Join(ctx, s2.laddr); resp.Err() != nil {
+		t.Fatalf("unexpected error: %s", resp.Err())
+	}
+	s1.migrations.Wait()
+	s2.migrations.Wait()
+
+	var key string
+	for i := 0; key == ""; i++ {
+		if k := fmt.Sprintf("key%d", i); s1.nodeOfKey(k).Conn != nil {
+			key = k
+		}
+	}
+	s2.store.Store(key, hash.Record{Data: "a"})
+
+	// Break the connection, while the node is not reachable.
+	node := s1.nodeOfKey(key)
+	ln2.Close()
+	closeWire(node.Conn)
+
+	// The broken connection is detected either by the request or by the
+	// reader of the responses, whichever comes first.
+	resp := s1.Do(ctx, &store.RequestLoad{Key: key})
+	if resp.Status != http.StatusServiceUnavailable {
+		t.Fatalf("broken connection should be reported: %v", resp)
+	}
+
+	resp = s1.Do(ctx, &store.RequestLoad{Key: key})
+	if resp.Status != http.StatusServiceUnavailable ||
+		!strings.Contains(resp.Error, "down") {
+		t.Fatalf("requests should fail while node is down: %v", resp)
+	}
+
+	// Start accepting the connections again at the same address.
+	ln, err := net.Listen("tcp", s2.laddr.String())
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go s2.handle(conn)
+		}
+	}()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for down := true; down; time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("connection to %s should be re-established", node.Addr)
+		}
+		node.mu.Lock()
+		down = node.down
+		node.mu.Unlock()
+	}
+
+	resp = s1.Do(ctx, &store.RequestLoad{Key: key})
+	if resp.Err() != nil || resp.Record.Data != "a" {
+		t.Fatalf("request should be served after reconnect: %v", resp)
+	}
+}
+
+func TestServerIOTimeout(t *testing.T) {
+	s := newServer(&Config{NumPartitions: 4})
+	s.ioTimeout = 50 * time.Millisecond
+	defer s.Stop()
+
+	addr := &net.TCPAddr{IP: net.ParseIP("127.0.0.1"), Port: 2372}
+	req := &requestDigest{ID: "digest"}
+
+	// The first peer reads the requests, but never responds, the second
+	// one does not read the requests either.
+	for _, drain := range []bool{true, false} {
+		c1, c2 := net.Pipe()
+		if drain {
+			go io.Copy(ioutil.Discard, c2)
+		}
+
+		node := &Node{Addr: addr, Conn: c1}
+		_, err := s.roundTrip(node, req, "")
+		if _, ok := err.(*store.ErrUnavailable); !ok {
+			t.Fatalf("unresponsive node should be unavailable: %v", err)
+		}
+		// The expired request is not awaited anymore, and the connection
+		// is broken by the deadline of the reader or the writer.
+		var down bool
+		for deadline := time.Now().Add(time.Second); !down; {
+			node.mu.Lock()
+			down = node.down && len(node.pending) == 0
+			node.mu.Unlock()
+			if !down && time.Now().After(deadline) {
+				t.Fatalf("connection to unresponsive node should be broken")
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		c2.Close()
+	}
+
+	c1, c2 := net.Pipe()
+	defer c2.Close()
+	go io.Copy(ioutil.Discard, c2)
+	if _, err := s.exchange(c1, req, ""); err == nil {
+		t.Fatalf("exchange with unresponsive node should fail")
+	}
+}
@@ -1,6 +1,7 @@
 package netutil
 
 import (
+	"context"
 	"crypto/tls"
 	"net"
 	"time"
@@ -9,10 +10,23 @@ import (
 // Dial setups a connection to the given address. If TLS configuration
 // is not empty, it also sets up a security transport.
 func Dial(laddr, raddr *net.TCPAddr, config *tls.Config) (net.Conn, error) {
-	conn, err := net.DialTCP("tcp", laddr, raddr)
+	return DialContext(context.Background(), laddr, raddr, config)
+}
+
+// DialContext setups a connection to the given address like Dial, but the
+// connection attempt is aborted, when the context is canceled.
+func DialContext(ctx context.Context, laddr, raddr *net.TCPAddr,
+	config *tls.Config) (net.Conn, error) {
+
+	var dialer net.Dialer
+	if laddr != nil {
+		dialer.LocalAddr = laddr
+	}
+	raw, err := dialer.DialContext(ctx, "tcp", raddr.String())
 	if err != nil {
 		return nil, err
 	}
+	conn := raw.(*net.TCPConn)
 
 	// The code below can result in a error, in order to prevent a
 	// file descriptor leak, the connection should be closed in case
@@ -1,8 +1,30 @@
 package netutil
 
 import (
+	"context"
+	"net"
 	"testing"
 )
 
 func TestDial(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer ln.Close()
+
+	raddr := ln.Addr().(*net.TCPAddr)
+	conn, err := Dial(nil, raddr, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	conn.Close()
+
+	// The attempt is aborted, when the context is canceled.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if conn, err = DialContext(ctx, nil, raddr, nil); err == nil {
+		conn.Close()
+		t.Fatalf("canceled dial should fail")
+	}
 }
@@ -14,6 +14,9 @@ func setKeepalive(conn *net.TCPConn, count, idle, timeout int) error {
 	if err != nil {
 		return err
 	}
+	// The file is a duplicate of the connection descriptor, the options
+	// are applied to the socket, so the duplicate is not needed after.
+	defer file.Close()
 
 	fd := int(file.Fd())
 	// After the three unsuccessful submissions, client closes a